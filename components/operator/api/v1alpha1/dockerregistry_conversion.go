@@ -0,0 +1,657 @@
+package v1alpha1
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1beta1"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// ConversionDataAnnotation keeps the parts of a v1alpha1 DockerRegistry that have no v1beta1
+// representation, so that an object converted to v1beta1 and back is not changed
+const ConversionDataAnnotation = "operator.kyma-project.io/v1alpha1-conversion-data"
+
+var _ conversion.Convertible = &DockerRegistry{}
+
+type conversionData struct {
+	// Storage is kept when more than one backend is configured, v1beta1 keeps only the one the operator uses
+	Storage *Storage `json:"storage,omitempty"`
+
+	// the status flags are kept when they hold something else than "True", "False" or nothing
+	InternalAccessEnabled *string `json:"internalAccessEnabled,omitempty"`
+	ExternalAccessEnabled *string `json:"externalAccessEnabled,omitempty"`
+	DeleteEnabled         *string `json:"deleteEnabled,omitempty"`
+}
+
+func (d conversionData) isEmpty() bool {
+	return reflect.DeepEqual(d, conversionData{})
+}
+
+// ConvertTo converts this DockerRegistry to the hub version (v1beta1)
+func (src *DockerRegistry) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1beta1.DockerRegistry)
+	if !ok {
+		return errors.Errorf("unsupported hub type %T", dstRaw)
+	}
+
+	data := conversionData{}
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	dst.Spec = v1beta1.DockerRegistrySpec{
+		Storage:           storageToHub(src.Spec.Storage, &data),
+		ExternalAccess:    externalAccessToHub(src.Spec.ExternalAccess),
+		Logging:           (*v1beta1.Logging)(src.Spec.Logging.DeepCopy()),
+		HighAvailability:  (*v1beta1.HighAvailability)(src.Spec.HighAvailability.DeepCopy()),
+		GarbageCollection: (*v1beta1.GarbageCollection)(src.Spec.GarbageCollection.DeepCopy()),
+		Retention:         retentionToHub(src.Spec.Retention),
+		Proxy:             (*v1beta1.Proxy)(src.Spec.Proxy.DeepCopy()),
+		Auth:              authToHub(src.Spec.Auth),
+		Credentials:       credentialsToHub(src.Spec.Credentials),
+		Users:             usersToHub(src.Spec.Users),
+	}
+
+	dst.Status = v1beta1.DockerRegistryStatus{
+		InternalAccess: networkAccessToHub(src.Status.InternalAccess, &data.InternalAccessEnabled),
+		ExternalAccess: v1beta1.ExternalNetworkAccess{
+			NetworkAccess: networkAccessToHub(src.Status.ExternalAccess.NetworkAccess, &data.ExternalAccessEnabled),
+			Gateway:       src.Status.ExternalAccess.Gateway,
+		},
+		Storage:             src.Status.Storage,
+		PVC:                 src.Status.PVC,
+		DeleteEnabled:       flagToHub(src.Status.DeleteEnabled, &data.DeleteEnabled),
+		GarbageCollection:   garbageCollectionStatusToHub(src.Status.GarbageCollection),
+		Retention:           retentionStatusToHub(src.Status.Retention),
+		Proxy:               src.Status.Proxy,
+		Credentials:         (*v1beta1.CredentialsStatus)(src.Status.Credentials.DeepCopy()),
+		ExternalCredentials: (*v1beta1.CredentialsStatus)(src.Status.ExternalCredentials.DeepCopy()),
+		Auth:                src.Status.Auth,
+		StorageAuth:         src.Status.StorageAuth,
+		StorageLocation:     src.Status.StorageLocation,
+		StorageChecksum:     src.Status.StorageChecksum,
+		StorageMigration:    storageMigrationStatusToHub(src.Status.StorageMigration),
+		ObservedGeneration:  src.Status.ObservedGeneration,
+		State:               v1beta1.State(src.Status.State),
+		Served:              v1beta1.Served(src.Status.Served),
+		Conditions:          copyConditions(src.Status.Conditions),
+	}
+
+	return setConversionData(&dst.ObjectMeta, data)
+}
+
+// ConvertFrom converts the hub version (v1beta1) to this DockerRegistry
+func (dst *DockerRegistry) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1beta1.DockerRegistry)
+	if !ok {
+		return errors.Errorf("unsupported hub type %T", srcRaw)
+	}
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	data, err := popConversionData(&dst.ObjectMeta)
+	if err != nil {
+		return err
+	}
+
+	dst.Spec = DockerRegistrySpec{
+		Storage:           storageFromHub(src.Spec.Storage, data.Storage),
+		ExternalAccess:    externalAccessFromHub(src.Spec.ExternalAccess),
+		Logging:           (*Logging)(src.Spec.Logging.DeepCopy()),
+		HighAvailability:  (*HighAvailability)(src.Spec.HighAvailability.DeepCopy()),
+		GarbageCollection: (*GarbageCollection)(src.Spec.GarbageCollection.DeepCopy()),
+		Retention:         retentionFromHub(src.Spec.Retention),
+		Proxy:             (*Proxy)(src.Spec.Proxy.DeepCopy()),
+		Auth:              authFromHub(src.Spec.Auth),
+		Credentials:       credentialsFromHub(src.Spec.Credentials),
+		Users:             usersFromHub(src.Spec.Users),
+	}
+
+	dst.Status = DockerRegistryStatus{
+		InternalAccess: networkAccessFromHub(src.Status.InternalAccess, data.InternalAccessEnabled),
+		ExternalAccess: ExternalNetworkAccess{
+			NetworkAccess: networkAccessFromHub(src.Status.ExternalAccess.NetworkAccess, data.ExternalAccessEnabled),
+			Gateway:       src.Status.ExternalAccess.Gateway,
+		},
+		Storage:             src.Status.Storage,
+		PVC:                 src.Status.PVC,
+		DeleteEnabled:       flagFromHub(src.Status.DeleteEnabled, data.DeleteEnabled),
+		GarbageCollection:   garbageCollectionStatusFromHub(src.Status.GarbageCollection),
+		Retention:           retentionStatusFromHub(src.Status.Retention),
+		Proxy:               src.Status.Proxy,
+		Credentials:         (*CredentialsStatus)(src.Status.Credentials.DeepCopy()),
+		ExternalCredentials: (*CredentialsStatus)(src.Status.ExternalCredentials.DeepCopy()),
+		Auth:                src.Status.Auth,
+		StorageAuth:         src.Status.StorageAuth,
+		StorageLocation:     src.Status.StorageLocation,
+		StorageChecksum:     src.Status.StorageChecksum,
+		StorageMigration:    storageMigrationStatusFromHub(src.Status.StorageMigration),
+		ObservedGeneration:  src.Status.ObservedGeneration,
+		State:               State(src.Status.State),
+		Served:              Served(src.Status.Served),
+		Conditions:          copyConditions(src.Status.Conditions),
+	}
+
+	return nil
+}
+
+func storageToHub(src *Storage, data *conversionData) *v1beta1.Storage {
+	if src == nil {
+		return nil
+	}
+
+	dst := &v1beta1.Storage{
+		Type:          v1beta1.StorageTypeFilesystem,
+		DeleteEnabled: src.DeleteEnabled,
+		Migrate:       src.Migrate,
+	}
+
+	// the same order the operator picks the backend in when more than one is configured
+	switch {
+	case src.Azure != nil:
+		dst.Type = v1beta1.StorageTypeAzure
+		dst.Azure = azureToHub(src.Azure)
+	case src.S3 != nil:
+		dst.Type = v1beta1.StorageTypeS3
+		dst.S3 = s3ToHub(src.S3)
+	case src.GCS != nil:
+		dst.Type = v1beta1.StorageTypeGCS
+		dst.GCS = gcsToHub(src.GCS)
+	case src.BTPObjectStore != nil:
+		dst.Type = v1beta1.StorageTypeBTPObjectStore
+		dst.BTPObjectStore = (*v1beta1.StorageBTPObjectStore)(src.BTPObjectStore.DeepCopy())
+	case src.PVC != nil:
+		dst.Type = v1beta1.StorageTypePVC
+		dst.PVC = (*v1beta1.StoragePVC)(src.PVC.DeepCopy())
+	}
+
+	if countStorageBackends(src) > 1 {
+		data.Storage = src.DeepCopy()
+	}
+
+	return dst
+}
+
+func storageFromHub(src *v1beta1.Storage, kept *Storage) *Storage {
+	if src == nil {
+		return nil
+	}
+
+	// the kept storage is only restored while the hub still holds what was derived from it, a change
+	// made through v1beta1 in the meantime wins
+	if kept != nil && reflect.DeepEqual(storageToHub(kept, &conversionData{}), src) {
+		return kept.DeepCopy()
+	}
+
+	return &Storage{
+		Azure:          azureFromHub(src.Azure),
+		S3:             s3FromHub(src.S3),
+		GCS:            gcsFromHub(src.GCS),
+		BTPObjectStore: (*StorageBTPObjectStore)(src.BTPObjectStore.DeepCopy()),
+		PVC:            (*StoragePVC)(src.PVC.DeepCopy()),
+		DeleteEnabled:  src.DeleteEnabled,
+		Migrate:        src.Migrate,
+	}
+}
+
+func azureToHub(src *StorageAzure) *v1beta1.StorageAzure {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	return &v1beta1.StorageAzure{
+		SecretName:       src.SecretName,
+		WorkloadIdentity: (*v1beta1.AzureWorkloadIdentity)(src.WorkloadIdentity),
+	}
+}
+
+func azureFromHub(src *v1beta1.StorageAzure) *StorageAzure {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	return &StorageAzure{
+		SecretName:       src.SecretName,
+		WorkloadIdentity: (*AzureWorkloadIdentity)(src.WorkloadIdentity),
+	}
+}
+
+func s3ToHub(src *StorageS3) *v1beta1.StorageS3 {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	return &v1beta1.StorageS3{
+		Bucket:           src.Bucket,
+		Region:           src.Region,
+		RegionEndpoint:   src.RegionEndpoint,
+		Encrypt:          src.Encrypt,
+		Secure:           src.Secure,
+		SecretName:       src.SecretName,
+		Rootdirectory:    src.Rootdirectory,
+		ForcePathStyle:   src.ForcePathStyle,
+		KeyID:            src.KeyID,
+		StorageClass:     src.StorageClass,
+		Chunksize:        src.Chunksize,
+		SkipVerify:       src.SkipVerify,
+		V4Auth:           src.V4Auth,
+		WorkloadIdentity: (*v1beta1.S3WorkloadIdentity)(src.WorkloadIdentity),
+	}
+}
+
+func s3FromHub(src *v1beta1.StorageS3) *StorageS3 {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	return &StorageS3{
+		Bucket:           src.Bucket,
+		Region:           src.Region,
+		RegionEndpoint:   src.RegionEndpoint,
+		Encrypt:          src.Encrypt,
+		Secure:           src.Secure,
+		SecretName:       src.SecretName,
+		Rootdirectory:    src.Rootdirectory,
+		ForcePathStyle:   src.ForcePathStyle,
+		KeyID:            src.KeyID,
+		StorageClass:     src.StorageClass,
+		Chunksize:        src.Chunksize,
+		SkipVerify:       src.SkipVerify,
+		V4Auth:           src.V4Auth,
+		WorkloadIdentity: (*S3WorkloadIdentity)(src.WorkloadIdentity),
+	}
+}
+
+func gcsToHub(src *StorageGCS) *v1beta1.StorageGCS {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	return &v1beta1.StorageGCS{
+		Bucket:           src.Bucket,
+		SecretName:       src.SecretName,
+		Rootdirectory:    src.Rootdirectory,
+		Chunksize:        src.Chunksize,
+		WorkloadIdentity: (*v1beta1.GCSWorkloadIdentity)(src.WorkloadIdentity),
+	}
+}
+
+func gcsFromHub(src *v1beta1.StorageGCS) *StorageGCS {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	return &StorageGCS{
+		Bucket:           src.Bucket,
+		SecretName:       src.SecretName,
+		Rootdirectory:    src.Rootdirectory,
+		Chunksize:        src.Chunksize,
+		WorkloadIdentity: (*GCSWorkloadIdentity)(src.WorkloadIdentity),
+	}
+}
+
+func countStorageBackends(storage *Storage) int {
+	count := 0
+	for _, backend := range []bool{
+		storage.Azure != nil,
+		storage.S3 != nil,
+		storage.GCS != nil,
+		storage.BTPObjectStore != nil,
+		storage.PVC != nil,
+	} {
+		if backend {
+			count++
+		}
+	}
+	return count
+}
+
+func networkAccessToHub(src NetworkAccess, kept **string) v1beta1.NetworkAccess {
+	return v1beta1.NetworkAccess{
+		Enabled:     flagToHub(src.Enabled, kept),
+		SecretName:  src.SecretName,
+		PushAddress: src.PushAddress,
+		PullAddress: src.PullAddress,
+	}
+}
+
+func networkAccessFromHub(src v1beta1.NetworkAccess, kept *string) NetworkAccess {
+	return NetworkAccess{
+		Enabled:     flagFromHub(src.Enabled, kept),
+		SecretName:  src.SecretName,
+		PushAddress: src.PushAddress,
+		PullAddress: src.PullAddress,
+	}
+}
+
+// flagToHub converts the "True"/"False" status flags the operator writes, any other value is kept aside
+func flagToHub(value string, kept **string) *bool {
+	switch value {
+	case "":
+		return nil
+	case string(metav1.ConditionTrue):
+		return ptr.To(true)
+	case string(metav1.ConditionFalse):
+		return ptr.To(false)
+	default:
+		*kept = ptr.To(value)
+		return nil
+	}
+}
+
+func flagFromHub(value *bool, kept *string) string {
+	switch {
+	case value == nil && kept != nil:
+		return *kept
+	case value == nil:
+		return ""
+	case *value:
+		return string(metav1.ConditionTrue)
+	default:
+		return string(metav1.ConditionFalse)
+	}
+}
+
+func garbageCollectionStatusToHub(src *GarbageCollectionStatus) *v1beta1.GarbageCollectionStatus {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	return &v1beta1.GarbageCollectionStatus{
+		Schedule:         src.Schedule,
+		NextScheduleTime: src.NextScheduleTime,
+		LastScheduleTime: src.LastScheduleTime,
+		LastRunTime:      src.LastRunTime,
+		JobName:          src.JobName,
+		Result:           v1beta1.GarbageCollectionResult(src.Result),
+		FreedBytes:       src.FreedBytes,
+		DeletedBlobs:     src.DeletedBlobs,
+		DeletedManifests: src.DeletedManifests,
+		Message:          src.Message,
+	}
+}
+
+func garbageCollectionStatusFromHub(src *v1beta1.GarbageCollectionStatus) *GarbageCollectionStatus {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	return &GarbageCollectionStatus{
+		Schedule:         src.Schedule,
+		NextScheduleTime: src.NextScheduleTime,
+		LastScheduleTime: src.LastScheduleTime,
+		LastRunTime:      src.LastRunTime,
+		JobName:          src.JobName,
+		Result:           GarbageCollectionResult(src.Result),
+		FreedBytes:       src.FreedBytes,
+		DeletedBlobs:     src.DeletedBlobs,
+		DeletedManifests: src.DeletedManifests,
+		Message:          src.Message,
+	}
+}
+
+func storageMigrationStatusToHub(src *StorageMigrationStatus) *v1beta1.StorageMigrationStatus {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	return &v1beta1.StorageMigrationStatus{
+		Source:         src.Source,
+		Target:         src.Target,
+		Phase:          v1beta1.StorageMigrationPhase(src.Phase),
+		StartTime:      src.StartTime,
+		CompletionTime: src.CompletionTime,
+		JobName:        src.JobName,
+		Attempts:       src.Attempts,
+	}
+}
+
+func storageMigrationStatusFromHub(src *v1beta1.StorageMigrationStatus) *StorageMigrationStatus {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	return &StorageMigrationStatus{
+		Source:         src.Source,
+		Target:         src.Target,
+		Phase:          StorageMigrationPhase(src.Phase),
+		StartTime:      src.StartTime,
+		CompletionTime: src.CompletionTime,
+		JobName:        src.JobName,
+		Attempts:       src.Attempts,
+	}
+}
+
+func authToHub(src *Auth) *v1beta1.Auth {
+	if src == nil {
+		return nil
+	}
+
+	return &v1beta1.Auth{
+		Token: (*v1beta1.TokenAuth)(src.Token.DeepCopy()),
+	}
+}
+
+func authFromHub(src *v1beta1.Auth) *Auth {
+	if src == nil {
+		return nil
+	}
+
+	return &Auth{
+		Token: (*TokenAuth)(src.Token.DeepCopy()),
+	}
+}
+
+func externalAccessToHub(src *ExternalAccess) *v1beta1.ExternalAccess {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	dst := &v1beta1.ExternalAccess{
+		Enabled:                     src.Enabled,
+		Gateway:                     src.Gateway,
+		Host:                        src.Host,
+		CredentialsRotationInterval: src.CredentialsRotationInterval,
+	}
+	if src.OIDC != nil {
+		dst.OIDC = &v1beta1.ExternalAccessOIDC{
+			IssuerURL:       src.OIDC.IssuerURL,
+			ClientID:        src.OIDC.ClientID,
+			ClientSecretRef: (*v1beta1.SecretReference)(src.OIDC.ClientSecretRef),
+			UsernameClaim:   src.OIDC.UsernameClaim,
+		}
+	}
+	return dst
+}
+
+func externalAccessFromHub(src *v1beta1.ExternalAccess) *ExternalAccess {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	dst := &ExternalAccess{
+		Enabled:                     src.Enabled,
+		Gateway:                     src.Gateway,
+		Host:                        src.Host,
+		CredentialsRotationInterval: src.CredentialsRotationInterval,
+	}
+	if src.OIDC != nil {
+		dst.OIDC = &ExternalAccessOIDC{
+			IssuerURL:       src.OIDC.IssuerURL,
+			ClientID:        src.OIDC.ClientID,
+			ClientSecretRef: (*SecretReference)(src.OIDC.ClientSecretRef),
+			UsernameClaim:   src.OIDC.UsernameClaim,
+		}
+	}
+	return dst
+}
+
+func credentialsToHub(src *Credentials) *v1beta1.Credentials {
+	if src == nil {
+		return nil
+	}
+
+	return &v1beta1.Credentials{
+		PerNamespace:     src.PerNamespace,
+		SecretRef:        (*v1beta1.SecretReference)(src.SecretRef.DeepCopy()),
+		RotationInterval: src.RotationInterval.DeepCopy(),
+	}
+}
+
+func credentialsFromHub(src *v1beta1.Credentials) *Credentials {
+	if src == nil {
+		return nil
+	}
+
+	return &Credentials{
+		PerNamespace:     src.PerNamespace,
+		SecretRef:        (*SecretReference)(src.SecretRef.DeepCopy()),
+		RotationInterval: src.RotationInterval.DeepCopy(),
+	}
+}
+
+func usersToHub(src []RegistryUser) []v1beta1.RegistryUser {
+	if src == nil {
+		return nil
+	}
+
+	dst := make([]v1beta1.RegistryUser, 0, len(src))
+	for _, user := range src {
+		dst = append(dst, v1beta1.RegistryUser{
+			Name:      user.Name,
+			SecretRef: v1beta1.SecretReference(user.SecretRef),
+		})
+	}
+	return dst
+}
+
+func usersFromHub(src []v1beta1.RegistryUser) []RegistryUser {
+	if src == nil {
+		return nil
+	}
+
+	dst := make([]RegistryUser, 0, len(src))
+	for _, user := range src {
+		dst = append(dst, RegistryUser{
+			Name:      user.Name,
+			SecretRef: SecretReference(user.SecretRef),
+		})
+	}
+	return dst
+}
+
+func retentionToHub(src *Retention) *v1beta1.Retention {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	dst := &v1beta1.Retention{Interval: src.Interval}
+	for _, policy := range src.Policies {
+		dst.Policies = append(dst.Policies, v1beta1.RetentionPolicy(policy))
+	}
+	return dst
+}
+
+func retentionFromHub(src *v1beta1.Retention) *Retention {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	dst := &Retention{Interval: src.Interval}
+	for _, policy := range src.Policies {
+		dst.Policies = append(dst.Policies, RetentionPolicy(policy))
+	}
+	return dst
+}
+
+func retentionStatusToHub(src *RetentionStatus) *v1beta1.RetentionStatus {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	dst := &v1beta1.RetentionStatus{LastRunTime: src.LastRunTime, Message: src.Message}
+	for _, policy := range src.Policies {
+		dst.Policies = append(dst.Policies, v1beta1.RetentionPolicyStatus(policy))
+	}
+	return dst
+}
+
+func retentionStatusFromHub(src *v1beta1.RetentionStatus) *RetentionStatus {
+	if src == nil {
+		return nil
+	}
+
+	src = src.DeepCopy()
+	dst := &RetentionStatus{LastRunTime: src.LastRunTime, Message: src.Message}
+	for _, policy := range src.Policies {
+		dst.Policies = append(dst.Policies, RetentionPolicyStatus(policy))
+	}
+	return dst
+}
+
+func copyConditions(conditions []metav1.Condition) []metav1.Condition {
+	if conditions == nil {
+		return nil
+	}
+
+	out := make([]metav1.Condition, len(conditions))
+	for i := range conditions {
+		conditions[i].DeepCopyInto(&out[i])
+	}
+	return out
+}
+
+func setConversionData(meta *metav1.ObjectMeta, data conversionData) error {
+	if data.isEmpty() {
+		removeConversionData(meta)
+		return nil
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "while marshalling conversion data")
+	}
+
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[ConversionDataAnnotation] = string(raw)
+	return nil
+}
+
+func popConversionData(meta *metav1.ObjectMeta) (conversionData, error) {
+	data := conversionData{}
+	raw, ok := meta.Annotations[ConversionDataAnnotation]
+	if !ok {
+		return data, nil
+	}
+
+	removeConversionData(meta)
+	if err := json.Unmarshal([]byte(raw), &data); err != nil {
+		return data, errors.Wrap(err, "while unmarshalling conversion data")
+	}
+	return data, nil
+}
+
+func removeConversionData(meta *metav1.ObjectMeta) {
+	delete(meta.Annotations, ConversionDataAnnotation)
+	if len(meta.Annotations) == 0 {
+		meta.Annotations = nil
+	}
+}
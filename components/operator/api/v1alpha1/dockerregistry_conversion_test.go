@@ -0,0 +1,249 @@
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1beta1"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestDockerRegistry_ConvertTo(t *testing.T) {
+	t.Run("convert storage backend to the union", func(t *testing.T) {
+		src := fixConversionDockerRegistry()
+
+		dst := &v1beta1.DockerRegistry{}
+		require.NoError(t, src.ConvertTo(dst))
+
+		require.Equal(t, &v1beta1.Storage{
+			Type:          v1beta1.StorageTypeS3,
+			DeleteEnabled: true,
+			S3:            &v1beta1.StorageS3{Bucket: "bucket", Region: "region", SecretName: "s3-secret"},
+		}, dst.Spec.Storage)
+		require.Equal(t, ptr.To(true), dst.Status.InternalAccess.Enabled)
+		require.Equal(t, ptr.To(false), dst.Status.ExternalAccess.Enabled)
+		require.Nil(t, dst.Status.DeleteEnabled)
+		require.Equal(t, int64(3), dst.Status.ObservedGeneration)
+		require.NotContains(t, dst.GetAnnotations(), ConversionDataAnnotation)
+	})
+
+	t.Run("convert storage without backend to filesystem", func(t *testing.T) {
+		src := fixConversionDockerRegistry()
+		src.Spec.Storage = &Storage{DeleteEnabled: true}
+
+		dst := &v1beta1.DockerRegistry{}
+		require.NoError(t, src.ConvertTo(dst))
+
+		require.Equal(t, &v1beta1.Storage{Type: v1beta1.StorageTypeFilesystem, DeleteEnabled: true}, dst.Spec.Storage)
+	})
+
+	t.Run("keep backend the operator uses when many are configured", func(t *testing.T) {
+		src := fixConversionDockerRegistry()
+		src.Spec.Storage.Azure = &StorageAzure{SecretName: "azure-secret"}
+
+		dst := &v1beta1.DockerRegistry{}
+		require.NoError(t, src.ConvertTo(dst))
+
+		require.Equal(t, v1beta1.StorageTypeAzure, dst.Spec.Storage.Type)
+		require.Nil(t, dst.Spec.Storage.S3)
+		require.Contains(t, dst.GetAnnotations(), ConversionDataAnnotation)
+	})
+}
+
+func TestDockerRegistry_RoundTrip(t *testing.T) {
+	tests := map[string]func(*DockerRegistry){
+		"regular dockerregistry": func(*DockerRegistry) {},
+		"empty dockerregistry": func(dr *DockerRegistry) {
+			*dr = DockerRegistry{}
+		},
+		"many storage backends": func(dr *DockerRegistry) {
+			dr.Spec.Storage.GCS = &StorageGCS{Bucket: "gcs-bucket", Chunksize: 5242880}
+			dr.Spec.Storage.PVC = &StoragePVC{Name: "pvc"}
+		},
+		"s3 options": func(dr *DockerRegistry) {
+			dr.Spec.Storage.S3.Rootdirectory = "/registry"
+			dr.Spec.Storage.S3.KeyID = "key"
+			dr.Spec.Storage.S3.Chunksize = 10485760
+			dr.Spec.Storage.S3.V4Auth = ptr.To(false)
+		},
+		"workload identity": func(dr *DockerRegistry) {
+			dr.Spec.Storage.S3.SecretName = ""
+			dr.Spec.Storage.S3.WorkloadIdentity = &S3WorkloadIdentity{RoleARN: "arn:aws:iam::123456789012:role/registry"}
+			dr.Status.StorageAuth = "workloadIdentity"
+		},
+		"storage migration": func(dr *DockerRegistry) {
+			dr.Spec.Storage.Migrate = true
+			dr.Status.StorageLocation = "filesystem"
+			dr.Status.StorageMigration = &StorageMigrationStatus{
+				Source:    "filesystem",
+				Target:    "s3:images",
+				Phase:     StorageMigrationRunning,
+				StartTime: &metav1.Time{Time: time.Date(2024, 6, 2, 5, 0, 0, 0, time.UTC)},
+				JobName:   "dockerregistry-migration-1717304400",
+				Attempts:  1,
+			}
+		},
+		"storage preflight check": func(dr *DockerRegistry) {
+			dr.Status.StorageChecksum = "5f2b51ca2fdc5baa"
+		},
+		"unexpected status flags": func(dr *DockerRegistry) {
+			dr.Status.InternalAccess.Enabled = "true"
+			dr.Status.DeleteEnabled = "unknown"
+		},
+	}
+	for name, modify := range tests {
+		t.Run(name, func(t *testing.T) {
+			src := fixConversionDockerRegistry()
+			modify(src)
+
+			hub := &v1beta1.DockerRegistry{}
+			require.NoError(t, src.DeepCopy().ConvertTo(hub))
+			dst := &DockerRegistry{}
+			require.NoError(t, dst.ConvertFrom(hub))
+
+			require.Equal(t, src, dst)
+		})
+	}
+
+	t.Run("hub round trip", func(t *testing.T) {
+		hub := &v1beta1.DockerRegistry{}
+		require.NoError(t, fixConversionDockerRegistry().ConvertTo(hub))
+
+		spoke := &DockerRegistry{}
+		require.NoError(t, spoke.ConvertFrom(hub.DeepCopy()))
+		dst := &v1beta1.DockerRegistry{}
+		require.NoError(t, spoke.ConvertTo(dst))
+
+		require.Equal(t, hub, dst)
+	})
+
+	t.Run("prefer storage changed through the hub over the kept one", func(t *testing.T) {
+		src := fixConversionDockerRegistry()
+		src.Spec.Storage.Azure = &StorageAzure{SecretName: "azure-secret"}
+
+		hub := &v1beta1.DockerRegistry{}
+		require.NoError(t, src.ConvertTo(hub))
+		hub.Spec.Storage = &v1beta1.Storage{
+			Type: v1beta1.StorageTypePVC,
+			PVC:  &v1beta1.StoragePVC{Name: "pvc"},
+		}
+		dst := &DockerRegistry{}
+		require.NoError(t, dst.ConvertFrom(hub))
+
+		require.Equal(t, &Storage{PVC: &StoragePVC{Name: "pvc"}}, dst.Spec.Storage)
+		require.NotContains(t, dst.GetAnnotations(), ConversionDataAnnotation)
+	})
+}
+
+func fixConversionDockerRegistry() *DockerRegistry {
+	return &DockerRegistry{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "default",
+			Namespace:   "kyma-system",
+			Generation:  3,
+			Annotations: map[string]string{"owner": "team"},
+		},
+		Spec: DockerRegistrySpec{
+			Storage: &Storage{
+				S3:            &StorageS3{Bucket: "bucket", Region: "region", SecretName: "s3-secret"},
+				DeleteEnabled: true,
+			},
+			ExternalAccess: &ExternalAccess{
+				Enabled: ptr.To(false),
+				Gateway: ptr.To("kyma-system/kyma-gateway"),
+				OIDC: &ExternalAccessOIDC{
+					IssuerURL:       "https://idp.example.com",
+					ClientID:        "docker-registry",
+					ClientSecretRef: &SecretReference{Name: "oidc-client"},
+					UsernameClaim:   "preferred_username",
+				},
+				CredentialsRotationInterval: &metav1.Duration{Duration: 168 * time.Hour},
+			},
+			Logging: &Logging{
+				Level:            ptr.To("debug"),
+				AccessLogEnabled: ptr.To(true),
+			},
+			HighAvailability: &HighAvailability{
+				Replicas: ptr.To[int32](3),
+			},
+			GarbageCollection: &GarbageCollection{
+				Schedule:       "0 3 * * 0",
+				DeleteUntagged: true,
+			},
+			Retention: &Retention{
+				Interval: &metav1.Duration{Duration: time.Hour},
+				Policies: []RetentionPolicy{
+					{
+						Name:          "ci",
+						Repositories:  []string{"ci/*"},
+						KeepLast:      ptr.To[int32](10),
+						MaxAge:        &metav1.Duration{Duration: 30 * 24 * time.Hour},
+						ProtectedTags: []string{"^v[0-9]+"},
+					},
+				},
+			},
+			Proxy: &Proxy{
+				RemoteURL:  "https://registry-1.docker.io",
+				SecretName: "docker-hub",
+				TTL:        &metav1.Duration{Duration: 24 * time.Hour},
+			},
+			Auth: &Auth{
+				Token: &TokenAuth{
+					TTL:                  &metav1.Duration{Duration: 5 * time.Minute},
+					KeyRotationInterval:  &metav1.Duration{Duration: 720 * time.Hour},
+					ServiceAccountTokens: true,
+				},
+			},
+			Credentials: &Credentials{
+				PerNamespace:     true,
+				SecretRef:        &SecretReference{Name: "registry-credentials"},
+				RotationInterval: &metav1.Duration{Duration: 2160 * time.Hour},
+			},
+			Users: []RegistryUser{
+				{Name: "ci", SecretRef: SecretReference{Name: "ci-password"}},
+				{Name: "robot", SecretRef: SecretReference{Name: "robot-password"}},
+			},
+		},
+		Status: DockerRegistryStatus{
+			InternalAccess: NetworkAccess{
+				Enabled:     "True",
+				SecretName:  "dockerregistry-config",
+				PushAddress: "dockerregistry.kyma-system.svc.cluster.local:5000",
+				PullAddress: "localhost:32137",
+			},
+			ExternalAccess: ExternalNetworkAccess{
+				NetworkAccess: NetworkAccess{Enabled: "False"},
+			},
+			Storage: "s3",
+			GarbageCollection: &GarbageCollectionStatus{
+				Schedule:         "0 3 * * 0",
+				LastScheduleTime: &metav1.Time{Time: time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC)},
+				JobName:          "dockerregistry-gc-1717297200",
+				Result:           GarbageCollectionSucceeded,
+				FreedBytes:       ptr.To[int64](1024),
+			},
+			Retention: &RetentionStatus{
+				LastRunTime: &metav1.Time{Time: time.Date(2024, 6, 2, 4, 0, 0, 0, time.UTC)},
+				Policies:    []RetentionPolicyStatus{{Name: "ci", DeletedTags: 3}},
+			},
+			Proxy: "https://registry-1.docker.io",
+			Credentials: &CredentialsStatus{
+				LastRotationTime:         &metav1.Time{Time: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)},
+				PreviousCredentialsValid: true,
+			},
+			ExternalCredentials: &CredentialsStatus{
+				LastRotationTime: &metav1.Time{Time: time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC)},
+			},
+			Auth:               "token",
+			StorageAuth:        "secret",
+			ObservedGeneration: 3,
+			State:              StateReady,
+			Served:             ServedTrue,
+			Conditions: []metav1.Condition{
+				{Type: string(ConditionTypeInstalled), Status: metav1.ConditionTrue, Reason: string(ConditionReasonInstalled)},
+			},
+		},
+	}
+}
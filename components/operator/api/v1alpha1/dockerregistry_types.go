@@ -31,6 +31,158 @@ type DockerRegistrySpec struct {
 
 	// Logging defines the logging configuration for docker-registry pods.
 	Logging *Logging `json:"logging,omitempty"`
+
+	// HighAvailability runs the registry with multiple replicas that share the storage.
+	// It requires an object storage backend (s3 / azure / gcs / btpObjectStore) or a ReadWriteMany PVC.
+	HighAvailability *HighAvailability `json:"highAvailability,omitempty"`
+
+	// GarbageCollection makes the operator run the registry garbage collector on a schedule.
+	// The registry is switched to the read-only mode while the garbage collector runs.
+	GarbageCollection *GarbageCollection `json:"garbageCollection,omitempty"`
+
+	// Retention makes the operator delete image tags that are not kept by the retention policies.
+	// It requires storage.deleteEnabled, the space of the deleted images is freed by the garbage collection.
+	Retention *Retention `json:"retention,omitempty"`
+
+	// Proxy turns the registry into a pull-through cache of an upstream registry.
+	// A registry in the proxy mode serves the upstream images only, pushes are rejected.
+	Proxy *Proxy `json:"proxy,omitempty"`
+
+	// Auth defines how clients authenticate to the registry.
+	// The registry checks the credentials from the access Secrets itself when it is not set.
+	Auth *Auth `json:"auth,omitempty"`
+
+	// Credentials defines the registry credentials the operator propagates to the namespaces.
+	Credentials *Credentials `json:"credentials,omitempty"`
+
+	// Users are the additional identities the registry accepts next to the credentials from the access Secrets.
+	// Every user can be revoked on its own by removing it from the list.
+	Users []RegistryUser `json:"users,omitempty"`
+}
+
+type RegistryUser struct {
+	// Name is the username, it must be unique and must not contain ':'.
+	Name string `json:"name"`
+
+	// SecretRef points at a Secret with the password key holding the password of the user.
+	SecretRef SecretReference `json:"secretRef"`
+}
+
+type Credentials struct {
+	// PerNamespace gives every namespace its own generated credentials instead of a copy of the registry credentials,
+	// so that the credentials leaked from one namespace can be revoked without touching the other namespaces.
+	// The credentials of a namespace are revoked with the dockerregistry.kyma-project.io/credentials-revoked=true label.
+	// Only the registry served from the docker-registry namespace propagates its credentials.
+	PerNamespace bool `json:"perNamespace,omitempty"`
+
+	// SecretRef points at a Secret with the username and password keys the registry credentials are taken from
+	// instead of the generated ones. The registry is rolled out and the credentials are propagated again
+	// whenever the Secret changes. Cannot be used with rotationInterval.
+	SecretRef *SecretReference `json:"secretRef,omitempty"`
+
+	// RotationInterval makes the operator replace the registry credentials periodically.
+	// The registry accepts the replaced credentials until the new ones are propagated to every namespace.
+	// The credentials are never rotated when it is not set.
+	RotationInterval *metav1.Duration `json:"rotationInterval,omitempty"`
+}
+
+type SecretReference struct {
+	// Name is the name of the Secret in the namespace of the DockerRegistry CR.
+	Name string `json:"name"`
+}
+
+type Auth struct {
+	// Token makes the registry accept only short-lived tokens signed by the operator.
+	// Clients exchange the credentials from the access Secrets for a token at the token server of the operator.
+	// The clients outside the cluster are accepted there only with the external access credentials or the oidc login.
+	Token *TokenAuth `json:"token,omitempty"`
+}
+
+type TokenAuth struct {
+	// TTL defines how long an issued token is valid.
+	// default: 5m
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+
+	// KeyRotationInterval defines how often the key the tokens are signed with is replaced.
+	// default: 720h
+	KeyRotationInterval *metav1.Duration `json:"keyRotationInterval,omitempty"`
+
+	// ServiceAccountTokens makes the token server accept projected ServiceAccount tokens as passwords.
+	// The tokens must be issued for the audience of the registry token service. They allow pulling every repository
+	// and pushing the repositories prefixed with the namespace of the ServiceAccount.
+	ServiceAccountTokens bool `json:"serviceAccountTokens,omitempty"`
+}
+
+type Proxy struct {
+	// RemoteURL is the URL of the upstream registry, for example https://registry-1.docker.io.
+	// +kubebuilder:validation:Pattern=`^https?://`
+	RemoteURL string `json:"remoteURL"`
+
+	// SecretName is the name of the Secret with the username and password for the upstream registry.
+	// The upstream registry is accessed anonymously when it is not set.
+	SecretName string `json:"secretName,omitempty"`
+
+	// TTL defines how long the cached images are kept before they are fetched from the upstream registry again.
+	// default: 168h
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+}
+
+type Retention struct {
+	// Interval defines how often the retention policies are applied.
+	// default: 1h
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Policies define which tags are kept, the policies are applied one by one.
+	// +kubebuilder:validation:MinItems=1
+	// +listType=map
+	// +listMapKey=name
+	Policies []RetentionPolicy `json:"policies"`
+}
+
+// RetentionPolicy deletes the tags of the selected repositories that are neither protected, nor among the KeepLast
+// newest tags, nor newer than MaxAge.
+// +kubebuilder:validation:XValidation:rule="has(self.keepLast) || has(self.maxAge)",message="keepLast or maxAge must be set"
+type RetentionPolicy struct {
+	// Name identifies the policy in the status and events.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Repositories select the repositories by glob patterns, for example "ci/*".
+	// All repositories are selected when it is empty.
+	Repositories []string `json:"repositories,omitempty"`
+
+	// KeepLast keeps the given number of the newest tags of every repository.
+	// +kubebuilder:validation:Minimum=0
+	KeepLast *int32 `json:"keepLast,omitempty"`
+
+	// MaxAge keeps the tags of images created within the given duration, for example "720h".
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+
+	// ProtectedTags are regular expressions, the tags that match any of them are never deleted.
+	ProtectedTags []string `json:"protectedTags,omitempty"`
+}
+
+type GarbageCollection struct {
+	// Schedule defines when the garbage collector runs, in the cron format, for example "0 3 * * 0".
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+
+	// DeleteUntagged deletes manifests that are not referenced by any tag.
+	DeleteUntagged bool `json:"deleteUntagged,omitempty"`
+
+	// DryRun only reports what would be deleted, nothing is removed from the storage.
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+type HighAvailability struct {
+	// Replicas defines the number of docker-registry replicas.
+	// default: 2
+	// +kubebuilder:validation:Minimum=2
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// TopologyKey defines the node label the replicas are spread across.
+	// default: kubernetes.io/hostname
+	TopologyKey *string `json:"topologyKey,omitempty"`
 }
 
 type Logging struct {
@@ -61,6 +213,32 @@ type ExternalAccess struct {
 	// Host defines address under which registry will be exposed
 	// should fit to at least one server defined in the gateway
 	Host *string `json:"host,omitempty"`
+
+	// OIDC makes the clients outside the cluster log in with the identities of an OpenID Connect provider instead
+	// of the registry credentials, which stay usable inside the cluster only. It requires the token authentication.
+	OIDC *ExternalAccessOIDC `json:"oidc,omitempty"`
+
+	// CredentialsRotationInterval makes the operator replace the external access credentials periodically.
+	// The replaced credentials are rejected right away, their copies outside the cluster can't be followed.
+	// The credentials are never rotated when it is not set, they are revoked by deleting the external access Secret.
+	CredentialsRotationInterval *metav1.Duration `json:"credentialsRotationInterval,omitempty"`
+}
+
+type ExternalAccessOIDC struct {
+	// IssuerURL is the URL of the identity provider, its discovery document is served under
+	// /.well-known/openid-configuration.
+	IssuerURL string `json:"issuerURL"`
+
+	// ClientID is the client the ID tokens must be issued for.
+	ClientID string `json:"clientID"`
+
+	// ClientSecretRef references the Secret with the client secret in the clientSecret key. The secret is sent with
+	// the password grant of a confidential client.
+	ClientSecretRef *SecretReference `json:"clientSecretRef,omitempty"`
+
+	// UsernameClaim is the claim of the ID token the user is named by in the registry tokens and the access policies.
+	// default: email
+	UsernameClaim string `json:"usernameClaim,omitempty"`
 }
 
 type Storage struct {
@@ -70,10 +248,34 @@ type Storage struct {
 	BTPObjectStore *StorageBTPObjectStore `json:"btpObjectStore,omitempty"`
 	PVC            *StoragePVC            `json:"pvc,omitempty"`
 	DeleteEnabled  bool                   `json:"deleteEnabled,omitempty"`
+
+	// Migrate makes the operator copy the images to the new storage when the backend, the bucket, the container or
+	// the claim changes. The registry serves the images from the old storage in the read-only mode until they are
+	// copied, the registry comes up empty on the new storage when it is not set.
+	Migrate bool `json:"migrate,omitempty"`
 }
 
 type StorageAzure struct {
-	SecretName string `json:"secretName"`
+	// SecretName is the name of the Secret with the account name, key and container.
+	// It is required unless workloadIdentity is set.
+	SecretName string `json:"secretName,omitempty"`
+
+	// WorkloadIdentity authenticates the registry with the Azure workload identity instead of the account key.
+	WorkloadIdentity *AzureWorkloadIdentity `json:"workloadIdentity,omitempty"`
+}
+
+type AzureWorkloadIdentity struct {
+	// ClientID is the client ID of the managed identity the registry ServiceAccount is federated with.
+	// +kubebuilder:validation:MinLength=1
+	ClientID string `json:"clientID"`
+
+	// AccountName is the name of the storage account.
+	// +kubebuilder:validation:MinLength=1
+	AccountName string `json:"accountName"`
+
+	// Container is the name of the blob container in the storage account.
+	// +kubebuilder:validation:MinLength=1
+	Container string `json:"container"`
 }
 
 type StorageAzureSecrets struct {
@@ -91,6 +293,15 @@ type StorageGCS struct {
 	SecretName    string `json:"secretName,omitempty"`
 	Rootdirectory string `json:"rootdirectory,omitempty"`
 	Chunksize     int    `json:"chunksize,omitempty"`
+
+	// WorkloadIdentity authenticates the registry with the GKE Workload Identity instead of the account key.
+	WorkloadIdentity *GCSWorkloadIdentity `json:"workloadIdentity,omitempty"`
+}
+
+type GCSWorkloadIdentity struct {
+	// ServiceAccount is the email of the Google service account the registry ServiceAccount acts as.
+	// +kubebuilder:validation:MinLength=1
+	ServiceAccount string `json:"serviceAccount"`
 }
 
 type StorageS3 struct {
@@ -100,6 +311,41 @@ type StorageS3 struct {
 	Encrypt        bool   `json:"encrypt,omitempty"`
 	Secure         bool   `json:"secure,omitempty"`
 	SecretName     string `json:"secretName,omitempty"`
+
+	// Rootdirectory is the prefix the registry data is stored under in the bucket.
+	Rootdirectory string `json:"rootdirectory,omitempty"`
+
+	// ForcePathStyle addresses the bucket in the URL path instead of the host name, as MinIO and Ceph RGW expect.
+	ForcePathStyle bool `json:"forcePathStyle,omitempty"`
+
+	// KeyID is the KMS key the objects are encrypted with on the server side, it requires encrypt.
+	KeyID string `json:"keyID,omitempty"`
+
+	// StorageClass is the S3 storage class of the stored objects.
+	// default: STANDARD
+	// +kubebuilder:validation:Enum=STANDARD;REDUCED_REDUNDANCY;STANDARD_IA;ONEZONE_IA;INTELLIGENT_TIERING;GLACIER_IR;NONE
+	StorageClass string `json:"storageClass,omitempty"`
+
+	// Chunksize is the size of the multipart upload parts in bytes, S3 accepts at least 5242880.
+	// default: 10485760
+	// +kubebuilder:validation:Minimum=5242880
+	Chunksize int64 `json:"chunksize,omitempty"`
+
+	// SkipVerify skips the verification of the TLS certificate of the regionEndpoint.
+	SkipVerify bool `json:"skipVerify,omitempty"`
+
+	// V4Auth signs the requests with the AWS signature version 4, some S3 compatible storages need version 2.
+	// default: true
+	V4Auth *bool `json:"v4Auth,omitempty"`
+
+	// WorkloadIdentity authenticates the registry with IRSA or the EKS Pod Identity instead of the access keys.
+	WorkloadIdentity *S3WorkloadIdentity `json:"workloadIdentity,omitempty"`
+}
+
+type S3WorkloadIdentity struct {
+	// RoleARN is the IAM role the registry ServiceAccount assumes with IRSA.
+	// Leave it empty for the EKS Pod Identity, which associates the role with the ServiceAccount in EKS.
+	RoleARN string `json:"roleARN,omitempty"`
 }
 
 type StorageS3Secrets struct {
@@ -107,6 +353,11 @@ type StorageS3Secrets struct {
 	SecretKey string `json:"secretKey"`
 }
 
+type ProxySecrets struct {
+	Username string
+	Password string
+}
+
 type StorageBTPObjectStore struct {
 	SecretName string `json:"secretName,omitempty"`
 }
@@ -145,6 +396,12 @@ const (
 	// deletion
 	ConditionTypeDeleted = ConditionType("Deleted")
 
+	// progress and errors of the storage migration
+	ConditionTypeStorageMigrated = ConditionType("StorageMigrated")
+
+	// result of the storage preflight check
+	ConditionTypeStorageReady = ConditionType("StorageReady")
+
 	ConditionReasonConfiguration            = ConditionReason("Configuration")
 	ConditionReasonConfigurationErr         = ConditionReason("ConfigurationErr")
 	ConditionReasonConfigured               = ConditionReason("Configured")
@@ -156,6 +413,13 @@ const (
 	ConditionReasonDeletion                 = ConditionReason("Deletion")
 	ConditionReasonDeletionErr              = ConditionReason("DeletionErr")
 	ConditionReasonDeleted                  = ConditionReason("Deleted")
+	ConditionReasonHandedOver               = ConditionReason("HandedOver")
+	ConditionReasonStorageMigration         = ConditionReason("StorageMigration")
+	ConditionReasonStorageMigrationErr      = ConditionReason("StorageMigrationErr")
+	ConditionReasonStorageMigrated          = ConditionReason("StorageMigrated")
+	ConditionReasonStorageMigrationCanceled = ConditionReason("StorageMigrationCanceled")
+	ConditionReasonStorageReady             = ConditionReason("StorageReady")
+	ConditionReasonStorageErr               = ConditionReason("StorageErr")
 
 	Finalizer = "dockerregistry-operator.kyma-project.io/deletion-hook"
 )
@@ -181,6 +445,109 @@ type NetworkAccess struct {
 	PullAddress string `json:"pullAddress,omitempty"`
 }
 
+type GarbageCollectionResult string
+
+const (
+	GarbageCollectionRunning   GarbageCollectionResult = "Running"
+	GarbageCollectionSucceeded GarbageCollectionResult = "Succeeded"
+	GarbageCollectionFailed    GarbageCollectionResult = "Failed"
+)
+
+type StorageMigrationPhase string
+
+const (
+	StorageMigrationRunning   StorageMigrationPhase = "Running"
+	StorageMigrationSucceeded StorageMigrationPhase = "Succeeded"
+	StorageMigrationCanceled  StorageMigrationPhase = "Canceled"
+)
+
+type StorageMigrationStatus struct {
+	// Source is the location of the storage the images are copied from.
+	Source string `json:"source"`
+
+	// Target is the location of the storage the images are copied to.
+	Target string `json:"target"`
+
+	// Phase signifies the state of the migration, its progress and errors are reported in the StorageMigrated condition.
+	// Value can be one of ("Running", "Succeeded", "Canceled").
+	// +kubebuilder:validation:Enum=Running;Succeeded;Canceled
+	Phase StorageMigrationPhase `json:"phase"`
+
+	// StartTime is the time the registry was switched to the read-only mode at.
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is the time the registry was switched over to the target storage at.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// JobName is the name of the Job of the last copy attempt.
+	JobName string `json:"jobName,omitempty"`
+
+	// Attempts is the number of the started copy Jobs, a failed copy is retried.
+	Attempts int32 `json:"attempts,omitempty"`
+}
+
+type CredentialsStatus struct {
+	// LastRotationTime is the time the registry credentials were last replaced at.
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+
+	// PreviousCredentialsValid signifies that the registry still accepts the replaced credentials,
+	// until the new ones are propagated to every namespace.
+	PreviousCredentialsValid bool `json:"previousCredentialsValid,omitempty"`
+}
+
+type GarbageCollectionStatus struct {
+	// Schedule is the schedule the next run was computed from.
+	Schedule string `json:"schedule,omitempty"`
+
+	// NextScheduleTime is the time the next run starts at.
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"`
+
+	// LastScheduleTime is the time the last run was started at.
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// LastRunTime is the time the last run finished at.
+	LastRunTime *metav1.Time `json:"lastRunTime,omitempty"`
+
+	// JobName is the name of the Job of the last run.
+	JobName string `json:"jobName,omitempty"`
+
+	// Result signifies the result of the last run.
+	// Value can be one of ("Running", "Succeeded", "Failed").
+	// +kubebuilder:validation:Enum=Running;Succeeded;Failed
+	Result GarbageCollectionResult `json:"result,omitempty"`
+
+	// FreedBytes is the storage space freed by the last run. It is only measured for the filesystem and pvc storages.
+	FreedBytes *int64 `json:"freedBytes,omitempty"`
+
+	// DeletedBlobs is the number of blobs the last run deleted, or would delete in the dry-run mode.
+	DeletedBlobs *int64 `json:"deletedBlobs,omitempty"`
+
+	// DeletedManifests is the number of manifests the last run deleted, or would delete in the dry-run mode.
+	DeletedManifests *int64 `json:"deletedManifests,omitempty"`
+
+	// Message contains details about a failed run.
+	Message string `json:"message,omitempty"`
+}
+
+type RetentionStatus struct {
+	// LastRunTime is the time the retention policies were last applied at.
+	LastRunTime *metav1.Time `json:"lastRunTime,omitempty"`
+
+	// Policies contain the results of the policies in the last run.
+	Policies []RetentionPolicyStatus `json:"policies,omitempty"`
+
+	// Message contains details about a failed run.
+	Message string `json:"message,omitempty"`
+}
+
+type RetentionPolicyStatus struct {
+	// Name is the name of the policy.
+	Name string `json:"name"`
+
+	// DeletedTags is the number of tags the policy deleted in the last run.
+	DeletedTags int64 `json:"deletedTags"`
+}
+
 type DockerRegistryStatus struct {
 	// InternalAccess contains the in-cluster access configuration of the DockerRegistry.
 	InternalAccess NetworkAccess `json:"internalAccess,omitempty"`
@@ -195,6 +562,43 @@ type DockerRegistryStatus struct {
 
 	DeleteEnabled string `json:"deleteEnabled,omitempty"`
 
+	// GarbageCollection contains the state of the scheduled garbage collection.
+	GarbageCollection *GarbageCollectionStatus `json:"garbageCollection,omitempty"`
+
+	// Retention contains the results of the tag retention policies.
+	Retention *RetentionStatus `json:"retention,omitempty"`
+
+	// Proxy is the URL of the upstream registry the pull-through cache serves.
+	Proxy string `json:"proxy,omitempty"`
+
+	// Credentials contains the state of the registry credentials rotation.
+	Credentials *CredentialsStatus `json:"credentials,omitempty"`
+
+	// ExternalCredentials contains the state of the external access credentials rotation, the replaced ones are
+	// never accepted after it.
+	ExternalCredentials *CredentialsStatus `json:"externalCredentials,omitempty"`
+
+	// Auth signifies how clients authenticate to the registry.
+	// Value can be one of ("htpasswd", "token").
+	Auth string `json:"auth,omitempty"`
+
+	// StorageAuth signifies how the registry authenticates to the object storage.
+	// Value can be one of ("secret", "workloadIdentity"), it is empty for the filesystem and pvc storages.
+	StorageAuth string `json:"storageAuth,omitempty"`
+
+	// StorageLocation identifies the storage the registry serves the images from, for example "s3:images/registry".
+	StorageLocation string `json:"storageLocation,omitempty"`
+
+	// StorageMigration contains the state of the last storage migration.
+	StorageMigration *StorageMigrationStatus `json:"storageMigration,omitempty"`
+
+	// StorageChecksum is the checksum of the storage configuration the last preflight check passed for,
+	// the storage is probed again when the configuration or its credentials change.
+	StorageChecksum string `json:"storageChecksum,omitempty"`
+
+	// ObservedGeneration is the generation of the spec the status was last computed for.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
 	// State signifies current state of DockerRegistry.
 	// Value can be one of ("Ready", "Processing", "Error", "Deleting", "Warning").
 	// +kubebuilder:validation:Enum=Processing;Deleting;Ready;Error;Warning
@@ -1,6 +1,8 @@
 package v1alpha1
 
 import (
+	"time"
+
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -42,7 +44,113 @@ func (s *DockerRegistry) StorageSecretName() string {
 	}
 }
 
+// ProxySecretName returns the name of the Secret holding the upstream registry credentials of the
+// pull-through cache, or an empty string if the proxy is not configured or pulls anonymously.
+func (s *DockerRegistry) ProxySecretName() string {
+	if s.Spec.Proxy == nil {
+		return ""
+	}
+	return s.Spec.Proxy.SecretName
+}
+
+// PerNamespaceCredentials tells if every namespace gets its own registry credentials
+func (s *DockerRegistry) PerNamespaceCredentials() bool {
+	return s.Spec.Credentials != nil && s.Spec.Credentials.PerNamespace
+}
+
+// CredentialsSecretName returns the name of the user-owned Secret the registry credentials are taken from, or an
+// empty string if the operator generates them.
+func (s *DockerRegistry) CredentialsSecretName() string {
+	if s.Spec.Credentials == nil || s.Spec.Credentials.SecretRef == nil {
+		return ""
+	}
+	return s.Spec.Credentials.SecretRef.Name
+}
+
+// HasUserSecret tells if the Secret holds the password of any of the users listed in the CR
+func (s *DockerRegistry) HasUserSecret(name string) bool {
+	for _, user := range s.Spec.Users {
+		if user.SecretRef.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CredentialsRotationInterval returns how often the registry credentials are replaced, zero when they are never
+// rotated
+func (s *DockerRegistry) CredentialsRotationInterval() time.Duration {
+	if s.Spec.Credentials == nil || s.Spec.Credentials.RotationInterval == nil {
+		return 0
+	}
+	return s.Spec.Credentials.RotationInterval.Duration
+}
+
+// ExternalCredentialsRotationInterval returns how often the external access credentials are replaced, zero when
+// they are never rotated
+func (s *DockerRegistry) ExternalCredentialsRotationInterval() time.Duration {
+	externalAccess := s.Spec.ExternalAccess
+	if externalAccess == nil || externalAccess.CredentialsRotationInterval == nil {
+		return 0
+	}
+	return externalAccess.CredentialsRotationInterval.Duration
+}
+
+// TokenAuth tells if the registry checks the tokens of the operator token server instead of its htpasswd file
+func (s *DockerRegistry) TokenAuth() bool {
+	return s.Spec.Auth != nil && s.Spec.Auth.Token != nil
+}
+
+// ExternalOIDC returns the identity provider the clients outside the cluster log in with, or nil if the registry is
+// not exposed or the external clients use the registry credentials.
+func (s *DockerRegistry) ExternalOIDC() *ExternalAccessOIDC {
+	externalAccess := s.Spec.ExternalAccess
+	if externalAccess == nil || externalAccess.Enabled == nil || !*externalAccess.Enabled {
+		return nil
+	}
+	return externalAccess.OIDC
+}
+
+// GetUsernameClaim returns the claim of the ID token the users are named by.
+func (o *ExternalAccessOIDC) GetUsernameClaim() string {
+	if o == nil || o.UsernameClaim == "" {
+		return DefaultOIDCUsernameClaim
+	}
+	return o.UsernameClaim
+}
+
+// GetTTL returns how long the issued tokens are valid.
+func (t *TokenAuth) GetTTL() time.Duration {
+	if t == nil || t.TTL == nil || t.TTL.Duration <= 0 {
+		return DefaultTokenTTL
+	}
+	return t.TTL.Duration
+}
+
+// GetKeyRotationInterval returns how often the token signing key is replaced.
+func (t *TokenAuth) GetKeyRotationInterval() time.Duration {
+	if t == nil || t.KeyRotationInterval == nil || t.KeyRotationInterval.Duration <= 0 {
+		return DefaultTokenKeyRotationInterval
+	}
+	return t.KeyRotationInterval.Duration
+}
+
+const (
+	DefaultTokenTTL                 = 5 * time.Minute
+	DefaultTokenKeyRotationInterval = 720 * time.Hour
+)
+
+const DefaultOIDCUsernameClaim = "email"
+
 const (
 	DefaultEnableInternal = false
 	EndpointDisabled      = ""
 )
+
+// IsActive tells if the credentials of the grant are valid at the given time
+func (g *RegistryAccessGrant) IsActive(now time.Time) bool {
+	return g.GetDeletionTimestamp().IsZero() &&
+		g.Status.State == GrantStateActive &&
+		g.Status.ExpirationTime != nil &&
+		now.Before(g.Status.ExpirationTime.Time)
+}
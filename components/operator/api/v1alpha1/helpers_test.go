@@ -2,8 +2,11 @@ package v1alpha1
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
 )
 
 func TestDockerRegistry_StorageSecretName(t *testing.T) {
@@ -49,3 +52,290 @@ func TestDockerRegistry_StorageSecretName(t *testing.T) {
 		})
 	}
 }
+
+func TestDockerRegistry_ProxySecretName(t *testing.T) {
+	testCases := map[string]struct {
+		proxy    *Proxy
+		expected string
+	}{
+		"no proxy": {
+			proxy:    nil,
+			expected: "",
+		},
+		"proxy with credentials": {
+			proxy:    &Proxy{RemoteURL: "https://registry-1.docker.io", SecretName: "docker-hub"},
+			expected: "docker-hub",
+		},
+		"anonymous proxy": {
+			proxy:    &Proxy{RemoteURL: "https://registry-1.docker.io"},
+			expected: "",
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			instance := &DockerRegistry{Spec: DockerRegistrySpec{Proxy: testCase.proxy}}
+
+			require.Equal(t, testCase.expected, instance.ProxySecretName())
+		})
+	}
+}
+
+func TestDockerRegistry_PerNamespaceCredentials(t *testing.T) {
+	testCases := map[string]struct {
+		credentials *Credentials
+		expected    bool
+	}{
+		"no credentials": {
+			credentials: nil,
+			expected:    false,
+		},
+		"shared credentials": {
+			credentials: &Credentials{},
+			expected:    false,
+		},
+		"per-namespace credentials": {
+			credentials: &Credentials{PerNamespace: true},
+			expected:    true,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			instance := &DockerRegistry{Spec: DockerRegistrySpec{Credentials: testCase.credentials}}
+
+			require.Equal(t, testCase.expected, instance.PerNamespaceCredentials())
+		})
+	}
+}
+
+func TestDockerRegistry_CredentialsSecretName(t *testing.T) {
+	testCases := map[string]struct {
+		credentials *Credentials
+		expected    string
+	}{
+		"no credentials": {
+			credentials: nil,
+			expected:    "",
+		},
+		"generated credentials": {
+			credentials: &Credentials{PerNamespace: true},
+			expected:    "",
+		},
+		"user-owned credentials": {
+			credentials: &Credentials{SecretRef: &SecretReference{Name: "registry-credentials"}},
+			expected:    "registry-credentials",
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			instance := &DockerRegistry{Spec: DockerRegistrySpec{Credentials: testCase.credentials}}
+
+			require.Equal(t, testCase.expected, instance.CredentialsSecretName())
+		})
+	}
+}
+
+func TestDockerRegistry_HasUserSecret(t *testing.T) {
+	instance := &DockerRegistry{Spec: DockerRegistrySpec{Users: []RegistryUser{
+		{Name: "ci", SecretRef: SecretReference{Name: "ci-password"}},
+		{Name: "robot", SecretRef: SecretReference{Name: "robot-password"}},
+	}}}
+
+	t.Run("find secret of any user", func(t *testing.T) {
+		require.True(t, instance.HasUserSecret("ci-password"))
+		require.True(t, instance.HasUserSecret("robot-password"))
+	})
+
+	t.Run("skip other secret", func(t *testing.T) {
+		require.False(t, instance.HasUserSecret("storage-secret"))
+		require.False(t, (&DockerRegistry{}).HasUserSecret("ci-password"))
+	})
+}
+
+func TestDockerRegistry_CredentialsRotationInterval(t *testing.T) {
+	testCases := map[string]struct {
+		credentials *Credentials
+		expected    time.Duration
+	}{
+		"no credentials": {
+			credentials: nil,
+			expected:    0,
+		},
+		"no rotation": {
+			credentials: &Credentials{PerNamespace: true},
+			expected:    0,
+		},
+		"rotation interval": {
+			credentials: &Credentials{RotationInterval: &metav1.Duration{Duration: 24 * time.Hour}},
+			expected:    24 * time.Hour,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			instance := &DockerRegistry{Spec: DockerRegistrySpec{Credentials: testCase.credentials}}
+
+			require.Equal(t, testCase.expected, instance.CredentialsRotationInterval())
+		})
+	}
+}
+
+func TestDockerRegistry_ExternalCredentialsRotationInterval(t *testing.T) {
+	testCases := map[string]struct {
+		externalAccess *ExternalAccess
+		expected       time.Duration
+	}{
+		"no external access": {
+			externalAccess: nil,
+			expected:       0,
+		},
+		"no rotation": {
+			externalAccess: &ExternalAccess{Enabled: ptr.To(true)},
+			expected:       0,
+		},
+		"rotation interval": {
+			externalAccess: &ExternalAccess{CredentialsRotationInterval: &metav1.Duration{Duration: 168 * time.Hour}},
+			expected:       168 * time.Hour,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			instance := &DockerRegistry{Spec: DockerRegistrySpec{ExternalAccess: testCase.externalAccess}}
+
+			require.Equal(t, testCase.expected, instance.ExternalCredentialsRotationInterval())
+		})
+	}
+}
+
+func TestDockerRegistry_TokenAuth(t *testing.T) {
+	testCases := map[string]struct {
+		auth     *Auth
+		expected bool
+	}{
+		"htpasswd authentication": {
+			auth:     nil,
+			expected: false,
+		},
+		"empty authentication": {
+			auth:     &Auth{},
+			expected: false,
+		},
+		"token authentication": {
+			auth:     &Auth{Token: &TokenAuth{}},
+			expected: true,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			instance := &DockerRegistry{Spec: DockerRegistrySpec{Auth: testCase.auth}}
+
+			require.Equal(t, testCase.expected, instance.TokenAuth())
+		})
+	}
+}
+
+func TestDockerRegistry_ExternalOIDC(t *testing.T) {
+	oidc := &ExternalAccessOIDC{IssuerURL: "https://idp.example.com", ClientID: "docker-registry"}
+	testCases := map[string]struct {
+		externalAccess *ExternalAccess
+		expected       *ExternalAccessOIDC
+	}{
+		"no external access": {
+			externalAccess: nil,
+			expected:       nil,
+		},
+		"disabled external access": {
+			externalAccess: &ExternalAccess{Enabled: ptr.To(false), OIDC: oidc},
+			expected:       nil,
+		},
+		"external access with registry credentials": {
+			externalAccess: &ExternalAccess{Enabled: ptr.To(true)},
+			expected:       nil,
+		},
+		"external access with oidc": {
+			externalAccess: &ExternalAccess{Enabled: ptr.To(true), OIDC: oidc},
+			expected:       oidc,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			instance := &DockerRegistry{Spec: DockerRegistrySpec{ExternalAccess: testCase.externalAccess}}
+
+			require.Equal(t, testCase.expected, instance.ExternalOIDC())
+		})
+	}
+}
+
+func TestExternalAccessOIDC_GetUsernameClaim(t *testing.T) {
+	t.Run("default to email", func(t *testing.T) {
+		require.Equal(t, DefaultOIDCUsernameClaim, (&ExternalAccessOIDC{}).GetUsernameClaim())
+	})
+
+	t.Run("use configured claim", func(t *testing.T) {
+		require.Equal(t, "preferred_username", (&ExternalAccessOIDC{UsernameClaim: "preferred_username"}).GetUsernameClaim())
+	})
+}
+
+func TestTokenAuth_defaults(t *testing.T) {
+	t.Run("default when token authentication is not configured", func(t *testing.T) {
+		var auth *TokenAuth
+
+		require.Equal(t, DefaultTokenTTL, auth.GetTTL())
+		require.Equal(t, DefaultTokenKeyRotationInterval, auth.GetKeyRotationInterval())
+	})
+
+	t.Run("use configured durations", func(t *testing.T) {
+		auth := &TokenAuth{
+			TTL:                 &metav1.Duration{Duration: time.Minute},
+			KeyRotationInterval: &metav1.Duration{Duration: 24 * time.Hour},
+		}
+
+		require.Equal(t, time.Minute, auth.GetTTL())
+		require.Equal(t, 24*time.Hour, auth.GetKeyRotationInterval())
+	})
+}
+
+func TestRegistryAccessGrant_IsActive(t *testing.T) {
+	now := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+	expiration := &metav1.Time{Time: now.Add(time.Hour)}
+
+	testCases := map[string]struct {
+		grant    RegistryAccessGrant
+		expected bool
+	}{
+		"active before expiration": {
+			grant:    RegistryAccessGrant{Status: RegistryAccessGrantStatus{State: GrantStateActive, ExpirationTime: expiration}},
+			expected: true,
+		},
+		"expired at expiration": {
+			grant:    RegistryAccessGrant{Status: RegistryAccessGrantStatus{State: GrantStateActive, ExpirationTime: &metav1.Time{Time: now}}},
+			expected: false,
+		},
+		"revoked": {
+			grant:    RegistryAccessGrant{Status: RegistryAccessGrantStatus{State: GrantStateExpired, ExpirationTime: expiration}},
+			expected: false,
+		},
+		"pending": {
+			grant:    RegistryAccessGrant{Status: RegistryAccessGrantStatus{State: GrantStatePending}},
+			expected: false,
+		},
+		"being deleted": {
+			grant: RegistryAccessGrant{
+				ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &metav1.Time{Time: now}},
+				Status:     RegistryAccessGrantStatus{State: GrantStateActive, ExpirationTime: expiration},
+			},
+			expected: false,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, testCase.expected, testCase.grant.IsActive(now))
+		})
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RequesterAnnotation is set by the admission webhook of the operator to the user who created the
+// RegistryAccessGrant, it can't be changed afterwards
+const RequesterAnnotation = "dockerregistry.kyma-project.io/requester"
+
+type GrantState string
+
+const (
+	// GrantStatePending is set while the registry can't serve the credentials yet
+	GrantStatePending GrantState = "Pending"
+	// GrantStateActive is set while the issued credentials are valid
+	GrantStateActive GrantState = "Active"
+	// GrantStateExpired is set once the credentials are revoked
+	GrantStateExpired GrantState = "Expired"
+	// GrantStateRejected is set for the grants no credentials are ever issued for
+	GrantStateRejected GrantState = "Rejected"
+)
+
+// RegistryAccessGrantSpec requests temporary credentials for the external host of the registry. The registry
+// served from the namespace of the grant is used, or the one served from the docker-registry namespace when there
+// is none.
+type RegistryAccessGrantSpec struct {
+	// Duration is how long the credentials are valid after they are issued, for example "8h".
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="duration is immutable"
+	Duration metav1.Duration `json:"duration"`
+}
+
+type RegistryAccessGrantStatus struct {
+	// State signifies the current state of the grant (Pending / Active / Expired / Rejected).
+	State GrantState `json:"state,omitempty"`
+
+	// Message explains the state.
+	Message string `json:"message,omitempty"`
+
+	// Requester is the user the credentials are issued to.
+	Requester string `json:"requester,omitempty"`
+
+	// RegistryNamespace is the namespace the registry the credentials are valid for is served from.
+	RegistryNamespace string `json:"registryNamespace,omitempty"`
+
+	// SecretName is the name of the Secret in the namespace of the grant the credentials are written to.
+	SecretName string `json:"secretName,omitempty"`
+
+	// Username is the username of the issued credentials.
+	Username string `json:"username,omitempty"`
+
+	// ExpirationTime is when the credentials are revoked.
+	ExpirationTime *metav1.Time `json:"expirationTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="State",type="string",JSONPath=".status.state"
+//+kubebuilder:printcolumn:name="Requester",type="string",JSONPath=".status.requester"
+//+kubebuilder:printcolumn:name="Expiration",type="date",JSONPath=".status.expirationTime"
+//+kubebuilder:printcolumn:name="age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// RegistryAccessGrant is the Schema for the registryaccessgrants API
+type RegistryAccessGrant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RegistryAccessGrantSpec   `json:"spec,omitempty"`
+	Status RegistryAccessGrantStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RegistryAccessGrantList contains a list of RegistryAccessGrant
+type RegistryAccessGrantList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RegistryAccessGrant `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RegistryAccessGrant{}, &RegistryAccessGrantList{})
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type PolicySubjectKind string
+
+const (
+	// PolicySubjectNamespace is the namespace the per-namespace credentials were generated for
+	PolicySubjectNamespace PolicySubjectKind = "Namespace"
+	// PolicySubjectUser is one of the users listed in the DockerRegistry CR
+	PolicySubjectUser PolicySubjectKind = "User"
+	// PolicySubjectOIDCUser is a user logged in with the oidc login, named by the username claim. The names are
+	// chosen by the identity provider, so they are kept apart from the users listed in the CR.
+	PolicySubjectOIDCUser PolicySubjectKind = "OIDCUser"
+	// PolicySubjectServiceAccount is a ServiceAccount authenticated with its token
+	PolicySubjectServiceAccount PolicySubjectKind = "ServiceAccount"
+)
+
+// RegistryAccessPolicySpec defines which repositories the subjects may access in the registry served from the
+// namespace of the policy. A subject named in any policy gets only the actions the rules of its policies grant,
+// the subjects not named in any policy keep their access.
+type RegistryAccessPolicySpec struct {
+	// Subjects are the identities the rules apply to.
+	// +kubebuilder:validation:MinItems=1
+	Subjects []PolicySubject `json:"subjects"`
+
+	// Rules grant the repository actions, the subjects get the actions of every rule matching the repository.
+	// The rules only narrow the access the credentials have, they never grant more.
+	// +kubebuilder:validation:MinItems=1
+	Rules []PolicyRule `json:"rules"`
+}
+
+type PolicySubject struct {
+	// Kind is the kind of the subject (Namespace / User / OIDCUser / ServiceAccount).
+	// +kubebuilder:validation:Enum=Namespace;User;OIDCUser;ServiceAccount
+	Kind PolicySubjectKind `json:"kind"`
+
+	// Name is the name of the namespace, the user, the oidc user or the ServiceAccount.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Namespace is the namespace of the ServiceAccount, it is ignored for the other kinds.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type PolicyRule struct {
+	// Repositories select the repositories by glob patterns, for example "team-a/*".
+	// +kubebuilder:validation:MinItems=1
+	Repositories []string `json:"repositories"`
+
+	// Actions are the repository actions granted (pull / push / delete).
+	// +kubebuilder:validation:MinItems=1
+	// +kubebuilder:validation:items:Enum=pull;push;delete
+	Actions []string `json:"actions"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// RegistryAccessPolicy is the Schema for the registryaccesspolicies API
+type RegistryAccessPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RegistryAccessPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RegistryAccessPolicyList contains a list of RegistryAccessPolicy
+type RegistryAccessPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RegistryAccessPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RegistryAccessPolicy{}, &RegistryAccessPolicyList{})
+}
@@ -21,12 +21,14 @@ import (
 
 	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
 	"github.com/kyma-project/docker-registry/components/operator/internal/predicate"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
 	"github.com/kyma-project/docker-registry/components/operator/internal/state"
 	"github.com/kyma-project/docker-registry/components/operator/internal/tracing"
 	"github.com/kyma-project/manager-toolkit/installation/chart"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
@@ -44,12 +46,12 @@ type dockerRegistryReconciler struct {
 	log              *zap.SugaredLogger
 }
 
-func NewDockerRegistryReconciler(client client.Client, config *rest.Config, recorder record.EventRecorder, log *zap.SugaredLogger, chartPath string) *dockerRegistryReconciler {
+func NewDockerRegistryReconciler(client client.Client, config *rest.Config, recorder record.EventRecorder, log *zap.SugaredLogger, chartPath string, tokenServer, storageAdapter types.NamespacedName) *dockerRegistryReconciler {
 	cache := chart.NewSecretManifestCache(client)
 
 	return &dockerRegistryReconciler{
 		initStateMachine: func(log *zap.SugaredLogger) state.StateReconciler {
-			return state.NewMachine(client, config, recorder, log, cache, chartPath)
+			return state.NewMachine(client, config, recorder, log, cache, chartPath, tokenServer, storageAdapter)
 		},
 		client: client,
 		log:    log,
@@ -76,7 +78,10 @@ func (sr *dockerRegistryReconciler) SetupWithManager(mgr ctrl.Manager) error {
 }
 
 // mapStorageSecretToDockerRegistryCRs enqueues every DockerRegistry CR that references the given
-// Secret as its external storage credentials, so that creating or rotating the Secret is picked up.
+// Secret as its external storage, upstream proxy or registry credentials, or as the password of
+// one of its users, so that creating or rotating the Secret is picked up. The Secret with the
+// per-namespace credentials is mapped too, the registry has to be rolled when a namespace gets or
+// loses its credentials.
 func (sr *dockerRegistryReconciler) mapStorageSecretToDockerRegistryCRs(ctx context.Context, secret client.Object) []ctrl.Request {
 	log := sr.log.With("watcher", "storage_secret")
 
@@ -87,13 +92,17 @@ func (sr *dockerRegistryReconciler) mapStorageSecretToDockerRegistryCRs(ctx cont
 		return nil
 	}
 
+	namespaceCredentialsSecretName := registry.NewResourceNames(secret.GetNamespace()).NamespaceCredentialsSecretName
 	requests := []ctrl.Request{}
 	for _, dockerRegistry := range list.Items {
-		if dockerRegistry.StorageSecretName() != secret.GetName() {
+		namespaceCredentials := dockerRegistry.PerNamespaceCredentials() && namespaceCredentialsSecretName == secret.GetName()
+		if dockerRegistry.StorageSecretName() != secret.GetName() && dockerRegistry.ProxySecretName() != secret.GetName() &&
+			dockerRegistry.CredentialsSecretName() != secret.GetName() && !dockerRegistry.HasUserSecret(secret.GetName()) &&
+			!namespaceCredentials {
 			continue
 		}
 
-		log.Debugf("retriggering reconciliation for DockerRegistry %s/%s referencing storage secret %s",
+		log.Debugf("retriggering reconciliation for DockerRegistry %s/%s referencing credentials secret %s",
 			dockerRegistry.GetNamespace(), dockerRegistry.GetName(), secret.GetName())
 		requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKey{
 			Namespace: dockerRegistry.GetNamespace(),
@@ -14,7 +14,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-// storageSecretSource watches the Secrets holding external storage credentials.
+// storageSecretSource watches the Secrets holding external storage credentials, and the upstream
+// registry credentials of the pull-through cache, the user-owned registry credentials and the
+// passwords of the registry users, which are handled the same way.
 //
 // Those Secrets belong to the user, so they carry no operator labels and cannot be part of the
 // manager cache, which is restricted to the credentials Secrets this operator propagates. They get
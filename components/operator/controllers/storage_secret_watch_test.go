@@ -29,6 +29,7 @@ func Test_mapStorageSecretToDockerRegistryCRs(t *testing.T) {
 
 	testCases := map[string]struct {
 		objects  []client.Object
+		secret   *corev1.Secret
 		expected []ctrl.Request
 	}{
 		"enqueues the CR referencing the secret": {
@@ -55,6 +56,70 @@ func Test_mapStorageSecretToDockerRegistryCRs(t *testing.T) {
 				{NamespacedName: client.ObjectKey{Namespace: "docker-registry", Name: "btp"}},
 			},
 		},
+		"enqueues the CR referencing the secret as proxy credentials": {
+			objects: []client.Object{
+				&v1alpha1.DockerRegistry{
+					ObjectMeta: metav1.ObjectMeta{Name: "proxy", Namespace: "docker-registry"},
+					Spec: v1alpha1.DockerRegistrySpec{
+						Proxy: &v1alpha1.Proxy{RemoteURL: "https://registry-1.docker.io", SecretName: "storage-secret"},
+					},
+				},
+			},
+			expected: []ctrl.Request{
+				{NamespacedName: client.ObjectKey{Namespace: "docker-registry", Name: "proxy"}},
+			},
+		},
+		"enqueues the CR referencing the secret as registry credentials": {
+			objects: []client.Object{
+				&v1alpha1.DockerRegistry{
+					ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "docker-registry"},
+					Spec: v1alpha1.DockerRegistrySpec{
+						Credentials: &v1alpha1.Credentials{SecretRef: &v1alpha1.SecretReference{Name: "storage-secret"}},
+					},
+				},
+			},
+			expected: []ctrl.Request{
+				{NamespacedName: client.ObjectKey{Namespace: "docker-registry", Name: "default"}},
+			},
+		},
+		"enqueues the CR referencing the secret as user password": {
+			objects: []client.Object{
+				&v1alpha1.DockerRegistry{
+					ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "docker-registry"},
+					Spec: v1alpha1.DockerRegistrySpec{
+						Users: []v1alpha1.RegistryUser{{Name: "ci", SecretRef: v1alpha1.SecretReference{Name: "storage-secret"}}},
+					},
+				},
+			},
+			expected: []ctrl.Request{
+				{NamespacedName: client.ObjectKey{Namespace: "docker-registry", Name: "default"}},
+			},
+		},
+		"enqueues the CR with per-namespace credentials": {
+			objects: []client.Object{
+				&v1alpha1.DockerRegistry{
+					ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "docker-registry"},
+					Spec: v1alpha1.DockerRegistrySpec{
+						Credentials: &v1alpha1.Credentials{PerNamespace: true},
+					},
+				},
+			},
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "dockerregistry-namespace-credentials", Namespace: "docker-registry"},
+			},
+			expected: []ctrl.Request{
+				{NamespacedName: client.ObjectKey{Namespace: "docker-registry", Name: "default"}},
+			},
+		},
+		"skips a CR with shared credentials": {
+			objects: []client.Object{
+				dockerRegistry("default", "docker-registry", nil),
+			},
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "dockerregistry-namespace-credentials", Namespace: "docker-registry"},
+			},
+			expected: []ctrl.Request{},
+		},
 		"skips a CR referencing another secret": {
 			objects: []client.Object{
 				dockerRegistry("default", "docker-registry", &v1alpha1.Storage{
@@ -93,7 +158,12 @@ func Test_mapStorageSecretToDockerRegistryCRs(t *testing.T) {
 				log:    zap.NewNop().Sugar(),
 			}
 
-			requests := reconciler.mapStorageSecretToDockerRegistryCRs(context.Background(), secret)
+			givenSecret := secret
+			if testCase.secret != nil {
+				givenSecret = testCase.secret
+			}
+
+			requests := reconciler.mapStorageSecretToDockerRegistryCRs(context.Background(), givenSecret)
 
 			require.ElementsMatch(t, testCase.expected, requests)
 		})
@@ -24,6 +24,7 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	uberzap "go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/record"
@@ -97,7 +98,9 @@ var _ = BeforeSuite(func() {
 		k8sManager.GetConfig(),
 		record.NewFakeRecorder(100),
 		reconcilerLogger.Sugar(),
-		chartPath)).
+		chartPath,
+		types.NamespacedName{Name: "dockerregistry-token-server", Namespace: "docker-registry"},
+		types.NamespacedName{Name: "dockerregistry-storage-adapter", Namespace: "docker-registry"})).
 		SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
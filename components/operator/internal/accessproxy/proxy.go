@@ -0,0 +1,226 @@
+package accessproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBindAddress = ":5000"
+	defaultUpstream    = "http://127.0.0.1:5002"
+
+	readHeaderTimeout = 10 * time.Second
+	shutdownTimeout   = 10 * time.Second
+
+	// deniedCode is the error code of the registry API the clients report for the rejected requests
+	deniedCode = "DENIED"
+)
+
+type registryError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type errorResponse struct {
+	Errors []registryError `json:"errors"`
+}
+
+// Proxy forwards the requests to the registry in the same pod. The htpasswd authentication of the registry accepts
+// every user it lists for every action, so the proxy rejects the requests that change the registry with the pull-only
+// credentials, and the ones of the per-namespace credentials outside the repositories of their namespace. The
+// passwords are still checked by the registry.
+type Proxy struct {
+	pullUsername string
+	// namespaces holds the namespace of every per-namespace username
+	namespaces map[string]string
+	upstream   http.Handler
+	log        *zap.SugaredLogger
+}
+
+func NewProxy(upstream *url.URL, pullUsername string, namespaces map[string]string, log *zap.SugaredLogger) *Proxy {
+	proxy := &Proxy{
+		pullUsername: pullUsername,
+		namespaces:   namespaces,
+		log:          log.Named("access-proxy"),
+	}
+
+	// the Host and the X-Forwarded headers are kept, the registry builds the upload locations from them
+	reverseProxy := httputil.NewSingleHostReverseProxy(upstream)
+	if upstream.Scheme == "https" {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// the registry listens on the loopback address of the pod, its certificate is issued for the Service
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+		reverseProxy.Transport = transport
+	}
+	reverseProxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		proxy.log.Warnf("failed to forward request %s %s: %s", r.Method, r.URL.Path, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	proxy.upstream = reverseProxy
+	return proxy
+}
+
+// Run serves the proxy until the context is done. The credentials are read once, the registry is rolled out
+// whenever they change, as it reads its htpasswd file only on start too.
+func Run(ctx context.Context, args []string, log *zap.SugaredLogger) error {
+	flags := flag.NewFlagSet(registry.AccessProxyCommand, flag.ContinueOnError)
+	addr := flags.String("bind-address", defaultBindAddress, "The address the proxy binds to.")
+	upstreamAddr := flags.String("upstream", defaultUpstream, "URL of the registry the requests are forwarded to.")
+	pullUsernameFile := flags.String("pull-username-file", "", "Path of the file with the username of the pull-only credentials.")
+	namespaceCredentialsDir := flags.String("namespace-credentials", "", "Directory with the htpasswd entries of the per-namespace credentials, one file per namespace.")
+	tlsCert := flags.String("tls-cert", "", "Path of the certificate the proxy serves, the requests are served over plain http without it.")
+	tlsKey := flags.String("tls-key", "", "Path of the key of the served certificate.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	upstream, err := url.Parse(*upstreamAddr)
+	if err != nil {
+		return errors.Wrap(err, "while parsing upstream address")
+	}
+	pullUsername, err := readPullUsername(*pullUsernameFile)
+	if err != nil {
+		return err
+	}
+	namespaces, err := readNamespaceCredentials(*namespaceCredentialsDir)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           NewProxy(upstream, pullUsername, namespaces, log),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Infof("serving access proxy on %s for %s", *addr, upstream)
+		if *tlsCert != "" {
+			errs <- server.ListenAndServeTLS(*tlsCert, *tlsKey)
+			return
+		}
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return errors.Wrap(err, "while serving access proxy")
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if message := p.denied(r); message != "" {
+		writeDenied(w, message)
+		return
+	}
+	p.upstream.ServeHTTP(w, r)
+}
+
+// denied returns why the request is rejected, the reads and the requests without credentials are left to the
+// registry
+func (p *Proxy) denied(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return ""
+	}
+	username, _, ok := r.BasicAuth()
+	if !ok {
+		return ""
+	}
+
+	if p.pullUsername != "" && username == p.pullUsername {
+		return "the pull-only credentials are not allowed to push or delete"
+	}
+	namespace, ok := p.namespaces[username]
+	if !ok {
+		return ""
+	}
+	// the registry resolves the path segments on its own, a path leaving the prefix with them is not forwarded
+	prefix := "/v2/" + namespace + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) || strings.Contains(r.URL.Path+"/", "/../") ||
+		strings.Contains(r.URL.Path+"/", "/./") {
+		return fmt.Sprintf("the credentials of namespace %s push only to the repositories prefixed with %s/", namespace, namespace)
+	}
+	return ""
+}
+
+func readPullUsername(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	username, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "while reading pull-only username")
+	}
+	return strings.TrimSpace(string(username)), nil
+}
+
+// readNamespaceCredentials returns the namespace of every username of the mounted per-namespace credentials, the
+// files are named after the namespaces
+func readNamespaceCredentials(dir string) (map[string]string, error) {
+	namespaces := map[string]string{}
+	if dir == "" {
+		return namespaces, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		// the Secret is optional, the registry starts without the per-namespace credentials
+		return namespaces, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing namespace credentials")
+	}
+
+	for _, entry := range entries {
+		// the Secret volume keeps its data in hidden directories and links the keys to them
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while reading credentials of namespace %s", entry.Name())
+		}
+		if info.IsDir() {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while reading credentials of namespace %s", entry.Name())
+		}
+		if username := registry.HtpasswdUsername(strings.TrimSpace(string(content))); username != "" {
+			namespaces[username] = entry.Name()
+		}
+	}
+	return namespaces, nil
+}
+
+func writeDenied(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	_ = json.NewEncoder(w).Encode(errorResponse{
+		Errors: []registryError{{Code: deniedCode, Message: message}},
+	})
+}
@@ -0,0 +1,171 @@
+package accessproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestProxy_ServeHTTP(t *testing.T) {
+	testCases := map[string]struct {
+		method         string
+		path           string
+		username       string
+		expectedStatus int
+	}{
+		"forward pull of pull-only credentials": {
+			method:         http.MethodGet,
+			path:           "/v2/team-a/app/manifests/latest",
+			username:       "pull-user",
+			expectedStatus: http.StatusOK,
+		},
+		"forward head of pull-only credentials": {
+			method:         http.MethodHead,
+			path:           "/v2/team-a/app/blobs/sha256:abc",
+			username:       "pull-user",
+			expectedStatus: http.StatusOK,
+		},
+		"deny push of pull-only credentials": {
+			method:         http.MethodPost,
+			path:           "/v2/team-a/app/blobs/uploads/",
+			username:       "pull-user",
+			expectedStatus: http.StatusForbidden,
+		},
+		"deny delete of pull-only credentials": {
+			method:         http.MethodDelete,
+			path:           "/v2/team-a/app/manifests/sha256:abc",
+			username:       "pull-user",
+			expectedStatus: http.StatusForbidden,
+		},
+		"forward push of registry credentials": {
+			method:         http.MethodPut,
+			path:           "/v2/team-b/app/manifests/latest",
+			username:       "push-user",
+			expectedStatus: http.StatusOK,
+		},
+		"forward push without credentials": {
+			method:         http.MethodPost,
+			path:           "/v2/team-a/app/blobs/uploads/",
+			expectedStatus: http.StatusOK,
+		},
+		"forward push of namespace credentials to their namespace": {
+			method:         http.MethodPost,
+			path:           "/v2/team-a/app/blobs/uploads/",
+			username:       "team-a",
+			expectedStatus: http.StatusOK,
+		},
+		"forward pull of namespace credentials from other namespace": {
+			method:         http.MethodGet,
+			path:           "/v2/team-b/app/manifests/latest",
+			username:       "team-a",
+			expectedStatus: http.StatusOK,
+		},
+		"deny push of namespace credentials to other namespace": {
+			method:         http.MethodPost,
+			path:           "/v2/team-b/app/blobs/uploads/",
+			username:       "team-a",
+			expectedStatus: http.StatusForbidden,
+		},
+		"deny push of namespace credentials to repository named like namespace": {
+			method:         http.MethodPost,
+			path:           "/v2/team-a-other/app/blobs/uploads/",
+			username:       "team-a",
+			expectedStatus: http.StatusForbidden,
+		},
+		"deny push of namespace credentials leaving their namespace": {
+			method:         http.MethodPost,
+			path:           "/v2/team-a/../team-b/app/blobs/uploads/",
+			username:       "team-a",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			upstream := fixRegistryServer(t)
+			proxy := NewProxy(upstream, "pull-user", map[string]string{"team-a": "team-a"}, zap.NewNop().Sugar())
+
+			req := httptest.NewRequest(testCase.method, testCase.path, nil)
+			if testCase.username != "" {
+				req.SetBasicAuth(testCase.username, "password")
+			}
+			resp := httptest.NewRecorder()
+			proxy.ServeHTTP(resp, req)
+
+			require.Equal(t, testCase.expectedStatus, resp.Code)
+		})
+	}
+
+	t.Run("report denied request in registry error format", func(t *testing.T) {
+		proxy := NewProxy(fixRegistryServer(t), "pull-user", nil, zap.NewNop().Sugar())
+
+		req := httptest.NewRequest(http.MethodPut, "/v2/team-a/app/manifests/latest", nil)
+		req.SetBasicAuth("pull-user", "password")
+		resp := httptest.NewRecorder()
+		proxy.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusForbidden, resp.Code)
+		require.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+		require.JSONEq(t, `{"errors":[{"code":"DENIED","message":"the pull-only credentials are not allowed to push or delete"}]}`,
+			resp.Body.String())
+	})
+
+	t.Run("keep host of request", func(t *testing.T) {
+		var host string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host = r.Host
+		}))
+		t.Cleanup(server.Close)
+		upstream, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		proxy := NewProxy(upstream, "pull-user", nil, zap.NewNop().Sugar())
+
+		req := httptest.NewRequest(http.MethodGet, "http://dockerregistry.docker-registry.svc.cluster.local:5000/v2/", nil)
+		resp := httptest.NewRecorder()
+		proxy.ServeHTTP(resp, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		require.Equal(t, "dockerregistry.docker-registry.svc.cluster.local:5000", host)
+	})
+}
+
+func Test_readNamespaceCredentials(t *testing.T) {
+	t.Run("read usernames of namespace credentials", func(t *testing.T) {
+		dir := t.TempDir()
+		entry, err := registry.HtpasswdEntry("team-a", "team-pass")
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "team-a"), []byte(entry), 0o600))
+		// the Secret volume keeps the data in hidden directories
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "..data"), 0o700))
+
+		namespaces, err := readNamespaceCredentials(dir)
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"team-a": "team-a"}, namespaces)
+	})
+
+	t.Run("skip missing namespace credentials", func(t *testing.T) {
+		namespaces, err := readNamespaceCredentials(filepath.Join(t.TempDir(), "missing"))
+
+		require.NoError(t, err)
+		require.Empty(t, namespaces)
+	})
+}
+
+func fixRegistryServer(t *testing.T) *url.URL {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+
+	upstream, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return upstream
+}
@@ -0,0 +1,270 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/validation"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// GrantExpirationKey holds the expiration time of the credentials in the Secret of a RegistryAccessGrant
+	GrantExpirationKey = "expirationTime"
+
+	grantPendingRequeueDuration = time.Minute
+
+	grantReasonIssued   = "Issued"
+	grantReasonExpired  = "Expired"
+	grantReasonPending  = "Pending"
+	grantReasonRejected = "Rejected"
+)
+
+// GrantReconciler issues the temporary credentials requested with the RegistryAccessGrants and revokes them at
+// expiry. The token server is what accepts them, so they are valid only for the registries with the token
+// authentication.
+type GrantReconciler struct {
+	Log      *zap.SugaredLogger
+	client   client.Client
+	recorder record.EventRecorder
+	now      func() time.Time
+}
+
+func NewGrant(client client.Client, log *zap.SugaredLogger, recorder record.EventRecorder) *GrantReconciler {
+	return &GrantReconciler{
+		client:   client,
+		Log:      log,
+		recorder: recorder,
+		now:      time.Now,
+	}
+}
+
+func (r *GrantReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("grant-controller").
+		For(&v1alpha1.RegistryAccessGrant{}).
+		Complete(r)
+}
+
+// Reconcile issues the credentials of a RegistryAccessGrant once and deletes their Secret when they expire
+// +kubebuilder:rbac:groups=operator.kyma-project.io,resources=registryaccessgrants,verbs=get;list;watch
+// +kubebuilder:rbac:groups=operator.kyma-project.io,resources=registryaccessgrants/status,verbs=get;update;patch
+
+func (r *GrantReconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
+	grant := &v1alpha1.RegistryAccessGrant{}
+	if err := r.client.Get(ctx, request.NamespacedName, grant); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	// the Secret is owned by the grant, so it is removed together with it
+	if !grant.GetDeletionTimestamp().IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	logger := r.Log.With("namespace", grant.GetNamespace(), "name", grant.GetName())
+
+	switch grant.Status.State {
+	case v1alpha1.GrantStateExpired, v1alpha1.GrantStateRejected:
+		return ctrl.Result{}, nil
+	case v1alpha1.GrantStateActive:
+		return r.revokeAtExpiry(ctx, logger, grant)
+	default:
+		return r.issue(ctx, logger, grant)
+	}
+}
+
+func (r *GrantReconciler) issue(ctx context.Context, logger *zap.SugaredLogger, grant *v1alpha1.RegistryAccessGrant) (ctrl.Result, error) {
+	requester := grant.GetAnnotations()[v1alpha1.RequesterAnnotation]
+	if requester == "" {
+		return ctrl.Result{}, r.reject(ctx, grant, "the requester is not recorded, the grant was created without the admission webhook of the operator")
+	}
+
+	duration := grant.Spec.Duration.Duration
+	if err := validation.AccessGrantDuration(duration); err != nil {
+		return ctrl.Result{}, r.reject(ctx, grant, err.Error())
+	}
+
+	instance, err := r.grantRegistry(ctx, grant.GetNamespace())
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if reason := registryNotReadyForGrants(instance); reason != "" {
+		return ctrl.Result{RequeueAfter: grantPendingRequeueDuration}, r.pend(ctx, grant, reason)
+	}
+
+	username, err := registry.GenerateUsername()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	password, err := registry.GeneratePassword()
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	expiration := metav1.NewTime(r.now().Add(duration).Truncate(time.Second))
+
+	host := instance.Status.ExternalAccess.PushAddress
+	if err := r.writeSecret(ctx, grant, host, username, password, expiration); err != nil {
+		if errors.Is(err, errSecretNotOwned) {
+			return ctrl.Result{}, r.reject(ctx, grant, err.Error())
+		}
+		return ctrl.Result{}, err
+	}
+
+	grant.Status = v1alpha1.RegistryAccessGrantStatus{
+		State:             v1alpha1.GrantStateActive,
+		Message:           fmt.Sprintf("credentials for %s are valid until %s", host, expiration.UTC().Format(time.RFC3339)),
+		Requester:         requester,
+		RegistryNamespace: instance.GetNamespace(),
+		SecretName:        grant.GetName(),
+		Username:          username,
+		ExpirationTime:    &expiration,
+	}
+	if err := r.client.Status().Update(ctx, grant); err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "while updating registryaccessgrant status")
+	}
+
+	logger.Infof("issued credentials for %s to %s until %s", host, requester, expiration.UTC().Format(time.RFC3339))
+	r.recorder.Eventf(grant, corev1.EventTypeNormal, grantReasonIssued,
+		"Credentials for %s issued to %s, valid until %s", host, requester, expiration.UTC().Format(time.RFC3339))
+
+	return ctrl.Result{RequeueAfter: duration}, nil
+}
+
+func (r *GrantReconciler) revokeAtExpiry(ctx context.Context, logger *zap.SugaredLogger, grant *v1alpha1.RegistryAccessGrant) (ctrl.Result, error) {
+	now := r.now()
+	if grant.IsActive(now) {
+		return ctrl.Result{RequeueAfter: grant.Status.ExpirationTime.Sub(now)}, nil
+	}
+
+	// the token server stops accepting the credentials at the expiration time on its own, the Secret is deleted so
+	// that no one keeps using it
+	secret := &corev1.Secret{}
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: grant.GetNamespace(), Name: grant.Status.SecretName}, secret)
+	if client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, errors.Wrap(err, "while fetching registryaccessgrant secret")
+	}
+	if err == nil && metav1.IsControlledBy(secret, grant) {
+		if err := r.client.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, errors.Wrap(err, "while deleting registryaccessgrant secret")
+		}
+	}
+
+	grant.Status.State = v1alpha1.GrantStateExpired
+	grant.Status.Message = "credentials expired and were revoked"
+	if err := r.client.Status().Update(ctx, grant); err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "while updating registryaccessgrant status")
+	}
+
+	expiration := grant.Status.ExpirationTime.UTC().Format(time.RFC3339)
+	logger.Infof("revoked credentials issued to %s at %s", grant.Status.Requester, expiration)
+	r.recorder.Eventf(grant, corev1.EventTypeNormal, grantReasonExpired,
+		"Credentials issued to %s expired at %s and were revoked", grant.Status.Requester, expiration)
+
+	return ctrl.Result{}, nil
+}
+
+// grantRegistry returns the registry served from the namespace of the grant, or the one served from the base
+// namespace when there is none
+func (r *GrantReconciler) grantRegistry(ctx context.Context, namespace string) (*v1alpha1.DockerRegistry, error) {
+	for _, candidate := range []string{namespace, registry.BaseNamespace} {
+		instances := v1alpha1.DockerRegistryList{}
+		if err := r.client.List(ctx, &instances, client.InNamespace(candidate)); err != nil {
+			return nil, errors.Wrap(err, "while listing dockerregistries")
+		}
+		for i := range instances.Items {
+			if instances.Items[i].Status.Served == v1alpha1.ServedTrue {
+				return &instances.Items[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// registryNotReadyForGrants explains why the registry can't accept the credentials of a grant yet
+func registryNotReadyForGrants(instance *v1alpha1.DockerRegistry) string {
+	switch {
+	case instance == nil:
+		return "no registry is served for the namespace"
+	case instance.Spec.Auth == nil || instance.Spec.Auth.Token == nil:
+		return fmt.Sprintf("the registry in namespace %s has no token authentication, temporary credentials can't be revoked without it", instance.GetNamespace())
+	case instance.Status.ExternalAccess.Enabled != "true" || instance.Status.ExternalAccess.PushAddress == "":
+		return fmt.Sprintf("the registry in namespace %s is not exposed", instance.GetNamespace())
+	default:
+		return ""
+	}
+}
+
+var errSecretNotOwned = errors.New("secret is not owned by the grant")
+
+func (r *GrantReconciler) writeSecret(ctx context.Context, grant *v1alpha1.RegistryAccessGrant, host, username, password string, expiration metav1.Time) error {
+	dockerConfig, err := json.Marshal(map[string]any{
+		"auths": map[string]any{
+			host: map[string]string{
+				"auth": base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+			},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "while encoding docker config")
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      grant.GetName(),
+			Namespace: grant.GetNamespace(),
+		},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.client, secret, func() error {
+		if secret.GetResourceVersion() != "" && !metav1.IsControlledBy(secret, grant) {
+			return errors.Wrapf(errSecretNotOwned, "secret %s already exists", secret.GetName())
+		}
+		secret.Type = corev1.SecretTypeDockerConfigJson
+		secret.Data = map[string][]byte{
+			"username":                 []byte(username),
+			"password":                 []byte(password),
+			"pushRegAddr":              []byte(host),
+			"pullRegAddr":              []byte(host),
+			GrantExpirationKey:         []byte(expiration.UTC().Format(time.RFC3339)),
+			corev1.DockerConfigJsonKey: dockerConfig,
+		}
+		return controllerutil.SetControllerReference(grant, secret, r.client.Scheme())
+	})
+	return errors.Wrap(err, "while writing registryaccessgrant secret")
+}
+
+func (r *GrantReconciler) pend(ctx context.Context, grant *v1alpha1.RegistryAccessGrant, message string) error {
+	if grant.Status.State == v1alpha1.GrantStatePending && grant.Status.Message == message {
+		return nil
+	}
+
+	grant.Status.State = v1alpha1.GrantStatePending
+	grant.Status.Message = message
+	if err := r.client.Status().Update(ctx, grant); err != nil {
+		return errors.Wrap(err, "while updating registryaccessgrant status")
+	}
+	r.recorder.Event(grant, corev1.EventTypeWarning, grantReasonPending, message)
+	return nil
+}
+
+func (r *GrantReconciler) reject(ctx context.Context, grant *v1alpha1.RegistryAccessGrant, message string) error {
+	grant.Status.State = v1alpha1.GrantStateRejected
+	grant.Status.Message = message
+	if err := r.client.Status().Update(ctx, grant); err != nil {
+		return errors.Wrap(err, "while updating registryaccessgrant status")
+	}
+	r.recorder.Event(grant, corev1.EventTypeWarning, grantReasonRejected, message)
+	return nil
+}
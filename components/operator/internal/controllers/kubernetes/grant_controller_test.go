@@ -0,0 +1,268 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+)
+
+const testGrantNamespace = "dev"
+
+func TestGrantReconciler_Reconcile(t *testing.T) {
+	now := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+
+	t.Run("issue credentials for the registry of the docker-registry namespace", func(t *testing.T) {
+		c := fixGrantClient(t, fixGrantRegistry(testBaseNamespace), fixRegistryAccessGrant(8*time.Hour))
+		reconciler, recorder := fixGrantReconciler(c, now)
+
+		result, err := reconciler.Reconcile(context.Background(), fixGrantRequest())
+
+		require.NoError(t, err)
+		require.Equal(t, 8*time.Hour, result.RequeueAfter)
+
+		grant := getGrant(t, c)
+		require.Equal(t, v1alpha1.GrantStateActive, grant.Status.State)
+		require.Equal(t, "dev@example.com", grant.Status.Requester)
+		require.Equal(t, testBaseNamespace, grant.Status.RegistryNamespace)
+		require.Equal(t, "laptop", grant.Status.SecretName)
+		require.Equal(t, now.Add(8*time.Hour), grant.Status.ExpirationTime.Time.UTC())
+		require.NotEmpty(t, grant.Status.Username)
+
+		secret := &corev1.Secret{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: testGrantNamespace, Name: "laptop"}, secret))
+		require.True(t, metav1.IsControlledBy(secret, grant))
+		require.Equal(t, corev1.SecretTypeDockerConfigJson, secret.Type)
+		require.Equal(t, grant.Status.Username, string(secret.Data["username"]))
+		require.Equal(t, "registry.example.com", string(secret.Data["pushRegAddr"]))
+		require.Equal(t, "2024-06-02T20:00:00Z", string(secret.Data[GrantExpirationKey]))
+
+		dockerConfig := map[string]map[string]map[string]string{}
+		require.NoError(t, json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &dockerConfig))
+		auth := base64.StdEncoding.EncodeToString([]byte(grant.Status.Username + ":" + string(secret.Data["password"])))
+		require.Equal(t, auth, dockerConfig["auths"]["registry.example.com"]["auth"])
+
+		require.Equal(t, "Normal Issued Credentials for registry.example.com issued to dev@example.com, valid until 2024-06-02T20:00:00Z", <-recorder.Events)
+	})
+
+	t.Run("issue credentials for the registry served from the namespace of the grant", func(t *testing.T) {
+		c := fixGrantClient(t, fixGrantRegistry(testBaseNamespace), fixGrantRegistry(testGrantNamespace), fixRegistryAccessGrant(time.Hour))
+		reconciler, _ := fixGrantReconciler(c, now)
+
+		_, err := reconciler.Reconcile(context.Background(), fixGrantRequest())
+
+		require.NoError(t, err)
+		require.Equal(t, testGrantNamespace, getGrant(t, c).Status.RegistryNamespace)
+	})
+
+	t.Run("wait for registry with token authentication", func(t *testing.T) {
+		instance := fixGrantRegistry(testBaseNamespace)
+		instance.Spec.Auth = nil
+		c := fixGrantClient(t, instance, fixRegistryAccessGrant(time.Hour))
+		reconciler, recorder := fixGrantReconciler(c, now)
+
+		result, err := reconciler.Reconcile(context.Background(), fixGrantRequest())
+
+		require.NoError(t, err)
+		require.Equal(t, time.Minute, result.RequeueAfter)
+		grant := getGrant(t, c)
+		require.Equal(t, v1alpha1.GrantStatePending, grant.Status.State)
+		require.Equal(t, "the registry in namespace docker-registry has no token authentication, temporary credentials can't be revoked without it", grant.Status.Message)
+		require.Contains(t, <-recorder.Events, "Warning Pending")
+	})
+
+	t.Run("wait for exposed registry", func(t *testing.T) {
+		instance := fixGrantRegistry(testBaseNamespace)
+		instance.Status.ExternalAccess = v1alpha1.ExternalNetworkAccess{}
+		c := fixGrantClient(t, instance, fixRegistryAccessGrant(time.Hour))
+		reconciler, _ := fixGrantReconciler(c, now)
+
+		_, err := reconciler.Reconcile(context.Background(), fixGrantRequest())
+
+		require.NoError(t, err)
+		require.Equal(t, "the registry in namespace docker-registry is not exposed", getGrant(t, c).Status.Message)
+	})
+
+	t.Run("reject grant without recorded requester", func(t *testing.T) {
+		grant := fixRegistryAccessGrant(time.Hour)
+		grant.Annotations = nil
+		c := fixGrantClient(t, fixGrantRegistry(testBaseNamespace), grant)
+		reconciler, recorder := fixGrantReconciler(c, now)
+
+		result, err := reconciler.Reconcile(context.Background(), fixGrantRequest())
+
+		require.NoError(t, err)
+		require.Zero(t, result.RequeueAfter)
+		require.Equal(t, v1alpha1.GrantStateRejected, getGrant(t, c).Status.State)
+		require.Contains(t, <-recorder.Events, "Warning Rejected the requester is not recorded")
+	})
+
+	t.Run("reject too long duration", func(t *testing.T) {
+		c := fixGrantClient(t, fixGrantRegistry(testBaseNamespace), fixRegistryAccessGrant(48*time.Hour))
+		reconciler, _ := fixGrantReconciler(c, now)
+
+		_, err := reconciler.Reconcile(context.Background(), fixGrantRequest())
+
+		require.NoError(t, err)
+		grant := getGrant(t, c)
+		require.Equal(t, v1alpha1.GrantStateRejected, grant.Status.State)
+		require.Equal(t, "access grant duration '48h0m0s' must be between '1m0s' and '24h0m0s'", grant.Status.Message)
+	})
+
+	t.Run("reject grant named after existing secret", func(t *testing.T) {
+		existing := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "laptop", Namespace: testGrantNamespace},
+			Data:       map[string][]byte{"key": []byte("value")},
+		}
+		c := fixGrantClient(t, fixGrantRegistry(testBaseNamespace), fixRegistryAccessGrant(time.Hour), existing)
+		reconciler, _ := fixGrantReconciler(c, now)
+
+		_, err := reconciler.Reconcile(context.Background(), fixGrantRequest())
+
+		require.NoError(t, err)
+		require.Equal(t, v1alpha1.GrantStateRejected, getGrant(t, c).Status.State)
+
+		secret := &corev1.Secret{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(existing), secret))
+		require.Equal(t, existing.Data, secret.Data)
+	})
+
+	t.Run("requeue active grant until it expires", func(t *testing.T) {
+		grant := fixActiveGrant(now.Add(30 * time.Minute))
+		c := fixGrantClient(t, grant, fixOwnedGrantSecret(t, grant))
+		reconciler, _ := fixGrantReconciler(c, now)
+
+		result, err := reconciler.Reconcile(context.Background(), fixGrantRequest())
+
+		require.NoError(t, err)
+		require.Equal(t, 30*time.Minute, result.RequeueAfter)
+		require.Equal(t, v1alpha1.GrantStateActive, getGrant(t, c).Status.State)
+	})
+
+	t.Run("revoke credentials at expiry", func(t *testing.T) {
+		grant := fixActiveGrant(now)
+		c := fixGrantClient(t, grant, fixOwnedGrantSecret(t, grant))
+		reconciler, recorder := fixGrantReconciler(c, now)
+
+		result, err := reconciler.Reconcile(context.Background(), fixGrantRequest())
+
+		require.NoError(t, err)
+		require.Zero(t, result.RequeueAfter)
+		require.Equal(t, v1alpha1.GrantStateExpired, getGrant(t, c).Status.State)
+
+		err = c.Get(context.Background(), client.ObjectKey{Namespace: testGrantNamespace, Name: "laptop"}, &corev1.Secret{})
+		require.True(t, apierrors.IsNotFound(err))
+		require.Equal(t, "Normal Expired Credentials issued to dev@example.com expired at 2024-06-02T12:00:00Z and were revoked", <-recorder.Events)
+	})
+
+	t.Run("keep expired grant", func(t *testing.T) {
+		grant := fixActiveGrant(now.Add(-time.Hour))
+		grant.Status.State = v1alpha1.GrantStateExpired
+		c := fixGrantClient(t, grant, fixGrantRegistry(testBaseNamespace))
+		reconciler, recorder := fixGrantReconciler(c, now)
+
+		result, err := reconciler.Reconcile(context.Background(), fixGrantRequest())
+
+		require.NoError(t, err)
+		require.Zero(t, result.RequeueAfter)
+		require.Equal(t, v1alpha1.GrantStateExpired, getGrant(t, c).Status.State)
+		require.Empty(t, recorder.Events)
+	})
+}
+
+func fixGrantReconciler(c client.Client, now time.Time) (*GrantReconciler, *record.FakeRecorder) {
+	recorder := record.NewFakeRecorder(10)
+	reconciler := NewGrant(c, zap.NewNop().Sugar(), recorder)
+	reconciler.now = func() time.Time { return now }
+	return reconciler, recorder
+}
+
+func fixGrantClient(t *testing.T, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&v1alpha1.RegistryAccessGrant{}).
+		Build()
+}
+
+func fixGrantRequest() reconcile.Request {
+	return reconcile.Request{NamespacedName: client.ObjectKey{Namespace: testGrantNamespace, Name: "laptop"}}
+}
+
+func getGrant(t *testing.T, c client.Client) *v1alpha1.RegistryAccessGrant {
+	grant := &v1alpha1.RegistryAccessGrant{}
+	require.NoError(t, c.Get(context.Background(), fixGrantRequest().NamespacedName, grant))
+	return grant
+}
+
+func fixRegistryAccessGrant(duration time.Duration) *v1alpha1.RegistryAccessGrant {
+	return &v1alpha1.RegistryAccessGrant{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "laptop",
+			Namespace:   testGrantNamespace,
+			Annotations: map[string]string{v1alpha1.RequesterAnnotation: "dev@example.com"},
+		},
+		Spec: v1alpha1.RegistryAccessGrantSpec{
+			Duration: metav1.Duration{Duration: duration},
+		},
+	}
+}
+
+func fixActiveGrant(expiration time.Time) *v1alpha1.RegistryAccessGrant {
+	grant := fixRegistryAccessGrant(time.Hour)
+	grant.UID = "grant-uid"
+	grant.Status = v1alpha1.RegistryAccessGrantStatus{
+		State:             v1alpha1.GrantStateActive,
+		Requester:         "dev@example.com",
+		RegistryNamespace: testBaseNamespace,
+		SecretName:        "laptop",
+		Username:          "grant-user",
+		ExpirationTime:    &metav1.Time{Time: expiration},
+	}
+	return grant
+}
+
+func fixOwnedGrantSecret(t *testing.T, grant *v1alpha1.RegistryAccessGrant) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: grant.Status.SecretName, Namespace: grant.GetNamespace()},
+		Data:       map[string][]byte{"username": []byte("grant-user"), "password": []byte("grant-pass")},
+	}
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	require.NoError(t, controllerutil.SetControllerReference(grant, secret, scheme))
+	return secret
+}
+
+func fixGrantRegistry(namespace string) *v1alpha1.DockerRegistry {
+	return &v1alpha1.DockerRegistry{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: namespace},
+		Spec: v1alpha1.DockerRegistrySpec{
+			Auth: &v1alpha1.Auth{Token: &v1alpha1.TokenAuth{}},
+		},
+		Status: v1alpha1.DockerRegistryStatus{
+			Served: v1alpha1.ServedTrue,
+			ExternalAccess: v1alpha1.ExternalNetworkAccess{
+				NetworkAccess: v1alpha1.NetworkAccess{Enabled: "true", PushAddress: "registry.example.com"},
+			},
+		},
+	}
+}
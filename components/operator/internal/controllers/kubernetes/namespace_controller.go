@@ -8,6 +8,7 @@ import (
 	"go.uber.org/zap"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -52,10 +53,25 @@ func (r *NamespaceReconciler) predicate() predicate.Predicate {
 			return false
 		},
 		UpdateFunc: func(e event.UpdateEvent) bool {
-			return false
+			oldNamespace, ok := e.ObjectOld.(*corev1.Namespace)
+			if !ok {
+				return false
+			}
+			newNamespace, ok := e.ObjectNew.(*corev1.Namespace)
+			if !ok {
+				return false
+			}
+			return !isExcludedNamespace(newNamespace.Name, r.config.BaseNamespace, r.config.ExcludedNamespaces) &&
+				(isRevokedNamespace(oldNamespace) != isRevokedNamespace(newNamespace) ||
+					isPushNamespace(oldNamespace) != isPushNamespace(newNamespace) ||
+					isExternalAccessNamespace(oldNamespace) != isExternalAccessNamespace(newNamespace))
 		},
 		DeleteFunc: func(e event.DeleteEvent) bool {
-			return false
+			namespace, ok := e.Object.(*corev1.Namespace)
+			if !ok {
+				return false
+			}
+			return !isExcludedNamespace(namespace.Name, r.config.BaseNamespace, r.config.ExcludedNamespaces)
 		},
 	}
 }
@@ -67,11 +83,20 @@ func (r *NamespaceReconciler) predicate() predicate.Predicate {
 func (r *NamespaceReconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
 	instance := &corev1.Namespace{}
 	if err := r.client.Get(ctx, request.NamespacedName, instance); err != nil {
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+		if apierrors.IsNotFound(err) {
+			// the registry must stop accepting the credentials of the deleted namespace
+			return ctrl.Result{}, r.secretSvc.RevokeNamespace(ctx, r.Log.With("name", request.Name), request.Name)
+		}
+		return ctrl.Result{}, err
 	}
 
 	logger := r.Log.With("name", instance.GetName())
 
+	if instance.Status.Phase == corev1.NamespaceTerminating || isRevokedNamespace(instance) {
+		logger.Debug(fmt.Sprintf("Revoking credentials of namespace '%s'", instance.GetName()))
+		return ctrl.Result{}, r.secretSvc.RevokeNamespace(ctx, logger, instance.GetName())
+	}
+
 	logger.Debug(fmt.Sprintf("Updating Secret in namespace '%s'", instance.GetName()))
 	var errs []error
 	secrets, err := r.secretSvc.GetBase(ctx)
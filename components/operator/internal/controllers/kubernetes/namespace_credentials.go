@@ -0,0 +1,281 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+)
+
+// credentialsEntryAnnotation holds the digest of the htpasswd entry the credentials of a copy belong to, the
+// credentials are generated again when the entry was replaced or removed
+const credentialsEntryAnnotation = "dockerregistry.kyma-project.io/credentials-entry"
+
+type dockerConfig struct {
+	Auths map[string]dockerConfigAuth `json:"auths"`
+}
+
+type dockerConfigAuth struct {
+	Auth string `json:"auth"`
+}
+
+// perNamespaceCredentials tells if the copies of the base Secret get their own credentials
+func perNamespaceCredentials(secret *corev1.Secret) bool {
+	return secret.GetAnnotations()[registry.CredentialsScopeAnnotation] == registry.CredentialsScopeNamespace
+}
+
+func isRevokedNamespace(namespace *corev1.Namespace) bool {
+	return namespace.GetLabels()[registry.CredentialsRevokedLabel] == "true"
+}
+
+// pullOnlyCredentials tells if the copies of the base Secret get the pull-only credentials unless their namespace
+// opts in to push
+func pullOnlyCredentials(secret *corev1.Secret) bool {
+	return secret.GetAnnotations()[registry.CredentialsAccessAnnotation] == registry.CredentialsAccessPull
+}
+
+func isPushNamespace(namespace *corev1.Namespace) bool {
+	return namespace.GetLabels()[registry.PushAccessLabel] == "true"
+}
+
+// optInPropagation tells if the copies of the base Secret go only to the namespaces that opt in to them, like the
+// copies of the external access Secret
+func optInPropagation(secret *corev1.Secret) bool {
+	return secret.GetAnnotations()[registry.CredentialsPropagationAnnotation] == registry.CredentialsPropagationOptIn
+}
+
+func isExternalAccessNamespace(namespace *corev1.Namespace) bool {
+	return namespace.GetLabels()[registry.ExternalAccessLabel] == "true"
+}
+
+// namespaceOptedIn tells if the namespace gets the copies of the base Secrets propagated on opt-in
+func (r *secretService) namespaceOptedIn(ctx context.Context, namespace string) (bool, error) {
+	instance := &corev1.Namespace{}
+	if err := r.client.Get(ctx, client.ObjectKey{Name: namespace}, instance); err != nil {
+		return false, errors.Wrapf(err, "while fetching namespace %s", namespace)
+	}
+	return isExternalAccessNamespace(instance), nil
+}
+
+// namespaceInstance returns the base Secret as it is copied to the namespace, with the credentials the namespace
+// gets instead of the base ones
+func (r *secretService) namespaceInstance(ctx context.Context, logger *zap.SugaredLogger, namespace string, baseInstance *corev1.Secret) (*corev1.Secret, error) {
+	namespaceInstance := baseInstance.DeepCopy()
+	// the pull-only credentials are in the copies as the username and password if at all, the ones replaced by
+	// the rotation are only accepted until the copies get the new ones
+	for _, key := range []string{registry.PullUsernameKey, registry.PullPasswordKey,
+		registry.PreviousUsernameKey, registry.PreviousPasswordKey} {
+		delete(namespaceInstance.Data, key)
+	}
+
+	var username, password string
+	switch {
+	case perNamespaceCredentials(baseInstance):
+		var entry string
+		var err error
+		username, password, entry, err = r.namespaceCredentials(ctx, logger, namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		annotations := map[string]string{}
+		for key, value := range baseInstance.GetAnnotations() {
+			annotations[key] = value
+		}
+		annotations[credentialsEntryAnnotation] = entryDigest(entry)
+		namespaceInstance.SetAnnotations(annotations)
+	case pullOnlyCredentials(baseInstance):
+		instance := &corev1.Namespace{}
+		if err := r.client.Get(ctx, client.ObjectKey{Name: namespace}, instance); err != nil {
+			return nil, errors.Wrapf(err, "while fetching namespace %s", namespace)
+		}
+		if isPushNamespace(instance) {
+			return namespaceInstance, nil
+		}
+
+		username = string(baseInstance.Data[registry.PullUsernameKey])
+		password = string(baseInstance.Data[registry.PullPasswordKey])
+	default:
+		return namespaceInstance, nil
+	}
+
+	data, err := withCredentials(namespaceInstance.Data, username, password)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while building credentials of namespace %s", namespace)
+	}
+	namespaceInstance.Data = data
+	return namespaceInstance, nil
+}
+
+// namespaceCredentials returns the credentials the copies in the namespace already hold, or generates new ones and
+// stores their htpasswd entry for the registry
+func (r *secretService) namespaceCredentials(ctx context.Context, logger *zap.SugaredLogger, namespace string) (string, string, string, error) {
+	credentials, err := r.getNamespaceCredentialsSecret(ctx)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	// only the copies of the internal access Secret get the credentials of the namespace, the external access has
+	// its own
+	if entry := string(credentials.Data[namespace]); entry != "" {
+		instance := &corev1.Secret{}
+		err := r.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: r.config.BaseInternalSecretName}, instance)
+		username := string(instance.Data["username"])
+		password := string(instance.Data["password"])
+		if err == nil && instance.GetAnnotations()[credentialsEntryAnnotation] == entryDigest(entry) &&
+			username == registry.HtpasswdUsername(entry) && password != "" {
+			return username, password, entry, nil
+		}
+	}
+
+	logger.Debug(fmt.Sprintf("Generating credentials of namespace '%s'", namespace))
+	password, err := registry.GeneratePassword()
+	if err != nil {
+		return "", "", "", err
+	}
+	// the username tells which namespace the leaked credentials come from
+	entry, err := registry.HtpasswdEntry(namespace, password)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	if credentials.Data == nil {
+		credentials.Data = map[string][]byte{}
+	}
+	credentials.Data[namespace] = []byte(entry)
+	if err := r.client.Update(ctx, credentials); err != nil {
+		return "", "", "", errors.Wrapf(err, "while storing credentials of namespace %s", namespace)
+	}
+	return namespace, password, entry, nil
+}
+
+// RevokeNamespace removes the copies of the base Secrets from the namespace and makes the registry reject the
+// credentials of the namespace
+func (r *secretService) RevokeNamespace(ctx context.Context, logger *zap.SugaredLogger, namespace string) error {
+	var errs []error
+	for _, name := range []string{r.config.BaseInternalSecretName, r.config.BaseExternalSecretName} {
+		if err := r.deleteSecret(ctx, logger, namespace, name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := r.removeNamespaceCredentials(ctx, logger, func(entryNamespace string) bool {
+		return entryNamespace == namespace
+	}); err != nil {
+		errs = append(errs, err)
+	}
+	return goerrors.Join(errs...)
+}
+
+// PruneNamespaceCredentials revokes the credentials of the namespaces the base Secrets are no longer propagated to,
+// it catches the namespaces deleted or revoked while the operator was not running
+func (r *secretService) PruneNamespaceCredentials(ctx context.Context, logger *zap.SugaredLogger, namespaces []string) error {
+	active := map[string]struct{}{}
+	for _, namespace := range namespaces {
+		active[namespace] = struct{}{}
+	}
+
+	var errs []error
+	err := r.removeNamespaceCredentials(ctx, logger, func(entryNamespace string) bool {
+		if _, ok := active[entryNamespace]; ok {
+			return false
+		}
+
+		for _, name := range []string{r.config.BaseInternalSecretName, r.config.BaseExternalSecretName} {
+			if err := r.deleteSecret(ctx, logger, entryNamespace, name); err != nil {
+				errs = append(errs, fmt.Errorf("namespace %s: %w", entryNamespace, err))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return goerrors.Join(errs...)
+}
+
+func (r *secretService) removeNamespaceCredentials(ctx context.Context, logger *zap.SugaredLogger, remove func(namespace string) bool) error {
+	credentials, err := r.getNamespaceCredentialsSecret(ctx)
+	if apierrors.IsNotFound(errors.Cause(err)) {
+		// the shared credentials are used, there is nothing to revoke
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	removed := false
+	for namespace := range credentials.Data {
+		if remove(namespace) {
+			logger.Debug(fmt.Sprintf("Revoking credentials of namespace '%s'", namespace))
+			delete(credentials.Data, namespace)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+
+	return errors.Wrap(r.client.Update(ctx, credentials), "while revoking namespace credentials")
+}
+
+func (r *secretService) getNamespaceCredentialsSecret(ctx context.Context) (*corev1.Secret, error) {
+	credentials := &corev1.Secret{}
+	err := r.client.Get(ctx, client.ObjectKey{
+		Namespace: r.config.BaseNamespace,
+		Name:      r.config.BaseNamespaceCredentialsSecretName,
+	}, credentials)
+	if err != nil {
+		// the DockerRegistry reconciliation creates it once the per-namespace credentials are enabled
+		return nil, errors.Wrap(err, "while fetching namespace credentials secret")
+	}
+	return credentials, nil
+}
+
+// withCredentials returns the Secret data with the credentials replaced, also in the docker config of every
+// registry address
+func withCredentials(data map[string][]byte, username, password string) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(data))
+	for key, value := range data {
+		result[key] = value
+	}
+	result["username"] = []byte(username)
+	result["password"] = []byte(password)
+
+	raw, ok := data[corev1.DockerConfigJsonKey]
+	if !ok {
+		return result, nil
+	}
+
+	config := dockerConfig{}
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, errors.Wrap(err, "while decoding docker config")
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	for address := range config.Auths {
+		config.Auths[address] = dockerConfigAuth{Auth: auth}
+	}
+
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "while encoding docker config")
+	}
+	result[corev1.DockerConfigJsonKey] = encoded
+	return result, nil
+}
+
+func entryDigest(entry string) string {
+	digest := sha256.Sum256([]byte(entry))
+	return hex.EncodeToString(digest[:])[:16]
+}
@@ -0,0 +1,321 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/resource"
+)
+
+const (
+	testNamespaceCredentialsSecretName = "dockerregistry-namespace-credentials"
+	testRegistryAddress                = "localhost:32137"
+)
+
+func TestSecretReconcilerGivesEveryNamespaceItsOwnCredentials(t *testing.T) {
+	//GIVEN
+	c := fixNamespaceCredentialsClient(t, fixNamespace(testTargetNamespace), fixNamespace("other"))
+	reconciler := fixSecretReconciler(c)
+
+	//WHEN
+	_, err := reconciler.Reconcile(context.TODO(), fixBaseSecretRequest())
+
+	//THEN
+	require.NoError(t, err)
+
+	credentials := getSecret(t, c, testBaseNamespace, testNamespaceCredentialsSecretName)
+	passwords := map[string]struct{}{}
+	for _, namespace := range []string{testTargetNamespace, "other"} {
+		propagated := getSecret(t, c, namespace, testBaseSecretName)
+		username := string(propagated.Data["username"])
+		password := string(propagated.Data["password"])
+		require.Equal(t, namespace, username)
+		require.NotEqual(t, "secret-password", password)
+		require.True(t, registry.VerifyHtpasswdEntry(string(credentials.Data[namespace]), username, password),
+			"the registry must accept the credentials propagated to %s", namespace)
+		require.Equal(t, registry.CredentialsScopeNamespace,
+			propagated.GetAnnotations()[registry.CredentialsScopeAnnotation])
+
+		config := dockerConfig{}
+		require.NoError(t, json.Unmarshal(propagated.Data[corev1.DockerConfigJsonKey], &config))
+		require.Equal(t, base64.StdEncoding.EncodeToString([]byte(username+":"+password)),
+			config.Auths[testRegistryAddress].Auth)
+		passwords[password] = struct{}{}
+	}
+	require.Len(t, passwords, 2, "the namespaces must not share credentials")
+}
+
+func TestSecretReconcilerKeepsNamespaceCredentials(t *testing.T) {
+	//GIVEN
+	c := fixNamespaceCredentialsClient(t, fixNamespace(testTargetNamespace))
+	reconciler := fixSecretReconciler(c)
+	_, err := reconciler.Reconcile(context.TODO(), fixBaseSecretRequest())
+	require.NoError(t, err)
+	before := getSecret(t, c, testTargetNamespace, testBaseSecretName)
+
+	//WHEN
+	_, err = reconciler.Reconcile(context.TODO(), fixBaseSecretRequest())
+
+	//THEN
+	require.NoError(t, err)
+	after := getSecret(t, c, testTargetNamespace, testBaseSecretName)
+	require.Equal(t, before.Data["password"], after.Data["password"],
+		"the workloads of the namespace must not lose access on every refresh")
+}
+
+func TestSecretReconcilerReplacesCredentialsOfRemovedEntry(t *testing.T) {
+	//GIVEN
+	c := fixNamespaceCredentialsClient(t, fixNamespace(testTargetNamespace))
+	reconciler := fixSecretReconciler(c)
+	_, err := reconciler.Reconcile(context.TODO(), fixBaseSecretRequest())
+	require.NoError(t, err)
+	before := getSecret(t, c, testTargetNamespace, testBaseSecretName)
+
+	credentials := getSecret(t, c, testBaseNamespace, testNamespaceCredentialsSecretName)
+	delete(credentials.Data, testTargetNamespace)
+	require.NoError(t, c.Update(context.TODO(), credentials))
+
+	//WHEN
+	_, err = reconciler.Reconcile(context.TODO(), fixBaseSecretRequest())
+
+	//THEN
+	require.NoError(t, err)
+	after := getSecret(t, c, testTargetNamespace, testBaseSecretName)
+	require.NotEqual(t, before.Data["password"], after.Data["password"])
+
+	credentials = getSecret(t, c, testBaseNamespace, testNamespaceCredentialsSecretName)
+	require.True(t, registry.VerifyHtpasswdEntry(string(credentials.Data[testTargetNamespace]),
+		testTargetNamespace, string(after.Data["password"])))
+}
+
+func TestSecretReconcilerPrunesRevokedNamespaces(t *testing.T) {
+	//GIVEN
+	revoked := fixNamespace("revoked")
+	revoked.Labels = map[string]string{registry.CredentialsRevokedLabel: "true"}
+	c := fixNamespaceCredentialsClient(t, fixNamespace(testTargetNamespace), revoked, fixPropagatedSecret("revoked"))
+
+	credentials := getSecret(t, c, testBaseNamespace, testNamespaceCredentialsSecretName)
+	credentials.Data = map[string][]byte{
+		"revoked": []byte(fixHtpasswdEntry(t, "revoked")),
+		"deleted": []byte(fixHtpasswdEntry(t, "deleted")),
+	}
+	require.NoError(t, c.Update(context.TODO(), credentials))
+	reconciler := fixSecretReconciler(c)
+
+	//WHEN
+	_, err := reconciler.Reconcile(context.TODO(), fixBaseSecretRequest())
+
+	//THEN
+	require.NoError(t, err)
+
+	credentials = getSecret(t, c, testBaseNamespace, testNamespaceCredentialsSecretName)
+	require.Contains(t, credentials.Data, testTargetNamespace)
+	require.NotContains(t, credentials.Data, "revoked")
+	require.NotContains(t, credentials.Data, "deleted")
+
+	err = c.Get(context.TODO(), client.ObjectKey{Namespace: "revoked", Name: testBaseSecretName}, &corev1.Secret{})
+	require.True(t, apierrors.IsNotFound(err), "the copy must be removed from the revoked namespace, got %v", err)
+}
+
+func TestSecretReconcilerFailsWithoutNamespaceCredentialsSecret(t *testing.T) {
+	//GIVEN
+	c := fake.NewClientBuilder().
+		WithScheme(fixScheme(t)).
+		WithObjects(fixNamespace(testBaseNamespace), fixNamespace(testTargetNamespace), fixNamespaceCredentialsBaseSecret()).
+		Build()
+	reconciler := fixSecretReconciler(c)
+
+	//WHEN
+	_, err := reconciler.Reconcile(context.TODO(), fixBaseSecretRequest())
+
+	//THEN
+	require.ErrorContains(t, err, "while fetching namespace credentials secret")
+
+	err = c.Get(context.TODO(), client.ObjectKey{Namespace: testTargetNamespace, Name: testBaseSecretName}, &corev1.Secret{})
+	require.True(t, apierrors.IsNotFound(err), "the shared credentials must not be propagated, got %v", err)
+}
+
+func TestNamespaceReconcilerRevokesNamespaceCredentials(t *testing.T) {
+	//GIVEN
+	namespace := fixNamespace(testTargetNamespace)
+	c := fixNamespaceCredentialsClient(t, namespace)
+	_, err := fixSecretReconciler(c).Reconcile(context.TODO(), fixBaseSecretRequest())
+	require.NoError(t, err)
+
+	namespace.Labels = map[string]string{registry.CredentialsRevokedLabel: "true"}
+	require.NoError(t, c.Update(context.TODO(), namespace))
+	reconciler := fixNamespaceReconciler(c)
+
+	//WHEN
+	_, err = reconciler.Reconcile(context.TODO(), fixNamespaceRequest(testTargetNamespace))
+
+	//THEN
+	require.NoError(t, err)
+
+	credentials := getSecret(t, c, testBaseNamespace, testNamespaceCredentialsSecretName)
+	require.NotContains(t, credentials.Data, testTargetNamespace)
+
+	err = c.Get(context.TODO(), client.ObjectKey{Namespace: testTargetNamespace, Name: testBaseSecretName}, &corev1.Secret{})
+	require.True(t, apierrors.IsNotFound(err), "the copy must be removed from the revoked namespace, got %v", err)
+}
+
+func TestNamespaceReconcilerRevokesDeletedNamespace(t *testing.T) {
+	//GIVEN
+	c := fixNamespaceCredentialsClient(t)
+	credentials := getSecret(t, c, testBaseNamespace, testNamespaceCredentialsSecretName)
+	credentials.Data = map[string][]byte{testTargetNamespace: []byte(fixHtpasswdEntry(t, testTargetNamespace))}
+	require.NoError(t, c.Update(context.TODO(), credentials))
+	reconciler := fixNamespaceReconciler(c)
+
+	//WHEN
+	_, err := reconciler.Reconcile(context.TODO(), fixNamespaceRequest(testTargetNamespace))
+
+	//THEN
+	require.NoError(t, err)
+
+	credentials = getSecret(t, c, testBaseNamespace, testNamespaceCredentialsSecretName)
+	require.NotContains(t, credentials.Data, testTargetNamespace)
+}
+
+func TestNamespaceReconcilerIgnoresDeletedNamespaceWithSharedCredentials(t *testing.T) {
+	//GIVEN
+	c := fake.NewClientBuilder().
+		WithScheme(fixScheme(t)).
+		WithObjects(fixNamespace(testBaseNamespace), fixBaseSecret()).
+		Build()
+	reconciler := fixNamespaceReconciler(c)
+
+	//WHEN
+	_, err := reconciler.Reconcile(context.TODO(), fixNamespaceRequest(testTargetNamespace))
+
+	//THEN
+	require.NoError(t, err)
+}
+
+func TestSecretReconcilerPropagatesPullOnlyCredentials(t *testing.T) {
+	//GIVEN
+	push := fixNamespace("push")
+	push.Labels = map[string]string{registry.PushAccessLabel: "true"}
+	base := fixBaseSecret()
+	base.Annotations = map[string]string{registry.CredentialsAccessAnnotation: registry.CredentialsAccessPull}
+	base.Data["username"] = []byte("secret-username")
+	base.Data[registry.PullUsernameKey] = []byte("pull-username")
+	base.Data[registry.PullPasswordKey] = []byte("pull-password")
+	base.Data[corev1.DockerConfigJsonKey] = []byte(`{"auths":{"` + testRegistryAddress + `":{"auth":"c2VjcmV0LXVzZXJuYW1lOnNlY3JldC1wYXNzd29yZA=="}}}`)
+
+	c := fake.NewClientBuilder().
+		WithScheme(fixScheme(t)).
+		WithObjects(fixNamespace(testBaseNamespace), fixNamespace(testTargetNamespace), push, base).
+		Build()
+	reconciler := fixSecretReconciler(c)
+
+	//WHEN
+	_, err := reconciler.Reconcile(context.TODO(), fixBaseSecretRequest())
+
+	//THEN
+	require.NoError(t, err)
+
+	pull := getSecret(t, c, testTargetNamespace, testBaseSecretName)
+	require.Equal(t, []byte("pull-username"), pull.Data["username"])
+	require.Equal(t, []byte("pull-password"), pull.Data["password"])
+	require.NotContains(t, pull.Data, registry.PullUsernameKey)
+	require.NotContains(t, pull.Data, registry.PullPasswordKey)
+	config := dockerConfig{}
+	require.NoError(t, json.Unmarshal(pull.Data[corev1.DockerConfigJsonKey], &config))
+	require.Equal(t, base64.StdEncoding.EncodeToString([]byte("pull-username:pull-password")),
+		config.Auths[testRegistryAddress].Auth)
+
+	pushCopy := getSecret(t, c, "push", testBaseSecretName)
+	require.Equal(t, []byte("secret-username"), pushCopy.Data["username"])
+	require.Equal(t, []byte("secret-password"), pushCopy.Data["password"])
+	require.NotContains(t, pushCopy.Data, registry.PullUsernameKey)
+	require.Equal(t, base.Data[corev1.DockerConfigJsonKey], pushCopy.Data[corev1.DockerConfigJsonKey])
+}
+
+func TestNamespaceReconcilerSwitchesToPushCredentials(t *testing.T) {
+	//GIVEN
+	namespace := fixNamespace(testTargetNamespace)
+	base := fixBaseSecret()
+	base.Annotations = map[string]string{registry.CredentialsAccessAnnotation: registry.CredentialsAccessPull}
+	base.Data[registry.PullUsernameKey] = []byte("pull-username")
+	base.Data[registry.PullPasswordKey] = []byte("pull-password")
+	c := fake.NewClientBuilder().
+		WithScheme(fixScheme(t)).
+		WithObjects(fixNamespace(testBaseNamespace), namespace, base).
+		Build()
+	_, err := fixSecretReconciler(c).Reconcile(context.TODO(), fixBaseSecretRequest())
+	require.NoError(t, err)
+	require.Equal(t, []byte("pull-password"), getSecret(t, c, testTargetNamespace, testBaseSecretName).Data["password"])
+
+	namespace.Labels = map[string]string{registry.PushAccessLabel: "true"}
+	require.NoError(t, c.Update(context.TODO(), namespace))
+	reconciler := fixNamespaceReconciler(c)
+
+	//WHEN
+	_, err = reconciler.Reconcile(context.TODO(), fixNamespaceRequest(testTargetNamespace))
+
+	//THEN
+	require.NoError(t, err)
+	require.Equal(t, []byte("secret-password"), getSecret(t, c, testTargetNamespace, testBaseSecretName).Data["password"])
+}
+
+func fixNamespaceCredentialsClient(t *testing.T, objects ...client.Object) client.WithWatch {
+	t.Helper()
+	return fake.NewClientBuilder().
+		WithScheme(fixScheme(t)).
+		WithObjects(append(objects,
+			fixNamespace(testBaseNamespace),
+			fixNamespaceCredentialsBaseSecret(),
+			&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      testNamespaceCredentialsSecretName,
+					Namespace: testBaseNamespace,
+				},
+			},
+		)...).
+		Build()
+}
+
+func fixNamespaceCredentialsBaseSecret() *corev1.Secret {
+	secret := fixBaseSecret()
+	secret.Annotations = map[string]string{registry.CredentialsScopeAnnotation: registry.CredentialsScopeNamespace}
+	secret.Type = corev1.SecretTypeDockerConfigJson
+	secret.Data["username"] = []byte("secret-username")
+	secret.Data[corev1.DockerConfigJsonKey] = []byte(`{"auths":{"` + testRegistryAddress + `":{"auth":"c2VjcmV0LXVzZXJuYW1lOnNlY3JldC1wYXNzd29yZA=="}}}`)
+	return secret
+}
+
+func fixNamespaceReconciler(c client.Client) *NamespaceReconciler {
+	config := fixConfig()
+	return NewNamespace(c, zap.NewNop().Sugar(), config, NewSecretService(resource.New(c, c.Scheme()), config))
+}
+
+func fixNamespaceRequest(name string) reconcile.Request {
+	return reconcile.Request{NamespacedName: client.ObjectKey{Name: name}}
+}
+
+func fixHtpasswdEntry(t *testing.T, username string) string {
+	t.Helper()
+	entry, err := registry.HtpasswdEntry(username, "password")
+	require.NoError(t, err)
+	return entry
+}
+
+func getSecret(t *testing.T, c client.Client, namespace, name string) *corev1.Secret {
+	t.Helper()
+	secret := &corev1.Secret{}
+	require.NoError(t, c.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: name}, secret))
+	return secret
+}
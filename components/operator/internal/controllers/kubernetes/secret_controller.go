@@ -110,6 +110,11 @@ func (r *SecretReconciler) Reconcile(ctx context.Context, request ctrl.Request)
 			errs = append(errs, fmt.Errorf("namespace %s: %w", namespace, err))
 		}
 	}
+	if perNamespaceCredentials(instance) {
+		if err := r.svc.PruneNamespaceCredentials(ctx, logger, namespaces); err != nil {
+			errs = append(errs, err)
+		}
+	}
 	if err := errors.Join(errs...); err != nil {
 		return ctrl.Result{}, err
 	}
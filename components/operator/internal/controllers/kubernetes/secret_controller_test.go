@@ -18,6 +18,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
 	"github.com/kyma-project/docker-registry/components/operator/internal/resource"
 )
 
@@ -25,6 +26,8 @@ const (
 	testBaseNamespace   = "docker-registry"
 	testBaseSecretName  = "dockerregistry-config"
 	testTargetNamespace = "deployer"
+
+	testExternalSecretName = "dockerregistry-config-external"
 )
 
 // The kyma-module-label-protection ValidatingAdmissionPolicy rejects the copy in these
@@ -175,6 +178,30 @@ func TestSecretReconcilerPropagatesToEveryNamespaceWhenNothingIsDenied(t *testin
 	}
 }
 
+func TestSecretReconcilerDoesNotPropagateReplacedCredentials(t *testing.T) {
+	//GIVEN
+	base := fixBaseSecret()
+	base.Data[registry.PreviousUsernameKey] = []byte("old-username")
+	base.Data[registry.PreviousPasswordKey] = []byte("old-password")
+	c := fake.NewClientBuilder().
+		WithScheme(fixScheme(t)).
+		WithObjects(fixNamespace(testBaseNamespace), fixNamespace(testTargetNamespace), base).
+		Build()
+	reconciler := fixSecretReconciler(c)
+
+	//WHEN
+	_, err := reconciler.Reconcile(context.TODO(), fixBaseSecretRequest())
+
+	//THEN
+	require.NoError(t, err)
+
+	var propagated corev1.Secret
+	require.NoError(t,
+		c.Get(context.TODO(), client.ObjectKey{Namespace: testTargetNamespace, Name: testBaseSecretName}, &propagated))
+	require.Equal(t, map[string][]byte{"password": []byte("secret-password")}, propagated.Data,
+		"the namespaces must not get the credentials the registry is about to drop")
+}
+
 func TestSecretReconcilerSkipsBaseNamespace(t *testing.T) {
 	//GIVEN
 	c := fixClientDenyingSecretWrites(t)
@@ -259,6 +286,68 @@ func TestSecretServiceHandleFinalizerRemovesFinalizerWhenEveryDeleteSucceeds(t *
 	require.NotContains(t, base.GetFinalizers(), cfgSecretFinalizerName)
 }
 
+func TestSecretReconcilerPropagatesExternalSecretOnlyToOptedInNamespaces(t *testing.T) {
+	//GIVEN
+	optedIn := fixNamespace("opted-in")
+	optedIn.Labels = map[string]string{registry.ExternalAccessLabel: "true"}
+	stale := fixExternalSecret(testTargetNamespace)
+	c := fake.NewClientBuilder().
+		WithScheme(fixScheme(t)).
+		WithObjects(fixNamespace(testBaseNamespace), fixNamespace(testTargetNamespace), fixNamespace("other"), optedIn,
+			fixExternalBaseSecret(), stale).
+		Build()
+	reconciler := fixSecretReconciler(c)
+
+	//WHEN
+	_, err := reconciler.Reconcile(context.TODO(), reconcile.Request{
+		NamespacedName: client.ObjectKey{Namespace: testBaseNamespace, Name: testExternalSecretName},
+	})
+
+	//THEN
+	require.NoError(t, err)
+	require.Equal(t, []byte("external-password"), getSecret(t, c, "opted-in", testExternalSecretName).Data["password"])
+	for _, namespace := range []string{testTargetNamespace, "other"} {
+		err = c.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: testExternalSecretName}, &corev1.Secret{})
+		require.True(t, apierrors.IsNotFound(err), "namespace %s did not opt in to the external access", namespace)
+	}
+}
+
+func TestNamespaceReconcilerRemovesExternalSecretWhenOptInIsDropped(t *testing.T) {
+	//GIVEN
+	c := fake.NewClientBuilder().
+		WithScheme(fixScheme(t)).
+		WithObjects(fixNamespace(testBaseNamespace), fixNamespace(testTargetNamespace), fixBaseSecret(),
+			fixExternalBaseSecret(), fixExternalSecret(testTargetNamespace)).
+		Build()
+	reconciler := fixNamespaceReconciler(c)
+
+	//WHEN
+	_, err := reconciler.Reconcile(context.TODO(), fixNamespaceRequest(testTargetNamespace))
+
+	//THEN
+	require.NoError(t, err)
+	err = c.Get(context.TODO(), client.ObjectKey{Namespace: testTargetNamespace, Name: testExternalSecretName}, &corev1.Secret{})
+	require.True(t, apierrors.IsNotFound(err))
+	require.Equal(t, []byte("secret-password"), getSecret(t, c, testTargetNamespace, testBaseSecretName).Data["password"])
+}
+
+func fixExternalBaseSecret() *corev1.Secret {
+	secret := fixExternalSecret(testBaseNamespace)
+	secret.Annotations = map[string]string{registry.CredentialsPropagationAnnotation: registry.CredentialsPropagationOptIn}
+	return secret
+}
+
+func fixExternalSecret(namespace string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testExternalSecretName,
+			Namespace: namespace,
+			Labels:    map[string]string{ConfigLabel: CredentialsLabelValue},
+		},
+		Data: map[string][]byte{"password": []byte("external-password")},
+	}
+}
+
 func fixSecretReconciler(c client.Client) *SecretReconciler {
 	config := fixConfig()
 	return NewSecret(c, zap.NewNop().Sugar(), config, NewSecretService(resource.New(c, c.Scheme()), config))
@@ -332,9 +421,11 @@ func fixConfig() Config {
 	return Config{
 		BaseNamespace:          testBaseNamespace,
 		BaseInternalSecretName: testBaseSecretName,
-		BaseExternalSecretName: "dockerregistry-config-external",
+		BaseExternalSecretName: testExternalSecretName,
 		ExcludedNamespaces:     []string{testBaseNamespace},
 		SecretRequeueDuration:  time.Minute,
+
+		BaseNamespaceCredentialsSecretName: testNamespaceCredentialsSecretName,
 	}
 }
 
@@ -7,7 +7,6 @@ import (
 	"go.uber.org/zap"
 
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -27,6 +26,8 @@ type SecretService interface {
 	GetBase(ctx context.Context) ([]corev1.Secret, error)
 	UpdateNamespace(ctx context.Context, logger *zap.SugaredLogger, namespace string, baseInstance *corev1.Secret) error
 	HandleFinalizer(ctx context.Context, logger *zap.SugaredLogger, secret *corev1.Secret, namespaces []string) error
+	RevokeNamespace(ctx context.Context, logger *zap.SugaredLogger, namespace string) error
+	PruneNamespaceCredentials(ctx context.Context, logger *zap.SugaredLogger, namespaces []string) error
 }
 
 var _ SecretService = &secretService{}
@@ -73,17 +74,40 @@ func (r *secretService) IsBase(secret *corev1.Secret) bool {
 func (r *secretService) UpdateNamespace(ctx context.Context, logger *zap.SugaredLogger, namespace string, baseInstance *corev1.Secret) error {
 	logger.Debug(fmt.Sprintf("Updating Secret '%s/%s'", namespace, baseInstance.GetName()))
 	instance := &corev1.Secret{}
-	if err := r.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: baseInstance.GetName()}, instance); err != nil {
-		if errors.IsNotFound(err) {
-			return r.createSecret(ctx, logger, namespace, baseInstance)
-		}
+	err := r.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: baseInstance.GetName()}, instance)
+	if client.IgnoreNotFound(err) != nil {
 		logger.Error(err, fmt.Sprintf("Gathering existing Secret '%s/%s' failed", namespace, baseInstance.GetName()))
 		return err
 	}
-	if instance.Labels[FunctionManagedByLabel] == FunctionResourceLabelUserValue {
+	found := err == nil
+	if found && instance.Labels[FunctionManagedByLabel] == FunctionResourceLabelUserValue {
 		return nil
 	}
-	return r.updateSecret(ctx, logger, instance, baseInstance)
+
+	if optInPropagation(baseInstance) {
+		optedIn, err := r.namespaceOptedIn(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		if !optedIn {
+			if !found {
+				return nil
+			}
+			// the namespace dropped the opt-in or got the copy before the propagation required one
+			logger.Debug(fmt.Sprintf("Deleting Secret '%s/%s' of namespace not opted in", namespace, baseInstance.GetName()))
+			return r.deleteSecret(ctx, logger, namespace, baseInstance.GetName())
+		}
+	}
+
+	namespaceInstance, err := r.namespaceInstance(ctx, logger, namespace, baseInstance)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return r.createSecret(ctx, logger, namespace, namespaceInstance)
+	}
+	return r.updateSecret(ctx, logger, instance, namespaceInstance)
 }
 
 func (r *secretService) HandleFinalizer(ctx context.Context, logger *zap.SugaredLogger, instance *corev1.Secret, namespaces []string) error {
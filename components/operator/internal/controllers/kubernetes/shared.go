@@ -30,13 +30,15 @@ func DefaultExcludedNamespaces() []string {
 }
 
 type Config struct {
-	BaseNamespace                 string        `envconfig:"default=docker-registry"`
-	BaseInternalSecretName        string        `envconfig:"default=dockerregistry-config"`
-	BaseExternalSecretName        string        `envconfig:"default=dockerregistry-config-external"`
-	ExcludedNamespaces            []string      `envconfig:"default=docker-registry;istio-system;kyma-system"`
-	ConfigMapRequeueDuration      time.Duration `envconfig:"default=1m"`
-	SecretRequeueDuration         time.Duration `envconfig:"default=1m"`
-	ServiceAccountRequeueDuration time.Duration `envconfig:"default=1m"`
+	BaseNamespace          string `envconfig:"default=docker-registry"`
+	BaseInternalSecretName string `envconfig:"default=dockerregistry-config"`
+	BaseExternalSecretName string `envconfig:"default=dockerregistry-config-external"`
+	// BaseNamespaceCredentialsSecretName holds the htpasswd entries of the namespaces with their own credentials
+	BaseNamespaceCredentialsSecretName string        `envconfig:"default=dockerregistry-namespace-credentials"`
+	ExcludedNamespaces                 []string      `envconfig:"default=docker-registry;istio-system;kyma-system"`
+	ConfigMapRequeueDuration           time.Duration `envconfig:"default=1m"`
+	SecretRequeueDuration              time.Duration `envconfig:"default=1m"`
+	ServiceAccountRequeueDuration      time.Duration `envconfig:"default=1m"`
 }
 
 func getNamespaces(ctx context.Context, client client.Client, base string, excluded []string) ([]string, error) {
@@ -47,7 +49,8 @@ func getNamespaces(ctx context.Context, client client.Client, base string, exclu
 
 	names := make([]string, 0)
 	for _, namespace := range namespaces.Items {
-		if !isExcludedNamespace(namespace.GetName(), base, excluded) && namespace.Status.Phase != corev1.NamespaceTerminating {
+		if !isExcludedNamespace(namespace.GetName(), base, excluded) && namespace.Status.Phase != corev1.NamespaceTerminating &&
+			!isRevokedNamespace(&namespace) {
 			names = append(names, namespace.GetName())
 		}
 	}
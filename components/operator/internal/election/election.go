@@ -0,0 +1,85 @@
+package election
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+const (
+	DefaultLeaseNamespace = "docker-registry"
+	DefaultLeaseName      = "dockerregistry-operator.kyma-project.io"
+
+	// the defaults match the ones client-go recommends and controller-runtime uses
+	DefaultLeaseDuration = 15 * time.Second
+	DefaultRenewDeadline = 10 * time.Second
+	DefaultRetryPeriod   = 2 * time.Second
+)
+
+// Config describes the Lease the operator replicas compete for. Only the replica holding it runs the
+// controllers, so that they never race each other on the same Secrets and chart installations.
+type Config struct {
+	Enabled       bool
+	Namespace     string
+	Name          string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+func (c Config) LeaseKey() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: c.Namespace,
+		Name:      c.Name,
+	}
+}
+
+// ReadinessCheck reports a replica as ready as long as the operator has an active leader: either the
+// replica holds the Lease itself, or another replica renewed it recently. A standby is ready to take
+// over, so it counts as available for the PodDisruptionBudget and draining the leader's node is never
+// blocked, while a replica that sees no live leader at all is reported instead of silently idling.
+func ReadinessCheck(elected <-chan struct{}, reader client.Reader, config Config) healthz.Checker {
+	return newReadinessCheck(elected, reader, config, time.Now)
+}
+
+func newReadinessCheck(elected <-chan struct{}, reader client.Reader, config Config, now func() time.Time) healthz.Checker {
+	return func(req *http.Request) error {
+		select {
+		case <-elected:
+			// the manager closes the channel right away when leader election is disabled
+			return nil
+		default:
+		}
+
+		lease := &coordinationv1.Lease{}
+		if err := reader.Get(req.Context(), config.LeaseKey(), lease); err != nil {
+			return errors.Wrap(err, "while fetching leader election lease")
+		}
+
+		return checkLeaseRenewed(lease, config.LeaseDuration, now())
+	}
+}
+
+func checkLeaseRenewed(lease *coordinationv1.Lease, leaseDuration time.Duration, now time.Time) error {
+	holder := lease.Spec.HolderIdentity
+	if holder == nil || *holder == "" {
+		return fmt.Errorf("no replica holds the lease %s/%s", lease.GetNamespace(), lease.GetName())
+	}
+
+	if lease.Spec.LeaseDurationSeconds != nil {
+		leaseDuration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+
+	renewTime := lease.Spec.RenewTime
+	if renewTime == nil || now.Sub(renewTime.Time) > leaseDuration {
+		return fmt.Errorf("leader %s stopped renewing the lease %s/%s", *holder, lease.GetNamespace(), lease.GetName())
+	}
+
+	return nil
+}
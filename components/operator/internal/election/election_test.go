@@ -0,0 +1,104 @@
+package election
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReadinessCheck(t *testing.T) {
+	now := time.Date(2024, 5, 16, 10, 0, 0, 0, time.UTC)
+	config := Config{
+		Enabled:       true,
+		Namespace:     DefaultLeaseNamespace,
+		Name:          DefaultLeaseName,
+		LeaseDuration: DefaultLeaseDuration,
+	}
+
+	t.Run("ready when elected", func(t *testing.T) {
+		elected := make(chan struct{})
+		close(elected)
+
+		check := newReadinessCheck(elected, fixClient(t), config, func() time.Time { return now })
+
+		require.NoError(t, check(fixRequest(t)))
+	})
+
+	t.Run("ready as standby while the leader renews the lease", func(t *testing.T) {
+		lease := fixLease("operator-1", now.Add(-5*time.Second), nil)
+
+		check := newReadinessCheck(make(chan struct{}), fixClient(t, lease), config, func() time.Time { return now })
+
+		require.NoError(t, check(fixRequest(t)))
+	})
+
+	t.Run("not ready when the lease does not exist", func(t *testing.T) {
+		check := newReadinessCheck(make(chan struct{}), fixClient(t), config, func() time.Time { return now })
+
+		require.ErrorContains(t, check(fixRequest(t)), "while fetching leader election lease")
+	})
+
+	t.Run("not ready when nobody holds the lease", func(t *testing.T) {
+		lease := fixLease("", now, nil)
+
+		check := newReadinessCheck(make(chan struct{}), fixClient(t, lease), config, func() time.Time { return now })
+
+		require.EqualError(t, check(fixRequest(t)),
+			"no replica holds the lease docker-registry/dockerregistry-operator.kyma-project.io")
+	})
+
+	t.Run("not ready when the leader stopped renewing the lease", func(t *testing.T) {
+		lease := fixLease("operator-1", now.Add(-time.Minute), nil)
+
+		check := newReadinessCheck(make(chan struct{}), fixClient(t, lease), config, func() time.Time { return now })
+
+		require.EqualError(t, check(fixRequest(t)),
+			"leader operator-1 stopped renewing the lease docker-registry/dockerregistry-operator.kyma-project.io")
+	})
+
+	t.Run("use the lease duration written by the leader", func(t *testing.T) {
+		lease := fixLease("operator-1", now.Add(-time.Minute), ptr.To[int32](120))
+
+		check := newReadinessCheck(make(chan struct{}), fixClient(t, lease), config, func() time.Time { return now })
+
+		require.NoError(t, check(fixRequest(t)))
+	})
+}
+
+func fixLease(holder string, renewTime time.Time, durationSeconds *int32) *coordinationv1.Lease {
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: DefaultLeaseNamespace,
+			Name:      DefaultLeaseName,
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To(holder),
+			LeaseDurationSeconds: durationSeconds,
+			RenewTime:            &metav1.MicroTime{Time: renewTime},
+		},
+	}
+}
+
+func fixClient(t *testing.T, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	require.NoError(t, coordinationv1.AddToScheme(scheme))
+
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		Build()
+}
+
+func fixRequest(t *testing.T) *http.Request {
+	req, err := http.NewRequest(http.MethodGet, "/readyz", nil)
+	require.NoError(t, err)
+	return req
+}
@@ -4,7 +4,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
 	"github.com/kyma-project/manager-toolkit/installation/chart"
@@ -12,6 +14,8 @@ import (
 
 const (
 	FullnameOverride = "dockerregistry"
+	// TokenBundlePath is where the chart mounts the certificates of the token signing keys
+	TokenBundlePath = "/etc/registry-token/bundle.pem"
 )
 
 type Builder struct {
@@ -30,12 +34,30 @@ func (fb *Builder) WithFullname(fullname string) *Builder {
 	return fb
 }
 
+func (fb *Builder) WithAccessSecretNames(internalSecretName, externalSecretName string) *Builder {
+	_ = fb.With("internalAccessSecretName", internalSecretName)
+	_ = fb.With("externalAccessSecretName", externalSecretName)
+	return fb
+}
+
+func (fb *Builder) WithPriorityClassName(name string) *Builder {
+	_ = fb.With("dockerregistryPriorityClassName", name)
+	return fb
+}
+
 func (fb *Builder) WithRegistryCredentials(username, password string) *Builder {
 	_ = fb.With("dockerRegistry.username", username)
 	_ = fb.With("dockerRegistry.password", password)
 	return fb
 }
 
+// WithRegistryCredentialsFromSecret sets the credentials from the user-owned Secret, they are changed by the user
+// at any time, so the registry is rolled out to load them
+func (fb *Builder) WithRegistryCredentialsFromSecret(username, password string) *Builder {
+	return fb.WithRegistryCredentials(username, password).
+		withCredentialsRollme("dockerRegistry", username, password)
+}
+
 func (fb *Builder) WithRegistryHttpSecret(httpSecret string) *Builder {
 	_ = fb.With("registryHTTPSecret", httpSecret)
 	return fb
@@ -54,11 +76,54 @@ func (fb *Builder) WithVirtualService(host, gateway string) *Builder {
 	return fb
 }
 
+// WithoutVirtualService stops exposing the registry configured to be exposed before
+func (fb *Builder) WithoutVirtualService() *Builder {
+	_ = fb.With("virtualService.enabled", false)
+	return fb
+}
+
+// WithVirtualServiceTokenServer routes the token requests of the clients outside the cluster to the external
+// login of the token server, which accepts only the external access credentials or the oidc identities
+func (fb *Builder) WithVirtualServiceTokenServer(tokenServerHost string, tokenServerPort int32) *Builder {
+	_ = fb.With("virtualService.tokenServer.enabled", true)
+	_ = fb.With("virtualService.tokenServer.host", tokenServerHost)
+	_ = fb.With("virtualService.tokenServer.port", int64(tokenServerPort))
+	return fb
+}
+
+// WithVirtualServiceOIDC leaves the credentials out of the external access Secret, the clients outside the cluster
+// log in with their oidc identities
+func (fb *Builder) WithVirtualServiceOIDC() *Builder {
+	_ = fb.With("virtualService.oidc.enabled", true)
+	return fb
+}
+
+// WithExternalCredentials sets the credentials of the external access Secret, the registry is rolled out to accept
+// them whenever they are replaced
+func (fb *Builder) WithExternalCredentials(username, password string) *Builder {
+	_ = fb.With("externalCredentials.enabled", true)
+	_ = fb.With("externalCredentials.username", username)
+	_ = fb.With("externalCredentials.password", password)
+	return fb.withCredentialsRollme("externalCredentials", username, password)
+}
+
 func (fb *Builder) WithNodePort(nodePort int64) *Builder {
 	_ = fb.With("registryNodePort", nodePort)
 	return fb
 }
 
+func (fb *Builder) WithHighAvailability(replicas int32, topologyKey string) *Builder {
+	_ = fb.With("replicaCount", replicas)
+	// keep serving while the replicas are replaced one by one
+	_ = fb.With("updateStrategy.type", "RollingUpdate")
+	_ = fb.With("updateStrategy.rollingUpdate.maxSurge", 1)
+	_ = fb.With("updateStrategy.rollingUpdate.maxUnavailable", 0)
+	_ = fb.With("podDisruptionBudget.maxUnavailable", 1)
+	_ = fb.With("topologySpread.enabled", true)
+	_ = fb.With("topologySpread.topologyKey", topologyKey)
+	return fb
+}
+
 func (fb *Builder) WithPVCDisabled() *Builder {
 	_ = fb.With("persistence.enabled", false)
 	return fb
@@ -73,6 +138,34 @@ func (fb *Builder) WithAzure(secret *v1alpha1.StorageAzureSecrets) *Builder {
 	return fb.withCredentialsRollme("secrets.azure", secret.AccountName, secret.AccountKey, secret.Container)
 }
 
+// WithAzureWorkloadIdentity makes the registry authenticate to the storage account with the federated token of its
+// ServiceAccount instead of the account key
+func (fb *Builder) WithAzureWorkloadIdentity(config *v1alpha1.AzureWorkloadIdentity) *Builder {
+	_ = fb.With("storage", "azure")
+	_ = fb.With("azure.accountName", config.AccountName)
+	_ = fb.With("azure.container", config.Container)
+	_ = fb.With("azure.credentialsType", "default_credentials")
+	return fb.WithWorkloadIdentity(
+		map[string]string{"azure.workload.identity/client-id": config.ClientID},
+		map[string]string{"azure.workload.identity/use": "true"},
+	)
+}
+
+// WithAzureStorageAdapter points the azure storage driver to the storage adapter of the operator, which authorizes
+// the requests with the SAS token of the BTP Object Store binding. The adapter recognizes the registry by the account
+// name and checks the signature made with the account key generated for it.
+func (fb *Builder) WithAzureStorageAdapter(serviceURL, accountName, accountKey, container string) *Builder {
+	fb = fb.WithAzure(&v1alpha1.StorageAzureSecrets{
+		AccountName: accountName,
+		AccountKey:  accountKey,
+		Container:   container,
+	})
+	_ = fb.With("azure.serviceURL", serviceURL)
+	// the clients can't download the blobs from the adapter, the registry serves them instead of redirecting
+	_ = fb.With("configData.storage.redirect.disable", true)
+	return fb.withRollme(fmt.Sprintf("azure.serviceURL=%s", serviceURL))
+}
+
 func (fb *Builder) WithS3(config *v1alpha1.StorageS3, secret *v1alpha1.StorageS3Secrets) *Builder {
 	_ = fb.With("storage", "s3")
 	_ = fb.With("s3.bucket", config.Bucket)
@@ -84,6 +177,8 @@ func (fb *Builder) WithS3(config *v1alpha1.StorageS3, secret *v1alpha1.StorageS3
 		_ = fb.With("s3.regionEndpoint", config.RegionEndpoint)
 	}
 
+	fb = fb.withS3Options(config)
+
 	if secret != nil {
 		_ = fb.With("secrets.s3.accessKey", secret.AccessKey)
 		_ = fb.With("secrets.s3.secretKey", secret.SecretKey)
@@ -94,6 +189,66 @@ func (fb *Builder) WithS3(config *v1alpha1.StorageS3, secret *v1alpha1.StorageS3
 	return fb
 }
 
+// withS3Options sets the optional s3 parameters, the ones left empty keep the defaults of the registry
+func (fb *Builder) withS3Options(config *v1alpha1.StorageS3) *Builder {
+	options := map[string]any{}
+	if config.Rootdirectory != "" {
+		options["rootdirectory"] = config.Rootdirectory
+	}
+	if config.ForcePathStyle {
+		options["forcepathstyle"] = true
+	}
+	if config.KeyID != "" {
+		options["keyid"] = config.KeyID
+	}
+	if config.StorageClass != "" {
+		options["storageclass"] = config.StorageClass
+	}
+	if config.Chunksize != 0 {
+		options["chunksize"] = config.Chunksize
+	}
+	if config.SkipVerify {
+		options["skipverify"] = true
+	}
+	if config.V4Auth != nil {
+		options["v4auth"] = *config.V4Auth
+	}
+
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_ = fb.With("s3."+name, options[name])
+		// restart the registry deployment to apply the changed option
+		fb = fb.withRollme(fmt.Sprintf("s3.%s=%v", name, options[name]))
+	}
+	return fb
+}
+
+// WithWorkloadIdentity annotates the registry ServiceAccount and labels the registry pods with the identity the object
+// storage accepts instead of static keys
+func (fb *Builder) WithWorkloadIdentity(serviceAccountAnnotations, podLabels map[string]string) *Builder {
+	fb = fb.withMetadata("workloadIdentity.serviceAccountAnnotations", serviceAccountAnnotations)
+	return fb.withMetadata("workloadIdentity.podLabels", podLabels)
+}
+
+// withMetadata sets annotations or labels under the given value, the dots in their keys are escaped
+func (fb *Builder) withMetadata(name string, metadata map[string]string) *Builder {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		_ = fb.With(name+"."+strings.ReplaceAll(key, ".", "\\."), metadata[key])
+		// the identity is injected into the pods when they are created, restart them to pick up the changed one
+		fb = fb.withRollme(fmt.Sprintf("%s=%s", key, metadata[key]))
+	}
+	return fb
+}
+
 func (fb *Builder) WithDeleteEnabled(enabled bool) *Builder {
 	_ = fb.With("configData.storage.delete.enabled", enabled)
 	// restart deployment registry deploy to fetch new configuration from configmap
@@ -101,6 +256,93 @@ func (fb *Builder) WithDeleteEnabled(enabled bool) *Builder {
 	return fb.withRollme(fmt.Sprintf("configData.storage.delete.enabled=%t", enabled))
 }
 
+func (fb *Builder) WithReadOnlyMaintenance() *Builder {
+	_ = fb.With("configData.storage.maintenance.readonly.enabled", true)
+	// restart deployment registry to stop accepting pushes before the garbage collection starts
+	return fb.withRollme("configData.storage.maintenance.readonly.enabled=true")
+}
+
+// WithStorageMigration scales the registry down while the images are copied to its new storage, the old storage is
+// served by a read-only copy of the registry deployment. The PVC of the filesystem storage is kept while it is copied.
+func (fb *Builder) WithStorageMigration(keepPVC bool) *Builder {
+	_ = fb.With("replicaCount", 0)
+	_ = fb.With("storageMigration.enabled", true)
+	if keepPVC {
+		_ = fb.With("persistence.enabled", true)
+	}
+	return fb
+}
+
+func (fb *Builder) WithProxy(remoteURL string, ttl time.Duration, secret *v1alpha1.ProxySecrets) *Builder {
+	_ = fb.With("configData.proxy.remoteurl", remoteURL)
+	_ = fb.With("configData.proxy.ttl", ttl.String())
+	// restart deployment registry to fetch new proxy configuration from configmap
+	fb = fb.withRollme(fmt.Sprintf("configData.proxy.remoteurl=%s", remoteURL))
+	fb = fb.withRollme(fmt.Sprintf("configData.proxy.ttl=%s", ttl))
+
+	if secret != nil {
+		_ = fb.With("secrets.proxy.username", secret.Username)
+		_ = fb.With("secrets.proxy.password", secret.Password)
+		// restart the registry deployment to pick up rotated credentials
+		fb = fb.withCredentialsRollme("secrets.proxy", secret.Username, secret.Password)
+	}
+
+	return fb
+}
+
+func (fb *Builder) WithTokenAuth(realm, service, issuer, keySecretName string, bundle []byte) *Builder {
+	_ = fb.With("tokenAuth.enabled", true)
+	_ = fb.With("tokenAuth.secretName", keySecretName)
+	_ = fb.With("configData.auth.token.realm", realm)
+	_ = fb.With("configData.auth.token.service", service)
+	_ = fb.With("configData.auth.token.issuer", issuer)
+	_ = fb.With("configData.auth.token.rootcertbundle", TokenBundlePath)
+	// restart deployment registry to switch the authentication and to follow the token server address
+	fb = fb.withRollme(fmt.Sprintf("configData.auth.token.realm=%s", realm))
+	// restart the registry deployment to trust the rotated signing keys
+	return fb.withCredentialsRollme("tokenAuth.bundle", string(bundle))
+}
+
+func (fb *Builder) WithNamespaceCredentials(secretName string, entries []string) *Builder {
+	_ = fb.With("namespaceCredentials.enabled", true)
+	_ = fb.With("namespaceCredentials.secretName", secretName)
+	// restart the registry deployment to load the htpasswd entries of the added and the revoked namespaces
+	return fb.withCredentialsRollme("namespaceCredentials", entries...)
+}
+
+func (fb *Builder) WithUsers(secretName string, entries []string) *Builder {
+	_ = fb.With("users.enabled", true)
+	_ = fb.With("users.secretName", secretName)
+	// restart the registry deployment to load the htpasswd entries of the added, changed and removed users
+	return fb.withCredentialsRollme("users", entries...)
+}
+
+// WithPullCredentials adds the pull-only credentials to the internal access Secret
+func (fb *Builder) WithPullCredentials(username, password string) *Builder {
+	_ = fb.With("pullCredentials.enabled", true)
+	_ = fb.With("pullCredentials.username", username)
+	_ = fb.With("pullCredentials.password", password)
+	// restart the registry deployment to load the htpasswd entry of the credentials, they are reused afterwards
+	return fb.withCredentialsRollme("pullCredentials", username, password)
+}
+
+// WithAccessProxy runs the operator image in front of the registry with the htpasswd authentication, to reject
+// the pushes of the pull-only credentials and the pushes of the per-namespace credentials outside their namespace
+func (fb *Builder) WithAccessProxy(image string) *Builder {
+	_ = fb.With("accessProxy.enabled", true)
+	_ = fb.With("accessProxy.image", image)
+	return fb
+}
+
+func (fb *Builder) WithPreviousRegistryCredentials(username, password string) *Builder {
+	_ = fb.With("previousCredentials.enabled", true)
+	_ = fb.With("previousCredentials.username", username)
+	_ = fb.With("previousCredentials.password", password)
+	// restart the registry deployment to accept the replaced credentials next to the new ones, it is restarted
+	// again once they are dropped
+	return fb.withCredentialsRollme("previousCredentials", username, password)
+}
+
 func (fb *Builder) WithFilesystem() *Builder {
 	_ = fb.With("storage", "filesystem")
 	_ = fb.With("configData.storage.filesystem.rootdirectory", "/var/lib/registry")
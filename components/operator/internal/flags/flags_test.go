@@ -2,10 +2,12 @@ package flags
 
 import (
 	"testing"
+	"time"
 
 	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
 	"github.com/kyma-project/manager-toolkit/installation/chart"
 	"github.com/stretchr/testify/require"
+	"k8s.io/utils/ptr"
 )
 
 func Test_flagsBuilder_Build(t *testing.T) {
@@ -198,6 +200,22 @@ func Test_flagsBuilder_credentialsRollme(t *testing.T) {
 					return b.WithGCS(&v1alpha1.StorageGCS{Bucket: "bucket"}, &v1alpha1.StorageGCSSecrets{AccountKey: "new-key"})
 				},
 			},
+			"proxy": {
+				before: func(b *Builder) *Builder {
+					return b.WithProxy("https://registry-1.docker.io", time.Hour, &v1alpha1.ProxySecrets{Username: "user", Password: "old-password"})
+				},
+				after: func(b *Builder) *Builder {
+					return b.WithProxy("https://registry-1.docker.io", time.Hour, &v1alpha1.ProxySecrets{Username: "user", Password: "new-password"})
+				},
+			},
+			"token signing keys": {
+				before: func(b *Builder) *Builder {
+					return b.WithTokenAuth("http://10.0.0.1:8090/token", "service", "issuer", "keys", []byte("old-bundle"))
+				},
+				after: func(b *Builder) *Builder {
+					return b.WithTokenAuth("http://10.0.0.1:8090/token", "service", "issuer", "keys", []byte("new-bundle"))
+				},
+			},
 		}
 
 		for name, testCase := range testCases {
@@ -226,6 +244,9 @@ func Test_flagsBuilder_credentialsRollme(t *testing.T) {
 			rollmeOf(t, func(b *Builder) *Builder {
 				return b.WithGCS(&v1alpha1.StorageGCS{Bucket: "bucket"}, &v1alpha1.StorageGCSSecrets{AccountKey: secret})
 			}),
+			rollmeOf(t, func(b *Builder) *Builder {
+				return b.WithProxy("https://registry-1.docker.io", time.Hour, &v1alpha1.ProxySecrets{Username: "user", Password: secret})
+			}),
 		}
 
 		for _, rollme := range rollmes {
@@ -242,3 +263,459 @@ func Test_flagsBuilder_credentialsRollme(t *testing.T) {
 		require.NotContains(t, flags, "rollme")
 	})
 }
+
+func Test_flagsBuilder_WithHighAvailability(t *testing.T) {
+	t.Run("set replicas, rolling update, disruption budget and topology spread", func(t *testing.T) {
+		expectedFlags := map[string]interface{}{
+			"replicaCount": int64(3),
+			"updateStrategy": map[string]interface{}{
+				"type": "RollingUpdate",
+				"rollingUpdate": map[string]interface{}{
+					"maxSurge":       int64(1),
+					"maxUnavailable": int64(0),
+				},
+			},
+			"podDisruptionBudget": map[string]interface{}{
+				"maxUnavailable": int64(1),
+			},
+			"topologySpread": map[string]interface{}{
+				"enabled":     true,
+				"topologyKey": "topology.kubernetes.io/zone",
+			},
+		}
+
+		flags, err := NewBuilder().
+			WithHighAvailability(3, "topology.kubernetes.io/zone").
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+	})
+}
+
+func Test_flagsBuilder_WithReadOnlyMaintenance(t *testing.T) {
+	t.Run("switch registry to read-only mode and restart it", func(t *testing.T) {
+		expectedFlags := map[string]interface{}{
+			"configData": map[string]interface{}{
+				"storage": map[string]interface{}{
+					"maintenance": map[string]interface{}{
+						"readonly": map[string]interface{}{
+							"enabled": true,
+						},
+					},
+				},
+			},
+			"rollme": "configData.storage.maintenance.readonly.enabled=true",
+		}
+
+		flags, err := NewBuilder().
+			WithReadOnlyMaintenance().
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+	})
+}
+
+func Test_flagsBuilder_WithStorageMigration(t *testing.T) {
+	t.Run("scale registry down while the images are copied", func(t *testing.T) {
+		expectedFlags := map[string]interface{}{
+			"replicaCount": int64(0),
+			"storageMigration": map[string]interface{}{
+				"enabled": true,
+			},
+		}
+
+		flags, err := NewBuilder().
+			WithStorageMigration(false).
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+	})
+
+	t.Run("keep the pvc of the filesystem storage", func(t *testing.T) {
+		expectedFlags := map[string]interface{}{
+			"persistence": map[string]interface{}{
+				"enabled": true,
+			},
+			"replicaCount": int64(0),
+			"storageMigration": map[string]interface{}{
+				"enabled": true,
+			},
+		}
+
+		flags, err := NewBuilder().
+			WithPVCDisabled().
+			WithStorageMigration(true).
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+	})
+}
+
+func Test_flagsBuilder_WithProxy(t *testing.T) {
+	t.Run("set upstream registry and credentials", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithProxy("https://registry-1.docker.io", 24*time.Hour, &v1alpha1.ProxySecrets{Username: "user", Password: "password"}).
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"proxy": map[string]interface{}{
+				"remoteurl": "https://registry-1.docker.io",
+				"ttl":       "24h0m0s",
+			},
+		}, flags["configData"])
+		require.Equal(t, map[string]interface{}{
+			"proxy": map[string]interface{}{
+				"username": "user",
+				"password": "password",
+			},
+		}, flags["secrets"])
+		require.Contains(t, flags["rollme"], "configData.proxy.remoteurl=https://registry-1.docker.io")
+		require.Contains(t, flags["rollme"], "configData.proxy.ttl=24h0m0s")
+	})
+
+	t.Run("pull anonymously without credentials", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithProxy("https://ghcr.io", 168*time.Hour, nil).
+			Build()
+
+		require.NoError(t, err)
+		require.NotContains(t, flags, "secrets")
+		require.Equal(t, "configData.proxy.remoteurl=https://ghcr.io,configData.proxy.ttl=168h0m0s", flags["rollme"])
+	})
+}
+
+func Test_flagsBuilder_WithS3(t *testing.T) {
+	t.Run("set advanced options and restart the registry", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithS3(&v1alpha1.StorageS3{
+				Bucket:         "bucket",
+				Region:         "us-east-1",
+				RegionEndpoint: "https://minio.example.com",
+				Encrypt:        true,
+				Rootdirectory:  "/registry",
+				ForcePathStyle: true,
+				KeyID:          "arn:aws:kms:us-east-1:123456789012:key/registry",
+				StorageClass:   "STANDARD_IA",
+				Chunksize:      10485760,
+				SkipVerify:     true,
+				V4Auth:         ptr.To(false),
+			}, nil).
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"bucket":         "bucket",
+			"region":         "us-east-1",
+			"regionEndpoint": "https://minio.example.com",
+			"encrypt":        true,
+			"secure":         false,
+			"rootdirectory":  "/registry",
+			"forcepathstyle": true,
+			"keyid":          "arn:aws:kms:us-east-1:123456789012:key/registry",
+			"storageclass":   "STANDARD_IA",
+			"chunksize":      int64(10485760),
+			"skipverify":     true,
+			"v4auth":         false,
+		}, flags["s3"])
+		require.Equal(t, "s3.chunksize=10485760,s3.forcepathstyle=true,s3.keyid=arn:aws:kms:us-east-1:123456789012:key/registry,"+
+			"s3.rootdirectory=/registry,s3.skipverify=true,s3.storageclass=STANDARD_IA,s3.v4auth=false", flags["rollme"])
+	})
+
+	t.Run("keep registry defaults without options", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithS3(&v1alpha1.StorageS3{Bucket: "bucket", Region: "region"}, nil).
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"bucket":  "bucket",
+			"region":  "region",
+			"encrypt": false,
+			"secure":  false,
+		}, flags["s3"])
+		require.NotContains(t, flags, "rollme")
+	})
+}
+
+func Test_flagsBuilder_WithWorkloadIdentity(t *testing.T) {
+	t.Run("annotate service account and restart the registry", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithWorkloadIdentity(map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789012:role/registry"}, nil).
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"serviceAccountAnnotations": map[string]interface{}{
+				"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789012:role/registry",
+			},
+		}, flags["workloadIdentity"])
+		require.Equal(t, "eks.amazonaws.com/role-arn=arn:aws:iam::123456789012:role/registry", flags["rollme"])
+	})
+
+	t.Run("configure azure storage without account key", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithAzureWorkloadIdentity(&v1alpha1.AzureWorkloadIdentity{
+				ClientID:    "client-id",
+				AccountName: "account",
+				Container:   "container",
+			}).
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"storage": "azure",
+			"azure": map[string]interface{}{
+				"accountName":     "account",
+				"container":       "container",
+				"credentialsType": "default_credentials",
+			},
+			"workloadIdentity": map[string]interface{}{
+				"serviceAccountAnnotations": map[string]interface{}{
+					"azure.workload.identity/client-id": "client-id",
+				},
+				"podLabels": map[string]interface{}{
+					"azure.workload.identity/use": true,
+				},
+			},
+			"rollme": "azure.workload.identity/client-id=client-id,azure.workload.identity/use=true",
+		}, flags)
+	})
+}
+
+func Test_flagsBuilder_WithAzureStorageAdapter(t *testing.T) {
+	t.Run("route azure storage through the storage adapter", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithAzureStorageAdapter(
+				"http://dockerregistry-storage-adapter.kyma-system.svc.cluster.local:8091/docker-registry",
+				"account",
+				"YWNjb3VudC1rZXk=",
+				"container",
+			).
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, "azure", flags["storage"])
+		require.Equal(t, map[string]interface{}{
+			"serviceURL": "http://dockerregistry-storage-adapter.kyma-system.svc.cluster.local:8091/docker-registry",
+		}, flags["azure"])
+		require.Equal(t, map[string]interface{}{
+			"azure": map[string]interface{}{
+				"accountName": "account",
+				"accountKey":  "YWNjb3VudC1rZXk=",
+				"container":   "container",
+			},
+		}, flags["secrets"])
+		require.Equal(t, map[string]interface{}{
+			"storage": map[string]interface{}{
+				"redirect": map[string]interface{}{
+					"disable": true,
+				},
+			},
+		}, flags["configData"])
+		require.Contains(t, flags["rollme"], "azure.serviceURL=http://dockerregistry-storage-adapter.kyma-system.svc.cluster.local:8091/docker-registry")
+	})
+}
+
+func Test_flagsBuilder_WithTokenAuth(t *testing.T) {
+	t.Run("configure token authentication", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithTokenAuth(
+				"http://10.0.0.1:8090/token",
+				"dockerregistry.docker-registry.svc.cluster.local",
+				"dockerregistry-operator",
+				"dockerregistry-token-key",
+				[]byte("bundle"),
+			).
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"auth": map[string]interface{}{
+				"token": map[string]interface{}{
+					"realm":          "http://10.0.0.1:8090/token",
+					"service":        "dockerregistry.docker-registry.svc.cluster.local",
+					"issuer":         "dockerregistry-operator",
+					"rootcertbundle": "/etc/registry-token/bundle.pem",
+				},
+			},
+		}, flags["configData"])
+		require.Equal(t, map[string]interface{}{
+			"enabled":    true,
+			"secretName": "dockerregistry-token-key",
+		}, flags["tokenAuth"])
+		require.Contains(t, flags["rollme"], "configData.auth.token.realm=http://10.0.0.1:8090/token")
+		require.Contains(t, flags["rollme"], "tokenAuth.bundle=")
+	})
+}
+
+func Test_flagsBuilder_WithNamespaceCredentials(t *testing.T) {
+	t.Run("configure namespace credentials", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithNamespaceCredentials("dockerregistry-namespace-credentials", []string{"deployer:hash\n"}).
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"enabled":    true,
+			"secretName": "dockerregistry-namespace-credentials",
+		}, flags["namespaceCredentials"])
+		require.Contains(t, flags["rollme"], "namespaceCredentials=")
+	})
+
+	t.Run("roll registry when entries change", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithNamespaceCredentials("dockerregistry-namespace-credentials", []string{"deployer:hash\n"}).
+			Build()
+		require.NoError(t, err)
+		otherFlags, err := NewBuilder().
+			WithNamespaceCredentials("dockerregistry-namespace-credentials", []string{"deployer:hash\n", "tester:hash\n"}).
+			Build()
+		require.NoError(t, err)
+
+		require.NotEqual(t, flags["rollme"], otherFlags["rollme"])
+	})
+}
+
+func Test_flagsBuilder_WithUsers(t *testing.T) {
+	t.Run("configure users", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithUsers("dockerregistry-users", []string{"ci:hash\n"}).
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"enabled":    true,
+			"secretName": "dockerregistry-users",
+		}, flags["users"])
+		require.Contains(t, flags["rollme"], "users=")
+	})
+
+	t.Run("roll registry when entries change", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithUsers("dockerregistry-users", []string{"ci:hash\n", "robot:hash\n"}).
+			Build()
+		require.NoError(t, err)
+		otherFlags, err := NewBuilder().
+			WithUsers("dockerregistry-users", []string{"ci:hash\n"}).
+			Build()
+		require.NoError(t, err)
+
+		require.NotEqual(t, flags["rollme"], otherFlags["rollme"])
+	})
+}
+
+func Test_flagsBuilder_WithVirtualServiceTokenServer(t *testing.T) {
+	t.Run("route token requests to token server", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithVirtualService("registry.example.com", "kyma-system/kyma-gateway").
+			WithVirtualServiceTokenServer("dockerregistry-token-server.kyma-system.svc.cluster.local", 8090).
+			WithVirtualServiceOIDC().
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"virtualService": map[string]interface{}{
+				"enabled": true,
+				"host":    "registry.example.com",
+				"gateway": "kyma-system/kyma-gateway",
+				"tokenServer": map[string]interface{}{
+					"enabled": true,
+					"host":    "dockerregistry-token-server.kyma-system.svc.cluster.local",
+					"port":    int64(8090),
+				},
+				"oidc": map[string]interface{}{
+					"enabled": true,
+				},
+			},
+		}, flags)
+	})
+}
+
+func Test_flagsBuilder_WithExternalCredentials(t *testing.T) {
+	t.Run("configure external credentials", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithExternalCredentials("external-user", "external-password").
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"enabled":  true,
+			"username": "external-user",
+			"password": "external-password",
+		}, flags["externalCredentials"])
+		require.Contains(t, flags["rollme"], "externalCredentials=")
+	})
+}
+
+func Test_flagsBuilder_WithPullCredentials(t *testing.T) {
+	t.Run("configure pull credentials", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithPullCredentials("pull-user", "pull-password").
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"enabled":  true,
+			"username": "pull-user",
+			"password": "pull-password",
+		}, flags["pullCredentials"])
+		require.Contains(t, flags["rollme"], "pullCredentials=")
+	})
+}
+
+func Test_flagsBuilder_WithAccessProxy(t *testing.T) {
+	t.Run("configure access proxy", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithAccessProxy("dockerregistry-operator:1.0.0").
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"accessProxy": map[string]interface{}{
+				"enabled": true,
+				"image":   "dockerregistry-operator:1.0.0",
+			},
+		}, flags)
+	})
+}
+
+func Test_flagsBuilder_WithPreviousRegistryCredentials(t *testing.T) {
+	t.Run("configure previous credentials", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithPreviousRegistryCredentials("old-user", "old-password").
+			Build()
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"enabled":  true,
+			"username": "old-user",
+			"password": "old-password",
+		}, flags["previousCredentials"])
+		require.Contains(t, flags["rollme"], "previousCredentials=")
+	})
+}
+
+func Test_flagsBuilder_WithRegistryCredentialsFromSecret(t *testing.T) {
+	t.Run("roll registry when credentials change", func(t *testing.T) {
+		flags, err := NewBuilder().
+			WithRegistryCredentialsFromSecret("user", "password").
+			Build()
+		require.NoError(t, err)
+		otherFlags, err := NewBuilder().
+			WithRegistryCredentialsFromSecret("user", "other-password").
+			Build()
+		require.NoError(t, err)
+
+		require.Equal(t, map[string]interface{}{
+			"username": "user",
+			"password": "password",
+		}, flags["dockerRegistry"])
+		require.Contains(t, flags["rollme"], "dockerRegistry=")
+		require.NotEqual(t, flags["rollme"], otherFlags["rollme"])
+	})
+}
@@ -0,0 +1,119 @@
+package migration
+
+import (
+	"context"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1beta1"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	DockerRegistryCRDName = "dockerregistries.operator.kyma-project.io"
+
+	defaultRetryInterval = time.Minute
+)
+
+var _ manager.LeaderElectionRunnable = &StorageVersion{}
+
+// StorageVersion moves the stored DockerRegistry objects to the v1beta1 storage version. Every object is
+// written once, which makes the API server store it in the current storage version, and then the older
+// versions are removed from the CRD status, so that they can be dropped from the CRD in a later release.
+type StorageVersion struct {
+	client        client.Client
+	reader        client.Reader
+	log           *zap.SugaredLogger
+	retryInterval time.Duration
+}
+
+func NewStorageVersion(client client.Client, reader client.Reader, log *zap.SugaredLogger) *StorageVersion {
+	return &StorageVersion{
+		client:        client,
+		reader:        reader,
+		log:           log,
+		retryInterval: defaultRetryInterval,
+	}
+}
+
+// NeedLeaderElection makes only the leader migrate, so that the replicas do not rewrite the same objects
+func (m *StorageVersion) NeedLeaderElection() bool {
+	return true
+}
+
+// Start retries the migration until it succeeds, it needs the conversion webhook to be served to read
+// objects stored in older versions
+func (m *StorageVersion) Start(ctx context.Context) error {
+	err := wait.PollUntilContextCancel(ctx, m.retryInterval, true, func(ctx context.Context) (bool, error) {
+		if err := m.Migrate(ctx); err != nil {
+			m.log.Warnf("storage version migration failed, retrying in %s: %s", m.retryInterval, err)
+			return false, nil
+		}
+		return true, nil
+	})
+	if ctx.Err() != nil {
+		// the manager is stopping, the next leader migrates
+		return nil
+	}
+	return err
+}
+
+func (m *StorageVersion) Migrate(ctx context.Context) error {
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := m.reader.Get(ctx, client.ObjectKey{Name: DockerRegistryCRDName}, crd); err != nil {
+		return errors.Wrap(err, "while fetching dockerregistry CRD")
+	}
+
+	storageVersion := getStorageVersion(crd)
+	if storageVersion != v1beta1.GroupVersion.Version {
+		m.log.Infof("skipping storage version migration, CRD stores objects in version '%s'", storageVersion)
+		return nil
+	}
+
+	if isMigrated(crd, storageVersion) {
+		return nil
+	}
+
+	list := &v1beta1.DockerRegistryList{}
+	if err := m.reader.List(ctx, list); err != nil {
+		return errors.Wrap(err, "while listing dockerregistry objects")
+	}
+
+	for i := range list.Items {
+		err := m.client.Update(ctx, &list.Items[i])
+		// a conflict means the object was written in the meantime, so it is stored in the new version anyway
+		if client.IgnoreNotFound(err) != nil && !apierrors.IsConflict(err) {
+			return errors.Wrapf(err, "while migrating dockerregistry %s/%s",
+				list.Items[i].GetNamespace(), list.Items[i].GetName())
+		}
+	}
+
+	original := crd.DeepCopy()
+	crd.Status.StoredVersions = []string{storageVersion}
+	err := m.client.Status().Patch(ctx, crd, client.MergeFromWithOptions(original, client.MergeFromWithOptimisticLock{}))
+	if err != nil {
+		return errors.Wrap(err, "while updating stored versions of dockerregistry CRD")
+	}
+
+	m.log.Infof("migrated %d dockerregistry objects to storage version '%s'", len(list.Items), storageVersion)
+	return nil
+}
+
+func getStorageVersion(crd *apiextensionsv1.CustomResourceDefinition) string {
+	for _, version := range crd.Spec.Versions {
+		if version.Storage {
+			return version.Name
+		}
+	}
+	return ""
+}
+
+func isMigrated(crd *apiextensionsv1.CustomResourceDefinition, storageVersion string) bool {
+	storedVersions := crd.Status.StoredVersions
+	return len(storedVersions) == 1 && storedVersions[0] == storageVersion
+}
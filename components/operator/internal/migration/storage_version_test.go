@@ -0,0 +1,108 @@
+package migration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1beta1"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestStorageVersion_Migrate(t *testing.T) {
+	t.Run("rewrite objects and drop old stored versions", func(t *testing.T) {
+		dockerRegistry := fixDockerRegistry()
+		c := fixClient(t, fixCRD("v1beta1", "v1alpha1", "v1beta1"), dockerRegistry)
+
+		err := NewStorageVersion(c, c, zap.NewNop().Sugar()).Migrate(context.Background())
+
+		require.NoError(t, err)
+
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: DockerRegistryCRDName}, crd))
+		require.Equal(t, []string{"v1beta1"}, crd.Status.StoredVersions)
+
+		migrated := &v1beta1.DockerRegistry{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(dockerRegistry), migrated))
+		require.NotEqual(t, dockerRegistry.GetResourceVersion(), migrated.GetResourceVersion())
+	})
+
+	t.Run("skip migrated CRD", func(t *testing.T) {
+		dockerRegistry := fixDockerRegistry()
+		c := fixClient(t, fixCRD("v1beta1", "v1beta1"), dockerRegistry)
+
+		err := NewStorageVersion(c, c, zap.NewNop().Sugar()).Migrate(context.Background())
+
+		require.NoError(t, err)
+
+		notMigrated := &v1beta1.DockerRegistry{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(dockerRegistry), notMigrated))
+		require.Equal(t, dockerRegistry.GetResourceVersion(), notMigrated.GetResourceVersion())
+	})
+
+	t.Run("skip CRD with another storage version", func(t *testing.T) {
+		c := fixClient(t, fixCRD("v1alpha1", "v1alpha1"))
+
+		err := NewStorageVersion(c, c, zap.NewNop().Sugar()).Migrate(context.Background())
+
+		require.NoError(t, err)
+
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: DockerRegistryCRDName}, crd))
+		require.Equal(t, []string{"v1alpha1"}, crd.Status.StoredVersions)
+	})
+
+	t.Run("return error when CRD does not exist", func(t *testing.T) {
+		c := fixClient(t)
+
+		err := NewStorageVersion(c, c, zap.NewNop().Sugar()).Migrate(context.Background())
+
+		require.ErrorContains(t, err, "while fetching dockerregistry CRD")
+	})
+}
+
+func fixDockerRegistry() *v1beta1.DockerRegistry {
+	return &v1beta1.DockerRegistry{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "default",
+			Namespace:       "kyma-system",
+			ResourceVersion: "10",
+		},
+	}
+}
+
+func fixCRD(storageVersion string, storedVersions ...string) *apiextensionsv1.CustomResourceDefinition {
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DockerRegistryCRDName,
+		},
+		Status: apiextensionsv1.CustomResourceDefinitionStatus{
+			StoredVersions: storedVersions,
+		},
+	}
+	for _, version := range []string{"v1alpha1", "v1beta1"} {
+		crd.Spec.Versions = append(crd.Spec.Versions, apiextensionsv1.CustomResourceDefinitionVersion{
+			Name:    version,
+			Served:  true,
+			Storage: version == storageVersion,
+		})
+	}
+	return crd
+}
+
+func fixClient(t *testing.T, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	require.NoError(t, apiextensionsv1.AddToScheme(scheme))
+	require.NoError(t, v1beta1.AddToScheme(scheme))
+
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&apiextensionsv1.CustomResourceDefinition{}).
+		Build()
+}
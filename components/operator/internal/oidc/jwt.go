@@ -0,0 +1,168 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// RS256 and ES256 are the algorithms the ID tokens may be signed with, the others are rejected
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+type tokenHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type parsedToken struct {
+	header       tokenHeader
+	claims       Claims
+	signingInput string
+	signature    []byte
+}
+
+func parseToken(rawToken string) (*parsedToken, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.Wrap(ErrInvalidToken, "token is not a JWT")
+	}
+
+	token := &parsedToken{signingInput: parts[0] + "." + parts[1]}
+	if err := decodeSegment(parts[0], &token.header); err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, "malformed token header")
+	}
+	if err := decodeSegment(parts[1], &token.claims); err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, "malformed token claims")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidToken, "malformed token signature")
+	}
+	token.signature = signature
+
+	if token.header.Algorithm != RS256 && token.header.Algorithm != ES256 {
+		return nil, errors.Wrapf(ErrInvalidToken, "unsupported signing algorithm '%s'", token.header.Algorithm)
+	}
+	return token, nil
+}
+
+// verifySignature checks the signature with the key of the token, or with every key when the token names none
+func (t *parsedToken) verifySignature(keys map[string]crypto.PublicKey) bool {
+	digest := sha256.Sum256([]byte(t.signingInput))
+
+	if t.header.KeyID != "" {
+		key, ok := keys[t.header.KeyID]
+		return ok && verify(t.header.Algorithm, key, digest[:], t.signature)
+	}
+	for _, key := range keys {
+		if verify(t.header.Algorithm, key, digest[:], t.signature) {
+			return true
+		}
+	}
+	return false
+}
+
+func verify(algorithm string, key crypto.PublicKey, digest, signature []byte) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return algorithm == RS256 && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// ES256 signatures are the fixed size big-endian r and s concatenated
+		if algorithm != ES256 || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest, r, s)
+	default:
+		return false
+	}
+}
+
+func decodeSegment(segment string, into interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(decoded, into)
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// publicKeys returns the signing keys of the set by their ids, the encryption keys and the keys of unsupported
+// types are skipped
+func (s jsonWebKeySet) publicKeys() map[string]crypto.PublicKey {
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range s.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+	return keys
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("rsa exponent is too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, errors.Errorf("unsupported curve '%s'", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("malformed P-256 point")
+		}
+		// the uncompressed point format is checked to be on the curve
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+	default:
+		return nil, errors.Errorf("unsupported key type '%s'", k.KeyType)
+	}
+}
@@ -0,0 +1,181 @@
+// Package oidctest runs a local OpenID Connect identity provider for the tests of the oidc login
+package oidctest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	keyID = "oidctest"
+	// TokenTTL is how long the minted ID tokens are valid unless the claims set the expiration
+	TokenTTL = time.Hour
+)
+
+type user struct {
+	password string
+	claims   map[string]interface{}
+}
+
+// Provider is an identity provider serving the discovery document, the keys and the password grant of its users
+// over TLS, the clients must use the HTTP client of the server to trust it
+type Provider struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	algorithm string
+	key       crypto.Signer
+
+	mu    sync.Mutex
+	users map[string]user
+}
+
+// NewProvider starts an identity provider signing the ID tokens for the client with the RS256 or the ES256 algorithm,
+// the provider is stopped when the test finishes
+func NewProvider(t testing.TB, clientID, algorithm string) *Provider {
+	t.Helper()
+
+	var key crypto.Signer
+	var err error
+	switch algorithm {
+	case "RS256":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		t.Fatalf("unsupported algorithm %s", algorithm)
+	}
+	if err != nil {
+		t.Fatalf("failed to generate signing key: %s", err)
+	}
+
+	p := &Provider{
+		ClientID:  clientID,
+		algorithm: algorithm,
+		key:       key,
+		users:     map[string]user{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.serveDiscovery)
+	mux.HandleFunc("/keys", p.serveKeys)
+	mux.HandleFunc("/token", p.serveToken)
+	p.Server = httptest.NewTLSServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// AddUser lets the user log in with the password grant, the claims are added to the ID tokens of the user
+func (p *Provider) AddUser(username, password string, claims map[string]interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.users[username] = user{password: password, claims: claims}
+}
+
+// IDToken mints an ID token for the client, the issuer, the audience and the expiration are set unless the claims
+// override them
+func (p *Provider) IDToken(claims map[string]interface{}) string {
+	payload := map[string]interface{}{
+		"iss": p.URL,
+		"aud": p.ClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(TokenTTL).Unix(),
+	}
+	for name, value := range claims {
+		payload[name] = value
+	}
+
+	header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": p.algorithm, "kid": keyID})
+	body, _ := json.Marshal(payload)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(p.sign(signingInput))
+}
+
+func (p *Provider) sign(signingInput string) []byte {
+	digest := sha256.Sum256([]byte(signingInput))
+	switch key := p.key.(type) {
+	case *rsa.PrivateKey:
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		return signature
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+		return signature
+	default:
+		return nil
+	}
+}
+
+func (p *Provider) serveDiscovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":         p.URL,
+		"jwks_uri":       p.URL + "/keys",
+		"token_endpoint": p.URL + "/token",
+	})
+}
+
+func (p *Provider) serveKeys(w http.ResponseWriter, _ *http.Request) {
+	jwk := map[string]string{"kid": keyID, "use": "sig", "alg": p.algorithm}
+	switch key := p.key.(type) {
+	case *rsa.PrivateKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk["e"] = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PrivateKey:
+		point, _ := key.PublicKey.Bytes()
+		jwk["kty"] = "EC"
+		jwk["crv"] = "P-256"
+		jwk["x"] = base64.RawURLEncoding.EncodeToString(point[1:33])
+		jwk["y"] = base64.RawURLEncoding.EncodeToString(point[33:])
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{jwk}})
+}
+
+// serveToken implements the resource owner password grant, the client secret is checked when the provider has one
+func (p *Provider) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "password" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+	if r.PostForm.Get("client_id") != p.ClientID || r.PostForm.Get("client_secret") != p.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	found, ok := p.users[r.PostForm.Get("username")]
+	p.mu.Unlock()
+	if !ok || found.password != r.PostForm.Get("password") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "opaque",
+		"token_type":   "Bearer",
+		"expires_in":   int64(TokenTTL.Seconds()),
+		"id_token":     p.IDToken(found.claims),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
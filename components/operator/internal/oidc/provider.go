@@ -0,0 +1,247 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+
+	// keysRefreshInterval limits how often the keys are fetched again for the tokens signed by an unknown key
+	keysRefreshInterval = time.Minute
+	// clockSkew is how much the clocks of the identity provider and the operator may differ
+	clockSkew = time.Minute
+	// the discovery documents, the keys and the token responses are small, a larger one is not read to the end
+	maxResponseSize = 1 << 20
+)
+
+var (
+	// ErrInvalidToken is returned for the ID tokens the identity provider did not issue for the client or that
+	// expired
+	ErrInvalidToken = errors.New("invalid id token")
+	// ErrInvalidCredentials is returned when the identity provider rejects the username and the password
+	ErrInvalidCredentials = errors.New("invalid oidc credentials")
+)
+
+// Claims are the claims of a verified ID token
+type Claims map[string]interface{}
+
+// String returns the value of the claim, or an empty string if the claim is missing or not a string
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+type discovery struct {
+	Issuer        string `json:"issuer"`
+	JWKSURI       string `json:"jwks_uri"`
+	TokenEndpoint string `json:"token_endpoint"`
+}
+
+// Provider logs the users in with an OpenID Connect identity provider. The discovery document is fetched on the
+// first use, the keys also when a token is signed by a key that is not known yet.
+type Provider struct {
+	issuerURL  string
+	clientID   string
+	httpClient *http.Client
+
+	mu            sync.Mutex
+	discovery     *discovery
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+func NewProvider(issuerURL, clientID string, httpClient *http.Client) *Provider {
+	return &Provider{
+		issuerURL:  issuerURL,
+		clientID:   clientID,
+		httpClient: httpClient,
+	}
+}
+
+// PasswordGrant exchanges the username and the password of the user for an ID token with the resource owner
+// password grant, the client secret is sent only for the confidential clients
+func (p *Provider) PasswordGrant(ctx context.Context, username, password, clientSecret string) (string, error) {
+	config, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+	if config.TokenEndpoint == "" {
+		return "", errors.Errorf("identity provider %s has no token endpoint", p.issuerURL)
+	}
+
+	form := url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+		"client_id":  {p.clientID},
+		"scope":      {"openid"},
+	}
+	if clientSecret != "" {
+		form.Set("client_secret", clientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", errors.Wrap(err, "while building token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "while requesting token from %s", config.TokenEndpoint)
+	}
+	defer resp.Body.Close()
+
+	token := struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}{}
+	// the error responses are read too, they tell the rejected credentials from a misconfigured client
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&token)
+	if resp.StatusCode != http.StatusOK {
+		if token.Error == "invalid_grant" {
+			return "", ErrInvalidCredentials
+		}
+		return "", errors.Errorf("token endpoint %s responded with status %d and error '%s'", config.TokenEndpoint, resp.StatusCode, token.Error)
+	}
+	if decodeErr != nil {
+		return "", errors.Wrap(decodeErr, "while reading token response")
+	}
+	if token.IDToken == "" {
+		return "", errors.Errorf("token endpoint %s returned no id token", config.TokenEndpoint)
+	}
+	return token.IDToken, nil
+}
+
+// Verify checks that the ID token is signed by the identity provider, issued for the client and not expired, and
+// returns its claims
+func (p *Provider) Verify(ctx context.Context, rawToken string, now time.Time) (Claims, error) {
+	token, err := parseToken(rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := p.getKeys(ctx, token.header.KeyID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !token.verifySignature(keys) {
+		return nil, errors.Wrap(ErrInvalidToken, "signature does not match any key of the identity provider")
+	}
+
+	claims := token.claims
+	if claims.String("iss") != p.issuerURL {
+		return nil, errors.Wrapf(ErrInvalidToken, "token is issued by '%s'", claims.String("iss"))
+	}
+	if !audienceContains(claims["aud"], p.clientID) {
+		return nil, errors.Wrapf(ErrInvalidToken, "token is not issued for client '%s'", p.clientID)
+	}
+	expiration, ok := numericDate(claims["exp"])
+	if !ok || !now.Before(expiration.Add(clockSkew)) {
+		return nil, errors.Wrap(ErrInvalidToken, "token expired")
+	}
+	if notBefore, ok := numericDate(claims["nbf"]); ok && now.Add(clockSkew).Before(notBefore) {
+		return nil, errors.Wrap(ErrInvalidToken, "token is not valid yet")
+	}
+	return claims, nil
+}
+
+func (p *Provider) getDiscovery(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	config := &discovery{}
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.issuerURL, "/")+discoveryPath, config); err != nil {
+		return nil, err
+	}
+	// the issuer of the document must be the one it was discovered from, the tokens are checked against it
+	if config.Issuer != p.issuerURL {
+		return nil, errors.Errorf("identity provider %s announces issuer '%s'", p.issuerURL, config.Issuer)
+	}
+	p.discovery = config
+	return config, nil
+}
+
+// getKeys returns the keys of the identity provider, they are fetched again when the key id is unknown, as the
+// keys are rotated, but not more often than the refresh interval
+func (p *Provider) getKeys(ctx context.Context, keyID string, now time.Time) (map[string]crypto.PublicKey, error) {
+	config, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, known := p.keys[keyID]
+	if p.keys != nil && (known || keyID == "" || now.Before(p.keysFetchedAt.Add(keysRefreshInterval))) {
+		return p.keys, nil
+	}
+
+	set := jsonWebKeySet{}
+	if err := p.getJSON(ctx, config.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	p.keys = set.publicKeys()
+	p.keysFetchedAt = now
+	return p.keys, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, into interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return errors.Wrapf(err, "while building request to %s", url)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "while fetching %s", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s responded with status %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(into); err != nil {
+		return errors.Wrapf(err, "while reading %s", url)
+	}
+	return nil
+}
+
+// audienceContains tells if the client is in the audience, which is a single string or a list of them
+func audienceContains(audience interface{}, clientID string) bool {
+	switch audience := audience.(type) {
+	case string:
+		return audience == clientID
+	case []interface{}:
+		for _, entry := range audience {
+			if entry == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func numericDate(value interface{}) (time.Time, bool) {
+	seconds, ok := value.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
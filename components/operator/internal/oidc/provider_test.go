@@ -0,0 +1,126 @@
+package oidc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/internal/oidc/oidctest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvider_Verify(t *testing.T) {
+	for _, algorithm := range []string{RS256, ES256} {
+		t.Run("accept token signed with "+algorithm, func(t *testing.T) {
+			idp := oidctest.NewProvider(t, "docker-registry", algorithm)
+			provider := NewProvider(idp.URL, "docker-registry", idp.Client())
+
+			claims, err := provider.Verify(context.Background(), idp.IDToken(map[string]interface{}{"email": "dev@example.com"}), time.Now())
+
+			require.NoError(t, err)
+			require.Equal(t, "dev@example.com", claims.String("email"))
+		})
+	}
+
+	idp := oidctest.NewProvider(t, "docker-registry", RS256)
+	other := oidctest.NewProvider(t, "docker-registry", RS256)
+
+	testCases := map[string]struct {
+		token         string
+		expectedError string
+	}{
+		"accept audience list": {
+			token: idp.IDToken(map[string]interface{}{"aud": []string{"other", "docker-registry"}}),
+		},
+		"reject token of another client": {
+			token:         idp.IDToken(map[string]interface{}{"aud": "other"}),
+			expectedError: "token is not issued for client 'docker-registry'",
+		},
+		"reject token of another issuer": {
+			token:         idp.IDToken(map[string]interface{}{"iss": "https://other.example.com"}),
+			expectedError: "token is issued by 'https://other.example.com'",
+		},
+		"reject expired token": {
+			token:         idp.IDToken(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}),
+			expectedError: "token expired",
+		},
+		"reject token signed by another provider": {
+			token:         other.IDToken(map[string]interface{}{"iss": idp.URL}),
+			expectedError: "signature does not match any key of the identity provider",
+		},
+		"reject unsigned token": {
+			token:         "eyJhbGciOiJub25lIn0.eyJpc3MiOiJ4In0.",
+			expectedError: "unsupported signing algorithm 'none'",
+		},
+		"reject malformed token": {
+			token:         "not-a-token",
+			expectedError: "token is not a JWT",
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			provider := NewProvider(idp.URL, "docker-registry", idp.Client())
+
+			_, err := provider.Verify(context.Background(), testCase.token, time.Now())
+
+			if testCase.expectedError == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrInvalidToken)
+			require.ErrorContains(t, err, testCase.expectedError)
+		})
+	}
+
+	t.Run("return error for unreachable provider", func(t *testing.T) {
+		provider := NewProvider("https://127.0.0.1:1", "docker-registry", idp.Client())
+
+		_, err := provider.Verify(context.Background(), idp.IDToken(nil), time.Now())
+
+		require.ErrorContains(t, err, "while fetching https://127.0.0.1:1/.well-known/openid-configuration")
+		require.NotErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("reject provider announcing another issuer", func(t *testing.T) {
+		provider := NewProvider(idp.URL+"/", "docker-registry", idp.Client())
+
+		_, err := provider.Verify(context.Background(), idp.IDToken(nil), time.Now())
+
+		require.ErrorContains(t, err, "announces issuer '"+idp.URL+"'")
+	})
+}
+
+func TestProvider_PasswordGrant(t *testing.T) {
+	idp := oidctest.NewProvider(t, "docker-registry", RS256)
+	idp.ClientSecret = "client-secret"
+	idp.AddUser("dev", "dev-password", map[string]interface{}{"email": "dev@example.com"})
+
+	t.Run("exchange password for id token", func(t *testing.T) {
+		provider := NewProvider(idp.URL, "docker-registry", idp.Client())
+
+		idToken, err := provider.PasswordGrant(context.Background(), "dev", "dev-password", "client-secret")
+		require.NoError(t, err)
+
+		claims, err := provider.Verify(context.Background(), idToken, time.Now())
+		require.NoError(t, err)
+		require.Equal(t, "dev@example.com", claims.String("email"))
+	})
+
+	t.Run("reject wrong password", func(t *testing.T) {
+		provider := NewProvider(idp.URL, "docker-registry", idp.Client())
+
+		_, err := provider.PasswordGrant(context.Background(), "dev", "wrong", "client-secret")
+
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+
+	t.Run("return error for wrong client secret", func(t *testing.T) {
+		provider := NewProvider(idp.URL, "docker-registry", idp.Client())
+
+		_, err := provider.PasswordGrant(context.Background(), "dev", "dev-password", "wrong")
+
+		require.ErrorContains(t, err, "responded with status 401 and error 'invalid_client'")
+		require.NotErrorIs(t, err, ErrInvalidCredentials)
+	})
+}
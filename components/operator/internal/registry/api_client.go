@@ -0,0 +1,587 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	apiPageSize = 1000
+	apiTimeout  = 30 * time.Second
+	// the API responses are small JSON documents, a larger one is not read to the end
+	maxResponseSize = 4 << 20
+)
+
+// manifestMediaTypes are the manifests the registry is asked for, an index is resolved to its first image
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+}
+
+// APIClient talks to the registry HTTP API with the internal access credentials. A registry with the token
+// authentication is answered with the tokens the credentials are exchanged for.
+type APIClient struct {
+	baseURL    string
+	username   string
+	password   string
+	httpClient *http.Client
+	// transferClient moves the blobs, which takes longer than any other request
+	transferClient *http.Client
+
+	tokensMu sync.Mutex
+	// tokens are cached per method and repository, which is what the registry scopes them to
+	tokens map[string]string
+}
+
+func NewAPIClient(address, username, password string) *APIClient {
+	return &APIClient{
+		baseURL:        "http://" + address,
+		username:       username,
+		password:       password,
+		httpClient:     &http.Client{Timeout: apiTimeout},
+		transferClient: &http.Client{},
+		tokens:         map[string]string{},
+	}
+}
+
+// Manifest is a manifest the way the registry stores it, it is pushed to another registry unchanged to keep its digest
+type Manifest struct {
+	MediaType string
+	Digest    string
+	Data      []byte
+}
+
+type manifest struct {
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Manifests []struct {
+		Digest string `json:"digest"`
+	} `json:"manifests"`
+}
+
+type imageConfig struct {
+	Created *time.Time `json:"created"`
+}
+
+// Repositories lists all repositories of the registry
+func (c *APIClient) Repositories(ctx context.Context) ([]string, error) {
+	var repositories []string
+	err := c.list(ctx, "/v2/_catalog", func(body []byte) error {
+		page := struct {
+			Repositories []string `json:"repositories"`
+		}{}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+		repositories = append(repositories, page.Repositories...)
+		return nil
+	})
+	return repositories, errors.Wrap(err, "while listing repositories")
+}
+
+// Tags lists all tags of the repository
+func (c *APIClient) Tags(ctx context.Context, repository string) ([]string, error) {
+	var tags []string
+	err := c.list(ctx, fmt.Sprintf("/v2/%s/tags/list", repository), func(body []byte) error {
+		page := struct {
+			Tags []string `json:"tags"`
+		}{}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+		tags = append(tags, page.Tags...)
+		return nil
+	})
+	return tags, errors.Wrapf(err, "while listing tags of repository %s", repository)
+}
+
+// TagCreated returns the creation time of the image the tag points to, it is read from the image configuration,
+// because the registry does not know when a tag was pushed. The time is zero when the image does not report it.
+func (c *APIClient) TagCreated(ctx context.Context, repository, tag string) (time.Time, error) {
+	m, err := c.getManifest(ctx, repository, tag)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if m.Config.Digest == "" && len(m.Manifests) > 0 {
+		// the images of an index are built together, the first one tells when
+		m, err = c.getManifest(ctx, repository, m.Manifests[0].Digest)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	if m.Config.Digest == "" {
+		return time.Time{}, nil
+	}
+
+	body, err := c.get(ctx, fmt.Sprintf("/v2/%s/blobs/%s", repository, m.Config.Digest), nil)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "while fetching image configuration of %s:%s", repository, tag)
+	}
+
+	config := imageConfig{}
+	if err := json.Unmarshal(body, &config); err != nil || config.Created == nil {
+		// not every artifact is an image with a creation time
+		return time.Time{}, nil
+	}
+	return *config.Created, nil
+}
+
+// DeleteTag removes the tag only, the manifest it points to stays available by digest and under other tags
+func (c *APIClient) DeleteTag(ctx context.Context, repository, tag string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, fmt.Sprintf("/v2/%s/manifests/%s", repository, tag))
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.send(req)
+	if err != nil {
+		return errors.Wrapf(err, "while deleting tag %s:%s", repository, tag)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusAccepted, http.StatusOK, http.StatusNotFound:
+		return nil
+	default:
+		return errors.Errorf("while deleting tag %s:%s: unexpected status %s", repository, tag, resp.Status)
+	}
+}
+
+// Manifest fetches the manifest the reference points to
+func (c *APIClient) Manifest(ctx context.Context, repository, reference string) (*Manifest, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/manifests/%s", repository, reference))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+
+	body, header, err := c.do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while fetching manifest %s:%s", repository, reference)
+	}
+	return &Manifest{
+		MediaType: header.Get("Content-Type"),
+		Digest:    header.Get("Docker-Content-Digest"),
+		Data:      body,
+	}, nil
+}
+
+// ManifestDigest returns the digest of the manifest the reference points to, it is empty when there is none
+func (c *APIClient) ManifestDigest(ctx context.Context, repository, reference string) (string, error) {
+	req, err := c.newRequest(ctx, http.MethodHead, fmt.Sprintf("/v2/%s/manifests/%s", repository, reference))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+
+	resp, err := c.send(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "while checking manifest %s:%s", repository, reference)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Header.Get("Docker-Content-Digest"), nil
+	case http.StatusNotFound:
+		return "", nil
+	default:
+		return "", errors.Errorf("while checking manifest %s:%s: unexpected status %s", repository, reference, resp.Status)
+	}
+}
+
+// PutManifest pushes the manifest under the reference and returns the digest the registry computed for it
+func (c *APIClient) PutManifest(ctx context.Context, repository, reference string, m *Manifest) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut,
+		fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repository, reference), bytes.NewReader(m.Data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", m.MediaType)
+
+	resp, err := c.send(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "while pushing manifest %s:%s", repository, reference)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", errors.Errorf("while pushing manifest %s:%s: unexpected status %s: %s",
+			repository, reference, resp.Status, readError(resp))
+	}
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+// BlobExists checks whether the repository holds the blob
+func (c *APIClient) BlobExists(ctx context.Context, repository, digest string) (bool, error) {
+	req, err := c.newRequest(ctx, http.MethodHead, fmt.Sprintf("/v2/%s/blobs/%s", repository, digest))
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.send(req)
+	if err != nil {
+		return false, errors.Wrapf(err, "while checking blob %s of repository %s", digest, repository)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, errors.Errorf("while checking blob %s of repository %s: unexpected status %s", digest, repository, resp.Status)
+	}
+}
+
+// MountBlob links the blob of another repository of the registry into the repository, so that it is not uploaded
+// again. It returns false when the registry expects an upload instead.
+func (c *APIClient) MountBlob(ctx context.Context, repository, digest, from string) (bool, error) {
+	query := url.Values{"mount": {digest}, "from": {from}}
+	req, err := c.newRequest(ctx, http.MethodPost, fmt.Sprintf("/v2/%s/blobs/uploads/?%s", repository, query.Encode()))
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := c.send(req)
+	if err != nil {
+		return false, errors.Wrapf(err, "while mounting blob %s to repository %s", digest, repository)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, nil
+	case http.StatusAccepted:
+		// the registry started an upload, it expires unused
+		return false, nil
+	default:
+		return false, errors.Errorf("while mounting blob %s to repository %s: unexpected status %s", digest, repository, resp.Status)
+	}
+}
+
+// Blob streams the content of the blob, the caller closes it
+func (c *APIClient) Blob(ctx context.Context, repository, digest string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/blobs/%s", repository, digest))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.sendWith(c.transferClient, req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while fetching blob %s of repository %s", digest, repository)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("while fetching blob %s of repository %s: unexpected status %s", digest, repository, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// PutBlob uploads the blob in a single request, the registry rejects the content that does not match the digest
+func (c *APIClient) PutBlob(ctx context.Context, repository, digest string, size int64, content io.Reader) error {
+	req, err := c.newRequest(ctx, http.MethodPost, fmt.Sprintf("/v2/%s/blobs/uploads/", repository))
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.send(req)
+	if err != nil {
+		return errors.Wrapf(err, "while starting upload of blob %s to repository %s", digest, repository)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return errors.Errorf("while starting upload of blob %s to repository %s: unexpected status %s", digest, repository, resp.Status)
+	}
+
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return errors.Wrapf(err, "invalid upload location '%s'", resp.Header.Get("Location"))
+	}
+	query := location.Query()
+	query.Set("digest", digest)
+	location.RawQuery = query.Encode()
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodPut, location.String(), content)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err = c.sendWith(c.transferClient, req)
+	if err != nil {
+		return errors.Wrapf(err, "while uploading blob %s to repository %s", digest, repository)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return errors.Errorf("while uploading blob %s to repository %s: unexpected status %s: %s",
+			digest, repository, resp.Status, readError(resp))
+	}
+	return nil
+}
+
+func (c *APIClient) getManifest(ctx context.Context, repository, reference string) (*manifest, error) {
+	body, err := c.get(ctx, fmt.Sprintf("/v2/%s/manifests/%s", repository, reference), manifestMediaTypes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "while fetching manifest %s:%s", repository, reference)
+	}
+
+	m := &manifest{}
+	if err := json.Unmarshal(body, m); err != nil {
+		return nil, errors.Wrapf(err, "while decoding manifest %s:%s", repository, reference)
+	}
+	return m, nil
+}
+
+// list follows the pagination links of the registry API
+func (c *APIClient) list(ctx context.Context, path string, decode func([]byte) error) error {
+	next := fmt.Sprintf("%s?n=%d", path, apiPageSize)
+	for next != "" {
+		req, err := c.newRequest(ctx, http.MethodGet, next)
+		if err != nil {
+			return err
+		}
+
+		body, header, err := c.do(req)
+		if err != nil {
+			return err
+		}
+		if err := decode(body); err != nil {
+			return err
+		}
+
+		next, err = nextPage(header.Get("Link"))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *APIClient) get(ctx context.Context, path string, accept []string) ([]byte, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(accept) > 0 {
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+	}
+
+	body, _, err := c.do(req)
+	return body, err
+}
+
+func (c *APIClient) do(req *http.Request) ([]byte, http.Header, error) {
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, errors.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, resp.Header, nil
+}
+
+func (c *APIClient) newRequest(ctx context.Context, method, path string) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, method, c.baseURL+path, nil)
+}
+
+func (c *APIClient) send(req *http.Request) (*http.Response, error) {
+	return c.sendWith(c.httpClient, req)
+}
+
+// sendWith authenticates the request with a cached token or the credentials. When the registry challenges it for a
+// token, the token is fetched and the request is sent again, which also replaces the expired tokens. A streamed
+// request body can't be sent again, the challenge of such a request is returned as an error.
+func (c *APIClient) sendWith(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	key := tokenCacheKey(req)
+	resp, err := httpClient.Do(c.authorize(req, c.cachedToken(key)))
+	if err != nil {
+		return nil, err
+	}
+
+	challenge, ok := bearerChallenge(resp)
+	if !ok {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	if req.Body != nil && req.GetBody == nil {
+		return nil, errors.New("registry asked for a token after the request body was sent")
+	}
+
+	token, err := c.fetchToken(req.Context(), challenge)
+	if err != nil {
+		return nil, err
+	}
+	c.cacheToken(key, token)
+
+	authorized := c.authorize(req, token)
+	if req.GetBody != nil {
+		if authorized.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return httpClient.Do(authorized)
+}
+
+// readError returns the beginning of the error response of the registry, it tells why a push was rejected
+func readError(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return strings.TrimSpace(string(body))
+}
+
+func (c *APIClient) authorize(req *http.Request, token string) *http.Request {
+	authorized := req.Clone(req.Context())
+	if token != "" {
+		authorized.Header.Set("Authorization", "Bearer "+token)
+		return authorized
+	}
+	authorized.SetBasicAuth(c.username, c.password)
+	return authorized
+}
+
+func (c *APIClient) fetchToken(ctx context.Context, challenge map[string]string) (string, error) {
+	realm, err := url.Parse(challenge["realm"])
+	if err != nil || realm.Host == "" {
+		return "", errors.Errorf("invalid token realm '%s'", challenge["realm"])
+	}
+
+	query := realm.Query()
+	for _, param := range []string{"service", "scope"} {
+		if value := challenge[param]; value != "" {
+			query.Set(param, value)
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(c.username, c.password)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "while fetching registry token")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("while fetching registry token: unexpected status %s", resp.Status)
+	}
+
+	tokenResponse := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&tokenResponse); err != nil {
+		return "", errors.Wrap(err, "while decoding registry token")
+	}
+	if tokenResponse.Token != "" {
+		return tokenResponse.Token, nil
+	}
+	if tokenResponse.AccessToken != "" {
+		return tokenResponse.AccessToken, nil
+	}
+	return "", errors.New("registry token response holds no token")
+}
+
+func (c *APIClient) cachedToken(key string) string {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
+	return c.tokens[key]
+}
+
+func (c *APIClient) cacheToken(key, token string) {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
+	c.tokens[key] = token
+}
+
+// tokenCacheKey returns the method and the repository of the request, the manifests, blobs and tags of a repository
+// are accessible with the same token
+func tokenCacheKey(req *http.Request) string {
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	for _, resource := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if i := strings.LastIndex(path, resource); i != -1 {
+			return req.Method + " " + path[:i]
+		}
+	}
+	return req.Method + " " + path
+}
+
+// bearerChallenge reads the parameters of the `Bearer realm="...",service="...",scope="..."` challenge of an
+// unauthorized response
+func bearerChallenge(resp *http.Response) (map[string]string, bool) {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil, false
+	}
+
+	header := resp.Header.Get("WWW-Authenticate")
+	scheme, params, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return nil, false
+	}
+
+	challenge := map[string]string{}
+	for params != "" {
+		name, rest, found := strings.Cut(strings.TrimLeft(params, " ,"), "=")
+		if !found {
+			break
+		}
+
+		// the values are quoted, because the scope lists the actions separated by commas
+		value := ""
+		if strings.HasPrefix(rest, `"`) {
+			value, params, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, params, _ = strings.Cut(rest, ",")
+		}
+		challenge[strings.ToLower(strings.TrimSpace(name))] = value
+	}
+	return challenge, challenge["realm"] != ""
+}
+
+// nextPage reads the path of the next page from the Link header in the `<path>; rel="next"` format
+func nextPage(link string) (string, error) {
+	if link == "" {
+		return "", nil
+	}
+
+	start := strings.Index(link, "<")
+	end := strings.Index(link, ">")
+	if start == -1 || end < start {
+		return "", errors.Errorf("invalid link header '%s'", link)
+	}
+
+	next, err := url.Parse(link[start+1 : end])
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid link header '%s'", link)
+	}
+	return next.RequestURI(), nil
+}
@@ -0,0 +1,379 @@
+package registry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIClient(t *testing.T) {
+	t.Run("list repositories across pages", func(t *testing.T) {
+		client := fixAPIClient(t, map[string]func(http.ResponseWriter, *http.Request){
+			"/v2/_catalog": func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("last") == "" {
+					w.Header().Set("Link", `</v2/_catalog?last=ci%2Fapp&n=1000>; rel="next"`)
+					_, _ = w.Write([]byte(`{"repositories":["base","ci/app"]}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"repositories":["ci/tool"]}`))
+			},
+		})
+
+		repositories, err := client.Repositories(context.Background())
+
+		require.NoError(t, err)
+		require.Equal(t, []string{"base", "ci/app", "ci/tool"}, repositories)
+	})
+
+	t.Run("read tag creation time from image configuration", func(t *testing.T) {
+		client := fixAPIClient(t, map[string]func(http.ResponseWriter, *http.Request){
+			"/v2/ci/app/manifests/latest": func(w http.ResponseWriter, r *http.Request) {
+				require.Contains(t, r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json")
+				_, _ = w.Write([]byte(`{"manifests":[{"digest":"sha256:amd64"}]}`))
+			},
+			"/v2/ci/app/manifests/sha256:amd64": func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(`{"config":{"digest":"sha256:config"}}`))
+			},
+			"/v2/ci/app/blobs/sha256:config": func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(`{"created":"2024-06-02T12:00:00Z"}`))
+			},
+		})
+
+		created, err := client.TagCreated(context.Background(), "ci/app", "latest")
+
+		require.NoError(t, err)
+		require.Equal(t, time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC), created.UTC())
+	})
+
+	t.Run("return zero time for artifact without creation time", func(t *testing.T) {
+		client := fixAPIClient(t, map[string]func(http.ResponseWriter, *http.Request){
+			"/v2/ci/chart/manifests/1.0.0": func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(`{"config":{"digest":"sha256:config"}}`))
+			},
+			"/v2/ci/chart/blobs/sha256:config": func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(`{"name":"chart"}`))
+			},
+		})
+
+		created, err := client.TagCreated(context.Background(), "ci/chart", "1.0.0")
+
+		require.NoError(t, err)
+		require.True(t, created.IsZero())
+	})
+
+	t.Run("delete tag", func(t *testing.T) {
+		deleted := false
+		client := fixAPIClient(t, map[string]func(http.ResponseWriter, *http.Request){
+			"/v2/ci/app/manifests/commit-1": func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodDelete, r.Method)
+				deleted = true
+				w.WriteHeader(http.StatusAccepted)
+			},
+		})
+
+		err := client.DeleteTag(context.Background(), "ci/app", "commit-1")
+
+		require.NoError(t, err)
+		require.True(t, deleted)
+	})
+
+	t.Run("return error when deletion is disabled", func(t *testing.T) {
+		client := fixAPIClient(t, map[string]func(http.ResponseWriter, *http.Request){
+			"/v2/ci/app/manifests/commit-1": func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			},
+		})
+
+		err := client.DeleteTag(context.Background(), "ci/app", "commit-1")
+
+		require.ErrorContains(t, err, "while deleting tag ci/app:commit-1: unexpected status 405 Method Not Allowed")
+	})
+
+	t.Run("fetch manifest with its media type and digest", func(t *testing.T) {
+		client := fixAPIClient(t, map[string]func(http.ResponseWriter, *http.Request){
+			"/v2/ci/app/manifests/latest": func(w http.ResponseWriter, r *http.Request) {
+				require.Contains(t, r.Header.Get("Accept"), "application/vnd.oci.image.manifest.v1+json")
+				w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+				w.Header().Set("Docker-Content-Digest", "sha256:app")
+				_, _ = w.Write([]byte(`{"config":{"digest":"sha256:config"}}`))
+			},
+		})
+
+		manifest, err := client.Manifest(context.Background(), "ci/app", "latest")
+
+		require.NoError(t, err)
+		require.Equal(t, &Manifest{
+			MediaType: "application/vnd.oci.image.manifest.v1+json",
+			Digest:    "sha256:app",
+			Data:      []byte(`{"config":{"digest":"sha256:config"}}`),
+		}, manifest)
+	})
+
+	t.Run("return empty digest of missing manifest", func(t *testing.T) {
+		client := fixAPIClient(t, map[string]func(http.ResponseWriter, *http.Request){
+			"/v2/ci/app/manifests/latest": func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodHead, r.Method)
+				w.Header().Set("Docker-Content-Digest", "sha256:app")
+			},
+		})
+
+		digest, err := client.ManifestDigest(context.Background(), "ci/app", "latest")
+		require.NoError(t, err)
+		require.Equal(t, "sha256:app", digest)
+
+		digest, err = client.ManifestDigest(context.Background(), "ci/app", "missing")
+		require.NoError(t, err)
+		require.Empty(t, digest)
+	})
+
+	t.Run("push manifest", func(t *testing.T) {
+		client := fixAPIClient(t, map[string]func(http.ResponseWriter, *http.Request){
+			"/v2/ci/app/manifests/latest": func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPut, r.Method)
+				require.Equal(t, "application/vnd.oci.image.manifest.v1+json", r.Header.Get("Content-Type"))
+				body, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				require.Equal(t, `{"layers":[]}`, string(body))
+				w.Header().Set("Docker-Content-Digest", "sha256:app")
+				w.WriteHeader(http.StatusCreated)
+			},
+		})
+
+		digest, err := client.PutManifest(context.Background(), "ci/app", "latest", &Manifest{
+			MediaType: "application/vnd.oci.image.manifest.v1+json",
+			Data:      []byte(`{"layers":[]}`),
+		})
+
+		require.NoError(t, err)
+		require.Equal(t, "sha256:app", digest)
+	})
+
+	t.Run("return reason of rejected manifest", func(t *testing.T) {
+		client := fixAPIClient(t, map[string]func(http.ResponseWriter, *http.Request){
+			"/v2/ci/app/manifests/latest": func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"errors":[{"code":"BLOB_UNKNOWN"}]}`))
+			},
+		})
+
+		_, err := client.PutManifest(context.Background(), "ci/app", "latest", &Manifest{Data: []byte(`{}`)})
+
+		require.ErrorContains(t, err, `while pushing manifest ci/app:latest: unexpected status 400 Bad Request: {"errors":[{"code":"BLOB_UNKNOWN"}]}`)
+	})
+
+	t.Run("check blob", func(t *testing.T) {
+		client := fixAPIClient(t, map[string]func(http.ResponseWriter, *http.Request){
+			"/v2/ci/app/blobs/sha256:layer": func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodHead, r.Method)
+			},
+		})
+
+		exists, err := client.BlobExists(context.Background(), "ci/app", "sha256:layer")
+		require.NoError(t, err)
+		require.True(t, exists)
+
+		exists, err = client.BlobExists(context.Background(), "ci/app", "sha256:missing")
+		require.NoError(t, err)
+		require.False(t, exists)
+	})
+
+	t.Run("mount blob from another repository", func(t *testing.T) {
+		client := fixAPIClient(t, map[string]func(http.ResponseWriter, *http.Request){
+			"/v2/ci/app/blobs/uploads/": func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				require.Equal(t, "sha256:layer", r.URL.Query().Get("mount"))
+				require.Equal(t, "base", r.URL.Query().Get("from"))
+				w.WriteHeader(http.StatusCreated)
+			},
+			"/v2/ci/tool/blobs/uploads/": func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusAccepted)
+			},
+		})
+
+		mounted, err := client.MountBlob(context.Background(), "ci/app", "sha256:layer", "base")
+		require.NoError(t, err)
+		require.True(t, mounted)
+
+		mounted, err = client.MountBlob(context.Background(), "ci/tool", "sha256:layer", "base")
+		require.NoError(t, err)
+		require.False(t, mounted)
+	})
+
+	t.Run("stream blob", func(t *testing.T) {
+		client := fixAPIClient(t, map[string]func(http.ResponseWriter, *http.Request){
+			"/v2/ci/app/blobs/sha256:layer": func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("layer"))
+			},
+		})
+
+		content, err := client.Blob(context.Background(), "ci/app", "sha256:layer")
+		require.NoError(t, err)
+		defer content.Close()
+
+		data, err := io.ReadAll(content)
+		require.NoError(t, err)
+		require.Equal(t, "layer", string(data))
+	})
+
+	t.Run("upload blob to the location of the started upload", func(t *testing.T) {
+		uploaded := false
+		client := fixAPIClient(t, map[string]func(http.ResponseWriter, *http.Request){
+			"/v2/ci/app/blobs/uploads/": func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				w.Header().Set("Location", "/v2/ci/app/blobs/uploads/upload-1?_state=abc")
+				w.WriteHeader(http.StatusAccepted)
+			},
+			"/v2/ci/app/blobs/uploads/upload-1": func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPut, r.Method)
+				require.Equal(t, "abc", r.URL.Query().Get("_state"))
+				require.Equal(t, "sha256:layer", r.URL.Query().Get("digest"))
+				require.Equal(t, int64(5), r.ContentLength)
+				body, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				require.Equal(t, "layer", string(body))
+				uploaded = true
+				w.WriteHeader(http.StatusCreated)
+			},
+		})
+
+		err := client.PutBlob(context.Background(), "ci/app", "sha256:layer", 5, strings.NewReader("layer"))
+
+		require.NoError(t, err)
+		require.True(t, uploaded)
+	})
+
+	t.Run("send manifest again after token challenge", func(t *testing.T) {
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(`{"token":"push-token"}`))
+		}))
+		t.Cleanup(tokenServer.Close)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			require.Equal(t, `{"layers":[]}`, string(body))
+			if r.Header.Get("Authorization") != "Bearer push-token" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+tokenServer.URL+`/token",scope="repository:ci/app:pull,push"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		}))
+		t.Cleanup(server.Close)
+		client := NewAPIClient(strings.TrimPrefix(server.URL, "http://"), "user", "pass")
+
+		_, err := client.PutManifest(context.Background(), "ci/app", "latest", &Manifest{Data: []byte(`{"layers":[]}`)})
+
+		require.NoError(t, err)
+	})
+
+	t.Run("exchange credentials for token when challenged", func(t *testing.T) {
+		tokenRequests := 0
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username, password, ok := r.BasicAuth()
+			require.True(t, ok)
+			require.Equal(t, "user", username)
+			require.Equal(t, "pass", password)
+			require.Equal(t, "dockerregistry.test.svc.cluster.local", r.URL.Query().Get("service"))
+			require.Equal(t, "repository:ci/app:pull", r.URL.Query().Get("scope"))
+
+			tokenRequests++
+			_, _ = w.Write([]byte(`{"token":"test-token"}`))
+		}))
+		t.Cleanup(tokenServer.Close)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer test-token" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+tokenServer.URL+
+					`/token",service="dockerregistry.test.svc.cluster.local",scope="repository:ci/app:pull"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"tags":["latest"]}`))
+		}))
+		t.Cleanup(server.Close)
+		client := NewAPIClient(strings.TrimPrefix(server.URL, "http://"), "user", "pass")
+
+		for range 2 {
+			tags, err := client.Tags(context.Background(), "ci/app")
+
+			require.NoError(t, err)
+			require.Equal(t, []string{"latest"}, tags)
+		}
+		require.Equal(t, 1, tokenRequests)
+	})
+
+	t.Run("return error when token is refused", func(t *testing.T) {
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		t.Cleanup(tokenServer.Close)
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+tokenServer.URL+`/token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		t.Cleanup(server.Close)
+		client := NewAPIClient(strings.TrimPrefix(server.URL, "http://"), "user", "pass")
+
+		_, err := client.Repositories(context.Background())
+
+		require.ErrorContains(t, err, "while fetching registry token: unexpected status 401 Unauthorized")
+	})
+}
+
+func Test_bearerChallenge(t *testing.T) {
+	t.Run("read challenge parameters", func(t *testing.T) {
+		resp := &http.Response{
+			StatusCode: http.StatusUnauthorized,
+			Header: http.Header{"Www-Authenticate": {
+				`Bearer realm="http://10.0.0.12:8090/token",service="registry",scope="repository:ci/app:pull,push"`,
+			}},
+		}
+
+		challenge, ok := bearerChallenge(resp)
+
+		require.True(t, ok)
+		require.Equal(t, map[string]string{
+			"realm":   "http://10.0.0.12:8090/token",
+			"service": "registry",
+			"scope":   "repository:ci/app:pull,push",
+		}, challenge)
+	})
+
+	t.Run("ignore basic challenge", func(t *testing.T) {
+		resp := &http.Response{
+			StatusCode: http.StatusUnauthorized,
+			Header:     http.Header{"Www-Authenticate": {`Basic realm="Registry Realm"`}},
+		}
+
+		_, ok := bearerChallenge(resp)
+
+		require.False(t, ok)
+	})
+}
+
+func fixAPIClient(t *testing.T, handlers map[string]func(http.ResponseWriter, *http.Request)) *APIClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "user", username)
+		require.Equal(t, "pass", password)
+
+		handler, ok := handlers[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return NewAPIClient(strings.TrimPrefix(server.URL, "http://"), "user", "pass")
+}
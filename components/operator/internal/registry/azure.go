@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// storageAdapterKeyLength is the length of the generated account keys, the keys of the storage accounts are as long
+const storageAdapterKeyLength = 64
+
+// GenerateStorageAdapterKey returns a random base64 encoded account key the azure storage driver of the registry
+// signs the requests to the storage adapter with
+func GenerateStorageAdapterKey() (string, error) {
+	key := make([]byte, storageAdapterKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return "", errors.Wrap(err, "while generating storage adapter key")
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// AzureSharedKeySignature returns the shared key signature of the request to the blob service of the account, the
+// same way the azure storage clients sign the requests. The date is expected in the x-ms-date header.
+func AzureSharedKeySignature(req *http.Request, account string, accountKey []byte) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+
+	msHeaders := []string{}
+	for key := range req.Header {
+		if name := strings.ToLower(key); strings.HasPrefix(name, "x-ms-") {
+			msHeaders = append(msHeaders, name)
+		}
+	}
+	sort.Strings(msHeaders)
+	canonicalHeaders := strings.Builder{}
+	for _, name := range msHeaders {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(strings.Join(req.Header.Values(name), ",")) + "\n")
+	}
+
+	canonicalResource := "/" + account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		canonicalResource += "\n" + strings.ToLower(key) + ":" + strings.Join(values, ",")
+	}
+
+	stringToSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		contentLength,
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		// the date is sent in x-ms-date
+		"",
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		canonicalHeaders.String() + canonicalResource,
+	}, "\n")
+
+	mac := hmac.New(sha256.New, accountKey)
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateStorageAdapterKey(t *testing.T) {
+	key, err := GenerateStorageAdapterKey()
+	require.NoError(t, err)
+	other, err := GenerateStorageAdapterKey()
+	require.NoError(t, err)
+
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	require.NoError(t, err)
+	require.Len(t, decoded, storageAdapterKeyLength)
+	require.NotEqual(t, key, other)
+}
+
+func TestAzureSharedKeySignature(t *testing.T) {
+	fixRequest := func(url string) *http.Request {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		require.NoError(t, err)
+		req.Header.Set("x-ms-version", "2021-08-06")
+		req.Header.Set("x-ms-date", "Fri, 02 Jan 2026 03:04:05 GMT")
+		return req
+	}
+
+	t.Run("sign same request the same way", func(t *testing.T) {
+		signature := AzureSharedKeySignature(fixRequest("https://account.blob.core.windows.net/images?restype=container&comp=list"), "account", []byte("key"))
+
+		require.Equal(t, signature, AzureSharedKeySignature(fixRequest("https://account.blob.core.windows.net/images?comp=list&restype=container"), "account", []byte("key")))
+	})
+
+	t.Run("cover key, account, path, query and headers", func(t *testing.T) {
+		req := fixRequest("https://account.blob.core.windows.net/images/blob?comp=list")
+		signature := AzureSharedKeySignature(req, "account", []byte("key"))
+
+		require.NotEqual(t, signature, AzureSharedKeySignature(req, "account", []byte("other-key")))
+		require.NotEqual(t, signature, AzureSharedKeySignature(req, "other", []byte("key")))
+		require.NotEqual(t, signature, AzureSharedKeySignature(fixRequest("https://account.blob.core.windows.net/images/other?comp=list"), "account", []byte("key")))
+		require.NotEqual(t, signature, AzureSharedKeySignature(fixRequest("https://account.blob.core.windows.net/images/blob?comp=block"), "account", []byte("key")))
+		req.Header.Set("x-ms-date", "Sat, 03 Jan 2026 03:04:05 GMT")
+		require.NotEqual(t, signature, AzureSharedKeySignature(req, "account", []byte("key")))
+	})
+}
@@ -0,0 +1,54 @@
+package registry
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// BTPAzureBinding holds the keys of a BTP Object Store binding on Azure. The binding grants access to one container
+// with a SAS token, which the azure storage driver of the registry can't use, the storage adapter of the operator
+// signs the requests of the registry with it.
+type BTPAzureBinding struct {
+	AccountName string
+	Container   string
+	// ContainerURI is the container on the DNS zone endpoint of the storage account
+	ContainerURI *url.URL
+	// SASToken is the query the requests to the container are authorized with
+	SASToken url.Values
+}
+
+// ParseBTPAzureBinding reads the Azure binding from the data of the BTP Object Store Secret
+func ParseBTPAzureBinding(data map[string][]byte) (*BTPAzureBinding, error) {
+	var missing []string
+	for _, key := range []string{"container_name", "container_uri", "sas_token"} {
+		if len(data[key]) == 0 {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, errors.Errorf("azure binding is missing %s", strings.Join(missing, ", "))
+	}
+
+	container := string(data["container_name"])
+	containerURI, err := url.Parse(string(data["container_uri"]))
+	if err != nil || containerURI.Scheme != "https" || containerURI.Host == "" {
+		return nil, errors.Errorf("container uri '%s' of the azure binding is not an absolute https url", data["container_uri"])
+	}
+	if strings.TrimSuffix(containerURI.Path, "/") != "/"+container {
+		return nil, errors.Errorf("container uri '%s' of the azure binding does not point to container %s", containerURI, container)
+	}
+
+	sasToken, err := url.ParseQuery(strings.TrimPrefix(string(data["sas_token"]), "?"))
+	if err != nil || sasToken.Get("sig") == "" {
+		return nil, errors.New("sas token of the azure binding is not signed")
+	}
+
+	return &BTPAzureBinding{
+		AccountName:  string(data["account_name"]),
+		Container:    container,
+		ContainerURI: containerURI,
+		SASToken:     sasToken,
+	}, nil
+}
@@ -0,0 +1,53 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBTPAzureBinding(t *testing.T) {
+	t.Run("read binding with dns zone endpoint", func(t *testing.T) {
+		binding, err := ParseBTPAzureBinding(map[string][]byte{
+			"account_name":   []byte("account"),
+			"container_name": []byte("container"),
+			"container_uri":  []byte("https://account.z13.blob.storage.azure.net/container"),
+			"sas_token":      []byte("?sv=2022-11-02&sr=c&sp=racwdl&sig=c2ln"),
+		})
+
+		require.NoError(t, err)
+		require.Equal(t, "account", binding.AccountName)
+		require.Equal(t, "container", binding.Container)
+		require.Equal(t, "account.z13.blob.storage.azure.net", binding.ContainerURI.Host)
+		require.Equal(t, "c2ln", binding.SASToken.Get("sig"))
+		require.Equal(t, "racwdl", binding.SASToken.Get("sp"))
+	})
+
+	t.Run("reject binding without sas token", func(t *testing.T) {
+		_, err := ParseBTPAzureBinding(map[string][]byte{
+			"container_name": []byte("container"),
+		})
+
+		require.EqualError(t, err, "azure binding is missing container_uri, sas_token")
+	})
+
+	t.Run("reject container uri of another container", func(t *testing.T) {
+		_, err := ParseBTPAzureBinding(map[string][]byte{
+			"container_name": []byte("container"),
+			"container_uri":  []byte("https://account.blob.core.windows.net/other"),
+			"sas_token":      []byte("sig=c2ln"),
+		})
+
+		require.EqualError(t, err, "container uri 'https://account.blob.core.windows.net/other' of the azure binding does not point to container container")
+	})
+
+	t.Run("reject unsigned sas token", func(t *testing.T) {
+		_, err := ParseBTPAzureBinding(map[string][]byte{
+			"container_name": []byte("container"),
+			"container_uri":  []byte("https://account.blob.core.windows.net/container"),
+			"sas_token":      []byte("sv=2022-11-02"),
+		})
+
+		require.EqualError(t, err, "sas token of the azure binding is not signed")
+	})
+}
@@ -3,10 +3,10 @@ package registry
 import (
 	"context"
 	"fmt"
-	"strings"
 
 	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
 	"github.com/kyma-project/docker-registry/components/operator/internal/istio"
+	"github.com/kyma-project/docker-registry/components/operator/internal/validation"
 	"github.com/pkg/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -71,16 +71,16 @@ func (ear *externalAccessResolver) resolveAccess(ctx context.Context, client cli
 }
 
 func resolveAccessWithCustomGateway(ctx context.Context, client client.Client, gateway, host *string) (*ResolvedAccess, error) {
-	if host == nil {
-		return nil, errors.New("failed to resolve custom gateway because host is empty")
+	if err := validation.CustomGatewayHost(gateway, host); err != nil {
+		return nil, err
 	}
 
-	namespacedName := strings.Split(*gateway, "/")
-	if len(namespacedName) != 2 {
-		return nil, errors.Errorf("gateway '%s' is in wrong format", *gateway)
+	gatewayNamespace, gatewayName, err := validation.ParseGateway(*gateway)
+	if err != nil {
+		return nil, err
 	}
 
-	isavailable := istio.IsGatewayAvailable(ctx, client, namespacedName[0], namespacedName[1])
+	isavailable := istio.IsGatewayAvailable(ctx, client, gatewayNamespace, gatewayName)
 	if !isavailable {
 		return nil, errors.Errorf("gateway '%s' not found", *gateway)
 	}
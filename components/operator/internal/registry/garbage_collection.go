@@ -0,0 +1,185 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	GarbageCollectionContainerName = "garbage-collect"
+	GarbageCollectionLabelKey      = "dockerregistry.kyma-project.io/garbage-collection"
+
+	// the release label makes the services and the pod disruption budget select a pod, the garbage collector
+	// must not serve requests
+	releaseLabelKey = "release"
+	// the Job keeps the registry read-only, so a stuck run must not block pushes forever
+	garbageCollectionTimeout = 6 * time.Hour
+	// the finished Job is kept for a day to let users read its logs
+	garbageCollectionJobTTL = 24 * time.Hour
+
+	registryDataPath   = "/var/lib/registry"
+	registryConfigPath = "/etc/distribution/config.yml"
+	freedKilobytesKey  = "freed-kilobytes"
+)
+
+var (
+	garbageCollectionSummaryRegexp = regexp.MustCompile(`(\d+) blobs marked, (\d+) blobs and (\d+) manifests eligible for deletion`)
+	freedKilobytesRegexp           = regexp.MustCompile(freedKilobytesKey + `: (-?\d+)`)
+)
+
+type GarbageCollectionOptions struct {
+	DeleteUntagged bool
+	DryRun         bool
+}
+
+// GarbageCollectionSummary is what the garbage collector reports in the termination message of its container
+type GarbageCollectionSummary struct {
+	DeletedBlobs     int64
+	DeletedManifests int64
+	// FreedBytes is only measured for the filesystem storage
+	FreedBytes *int64
+}
+
+// GarbageCollectionJobName returns the name of the Job of the garbage collection scheduled at the given time
+func GarbageCollectionJobName(scheduledAt time.Time) string {
+	return fmt.Sprintf("%s-gc-%d", DeploymentName, scheduledAt.Unix())
+}
+
+// NewGarbageCollectionJob returns the Job that runs the garbage collector with the configuration, the storage
+// credentials and the volumes of the registry deployment. The pods of the Job are not selected by the registry
+// services, and when the registry keeps images on a pvc they are scheduled next to the registry pods, so that
+// a ReadWriteOnce volume can be mounted by both.
+func NewGarbageCollectionJob(deployment *appsv1.Deployment, name string, opts GarbageCollectionOptions) (*batchv1.Job, error) {
+	template := deployment.Spec.Template.DeepCopy()
+	if len(template.Spec.Containers) == 0 {
+		return nil, errors.Errorf("deployment %s/%s has no containers", deployment.GetNamespace(), deployment.GetName())
+	}
+
+	onPVC := usesPVC(template.Spec.Volumes)
+	container := template.Spec.Containers[0]
+	container.Name = GarbageCollectionContainerName
+	container.Command = []string{"sh", "-c", garbageCollectionScript(opts, onPVC && !opts.DryRun)}
+	container.Args = nil
+	container.Ports = nil
+	container.LivenessProbe = nil
+	container.ReadinessProbe = nil
+	container.StartupProbe = nil
+	container.TerminationMessagePolicy = corev1.TerminationMessageFallbackToLogsOnError
+
+	labels := map[string]string{}
+	for key, value := range template.GetLabels() {
+		if key != releaseLabelKey {
+			labels[key] = value
+		}
+	}
+	labels[GarbageCollectionLabelKey] = name
+
+	podSpec := template.Spec
+	podSpec.InitContainers = nil
+	podSpec.Containers = []corev1.Container{container}
+	podSpec.RestartPolicy = corev1.RestartPolicyNever
+	podSpec.TopologySpreadConstraints = nil
+	if onPVC && deployment.Spec.Selector != nil {
+		podSpec.Affinity = &corev1.Affinity{
+			PodAffinity: &corev1.PodAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+					{
+						LabelSelector: deployment.Spec.Selector.DeepCopy(),
+						TopologyKey:   corev1.LabelHostname,
+					},
+				},
+			},
+		}
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: deployment.GetNamespace(),
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			// a failed run is not retried, the next one is scheduled anyway
+			BackoffLimit:            ptr.To[int32](0),
+			ActiveDeadlineSeconds:   ptr.To(int64(garbageCollectionTimeout.Seconds())),
+			TTLSecondsAfterFinished: ptr.To(int32(garbageCollectionJobTTL.Seconds())),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: podSpec,
+			},
+		},
+	}, nil
+}
+
+// ParseGarbageCollectionSummary reads the summary the garbage collector writes to its termination message
+func ParseGarbageCollectionSummary(message string) (*GarbageCollectionSummary, error) {
+	matches := garbageCollectionSummaryRegexp.FindStringSubmatch(message)
+	if matches == nil {
+		return nil, errors.Errorf("garbage collection summary not found in '%s'", message)
+	}
+
+	summary := &GarbageCollectionSummary{}
+	// the numbers are matched by the regexp, so they can't fail to parse
+	summary.DeletedBlobs, _ = strconv.ParseInt(matches[2], 10, 64)
+	summary.DeletedManifests, _ = strconv.ParseInt(matches[3], 10, 64)
+
+	if freed := freedKilobytesRegexp.FindStringSubmatch(message); freed != nil {
+		kilobytes, _ := strconv.ParseInt(freed[1], 10, 64)
+		// the storage usage is measured by du, which rounds it up to kilobytes
+		summary.FreedBytes = ptr.To(max(kilobytes, 0) * 1024)
+	}
+
+	return summary, nil
+}
+
+func garbageCollectionScript(opts GarbageCollectionOptions, measureFreedBytes bool) string {
+	command := []string{"/bin/registry", "garbage-collect"}
+	if opts.DeleteUntagged {
+		command = append(command, "--delete-untagged")
+	}
+	if opts.DryRun {
+		command = append(command, "--dry-run")
+	}
+	command = append(command, registryConfigPath)
+
+	lines := []string{}
+	if measureFreedBytes {
+		lines = append(lines, fmt.Sprintf("before=$(du -sk %s | cut -f1)", registryDataPath))
+	}
+	lines = append(lines,
+		fmt.Sprintf("output=$(%s 2>&1)", strings.Join(command, " ")),
+		"status=$?",
+		`echo "$output"`,
+		// the logs of a failed run become the termination message
+		`[ "$status" -eq 0 ] || exit "$status"`,
+		`echo "$output" | grep "blobs marked" | tail -n 1 > /dev/termination-log`,
+	)
+	if measureFreedBytes {
+		lines = append(lines,
+			fmt.Sprintf("after=$(du -sk %s | cut -f1)", registryDataPath),
+			fmt.Sprintf(`echo "%s: $((before - after))" >> /dev/termination-log`, freedKilobytesKey),
+		)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func usesPVC(volumes []corev1.Volume) bool {
+	for _, volume := range volumes {
+		if volume.PersistentVolumeClaim != nil {
+			return true
+		}
+	}
+	return false
+}
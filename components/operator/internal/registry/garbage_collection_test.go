@@ -0,0 +1,162 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestGarbageCollectionJobName(t *testing.T) {
+	t.Run("name job after the schedule time", func(t *testing.T) {
+		name := GarbageCollectionJobName(time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC))
+
+		require.Equal(t, "dockerregistry-gc-1717297200", name)
+	})
+}
+
+func TestNewGarbageCollectionJob(t *testing.T) {
+	t.Run("run garbage collector with registry configuration", func(t *testing.T) {
+		deployment := fixRegistryDeployment(corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{}})
+
+		job, err := NewGarbageCollectionJob(deployment, "dockerregistry-gc-1", GarbageCollectionOptions{
+			DeleteUntagged: true,
+		})
+
+		require.NoError(t, err)
+		require.Equal(t, "dockerregistry-gc-1", job.GetName())
+		require.Equal(t, "kyma-system", job.GetNamespace())
+		require.Equal(t, map[string]string{
+			"app":                     "docker-registry",
+			GarbageCollectionLabelKey: "dockerregistry-gc-1",
+		}, job.Spec.Template.GetLabels())
+		require.Equal(t, ptr.To[int32](0), job.Spec.BackoffLimit)
+
+		podSpec := job.Spec.Template.Spec
+		require.Equal(t, corev1.RestartPolicyNever, podSpec.RestartPolicy)
+		require.Empty(t, podSpec.InitContainers)
+		require.Nil(t, podSpec.Affinity)
+		require.Equal(t, deployment.Spec.Template.Spec.Volumes, podSpec.Volumes)
+		require.Len(t, podSpec.Containers, 1)
+
+		container := podSpec.Containers[0]
+		require.Equal(t, GarbageCollectionContainerName, container.Name)
+		require.Equal(t, "registry:3.1.1", container.Image)
+		require.Equal(t, deployment.Spec.Template.Spec.Containers[0].Env, container.Env)
+		require.Empty(t, container.Ports)
+		require.Nil(t, container.ReadinessProbe)
+		require.Contains(t, container.Command[2], "/bin/registry garbage-collect --delete-untagged /etc/distribution/config.yml")
+		require.NotContains(t, container.Command[2], "du -sk")
+	})
+
+	t.Run("schedule garbage collector next to registry on pvc", func(t *testing.T) {
+		deployment := fixRegistryDeployment(corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "dockerregistry"},
+		})
+
+		job, err := NewGarbageCollectionJob(deployment, "dockerregistry-gc-1", GarbageCollectionOptions{})
+
+		require.NoError(t, err)
+		require.Equal(t, &corev1.Affinity{
+			PodAffinity: &corev1.PodAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+					{
+						LabelSelector: deployment.Spec.Selector,
+						TopologyKey:   corev1.LabelHostname,
+					},
+				},
+			},
+		}, job.Spec.Template.Spec.Affinity)
+		require.Contains(t, job.Spec.Template.Spec.Containers[0].Command[2], "du -sk /var/lib/registry")
+	})
+
+	t.Run("skip measuring freed space in dry-run mode", func(t *testing.T) {
+		deployment := fixRegistryDeployment(corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "dockerregistry"},
+		})
+
+		job, err := NewGarbageCollectionJob(deployment, "dockerregistry-gc-1", GarbageCollectionOptions{DryRun: true})
+
+		require.NoError(t, err)
+		command := job.Spec.Template.Spec.Containers[0].Command[2]
+		require.Contains(t, command, "/bin/registry garbage-collect --dry-run /etc/distribution/config.yml")
+		require.NotContains(t, command, "du -sk")
+	})
+
+	t.Run("return error for deployment without containers", func(t *testing.T) {
+		deployment := fixRegistryDeployment(corev1.VolumeSource{})
+		deployment.Spec.Template.Spec.Containers = nil
+
+		job, err := NewGarbageCollectionJob(deployment, "dockerregistry-gc-1", GarbageCollectionOptions{})
+
+		require.ErrorContains(t, err, "deployment kyma-system/dockerregistry has no containers")
+		require.Nil(t, job)
+	})
+}
+
+func TestParseGarbageCollectionSummary(t *testing.T) {
+	t.Run("parse deleted blobs, manifests and freed space", func(t *testing.T) {
+		summary, err := ParseGarbageCollectionSummary("12 blobs marked, 3 blobs and 2 manifests eligible for deletion\nfreed-kilobytes: 2048")
+
+		require.NoError(t, err)
+		require.Equal(t, &GarbageCollectionSummary{
+			DeletedBlobs:     3,
+			DeletedManifests: 2,
+			FreedBytes:       ptr.To[int64](2 * 1024 * 1024),
+		}, summary)
+	})
+
+	t.Run("skip freed space when it is not measured", func(t *testing.T) {
+		summary, err := ParseGarbageCollectionSummary("12 blobs marked, 3 blobs and 2 manifests eligible for deletion")
+
+		require.NoError(t, err)
+		require.Nil(t, summary.FreedBytes)
+	})
+
+	t.Run("return error when summary is missing", func(t *testing.T) {
+		summary, err := ParseGarbageCollectionSummary("")
+
+		require.ErrorContains(t, err, "garbage collection summary not found")
+		require.Nil(t, summary)
+	})
+}
+
+func fixRegistryDeployment(dataVolume corev1.VolumeSource) *appsv1.Deployment {
+	labels := map[string]string{
+		"app":     "docker-registry",
+		"release": "dockerregistry",
+	}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DeploymentName,
+			Namespace: "kyma-system",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      labels,
+					Annotations: map[string]string{"rollme": "configData.storage.maintenance.readonly.enabled=true"},
+				},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{Name: "generate-htpasswd"}},
+					Containers: []corev1.Container{
+						{
+							Name:           "docker-registry",
+							Image:          "registry:3.1.1",
+							Command:        []string{"/bin/registry", "serve", "/etc/distribution/config.yml"},
+							Ports:          []corev1.ContainerPort{{ContainerPort: 5000}},
+							ReadinessProbe: &corev1.Probe{},
+							Env:            []corev1.EnvVar{{Name: HttpEnvKey, Value: "secret"}},
+						},
+					},
+					Volumes: []corev1.Volume{{Name: "data", VolumeSource: dataVolume}},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// CredentialsScopeAnnotation is set on the access Secrets whose copies get per-namespace credentials
+	CredentialsScopeAnnotation = "dockerregistry.kyma-project.io/credentials-scope"
+	CredentialsScopeNamespace  = "namespace"
+	// CredentialsRevokedLabel on a namespace stops the propagation of the registry credentials into it
+	// and removes the per-namespace credentials it got
+	CredentialsRevokedLabel = "dockerregistry.kyma-project.io/credentials-revoked"
+	// CredentialsAccessAnnotation is set on the access Secrets whose copies get the pull-only credentials
+	CredentialsAccessAnnotation = "dockerregistry.kyma-project.io/credentials-access"
+	CredentialsAccessPull       = "pull"
+	// PushAccessLabel on a namespace makes it get the credentials allowed to push instead of the pull-only ones
+	PushAccessLabel = "dockerregistry.kyma-project.io/push-access"
+	// CredentialsPropagationAnnotation is set on the access Secrets whose copies go only to the namespaces labeled
+	// with ExternalAccessLabel
+	CredentialsPropagationAnnotation = "dockerregistry.kyma-project.io/credentials-propagation"
+	CredentialsPropagationOptIn      = "opt-in"
+	// ExternalAccessLabel on a namespace makes it get a copy of the external access Secret
+	ExternalAccessLabel = "dockerregistry.kyma-project.io/external-access"
+	// PullUsernameKey and PullPasswordKey hold the pull-only credentials in the internal access Secret
+	PullUsernameKey = "pullUsername"
+	PullPasswordKey = "pullPassword"
+	// PreviousUsernameKey and PreviousPasswordKey hold the credentials replaced by the last rotation in the internal
+	// access Secret, until the new ones are propagated to every namespace
+	PreviousUsernameKey = "previousUsername"
+	PreviousPasswordKey = "previousPassword"
+	// AccessProxyCommand is the command of the operator binary that runs in front of the registries with the htpasswd
+	// authentication, which accepts every listed user for every action
+	AccessProxyCommand = "access-proxy"
+
+	usernameLength  = 20
+	passwordLength  = 40
+	passwordCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// GenerateUsername returns a random username of the same length and characters as the chart generates, the mixed
+// case keeps it apart from the per-namespace usernames, which are namespace names
+func GenerateUsername() (string, error) {
+	username, err := randomString(usernameLength)
+	return username, errors.Wrap(err, "while generating username")
+}
+
+// GeneratePassword returns a random password of the same length and characters as the chart generates
+func GeneratePassword() (string, error) {
+	password, err := randomString(passwordLength)
+	return password, errors.Wrap(err, "while generating password")
+}
+
+func randomString(length int) (string, error) {
+	result := make([]byte, length)
+	charsetSize := big.NewInt(int64(len(passwordCharset)))
+	for i := range result {
+		n, err := rand.Int(rand.Reader, charsetSize)
+		if err != nil {
+			return "", err
+		}
+		result[i] = passwordCharset[n.Int64()]
+	}
+	return string(result), nil
+}
+
+// HtpasswdEntry returns the htpasswd line of the user, the registry only supports the bcrypt hashes
+func HtpasswdEntry(username, password string) (string, error) {
+	if username == "" || strings.ContainsAny(username, ":\n") {
+		return "", errors.Errorf("invalid htpasswd username '%s'", username)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.Wrap(err, "while hashing password")
+	}
+	return username + ":" + string(hash) + "\n", nil
+}
+
+// HtpasswdUsername returns the user of the htpasswd line
+func HtpasswdUsername(entry string) string {
+	username, _, _ := strings.Cut(entry, ":")
+	return username
+}
+
+// VerifyHtpasswdEntry checks the credentials against the htpasswd line
+func VerifyHtpasswdEntry(entry, username, password string) bool {
+	entryUsername, hash, found := strings.Cut(strings.TrimSpace(entry), ":")
+	if !found || entryUsername != username {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -0,0 +1,58 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	t.Run("generate random alphanumeric password", func(t *testing.T) {
+		password, err := GeneratePassword()
+		require.NoError(t, err)
+		otherPassword, err := GeneratePassword()
+		require.NoError(t, err)
+
+		require.Len(t, password, passwordLength)
+		require.Empty(t, strings.Trim(password, passwordCharset))
+		require.NotEqual(t, password, otherPassword)
+	})
+}
+
+func TestGenerateUsername(t *testing.T) {
+	t.Run("generate random alphanumeric username", func(t *testing.T) {
+		username, err := GenerateUsername()
+		require.NoError(t, err)
+
+		require.Len(t, username, usernameLength)
+		require.Empty(t, strings.Trim(username, passwordCharset))
+	})
+}
+
+func TestHtpasswdEntry(t *testing.T) {
+	t.Run("build verifiable entry", func(t *testing.T) {
+		entry, err := HtpasswdEntry("deployer", "secret-password")
+		require.NoError(t, err)
+
+		require.True(t, strings.HasPrefix(entry, "deployer:$2a$"))
+		require.True(t, strings.HasSuffix(entry, "\n"))
+		require.Equal(t, "deployer", HtpasswdUsername(entry))
+		require.True(t, VerifyHtpasswdEntry(entry, "deployer", "secret-password"))
+	})
+
+	t.Run("reject other credentials", func(t *testing.T) {
+		entry, err := HtpasswdEntry("deployer", "secret-password")
+		require.NoError(t, err)
+
+		require.False(t, VerifyHtpasswdEntry(entry, "deployer", "other-password"))
+		require.False(t, VerifyHtpasswdEntry(entry, "other", "secret-password"))
+		require.False(t, VerifyHtpasswdEntry("", "deployer", "secret-password"))
+	})
+
+	t.Run("reject username breaking the htpasswd format", func(t *testing.T) {
+		_, err := HtpasswdEntry("deployer:admin", "secret-password")
+
+		require.ErrorContains(t, err, "invalid htpasswd username 'deployer:admin'")
+	})
+}
@@ -0,0 +1,108 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// BaseNamespace is the namespace the module is installed in, the credentials of the registry served
+	// from this namespace are propagated to all other namespaces
+	BaseNamespace = "docker-registry"
+
+	ReleaseName        = "dockerregistry"
+	PriorityClassName  = "dockerregistry-priority"
+	manifestCacheName  = "dockerregistry-manifest-cache"
+	tokenKeySecretName = "dockerregistry-token-key"
+	// NamespaceCredentialsSecretName holds the htpasswd entries of the per-namespace credentials
+	NamespaceCredentialsSecretName = "dockerregistry-namespace-credentials"
+	// usersSecretName holds the htpasswd entries of the users listed in the DockerRegistry CR
+	usersSecretName = "dockerregistry-users"
+	// storageAdapterSecretName holds the account name the registry is recognized by in the storage adapter and the
+	// account key it signs the requests with
+	storageAdapterSecretName = "dockerregistry-storage-adapter"
+	// StorageAdapterAccountKey is the key of the account name in the storage adapter Secret
+	StorageAdapterAccountKey = "accountName"
+	// StorageAdapterSharedKey is the key of the account key in the storage adapter Secret
+	StorageAdapterSharedKey = "accountKey"
+
+	// helm rejects longer release names
+	maxReleaseNameLength = 53
+	hashSuffixLength     = 8
+)
+
+// ResourceNames holds the names that have to be unique for every served DockerRegistry, so that
+// registries served from different namespaces do not overwrite each other
+type ResourceNames struct {
+	ReleaseName              string
+	CacheKey                 types.NamespacedName
+	InternalAccessSecretName string
+	ExternalAccessSecretName string
+	PriorityClassName        string
+	TokenKeySecretName       string
+	// NamespaceCredentialsSecretName is only used by the registry served from the BaseNamespace, the other
+	// registries do not propagate their credentials
+	NamespaceCredentialsSecretName string
+	UsersSecretName                string
+	StorageAdapterSecretName       string
+}
+
+// NewResourceNames returns the names for the DockerRegistry served from the given namespace. The registry
+// served from the BaseNamespace keeps the names used when only one registry per cluster was allowed, so that
+// upgrading the operator neither reinstalls it nor rotates its credentials. Other registries get the namespace
+// as a suffix, their secrets must not collide with the ones propagated from the BaseNamespace.
+func NewResourceNames(namespace string) ResourceNames {
+	if namespace == BaseNamespace {
+		return ResourceNames{
+			ReleaseName: ReleaseName,
+			CacheKey: types.NamespacedName{
+				Name:      manifestCacheName,
+				Namespace: BaseNamespace,
+			},
+			InternalAccessSecretName:       InternalAccessSecretName,
+			ExternalAccessSecretName:       ExternalAccessSecretName,
+			PriorityClassName:              PriorityClassName,
+			TokenKeySecretName:             tokenKeySecretName,
+			NamespaceCredentialsSecretName: NamespaceCredentialsSecretName,
+			UsersSecretName:                usersSecretName,
+			StorageAdapterSecretName:       storageAdapterSecretName,
+		}
+	}
+
+	return ResourceNames{
+		ReleaseName: releaseName(namespace),
+		// the cache is kept in the operator namespace, users with access to the registry namespace must not be able
+		// to change manifests the operator applies
+		CacheKey: types.NamespacedName{
+			Name:      withNamespace(manifestCacheName, namespace),
+			Namespace: BaseNamespace,
+		},
+		InternalAccessSecretName:       withNamespace(InternalAccessSecretName, namespace),
+		ExternalAccessSecretName:       withNamespace(ExternalAccessSecretName, namespace),
+		PriorityClassName:              withNamespace(PriorityClassName, namespace),
+		TokenKeySecretName:             withNamespace(tokenKeySecretName, namespace),
+		NamespaceCredentialsSecretName: withNamespace(NamespaceCredentialsSecretName, namespace),
+		UsersSecretName:                withNamespace(usersSecretName, namespace),
+		StorageAdapterSecretName:       withNamespace(storageAdapterSecretName, namespace),
+	}
+}
+
+func releaseName(namespace string) string {
+	name := withNamespace(ReleaseName, namespace)
+	if len(name) <= maxReleaseNameLength {
+		return name
+	}
+
+	// truncated names of different namespaces may be equal, the hash keeps them apart
+	hash := sha256.Sum256([]byte(namespace))
+	prefix := strings.TrimSuffix(name[:maxReleaseNameLength-hashSuffixLength-1], "-")
+	return fmt.Sprintf("%s-%s", prefix, hex.EncodeToString(hash[:])[:hashSuffixLength])
+}
+
+func withNamespace(name, namespace string) string {
+	return fmt.Sprintf("%s-%s", name, namespace)
+}
@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestNewResourceNames(t *testing.T) {
+	t.Run("keep names of the registry served from the base namespace", func(t *testing.T) {
+		names := NewResourceNames(BaseNamespace)
+
+		require.Equal(t, ResourceNames{
+			ReleaseName:                    "dockerregistry",
+			CacheKey:                       types.NamespacedName{Name: "dockerregistry-manifest-cache", Namespace: "docker-registry"},
+			InternalAccessSecretName:       "dockerregistry-config",
+			ExternalAccessSecretName:       "dockerregistry-config-external",
+			PriorityClassName:              "dockerregistry-priority",
+			TokenKeySecretName:             "dockerregistry-token-key",
+			NamespaceCredentialsSecretName: "dockerregistry-namespace-credentials",
+			UsersSecretName:                "dockerregistry-users",
+			StorageAdapterSecretName:       "dockerregistry-storage-adapter",
+		}, names)
+	})
+
+	t.Run("suffix names of the registry served from another namespace", func(t *testing.T) {
+		names := NewResourceNames("tenant")
+
+		require.Equal(t, ResourceNames{
+			ReleaseName:                    "dockerregistry-tenant",
+			CacheKey:                       types.NamespacedName{Name: "dockerregistry-manifest-cache-tenant", Namespace: "docker-registry"},
+			InternalAccessSecretName:       "dockerregistry-config-tenant",
+			ExternalAccessSecretName:       "dockerregistry-config-external-tenant",
+			PriorityClassName:              "dockerregistry-priority-tenant",
+			TokenKeySecretName:             "dockerregistry-token-key-tenant",
+			NamespaceCredentialsSecretName: "dockerregistry-namespace-credentials-tenant",
+			UsersSecretName:                "dockerregistry-users-tenant",
+			StorageAdapterSecretName:       "dockerregistry-storage-adapter-tenant",
+		}, names)
+	})
+
+	t.Run("shorten release name of long namespace", func(t *testing.T) {
+		namespace := strings.Repeat("a", 63)
+		otherNamespace := strings.Repeat("a", 62) + "b"
+
+		releaseName := NewResourceNames(namespace).ReleaseName
+		otherReleaseName := NewResourceNames(otherNamespace).ReleaseName
+
+		require.Len(t, releaseName, maxReleaseNameLength)
+		require.True(t, strings.HasPrefix(releaseName, "dockerregistry-aaa"))
+		require.NotEqual(t, releaseName, otherReleaseName)
+	})
+}
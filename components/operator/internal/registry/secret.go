@@ -14,6 +14,8 @@ const (
 	ExternalAccessSecretName = "dockerregistry-config-external"
 	LabelConfigKey           = "dockerregistry.kyma-project.io/config"
 	LabelConfigVal           = "credentials"
+	LabelManagedByKey        = "dockerregistry.kyma-project.io/managed-by"
+	LabelManagedByUserVal    = "user"
 	DeploymentName           = "dockerregistry"
 	HttpEnvKey               = "REGISTRY_HTTP_SECRET"
 	ConfigSecretFinalizer    = "dockerregistry.kyma-project.io/finalizer-registry-config"
@@ -50,11 +52,11 @@ func ReleaseConfigSecretFinalizers(ctx context.Context, c client.Client, namespa
 	return nil
 }
 
-func GetDockerRegistryInternalRegistrySecret(ctx context.Context, c client.Client, namespace string) (*corev1.Secret, error) {
+func GetDockerRegistryInternalRegistrySecret(ctx context.Context, c client.Client, name, namespace string) (*corev1.Secret, error) {
 	secret := corev1.Secret{}
 	key := client.ObjectKey{
 		Namespace: namespace,
-		Name:      InternalAccessSecretName,
+		Name:      name,
 	}
 	err := c.Get(ctx, key, &secret)
 	if err != nil {
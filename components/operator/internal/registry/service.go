@@ -1,5 +1,115 @@
 package registry
 
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
 const (
 	ServicePort = 5000
+
+	serviceDomainSuffix = ".svc.cluster.local"
 )
+
+// TokenService returns the name the registry served from the namespace is identified by in the tokens, it is the
+// in-cluster host of the registry
+func TokenService(namespace string) string {
+	return fmt.Sprintf("%s.%s%s", dockerRegistryService, namespace, serviceDomainSuffix)
+}
+
+// TokenServiceNamespace returns the namespace of the registry the token service name belongs to
+func TokenServiceNamespace(service string) (string, bool) {
+	namespace, found := strings.CutPrefix(service, dockerRegistryService+".")
+	if !found {
+		return "", false
+	}
+
+	namespace, found = strings.CutSuffix(namespace, serviceDomainSuffix)
+	if !found || namespace == "" || strings.Contains(namespace, ".") {
+		return "", false
+	}
+	return namespace, true
+}
+
+const (
+	// TokenServerPortName is the port of the operator Service the token server is exposed on
+	TokenServerPortName = "http-token"
+	// TokenServerPath is the path the token server issues the registry tokens on
+	TokenServerPath = "/token"
+	// ExternalTokenServerPath is the path the token server issues the registry tokens to the clients outside the
+	// cluster on, the VirtualService of the registry routes the external token requests to it
+	ExternalTokenServerPath = "/token/external"
+)
+
+// GetTokenRealm returns the URL the registry sends the clients to for a token. The ClusterIP of the token server
+// Service is used instead of its DNS name, because the kubelet pulls the images on the nodes, where the cluster
+// DNS names are not resolved.
+func GetTokenRealm(ctx context.Context, c client.Client, service types.NamespacedName) (string, error) {
+	svc, port, err := getTokenServerService(ctx, c, service)
+	if err != nil {
+		return "", err
+	}
+
+	clusterIP := svc.Spec.ClusterIP
+	if clusterIP == "" || clusterIP == corev1.ClusterIPNone {
+		return "", errors.Errorf("token server service %s has no cluster IP", service)
+	}
+
+	address := net.JoinHostPort(clusterIP, strconv.Itoa(int(port)))
+	return fmt.Sprintf("http://%s%s", address, TokenServerPath), nil
+}
+
+// GetTokenServerDestination returns the host and the port the VirtualService of the registry routes the external
+// token requests to, the Istio gateway resolves the cluster DNS names
+func GetTokenServerDestination(ctx context.Context, c client.Client, service types.NamespacedName) (string, int32, error) {
+	_, port, err := getTokenServerService(ctx, c, service)
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("%s.%s%s", service.Name, service.Namespace, serviceDomainSuffix), port, nil
+}
+
+func getTokenServerService(ctx context.Context, c client.Client, service types.NamespacedName) (*corev1.Service, int32, error) {
+	svc := &corev1.Service{}
+	if err := c.Get(ctx, service, svc); err != nil {
+		return nil, 0, errors.Wrapf(err, "while getting token server service %s", service)
+	}
+
+	for _, port := range svc.Spec.Ports {
+		if port.Name == TokenServerPortName {
+			return svc, port.Port, nil
+		}
+	}
+	return nil, 0, errors.Errorf("token server service %s has no %s port", service, TokenServerPortName)
+}
+
+const (
+	// StorageAdapterPortName is the port of the operator Service the storage adapter is exposed on
+	StorageAdapterPortName = "http-storage"
+)
+
+// GetStorageAdapterURL returns the service URL the azure storage driver of the registry served from the namespace
+// is pointed to, the adapter reads the namespace from the first segment of the path. The registry pods resolve the
+// cluster DNS names, so the URL does not change when the Service is recreated.
+func GetStorageAdapterURL(ctx context.Context, c client.Client, service types.NamespacedName, namespace string) (string, error) {
+	svc := &corev1.Service{}
+	if err := c.Get(ctx, service, svc); err != nil {
+		return "", errors.Wrapf(err, "while getting storage adapter service %s", service)
+	}
+
+	for _, port := range svc.Spec.Ports {
+		if port.Name == StorageAdapterPortName {
+			host := fmt.Sprintf("%s.%s%s", service.Name, service.Namespace, serviceDomainSuffix)
+			return fmt.Sprintf("http://%s/%s", net.JoinHostPort(host, strconv.Itoa(int(port.Port))), namespace), nil
+		}
+	}
+	return "", errors.Errorf("storage adapter service %s has no %s port", service, StorageAdapterPortName)
+}
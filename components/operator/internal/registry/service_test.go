@@ -0,0 +1,153 @@
+package registry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestTokenService(t *testing.T) {
+	t.Run("build service of namespace", func(t *testing.T) {
+		require.Equal(t, "dockerregistry.test-namespace.svc.cluster.local", TokenService("test-namespace"))
+	})
+
+	t.Run("read namespace of service", func(t *testing.T) {
+		namespace, ok := TokenServiceNamespace(TokenService("test-namespace"))
+
+		require.True(t, ok)
+		require.Equal(t, "test-namespace", namespace)
+	})
+
+	t.Run("reject foreign services", func(t *testing.T) {
+		for _, service := range []string{
+			"",
+			"registry.example.com",
+			"dockerregistry.svc.cluster.local",
+			"dockerregistry.a.b.svc.cluster.local",
+			"dockerregistry.test-namespace.svc.example.com",
+		} {
+			_, ok := TokenServiceNamespace(service)
+			require.False(t, ok, service)
+		}
+	})
+}
+
+func TestGetTokenRealm(t *testing.T) {
+	tokenServer := types.NamespacedName{Name: "dockerregistry-token-server", Namespace: "docker-registry"}
+
+	t.Run("build realm from cluster IP", func(t *testing.T) {
+		c := fake.NewClientBuilder().WithObjects(fixTokenServerService(tokenServer, "10.0.0.12")).Build()
+
+		realm, err := GetTokenRealm(context.Background(), c, tokenServer)
+
+		require.NoError(t, err)
+		require.Equal(t, "http://10.0.0.12:8090/token", realm)
+	})
+
+	t.Run("build realm from IPv6 cluster IP", func(t *testing.T) {
+		c := fake.NewClientBuilder().WithObjects(fixTokenServerService(tokenServer, "fd00::12")).Build()
+
+		realm, err := GetTokenRealm(context.Background(), c, tokenServer)
+
+		require.NoError(t, err)
+		require.Equal(t, "http://[fd00::12]:8090/token", realm)
+	})
+
+	t.Run("return error for headless service", func(t *testing.T) {
+		c := fake.NewClientBuilder().WithObjects(fixTokenServerService(tokenServer, corev1.ClusterIPNone)).Build()
+
+		_, err := GetTokenRealm(context.Background(), c, tokenServer)
+
+		require.ErrorContains(t, err, "token server service docker-registry/dockerregistry-token-server has no cluster IP")
+	})
+
+	t.Run("return error for service without token port", func(t *testing.T) {
+		svc := fixTokenServerService(tokenServer, "10.0.0.12")
+		svc.Spec.Ports[0].Name = "http"
+		c := fake.NewClientBuilder().WithObjects(svc).Build()
+
+		_, err := GetTokenRealm(context.Background(), c, tokenServer)
+
+		require.ErrorContains(t, err, "token server service docker-registry/dockerregistry-token-server has no http-token port")
+	})
+
+	t.Run("return error for missing service", func(t *testing.T) {
+		c := fake.NewClientBuilder().Build()
+
+		_, err := GetTokenRealm(context.Background(), c, tokenServer)
+
+		require.ErrorContains(t, err, "while getting token server service docker-registry/dockerregistry-token-server")
+	})
+}
+
+func TestGetTokenServerDestination(t *testing.T) {
+	tokenServer := types.NamespacedName{Name: "dockerregistry-token-server", Namespace: "kyma-system"}
+
+	t.Run("build destination from service name", func(t *testing.T) {
+		c := fake.NewClientBuilder().WithObjects(fixTokenServerService(tokenServer, "10.0.0.12")).Build()
+
+		host, port, err := GetTokenServerDestination(context.Background(), c, tokenServer)
+
+		require.NoError(t, err)
+		require.Equal(t, "dockerregistry-token-server.kyma-system.svc.cluster.local", host)
+		require.Equal(t, int32(8090), port)
+	})
+
+	t.Run("return error for service without token port", func(t *testing.T) {
+		svc := fixTokenServerService(tokenServer, "10.0.0.12")
+		svc.Spec.Ports[0].Name = "http"
+		c := fake.NewClientBuilder().WithObjects(svc).Build()
+
+		_, _, err := GetTokenServerDestination(context.Background(), c, tokenServer)
+
+		require.ErrorContains(t, err, "token server service kyma-system/dockerregistry-token-server has no http-token port")
+	})
+}
+
+func fixTokenServerService(name types.NamespacedName, clusterIP string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name.Name,
+			Namespace: name.Namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: clusterIP,
+			Ports: []corev1.ServicePort{
+				{Name: TokenServerPortName, Port: 8090},
+			},
+		},
+	}
+}
+
+func TestGetStorageAdapterURL(t *testing.T) {
+	storageAdapter := types.NamespacedName{Name: "dockerregistry-storage-adapter", Namespace: "kyma-system"}
+
+	t.Run("build url from service dns name", func(t *testing.T) {
+		c := fake.NewClientBuilder().WithObjects(&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: storageAdapter.Name, Namespace: storageAdapter.Namespace},
+			Spec: corev1.ServiceSpec{
+				Ports: []corev1.ServicePort{{Name: StorageAdapterPortName, Port: 8091}},
+			},
+		}).Build()
+
+		serviceURL, err := GetStorageAdapterURL(context.Background(), c, storageAdapter, "test-namespace")
+
+		require.NoError(t, err)
+		require.Equal(t, "http://dockerregistry-storage-adapter.kyma-system.svc.cluster.local:8091/test-namespace", serviceURL)
+	})
+
+	t.Run("fail without storage port", func(t *testing.T) {
+		c := fake.NewClientBuilder().WithObjects(&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: storageAdapter.Name, Namespace: storageAdapter.Namespace},
+		}).Build()
+
+		_, err := GetStorageAdapterURL(context.Background(), c, storageAdapter, "test-namespace")
+
+		require.EqualError(t, err, "storage adapter service kyma-system/dockerregistry-storage-adapter has no http-storage port")
+	})
+}
@@ -0,0 +1,412 @@
+package registry
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// StorageMigrationSourceName is the name of the Deployment, the ConfigMap and the Secret that keep serving the
+	// images from the old storage while they are copied
+	StorageMigrationSourceName    = DeploymentName + "-migration-source"
+	StorageMigrationLabelKey      = "dockerregistry.kyma-project.io/storage-migration"
+	StorageMigrationContainerName = "migrate-storage"
+	// StorageMigrationCommand is the command of the operator binary the migration Job copies the images with
+	StorageMigrationCommand = "migrate-storage"
+	// StorageMigrationSourceAddress and StorageMigrationTargetAddress are the addresses the registries of the
+	// migration Job listen on, they are reachable from the pod only
+	StorageMigrationSourceAddress = "localhost:5100"
+	StorageMigrationTargetAddress = "localhost:5200"
+
+	storageMigrationSourceLabelValue = "source"
+	storageMigrationSourceContainer  = "source"
+	storageMigrationTargetContainer  = "target"
+	storageMigrationSourceVolume     = "source-"
+	// the ConfigMap holds the configuration of the source Deployment under the key the registry reads, the registries
+	// of the Job get their own keys
+	registryConfigKey        = "config.yml"
+	migrationSourceConfigKey = "source.yml"
+	// StorageMigrationTargetConfigKey holds the configuration of the target registry, it is added when the Job starts
+	StorageMigrationTargetConfigKey = "target.yml"
+
+	// RegistryConfigMapName and RegistrySecretName are rendered by the chart, they follow the storage of the CR
+	RegistryConfigMapName = DeploymentName + "-config"
+	RegistrySecretName    = DeploymentName + "-secret"
+
+	// the copy of a large registry takes long, but a stuck one must not keep the registry read-only forever
+	storageMigrationTimeout = 24 * time.Hour
+	// the finished Job is kept for a day to let users read its logs
+	storageMigrationJobTTL = 24 * time.Hour
+)
+
+// StorageMigrationSource holds the copies of the registry resources that keep the old storage configuration, the
+// chart replaces the originals with the new one as soon as the migration starts
+type StorageMigrationSource struct {
+	Deployment *appsv1.Deployment
+	ConfigMap  *corev1.ConfigMap
+	Secret     *corev1.Secret
+}
+
+// StorageLocation identifies where the registry keeps the images, the registries with equal locations serve the same
+// images. The locations of storages configured with Secrets name the Secret, the bucket or container is not known
+// without reading it.
+func StorageLocation(storage *v1alpha1.Storage) string {
+	switch {
+	case storage == nil:
+		return "filesystem"
+	case storage.Azure != nil && storage.Azure.WorkloadIdentity != nil:
+		return "azure:" + path.Join(storage.Azure.WorkloadIdentity.AccountName, storage.Azure.WorkloadIdentity.Container)
+	case storage.Azure != nil:
+		return "azure:secret/" + storage.Azure.SecretName
+	case storage.S3 != nil:
+		return "s3:" + path.Join(endpointHost(storage.S3.RegionEndpoint), storage.S3.Bucket, storage.S3.Rootdirectory)
+	case storage.GCS != nil:
+		return "gcs:" + path.Join(storage.GCS.Bucket, storage.GCS.Rootdirectory)
+	case storage.BTPObjectStore != nil:
+		return "btpObjectStore:secret/" + storage.BTPObjectStore.SecretName
+	case storage.PVC != nil:
+		return "pvc:" + storage.PVC.Name
+	default:
+		return "filesystem"
+	}
+}
+
+func endpointHost(endpoint string) string {
+	if endpoint == "" {
+		return ""
+	}
+	if parsed, err := url.Parse(endpoint); err == nil && parsed.Host != "" {
+		return parsed.Host
+	}
+	return endpoint
+}
+
+// StorageMigrationJobName returns the name of the migration Job started at the given time
+func StorageMigrationJobName(startedAt time.Time) string {
+	return fmt.Sprintf("%s-migration-%d", DeploymentName, startedAt.Unix())
+}
+
+// NewStorageMigrationSource returns the read-only copy of the registry deployment and of the configuration it reads.
+// The pods of the copy are selected by the registry services, so they serve the images while the registry
+// deployment is scaled down. The secret is nil for the storages that need none.
+func NewStorageMigrationSource(deployment *appsv1.Deployment, config *corev1.ConfigMap, secret *corev1.Secret) (*StorageMigrationSource, error) {
+	if len(deployment.Spec.Template.Spec.Containers) == 0 {
+		return nil, errors.Errorf("deployment %s/%s has no containers", deployment.GetNamespace(), deployment.GetName())
+	}
+
+	servedConfig, err := storageMigrationConfig(config.Data[registryConfigKey], "", true)
+	if err != nil {
+		return nil, err
+	}
+	jobConfig, err := storageMigrationConfig(config.Data[registryConfigKey], StorageMigrationSourceAddress, true)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := deployment.GetNamespace()
+	source := &StorageMigrationSource{
+		ConfigMap: &corev1.ConfigMap{
+			ObjectMeta: storageMigrationSourceMeta(namespace),
+			Data: map[string]string{
+				registryConfigKey:        servedConfig,
+				migrationSourceConfigKey: jobConfig,
+			},
+		},
+	}
+	if secret != nil {
+		source.Secret = &corev1.Secret{
+			ObjectMeta: storageMigrationSourceMeta(namespace),
+			Type:       secret.Type,
+			Data:       secret.Data,
+		}
+	}
+
+	template := deployment.Spec.Template.DeepCopy()
+	if template.Labels == nil {
+		template.Labels = map[string]string{}
+	}
+	template.Labels[StorageMigrationLabelKey] = storageMigrationSourceLabelValue
+	rewriteStorageMigrationSourceRefs(&template.Spec)
+
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{}}
+	if deployment.Spec.Selector != nil {
+		selector = deployment.Spec.Selector.DeepCopy()
+		if selector.MatchLabels == nil {
+			selector.MatchLabels = map[string]string{}
+		}
+	}
+	// the selector keeps the pods of the copy apart from the ones of the registry deployment
+	selector.MatchLabels[StorageMigrationLabelKey] = storageMigrationSourceLabelValue
+
+	source.Deployment = &appsv1.Deployment{
+		ObjectMeta: storageMigrationSourceMeta(namespace),
+		Spec: appsv1.DeploymentSpec{
+			Replicas: deployment.Spec.Replicas,
+			Selector: selector,
+			Strategy: deployment.Spec.Strategy,
+			Template: *template,
+		},
+	}
+	source.Deployment.Labels = template.Labels
+
+	return source, nil
+}
+
+// NewStorageMigrationTargetConfig returns the configuration the target registry of the Job runs with, it is the
+// registry configuration without the read-only mode, so that the images can be pushed
+func NewStorageMigrationTargetConfig(config *corev1.ConfigMap) (string, error) {
+	return storageMigrationConfig(config.Data[registryConfigKey], StorageMigrationTargetAddress, false)
+}
+
+// NewStorageMigrationJob returns the Job that copies the images from the registry of the source deployment to the
+// registry of the target one. Both registries run as sidecars of the copy container with the storage configuration
+// and the credentials of their deployments, they listen on the loopback interface only and accept every request.
+func NewStorageMigrationJob(target, source *appsv1.Deployment, image, name string) (*batchv1.Job, error) {
+	if len(target.Spec.Template.Spec.Containers) == 0 {
+		return nil, errors.Errorf("deployment %s/%s has no containers", target.GetNamespace(), target.GetName())
+	}
+	if len(source.Spec.Template.Spec.Containers) == 0 {
+		return nil, errors.Errorf("deployment %s/%s has no containers", source.GetNamespace(), source.GetName())
+	}
+
+	targetTemplate := target.Spec.Template.DeepCopy()
+	sourceTemplate := source.Spec.Template.DeepCopy()
+
+	targetContainer := storageMigrationRegistry(targetTemplate.Spec.Containers[0], storageMigrationTargetContainer)
+	targetVolumes := storageMigrationConfigVolume(targetTemplate.Spec.Volumes, &targetContainer, storageMigrationTargetContainer, StorageMigrationTargetConfigKey)
+
+	sourceContainer := storageMigrationRegistry(sourceTemplate.Spec.Containers[0], storageMigrationSourceContainer)
+	sourceVolumes := storageMigrationConfigVolume(sourceTemplate.Spec.Volumes, &sourceContainer, storageMigrationSourceContainer, migrationSourceConfigKey)
+	// the volumes of both deployments are named alike, only the ones the source registry mounts are taken
+	sourceVolumes = prefixMountedVolumes(sourceVolumes, &sourceContainer, storageMigrationSourceVolume)
+
+	copyContainer := corev1.Container{
+		Name:  StorageMigrationContainerName,
+		Image: image,
+		Command: []string{
+			"/operator", StorageMigrationCommand,
+			"--source=" + StorageMigrationSourceAddress,
+			"--target=" + StorageMigrationTargetAddress,
+		},
+		SecurityContext:          targetContainer.SecurityContext.DeepCopy(),
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+
+	labels := map[string]string{}
+	for key, value := range targetTemplate.GetLabels() {
+		if key != releaseLabelKey {
+			labels[key] = value
+		}
+	}
+	labels[StorageMigrationLabelKey] = name
+
+	podSpec := targetTemplate.Spec
+	// the registries are sidecars, the Job finishes with the copy container
+	podSpec.InitContainers = []corev1.Container{sourceContainer, targetContainer}
+	podSpec.Containers = []corev1.Container{copyContainer}
+	podSpec.Volumes = append(targetVolumes, sourceVolumes...)
+	podSpec.RestartPolicy = corev1.RestartPolicyNever
+	podSpec.TopologySpreadConstraints = nil
+	podSpec.Affinity = nil
+	if usesPVC(sourceVolumes) && source.Spec.Selector != nil {
+		// a ReadWriteOnce volume of the old storage is mounted by the source deployment as well
+		podSpec.Affinity = &corev1.Affinity{
+			PodAffinity: &corev1.PodAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{
+					{
+						LabelSelector: source.Spec.Selector.DeepCopy(),
+						TopologyKey:   corev1.LabelHostname,
+					},
+				},
+			},
+		}
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: target.GetNamespace(),
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			// a failed copy is retried by the operator, the copied blobs are not copied again
+			BackoffLimit:            ptr.To[int32](0),
+			ActiveDeadlineSeconds:   ptr.To(int64(storageMigrationTimeout.Seconds())),
+			TTLSecondsAfterFinished: ptr.To(int32(storageMigrationJobTTL.Seconds())),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: podSpec,
+			},
+		},
+	}, nil
+}
+
+func storageMigrationSourceMeta(namespace string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      StorageMigrationSourceName,
+		Namespace: namespace,
+		Labels: map[string]string{
+			StorageMigrationLabelKey: storageMigrationSourceLabelValue,
+		},
+	}
+}
+
+// rewriteStorageMigrationSourceRefs points the pod to the copies of the configuration and of the storage secret
+func rewriteStorageMigrationSourceRefs(spec *corev1.PodSpec) {
+	for i := range spec.Volumes {
+		volume := &spec.Volumes[i]
+		if volume.ConfigMap != nil && volume.ConfigMap.Name == RegistryConfigMapName {
+			volume.ConfigMap.Name = StorageMigrationSourceName
+			// the configuration of the Job registry is kept next to the one of the deployment
+			volume.ConfigMap.Items = []corev1.KeyToPath{{Key: registryConfigKey, Path: registryConfigKey}}
+		}
+		if volume.Secret != nil && volume.Secret.SecretName == RegistrySecretName {
+			volume.Secret.SecretName = StorageMigrationSourceName
+		}
+	}
+
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			for j := range containers[i].Env {
+				valueFrom := containers[i].Env[j].ValueFrom
+				if valueFrom != nil && valueFrom.SecretKeyRef != nil && valueFrom.SecretKeyRef.Name == RegistrySecretName {
+					valueFrom.SecretKeyRef.Name = StorageMigrationSourceName
+				}
+			}
+		}
+	}
+}
+
+// storageMigrationRegistry returns the registry container of a deployment as a sidecar of the Job. The
+// authentication, the TLS and the proxy are set with the environment of the deployment, the copy container reaches
+// the registry without them.
+func storageMigrationRegistry(container corev1.Container, name string) corev1.Container {
+	container.Name = name
+	container.RestartPolicy = ptr.To(corev1.ContainerRestartPolicyAlways)
+	container.Ports = nil
+	container.LivenessProbe = nil
+	container.ReadinessProbe = nil
+	container.StartupProbe = nil
+	container.Lifecycle = nil
+
+	env := []corev1.EnvVar{}
+	for _, variable := range container.Env {
+		// the address of the registry behind the access proxy would override the one of the Job configuration
+		if strings.HasPrefix(variable.Name, "REGISTRY_AUTH") ||
+			variable.Name == "REGISTRY_HTTP_ADDR" ||
+			strings.HasPrefix(variable.Name, "REGISTRY_HTTP_TLS") ||
+			strings.HasPrefix(variable.Name, "REGISTRY_PROXY") {
+			continue
+		}
+		env = append(env, variable)
+	}
+	container.Env = env
+	return container
+}
+
+// storageMigrationConfigVolume replaces the registry configuration of the container with the given key of the
+// source ConfigMap
+func storageMigrationConfigVolume(volumes []corev1.Volume, container *corev1.Container, name, key string) []corev1.Volume {
+	configVolume := name + "-config"
+	result := []corev1.Volume{}
+	for _, volume := range volumes {
+		if volume.ConfigMap != nil && (volume.ConfigMap.Name == RegistryConfigMapName || volume.ConfigMap.Name == StorageMigrationSourceName) {
+			for i := range container.VolumeMounts {
+				if container.VolumeMounts[i].Name == volume.Name {
+					container.VolumeMounts[i].Name = configVolume
+				}
+			}
+			continue
+		}
+		result = append(result, volume)
+	}
+
+	return append(result, corev1.Volume{
+		Name: configVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: StorageMigrationSourceName},
+				Items:                []corev1.KeyToPath{{Key: key, Path: registryConfigKey}},
+			},
+		},
+	})
+}
+
+// prefixMountedVolumes returns the volumes the container mounts renamed with the prefix
+func prefixMountedVolumes(volumes []corev1.Volume, container *corev1.Container, prefix string) []corev1.Volume {
+	mounted := map[string]bool{}
+	for i := range container.VolumeMounts {
+		mounted[container.VolumeMounts[i].Name] = true
+		if !strings.HasPrefix(container.VolumeMounts[i].Name, prefix) {
+			container.VolumeMounts[i].Name = prefix + container.VolumeMounts[i].Name
+		}
+	}
+
+	result := []corev1.Volume{}
+	for _, volume := range volumes {
+		if !mounted[volume.Name] {
+			continue
+		}
+		if !strings.HasPrefix(volume.Name, prefix) {
+			volume.Name = prefix + volume.Name
+		}
+		result = append(result, volume)
+	}
+	return result
+}
+
+// storageMigrationConfig returns the registry configuration for the migration. The source registries are read-only,
+// the registries of the Job listen on the given address without the authentication, the proxy and the debug server,
+// which would collide with the one of the other registry of the pod.
+func storageMigrationConfig(config, addr string, readOnly bool) (string, error) {
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(config), &values); err != nil {
+		return "", errors.Wrap(err, "while decoding registry configuration")
+	}
+
+	storage := nestedMap(values, "storage")
+	maintenance := nestedMap(storage, "maintenance")
+	if readOnly {
+		maintenance["readonly"] = map[string]interface{}{"enabled": true}
+	} else {
+		delete(maintenance, "readonly")
+	}
+
+	if addr != "" {
+		delete(values, "auth")
+		delete(values, "proxy")
+		http := nestedMap(values, "http")
+		http["addr"] = addr
+		delete(http, "debug")
+		delete(http, "tls")
+	}
+
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return "", errors.Wrap(err, "while encoding registry configuration")
+	}
+	return string(data), nil
+}
+
+func nestedMap(values map[string]interface{}, key string) map[string]interface{} {
+	if nested, ok := values[key].(map[string]interface{}); ok {
+		return nested
+	}
+	nested := map[string]interface{}{}
+	values[key] = nested
+	return nested
+}
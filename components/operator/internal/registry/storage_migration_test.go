@@ -0,0 +1,315 @@
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+)
+
+func TestStorageLocation(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage *v1alpha1.Storage
+		want    string
+	}{
+		{
+			name: "filesystem without storage",
+			want: "filesystem",
+		},
+		{
+			name:    "s3 endpoint, bucket and root directory",
+			storage: &v1alpha1.Storage{S3: &v1alpha1.StorageS3{Bucket: "images", RegionEndpoint: "https://s3.eu-central-1.amazonaws.com", Rootdirectory: "/registry"}},
+			want:    "s3:s3.eu-central-1.amazonaws.com/images/registry",
+		},
+		{
+			name:    "s3 bucket without endpoint",
+			storage: &v1alpha1.Storage{S3: &v1alpha1.StorageS3{Bucket: "images"}},
+			want:    "s3:images",
+		},
+		{
+			name:    "gcs bucket",
+			storage: &v1alpha1.Storage{GCS: &v1alpha1.StorageGCS{Bucket: "images"}},
+			want:    "gcs:images",
+		},
+		{
+			name:    "azure secret",
+			storage: &v1alpha1.Storage{Azure: &v1alpha1.StorageAzure{SecretName: "azure-storage"}},
+			want:    "azure:secret/azure-storage",
+		},
+		{
+			name: "azure workload identity account and container",
+			storage: &v1alpha1.Storage{Azure: &v1alpha1.StorageAzure{WorkloadIdentity: &v1alpha1.AzureWorkloadIdentity{
+				AccountName: "account", Container: "images",
+			}}},
+			want: "azure:account/images",
+		},
+		{
+			name:    "pvc name",
+			storage: &v1alpha1.Storage{PVC: &v1alpha1.StoragePVC{Name: "images"}},
+			want:    "pvc:images",
+		},
+		{
+			name:    "btp object store secret",
+			storage: &v1alpha1.Storage{BTPObjectStore: &v1alpha1.StorageBTPObjectStore{SecretName: "binding"}},
+			want:    "btpObjectStore:secret/binding",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, StorageLocation(tt.storage))
+		})
+	}
+}
+
+func TestStorageMigrationJobName(t *testing.T) {
+	t.Run("name job after the start time", func(t *testing.T) {
+		name := StorageMigrationJobName(time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC))
+
+		require.Equal(t, "dockerregistry-migration-1717297200", name)
+	})
+}
+
+func TestNewStorageMigrationSource(t *testing.T) {
+	t.Run("copy registry as read-only source", func(t *testing.T) {
+		deployment := fixStorageMigrationDeployment(RegistryConfigMapName)
+		config := fixStorageMigrationConfig()
+		secret := &corev1.Secret{
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{"s3AccessKey": []byte("key")},
+		}
+
+		source, err := NewStorageMigrationSource(deployment, config, secret)
+
+		require.NoError(t, err)
+		require.Equal(t, StorageMigrationSourceName, source.Deployment.GetName())
+		require.Equal(t, "kyma-system", source.Deployment.GetNamespace())
+		require.Equal(t, ptr.To[int32](2), source.Deployment.Spec.Replicas)
+		require.Equal(t, map[string]string{
+			"app":                    "docker-registry",
+			"release":                "dockerregistry",
+			StorageMigrationLabelKey: "source",
+		}, source.Deployment.Spec.Selector.MatchLabels)
+		require.Equal(t, source.Deployment.Spec.Selector.MatchLabels, source.Deployment.Spec.Template.GetLabels())
+
+		podSpec := source.Deployment.Spec.Template.Spec
+		require.Equal(t, StorageMigrationSourceName, podSpec.Volumes[0].ConfigMap.Name)
+		require.Equal(t, []corev1.KeyToPath{{Key: "config.yml", Path: "config.yml"}}, podSpec.Volumes[0].ConfigMap.Items)
+		require.Equal(t, StorageMigrationSourceName, podSpec.Containers[0].Env[1].ValueFrom.SecretKeyRef.Name)
+		// the original deployment is left as it is
+		require.Equal(t, RegistryConfigMapName, deployment.Spec.Template.Spec.Volumes[0].ConfigMap.Name)
+
+		require.Equal(t, StorageMigrationSourceName, source.Secret.GetName())
+		require.Equal(t, secret.Data, source.Secret.Data)
+
+		served := map[string]interface{}{}
+		require.NoError(t, yaml.Unmarshal([]byte(source.ConfigMap.Data["config.yml"]), &served))
+		require.Equal(t, map[string]interface{}{"enabled": true},
+			served["storage"].(map[string]interface{})["maintenance"].(map[string]interface{})["readonly"])
+		require.Equal(t, ":5000", served["http"].(map[string]interface{})["addr"])
+		require.Contains(t, served, "auth")
+
+		job := map[string]interface{}{}
+		require.NoError(t, yaml.Unmarshal([]byte(source.ConfigMap.Data["source.yml"]), &job))
+		require.Equal(t, map[string]interface{}{"addr": StorageMigrationSourceAddress}, job["http"])
+		require.NotContains(t, job, "auth")
+		require.Equal(t, map[string]interface{}{"enabled": true},
+			job["storage"].(map[string]interface{})["maintenance"].(map[string]interface{})["readonly"])
+	})
+
+	t.Run("skip secret of storage without one", func(t *testing.T) {
+		source, err := NewStorageMigrationSource(fixStorageMigrationDeployment(RegistryConfigMapName), fixStorageMigrationConfig(), nil)
+
+		require.NoError(t, err)
+		require.Nil(t, source.Secret)
+	})
+
+	t.Run("return error for invalid configuration", func(t *testing.T) {
+		config := &corev1.ConfigMap{Data: map[string]string{"config.yml": "storage: ["}}
+
+		_, err := NewStorageMigrationSource(fixStorageMigrationDeployment(RegistryConfigMapName), config, nil)
+
+		require.ErrorContains(t, err, "while decoding registry configuration")
+	})
+}
+
+func TestNewStorageMigrationTargetConfig(t *testing.T) {
+	t.Run("accept pushes on the target address", func(t *testing.T) {
+		config := fixStorageMigrationConfig()
+		config.Data["config.yml"] += "    readonly:\n      enabled: true\n"
+
+		data, err := NewStorageMigrationTargetConfig(config)
+
+		require.NoError(t, err)
+		target := map[string]interface{}{}
+		require.NoError(t, yaml.Unmarshal([]byte(data), &target))
+		require.Equal(t, map[string]interface{}{"addr": StorageMigrationTargetAddress}, target["http"])
+		require.NotContains(t, target["storage"].(map[string]interface{})["maintenance"], "readonly")
+		require.NotContains(t, target, "auth")
+	})
+}
+
+func TestNewStorageMigrationJob(t *testing.T) {
+	t.Run("copy images between registry sidecars", func(t *testing.T) {
+		target := fixStorageMigrationDeployment(RegistryConfigMapName)
+		source, err := NewStorageMigrationSource(fixStorageMigrationDeployment(RegistryConfigMapName), fixStorageMigrationConfig(), nil)
+		require.NoError(t, err)
+
+		job, err := NewStorageMigrationJob(target, source.Deployment, "operator:1.0.0", "dockerregistry-migration-1")
+
+		require.NoError(t, err)
+		require.Equal(t, "dockerregistry-migration-1", job.GetName())
+		require.Equal(t, "kyma-system", job.GetNamespace())
+		require.Equal(t, map[string]string{
+			"app":                    "docker-registry",
+			StorageMigrationLabelKey: "dockerregistry-migration-1",
+		}, job.Spec.Template.GetLabels())
+		require.Equal(t, ptr.To[int32](0), job.Spec.BackoffLimit)
+
+		podSpec := job.Spec.Template.Spec
+		require.Equal(t, corev1.RestartPolicyNever, podSpec.RestartPolicy)
+		require.Nil(t, podSpec.Affinity)
+		require.Len(t, podSpec.InitContainers, 2)
+		for _, sidecar := range podSpec.InitContainers {
+			require.Equal(t, ptr.To(corev1.ContainerRestartPolicyAlways), sidecar.RestartPolicy)
+			require.Empty(t, sidecar.Ports)
+			require.Nil(t, sidecar.ReadinessProbe)
+			require.NotContains(t, sidecar.Env, corev1.EnvVar{Name: "REGISTRY_AUTH_HTPASSWD_PATH", Value: "/auth/htpasswd"})
+			require.NotContains(t, sidecar.Env, corev1.EnvVar{Name: "REGISTRY_HTTP_ADDR", Value: "127.0.0.1:5002"})
+		}
+
+		sourceContainer := podSpec.InitContainers[0]
+		require.Equal(t, "source", sourceContainer.Name)
+		require.Equal(t, []corev1.VolumeMount{
+			{Name: "source-config", MountPath: "/etc/distribution"},
+			{Name: "source-data", MountPath: "/var/lib/registry"},
+		}, sourceContainer.VolumeMounts)
+		require.Equal(t, StorageMigrationSourceName, sourceContainer.Env[0].ValueFrom.SecretKeyRef.Name)
+
+		targetContainer := podSpec.InitContainers[1]
+		require.Equal(t, "target", targetContainer.Name)
+		require.Equal(t, []corev1.VolumeMount{
+			{Name: "target-config", MountPath: "/etc/distribution"},
+			{Name: "data", MountPath: "/var/lib/registry"},
+		}, targetContainer.VolumeMounts)
+		require.Equal(t, RegistrySecretName, targetContainer.Env[0].ValueFrom.SecretKeyRef.Name)
+
+		volumes := map[string]corev1.Volume{}
+		for _, volume := range podSpec.Volumes {
+			volumes[volume.Name] = volume
+		}
+		require.Len(t, volumes, 5)
+		require.Equal(t, []corev1.KeyToPath{{Key: "source.yml", Path: "config.yml"}}, volumes["source-config"].ConfigMap.Items)
+		require.Equal(t, []corev1.KeyToPath{{Key: "target.yml", Path: "config.yml"}}, volumes["target-config"].ConfigMap.Items)
+		require.Equal(t, StorageMigrationSourceName, volumes["target-config"].ConfigMap.Name)
+		require.Contains(t, volumes, "source-data")
+		require.Contains(t, volumes, "data")
+		// the volumes of the target are kept, the ones of the source are taken only when its registry mounts them
+		require.Contains(t, volumes, "unused")
+		require.NotContains(t, volumes, "source-unused")
+
+		require.Len(t, podSpec.Containers, 1)
+		container := podSpec.Containers[0]
+		require.Equal(t, StorageMigrationContainerName, container.Name)
+		require.Equal(t, "operator:1.0.0", container.Image)
+		require.Equal(t, []string{"/operator", "migrate-storage", "--source=localhost:5100", "--target=localhost:5200"}, container.Command)
+		require.Equal(t, corev1.TerminationMessageFallbackToLogsOnError, container.TerminationMessagePolicy)
+	})
+
+	t.Run("schedule job next to source on pvc", func(t *testing.T) {
+		target := fixStorageMigrationDeployment(RegistryConfigMapName)
+		sourceDeployment := fixStorageMigrationDeployment(RegistryConfigMapName)
+		sourceDeployment.Spec.Template.Spec.Volumes[1].VolumeSource = corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "dockerregistry"},
+		}
+		source, err := NewStorageMigrationSource(sourceDeployment, fixStorageMigrationConfig(), nil)
+		require.NoError(t, err)
+
+		job, err := NewStorageMigrationJob(target, source.Deployment, "operator:1.0.0", "dockerregistry-migration-1")
+
+		require.NoError(t, err)
+		require.NotNil(t, job.Spec.Template.Spec.Affinity)
+		require.Equal(t, source.Deployment.Spec.Selector,
+			job.Spec.Template.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0].LabelSelector)
+	})
+
+	t.Run("return error for deployment without containers", func(t *testing.T) {
+		target := fixStorageMigrationDeployment(RegistryConfigMapName)
+		target.Spec.Template.Spec.Containers = nil
+
+		job, err := NewStorageMigrationJob(target, fixStorageMigrationDeployment(RegistryConfigMapName), "operator:1.0.0", "dockerregistry-migration-1")
+
+		require.ErrorContains(t, err, "deployment kyma-system/dockerregistry has no containers")
+		require.Nil(t, job)
+	})
+}
+
+func fixStorageMigrationDeployment(configMapName string) *appsv1.Deployment {
+	deployment := fixRegistryDeployment(corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}})
+	deployment.Spec.Replicas = ptr.To[int32](2)
+
+	podSpec := &deployment.Spec.Template.Spec
+	podSpec.Volumes = []corev1.Volume{
+		{
+			Name: "config",
+			VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configMapName},
+			}},
+		},
+		podSpec.Volumes[0],
+		{Name: "unused", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	}
+
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = []corev1.VolumeMount{
+		{Name: "config", MountPath: "/etc/distribution"},
+		{Name: "data", MountPath: "/var/lib/registry"},
+	}
+	container.Env = []corev1.EnvVar{
+		{
+			Name: "REGISTRY_STORAGE_S3_ACCESSKEY",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: RegistrySecretName},
+				Key:                  "s3AccessKey",
+			}},
+		},
+		{
+			Name: "REGISTRY_STORAGE_S3_SECRETKEY",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: RegistrySecretName},
+				Key:                  "s3SecretKey",
+			}},
+		},
+		{Name: "REGISTRY_AUTH_HTPASSWD_PATH", Value: "/auth/htpasswd"},
+		{Name: "REGISTRY_HTTP_ADDR", Value: "127.0.0.1:5002"},
+	}
+	return deployment
+}
+
+func fixStorageMigrationConfig() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		Data: map[string]string{
+			"config.yml": `version: 0.1
+auth:
+  htpasswd:
+    realm: Registry Realm
+    path: /auth/htpasswd
+http:
+  addr: :5000
+  debug:
+    addr: :5001
+storage:
+  s3:
+    bucket: images
+  maintenance:
+    uploadpurging:
+      enabled: false
+`,
+		},
+	}
+}
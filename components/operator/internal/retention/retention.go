@@ -0,0 +1,184 @@
+package retention
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/pkg/errors"
+)
+
+// Registry is the part of the registry API the retention policies are applied through
+type Registry interface {
+	Repositories(ctx context.Context) ([]string, error)
+	Tags(ctx context.Context, repository string) ([]string, error)
+	TagCreated(ctx context.Context, repository, tag string) (time.Time, error)
+	DeleteTag(ctx context.Context, repository, tag string) error
+}
+
+// Result is the number of tags a policy deleted
+type Result struct {
+	Policy      string
+	DeletedTags int64
+}
+
+type taggedImage struct {
+	tag     string
+	created time.Time
+}
+
+// Apply deletes the tags that are not kept by the policies. A policy only deletes tags that are neither protected,
+// nor among the KeepLast newest tags of the repository, nor newer than MaxAge. Tags of images without a creation
+// time are never deleted, their age is unknown. The results of the policies applied before an error are returned.
+func Apply(ctx context.Context, registry Registry, policies []v1alpha1.RetentionPolicy, now time.Time) ([]Result, error) {
+	repositories, err := registry.Repositories(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Result{}
+	for _, policy := range policies {
+		deleted, err := applyPolicy(ctx, registry, policy, repositories, now)
+		results = append(results, Result{Policy: policy.Name, DeletedTags: deleted})
+		if err != nil {
+			return results, errors.Wrapf(err, "while applying retention policy %s", policy.Name)
+		}
+	}
+	return results, nil
+}
+
+func applyPolicy(ctx context.Context, registry Registry, policy v1alpha1.RetentionPolicy, repositories []string, now time.Time) (int64, error) {
+	protected, err := compileProtectedTags(policy.ProtectedTags)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := int64(0)
+	for _, repository := range repositories {
+		selected, err := repositorySelected(policy.Repositories, repository)
+		if err != nil {
+			return deleted, err
+		}
+		if !selected {
+			continue
+		}
+
+		images, err := listImages(ctx, registry, repository, protected)
+		if err != nil {
+			return deleted, err
+		}
+
+		for _, image := range expiredImages(policy, images, now) {
+			if err := registry.DeleteTag(ctx, repository, image.tag); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// listImages returns the tags that can be deleted, from the newest to the oldest
+func listImages(ctx context.Context, registry Registry, repository string, protected []*regexp.Regexp) ([]taggedImage, error) {
+	tags, err := registry.Tags(ctx, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	images := []taggedImage{}
+	for _, tag := range tags {
+		if tagProtected(protected, tag) {
+			continue
+		}
+
+		created, err := registry.TagCreated(ctx, repository, tag)
+		if err != nil {
+			return nil, err
+		}
+		if created.IsZero() {
+			continue
+		}
+		images = append(images, taggedImage{tag: tag, created: created})
+	}
+
+	sort.SliceStable(images, func(i, j int) bool {
+		return images[i].created.After(images[j].created)
+	})
+	return images, nil
+}
+
+func expiredImages(policy v1alpha1.RetentionPolicy, images []taggedImage, now time.Time) []taggedImage {
+	if policy.KeepLast == nil && policy.MaxAge == nil {
+		// a policy without rules keeps everything
+		return nil
+	}
+
+	expired := []taggedImage{}
+	for i, image := range images {
+		if policy.KeepLast != nil && i < int(*policy.KeepLast) {
+			continue
+		}
+		if policy.MaxAge != nil && now.Sub(image.created) < policy.MaxAge.Duration {
+			continue
+		}
+		expired = append(expired, image)
+	}
+	return expired
+}
+
+// ValidateRepositories makes sure the repository patterns are valid globs
+func ValidateRepositories(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "repository pattern '%s' is not a valid glob", pattern)
+		}
+	}
+	return nil
+}
+
+func repositorySelected(patterns []string, repository string) (bool, error) {
+	if len(patterns) == 0 {
+		return true, nil
+	}
+
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, repository)
+		if err != nil {
+			return false, errors.Wrapf(err, "repository pattern '%s' is not a valid glob", pattern)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func compileProtectedTags(expressions []string) ([]*regexp.Regexp, error) {
+	protected := make([]*regexp.Regexp, 0, len(expressions))
+	for _, expression := range expressions {
+		re, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, errors.Wrapf(err, "protected tag '%s' is not a valid regular expression", expression)
+		}
+		protected = append(protected, re)
+	}
+	return protected, nil
+}
+
+// ValidateProtectedTags makes sure the protected tags are valid regular expressions
+func ValidateProtectedTags(expressions []string) error {
+	_, err := compileProtectedTags(expressions)
+	return err
+}
+
+func tagProtected(protected []*regexp.Regexp, tag string) bool {
+	for _, re := range protected {
+		if re.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+var testNow = time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+
+func TestApply(t *testing.T) {
+	t.Run("keep last tags of selected repositories", func(t *testing.T) {
+		registry := fixFakeRegistry()
+
+		results, err := Apply(context.Background(), registry, []v1alpha1.RetentionPolicy{
+			{Name: "ci", Repositories: []string{"ci/*"}, KeepLast: ptr.To[int32](1)},
+		}, testNow)
+
+		require.NoError(t, err)
+		require.Equal(t, []Result{{Policy: "ci", DeletedTags: 3}}, results)
+		require.Equal(t, []string{"ci/app:commit-2", "ci/app:v1.0.0", "ci/app:commit-1"}, registry.deleted)
+	})
+
+	t.Run("delete tags older than max age", func(t *testing.T) {
+		registry := fixFakeRegistry()
+
+		results, err := Apply(context.Background(), registry, []v1alpha1.RetentionPolicy{
+			{Name: "old", MaxAge: &metav1.Duration{Duration: 36 * time.Hour}},
+		}, testNow)
+
+		require.NoError(t, err)
+		require.Equal(t, []Result{{Policy: "old", DeletedTags: 3}}, results)
+		require.Equal(t, []string{"ci/app:v1.0.0", "ci/app:commit-1", "base:old"}, registry.deleted)
+	})
+
+	t.Run("delete only tags matching both rules", func(t *testing.T) {
+		registry := fixFakeRegistry()
+
+		results, err := Apply(context.Background(), registry, []v1alpha1.RetentionPolicy{
+			{
+				Name:     "ci",
+				KeepLast: ptr.To[int32](1),
+				MaxAge:   &metav1.Duration{Duration: 36 * time.Hour},
+			},
+		}, testNow)
+
+		require.NoError(t, err)
+		require.Equal(t, []Result{{Policy: "ci", DeletedTags: 2}}, results)
+		require.Equal(t, []string{"ci/app:v1.0.0", "ci/app:commit-1"}, registry.deleted)
+	})
+
+	t.Run("keep protected tags and tags of unknown age", func(t *testing.T) {
+		registry := fixFakeRegistry()
+
+		results, err := Apply(context.Background(), registry, []v1alpha1.RetentionPolicy{
+			{Name: "all", KeepLast: ptr.To[int32](0), ProtectedTags: []string{`^v\d+\.\d+\.\d+$`}},
+		}, testNow)
+
+		require.NoError(t, err)
+		require.Equal(t, []Result{{Policy: "all", DeletedTags: 4}}, results)
+		require.Equal(t, []string{"ci/app:commit-3", "ci/app:commit-2", "ci/app:commit-1", "base:old"}, registry.deleted)
+	})
+
+	t.Run("report deletions of applied policies on error", func(t *testing.T) {
+		registry := fixFakeRegistry()
+		registry.deleteErr = map[string]error{"base:old": errors.New("registry is read-only")}
+
+		results, err := Apply(context.Background(), registry, []v1alpha1.RetentionPolicy{
+			{Name: "ci", Repositories: []string{"ci/*"}, KeepLast: ptr.To[int32](2)},
+			{Name: "base", Repositories: []string{"base"}, KeepLast: ptr.To[int32](0)},
+		}, testNow)
+
+		require.ErrorContains(t, err, "while applying retention policy base: registry is read-only")
+		require.Equal(t, []Result{{Policy: "ci", DeletedTags: 2}, {Policy: "base", DeletedTags: 0}}, results)
+	})
+
+	t.Run("return error for invalid protected tag", func(t *testing.T) {
+		registry := fixFakeRegistry()
+
+		_, err := Apply(context.Background(), registry, []v1alpha1.RetentionPolicy{
+			{Name: "ci", KeepLast: ptr.To[int32](1), ProtectedTags: []string{"v("}},
+		}, testNow)
+
+		require.ErrorContains(t, err, "protected tag 'v(' is not a valid regular expression")
+		require.Empty(t, registry.deleted)
+	})
+}
+
+func TestValidateRepositories(t *testing.T) {
+	t.Run("accept globs", func(t *testing.T) {
+		require.NoError(t, ValidateRepositories([]string{"ci/*", "base", "team-?/app"}))
+	})
+
+	t.Run("reject malformed glob", func(t *testing.T) {
+		require.ErrorContains(t, ValidateRepositories([]string{"ci/["}), "repository pattern 'ci/[' is not a valid glob")
+	})
+}
+
+type fakeRegistry struct {
+	tags      map[string][]string
+	created   map[string]time.Time
+	deleteErr map[string]error
+	deleted   []string
+}
+
+func fixFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		tags: map[string][]string{
+			"ci/app": {"commit-1", "commit-2", "commit-3", "v1.0.0"},
+			"base":   {"latest", "old"},
+		},
+		created: map[string]time.Time{
+			"ci/app:commit-1": testNow.Add(-72 * time.Hour),
+			"ci/app:commit-2": testNow.Add(-24 * time.Hour),
+			"ci/app:commit-3": testNow.Add(-time.Hour),
+			"ci/app:v1.0.0":   testNow.Add(-48 * time.Hour).Add(time.Minute),
+			"base:old":        testNow.Add(-96 * time.Hour),
+			// base:latest has no creation time
+		},
+	}
+}
+
+func (r *fakeRegistry) Repositories(_ context.Context) ([]string, error) {
+	return []string{"ci/app", "base"}, nil
+}
+
+func (r *fakeRegistry) Tags(_ context.Context, repository string) ([]string, error) {
+	return r.tags[repository], nil
+}
+
+func (r *fakeRegistry) TagCreated(_ context.Context, repository, tag string) (time.Time, error) {
+	return r.created[repository+":"+tag], nil
+}
+
+func (r *fakeRegistry) DeleteTag(_ context.Context, repository, tag string) error {
+	if err := r.deleteErr[repository+":"+tag]; err != nil {
+		return err
+	}
+	r.deleted = append(r.deleted, repository+":"+tag)
+	return nil
+}
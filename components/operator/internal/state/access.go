@@ -6,6 +6,7 @@ import (
 
 	"github.com/kyma-project/docker-registry/components/operator/internal/flags"
 	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/validation"
 	"github.com/pkg/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
@@ -16,7 +17,7 @@ func sFnAccessConfiguration(ctx context.Context, r *reconciler, s *systemState)
 		s.warningBuilder.With("failed to set access configuration: " + err.Error())
 	}
 
-	return nextState(sFnLoggingConfiguration)
+	return nextState(sFnAuthConfiguration)
 }
 
 func setAccessConfig(ctx context.Context, r *reconciler, s *systemState) error {
@@ -24,30 +25,50 @@ func setAccessConfig(ctx context.Context, r *reconciler, s *systemState) error {
 		return err
 	}
 
+	if err := setPullCredentialsConfig(ctx, r, s); err != nil {
+		return err
+	}
+
+	if err := setNamespaceCredentialsConfig(ctx, r, s); err != nil {
+		return err
+	}
+
+	if err := setUsersConfig(ctx, r, s); err != nil {
+		return err
+	}
+
 	return setExternalAccessConfig(ctx, r, s)
 }
 
 func setInternalAccessConfig(ctx context.Context, r *reconciler, s *systemState) error {
-	existingIntRegSecret, err := registry.GetDockerRegistryInternalRegistrySecret(ctx, r.client, s.instance.Namespace)
+	names := s.resourceNames()
+	existingIntRegSecret, err := registry.GetDockerRegistryInternalRegistrySecret(ctx, r.client, names.InternalAccessSecretName, s.instance.Namespace)
 	if err != nil {
 		return errors.Wrap(err, "while fetching existing internal docker registry secret")
 	}
 	if existingIntRegSecret != nil {
 		r.log.Debugf("reusing existing credentials for internal docker registry to avoiding docker registry  rollout")
-		registryHttpSecretEnvValue, getErr := registry.GetRegistryHTTPSecretEnvValue(ctx, r.client, s.instance.Namespace)
-		if getErr != nil {
-			return errors.Wrap(getErr, "while reading env value registryHttpSecret from internal docker registry deployment")
-		}
 		s.flagsBuilder.
 			WithRegistryCredentials(
 				string(existingIntRegSecret.Data["username"]),
 				string(existingIntRegSecret.Data["password"]),
-			).
-			WithRegistryHttpSecret(
-				registryHttpSecretEnvValue,
 			)
 	}
 
+	if err := setCredentialsConfig(ctx, r, s, existingIntRegSecret); err != nil {
+		return err
+	}
+
+	// the http secret is reused on its own, old and new replicas must share it during a rolling update,
+	// otherwise uploads started on one replica fail on the other
+	registryHttpSecretEnvValue, err := registry.GetRegistryHTTPSecretEnvValue(ctx, r.client, s.instance.Namespace)
+	if err != nil {
+		return errors.Wrap(err, "while reading env value registryHttpSecret from internal docker registry deployment")
+	}
+	if registryHttpSecretEnvValue != "" {
+		s.flagsBuilder.WithRegistryHttpSecret(registryHttpSecretEnvValue)
+	}
+
 	nodePort, err := s.nodePortResolver.GetNodePort(ctx, r.client, s.instance.Namespace)
 	if err != nil {
 		return errors.Wrap(err, "while resolving registry node port")
@@ -55,7 +76,9 @@ func setInternalAccessConfig(ctx context.Context, r *reconciler, s *systemState)
 	r.log.Debugf("docker registry node port: %d", nodePort)
 	s.flagsBuilder.WithNodePort(int64(nodePort)).
 		WithServicePort(registry.ServicePort).
-		WithFullname(flags.FullnameOverride)
+		WithFullname(flags.FullnameOverride).
+		WithAccessSecretNames(names.InternalAccessSecretName, names.ExternalAccessSecretName).
+		WithPriorityClassName(names.PriorityClassName)
 	return nil
 }
 
@@ -65,6 +88,16 @@ func setExternalAccessConfig(ctx context.Context, r *reconciler, s *systemState)
 
 	if !externalConfigured || !*spec.ExternalAccess.Enabled {
 		// skip if its disabled
+		s.instance.Status.ExternalCredentials = nil
+		return nil
+	}
+
+	// the external access Secret would hold no credentials the clients outside the cluster could use, so the
+	// registry is not exposed at all
+	if err := validation.ExternalAccessOIDC(spec); err != nil {
+		msg := fmt.Sprintf(".spec.externalAccess.oidc is set but got error: %s", err.Error())
+		s.warningBuilder.With(msg)
+		r.log.Warnf(msg)
 		return nil
 	}
 
@@ -82,5 +115,10 @@ func setExternalAccessConfig(ctx context.Context, r *reconciler, s *systemState)
 		resolvedAccess.Gateway,
 	)
 
-	return nil
+	if spec.ExternalAccess.OIDC != nil {
+		s.flagsBuilder.WithVirtualServiceOIDC()
+		s.instance.Status.ExternalCredentials = nil
+		return nil
+	}
+	return setExternalCredentialsConfig(ctx, r, s)
 }
@@ -17,6 +17,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
@@ -25,17 +26,26 @@ import (
 func Test_sFnAccessConfiguration(t *testing.T) {
 	t.Run("setup node port only when registry secret does not exist", func(t *testing.T) {
 		s := &systemState{
-			instance:         v1alpha1.DockerRegistry{},
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: registry.BaseNamespace,
+				},
+			},
 			statusSnapshot:   v1alpha1.DockerRegistryStatus{},
 			flagsBuilder:     flags.NewBuilder(),
 			nodePortResolver: registry.NewNodePortResolver(registry.RandomNodePort),
 		}
 		r := &reconciler{
-			k8s: k8s{client: fake.NewClientBuilder().Build()},
+			k8s: k8s{client: fake.NewClientBuilder().WithObjects(fixOperatorPod()).Build()},
 			log: zap.NewNop().Sugar(),
 		}
+		r.managerPod = types.NamespacedName{Name: "operator", Namespace: "kyma-system"}
 		expectedFlags := map[string]interface{}{
 			"FullnameOverride": "dockerregistry",
+			"accessProxy": map[string]interface{}{
+				"enabled": true,
+				"image":   "dockerregistry-operator:1.0.0",
+			},
 			"configData": map[string]interface{}{
 				"http": map[string]interface{}{
 					"addr": ":5000",
@@ -45,23 +55,30 @@ func Test_sFnAccessConfiguration(t *testing.T) {
 			"service": map[string]interface{}{
 				"port": int64(5_000),
 			},
+			"internalAccessSecretName":        "dockerregistry-config",
+			"externalAccessSecretName":        "dockerregistry-config-external",
+			"dockerregistryPriorityClassName": "dockerregistry-priority",
 		}
 
 		next, result, err := sFnAccessConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnLoggingConfiguration, next)
+		requireEqualFunc(t, sFnAuthConfiguration, next)
 
 		flags, err := s.flagsBuilder.Build()
 		require.NoError(t, err)
 
+		// the pull-only credentials are generated
+		require.Contains(t, flags, "pullCredentials")
+		delete(flags, "pullCredentials")
+		delete(flags, "rollme")
 		require.EqualValues(t, expectedFlags, flags)
 	})
 
 	t.Run("setup node port and use existing username and password", func(t *testing.T) {
 		registrySecret := &corev1.Secret{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      registry.InternalAccessSecretName,
+				Name:      "dockerregistry-config-kyma",
 				Namespace: "kyma",
 				Labels: map[string]string{
 					registry.LabelConfigKey: registry.LabelConfigVal,
@@ -107,11 +124,16 @@ func Test_sFnAccessConfiguration(t *testing.T) {
 			nodePortResolver: registry.NewNodePortResolver(registry.RandomNodePort),
 		}
 		r := &reconciler{
-			k8s: k8s{client: fake.NewClientBuilder().WithObjects(registrySecret, registryDeploy).Build()},
+			k8s: k8s{client: fake.NewClientBuilder().WithObjects(registrySecret, registryDeploy, fixOperatorPod()).Build()},
 			log: zap.NewNop().Sugar(),
 		}
+		r.managerPod = types.NamespacedName{Name: "operator", Namespace: "kyma-system"}
 		expectedFlags := map[string]interface{}{
 			"FullnameOverride": "dockerregistry",
+			"accessProxy": map[string]interface{}{
+				"enabled": true,
+				"image":   "dockerregistry-operator:1.0.0",
+			},
 			"configData": map[string]interface{}{
 				"http": map[string]interface{}{
 					"addr": ":5000",
@@ -125,20 +147,68 @@ func Test_sFnAccessConfiguration(t *testing.T) {
 				"username": "ala",
 				"password": "makota",
 			},
-			"registryHTTPSecret": "httpEnvKeyVal",
+			"registryHTTPSecret":              "httpEnvKeyVal",
+			"internalAccessSecretName":        "dockerregistry-config-kyma",
+			"externalAccessSecretName":        "dockerregistry-config-external-kyma",
+			"dockerregistryPriorityClassName": "dockerregistry-priority-kyma",
 		}
 
 		next, result, err := sFnAccessConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnLoggingConfiguration, next)
+		requireEqualFunc(t, sFnAuthConfiguration, next)
 
 		flags, err := s.flagsBuilder.Build()
 		require.NoError(t, err)
 
+		// the pull-only credentials are generated
+		require.Contains(t, flags, "pullCredentials")
+		delete(flags, "pullCredentials")
+		delete(flags, "rollme")
 		require.EqualValues(t, expectedFlags, flags)
 	})
 
+	t.Run("do not reuse credentials propagated from the base namespace", func(t *testing.T) {
+		propagatedSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      registry.InternalAccessSecretName,
+				Namespace: "tenant",
+				Labels: map[string]string{
+					registry.LabelConfigKey: registry.LabelConfigVal,
+				},
+			},
+			Data: map[string][]byte{
+				"username": []byte("ala"),
+				"password": []byte("makota"),
+			},
+		}
+
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "tenant",
+				},
+			},
+			statusSnapshot:   v1alpha1.DockerRegistryStatus{},
+			flagsBuilder:     flags.NewBuilder(),
+			nodePortResolver: registry.NewNodePortResolver(registry.RandomNodePort),
+		}
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().WithObjects(propagatedSecret, fixOperatorPod()).Build()},
+			log: zap.NewNop().Sugar(),
+		}
+		r.managerPod = types.NamespacedName{Name: "operator", Namespace: "kyma-system"}
+
+		_, _, err := sFnAccessConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+
+		require.NotContains(t, flags, "dockerRegistry")
+		require.Equal(t, "dockerregistry-config-tenant", flags["internalAccessSecretName"])
+	})
+
 	t.Run("setup external access", func(t *testing.T) {
 		testScheme := runtime.NewScheme()
 		require.NoError(t, istiov1beta1.AddToScheme(testScheme))
@@ -176,11 +246,16 @@ func Test_sFnAccessConfiguration(t *testing.T) {
 			gatewayHostResolver: registry.NewExternalAccessResolver("registry-test-name-test-namespace"),
 		}
 		r := &reconciler{
-			k8s: k8s{client: fake.NewClientBuilder().WithScheme(testScheme).WithObjects(testGateway).Build()},
+			k8s: k8s{client: fake.NewClientBuilder().WithScheme(testScheme).WithObjects(testGateway, fixOperatorPod()).Build()},
 			log: zap.NewNop().Sugar(),
 		}
+		r.managerPod = types.NamespacedName{Name: "operator", Namespace: "kyma-system"}
 		expectedFlags := map[string]interface{}{
 			"FullnameOverride": "dockerregistry",
+			"accessProxy": map[string]interface{}{
+				"enabled": true,
+				"image":   "dockerregistry-operator:1.0.0",
+			},
 			"configData": map[string]interface{}{
 				"http": map[string]interface{}{
 					"addr": ":5000",
@@ -195,19 +270,87 @@ func Test_sFnAccessConfiguration(t *testing.T) {
 				"gateway": "kyma-system/kyma-gateway",
 				"host":    "registry-test-name-test-namespace.cluster.local",
 			},
+			"internalAccessSecretName":        "dockerregistry-config-test-namespace",
+			"externalAccessSecretName":        "dockerregistry-config-external-test-namespace",
+			"dockerregistryPriorityClassName": "dockerregistry-priority-test-namespace",
 		}
 
 		next, result, err := sFnAccessConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnLoggingConfiguration, next)
+		requireEqualFunc(t, sFnAuthConfiguration, next)
 
 		flags, err := s.flagsBuilder.Build()
 		require.NoError(t, err)
 
+		// the external access gets its own generated credentials
+		externalCredentials := flags["externalCredentials"].(map[string]interface{})
+		require.Len(t, externalCredentials["username"], 20)
+		require.Len(t, externalCredentials["password"], 40)
+		delete(flags, "externalCredentials")
+		delete(flags, "pullCredentials")
+		delete(flags, "rollme")
 		require.EqualValues(t, expectedFlags, flags)
 	})
 
+	t.Run("setup external access with oidc without external credentials", func(t *testing.T) {
+		testScheme := runtime.NewScheme()
+		require.NoError(t, istiov1beta1.AddToScheme(testScheme))
+		require.NoError(t, clientgoscheme.AddToScheme(testScheme))
+
+		testGateway := &istiov1beta1.Gateway{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "kyma-gateway",
+				Namespace: "kyma-system",
+			},
+			Spec: networkingv1beta1.Gateway{
+				Servers: []*networkingv1beta1.Server{
+					{
+						Hosts: []string{"*.cluster.local"},
+					},
+				},
+			},
+		}
+
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test-name",
+					Namespace: "test-namespace",
+				},
+				Spec: v1alpha1.DockerRegistrySpec{
+					ExternalAccess: fixOIDCExternalAccess(),
+					Auth:           &v1alpha1.Auth{Token: &v1alpha1.TokenAuth{}},
+				},
+			},
+			statusSnapshot:      v1alpha1.DockerRegistryStatus{},
+			flagsBuilder:        flags.NewBuilder(),
+			nodePortResolver:    registry.NewNodePortResolver(registry.RandomNodePort),
+			gatewayHostResolver: registry.NewExternalAccessResolver("registry-test-name-test-namespace"),
+			warningBuilder:      warning.NewBuilder(),
+		}
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().WithScheme(testScheme).WithObjects(testGateway).Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		_, _, err := sFnAccessConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Empty(t, s.warningBuilder.Build())
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.NotContains(t, flags, "externalCredentials")
+		require.Equal(t, map[string]interface{}{
+			"enabled": true,
+			"gateway": "kyma-system/kyma-gateway",
+			"host":    "registry-test-name-test-namespace.cluster.local",
+			"oidc": map[string]interface{}{
+				"enabled": true,
+			},
+		}, flags["virtualService"])
+	})
+
 	t.Run("external access gateway not found error", func(t *testing.T) {
 		testScheme := runtime.NewScheme()
 		require.NoError(t, istiov1beta1.AddToScheme(testScheme))
@@ -215,6 +358,9 @@ func Test_sFnAccessConfiguration(t *testing.T) {
 
 		s := &systemState{
 			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: registry.BaseNamespace,
+				},
 				Spec: v1alpha1.DockerRegistrySpec{
 					ExternalAccess: &v1alpha1.ExternalAccess{
 						Enabled: ptr.To(true),
@@ -229,11 +375,16 @@ func Test_sFnAccessConfiguration(t *testing.T) {
 		}
 
 		r := &reconciler{
-			k8s: k8s{client: fake.NewClientBuilder().WithScheme(testScheme).Build()},
+			k8s: k8s{client: fake.NewClientBuilder().WithScheme(testScheme).WithObjects(fixOperatorPod()).Build()},
 			log: zap.NewNop().Sugar(),
 		}
+		r.managerPod = types.NamespacedName{Name: "operator", Namespace: "kyma-system"}
 		expectedFlags := map[string]interface{}{
 			"FullnameOverride": "dockerregistry",
+			"accessProxy": map[string]interface{}{
+				"enabled": true,
+				"image":   "dockerregistry-operator:1.0.0",
+			},
 			"configData": map[string]interface{}{
 				"http": map[string]interface{}{
 					"addr": ":5000",
@@ -243,21 +394,69 @@ func Test_sFnAccessConfiguration(t *testing.T) {
 			"service": map[string]interface{}{
 				"port": int64(5_000),
 			},
+			"internalAccessSecretName":        "dockerregistry-config",
+			"externalAccessSecretName":        "dockerregistry-config-external",
+			"dockerregistryPriorityClassName": "dockerregistry-priority",
 		}
 
 		next, result, err := sFnAccessConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnLoggingConfiguration, next)
+		requireEqualFunc(t, sFnAuthConfiguration, next)
 
 		flags, err := s.flagsBuilder.Build()
 		require.NoError(t, err)
 
+		delete(flags, "pullCredentials")
+		delete(flags, "rollme")
 		require.EqualValues(t, expectedFlags, flags)
 
 		require.Equal(t, "Warning: .spec.externalAccess.enabled is true but got error: while getting Gateway kyma-gateway in namespace kyma-system: gatewaies.networking.istio.io \"kyma-gateway\" not found", s.warningBuilder.Build())
 	})
 
+	t.Run("skip external access with oidc without token authentication", func(t *testing.T) {
+		testScheme := runtime.NewScheme()
+		require.NoError(t, istiov1beta1.AddToScheme(testScheme))
+		require.NoError(t, clientgoscheme.AddToScheme(testScheme))
+
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: registry.BaseNamespace,
+				},
+				Spec: v1alpha1.DockerRegistrySpec{
+					ExternalAccess: &v1alpha1.ExternalAccess{
+						Enabled: ptr.To(true),
+						OIDC: &v1alpha1.ExternalAccessOIDC{
+							IssuerURL: "https://idp.example.com",
+							ClientID:  "docker-registry",
+						},
+					},
+				},
+			},
+			statusSnapshot:      v1alpha1.DockerRegistryStatus{},
+			flagsBuilder:        flags.NewBuilder(),
+			nodePortResolver:    registry.NewNodePortResolver(registry.RandomNodePort),
+			gatewayHostResolver: registry.NewExternalAccessResolver(""),
+			warningBuilder:      warning.NewBuilder(),
+		}
+
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().WithScheme(testScheme).WithObjects(fixOperatorPod()).Build()},
+			log: zap.NewNop().Sugar(),
+		}
+		r.managerPod = types.NamespacedName{Name: "operator", Namespace: "kyma-system"}
+
+		_, _, err := sFnAccessConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.NotContains(t, flags, "virtualService")
+		require.Equal(t, "Warning: .spec.externalAccess.oidc is set but got error: "+
+			"oidc login requires spec.auth.token, the token server is what logs the clients in", s.warningBuilder.Build())
+	})
+
 	t.Run("report the failure of the access configuration", func(t *testing.T) {
 		// an empty scheme makes reading the internal registry Secret fail for a reason other than
 		// NotFound, which is what the access configuration reports as a failure
@@ -276,12 +475,12 @@ func Test_sFnAccessConfiguration(t *testing.T) {
 		next, result, err := sFnAccessConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnLoggingConfiguration, next)
+		requireEqualFunc(t, sFnAuthConfiguration, next)
 
 		require.Contains(t, s.warningBuilder.Build(), "while fetching existing internal docker registry secret")
 
 		// the failure has to survive the state that reports the configuration status, which is reached
-		// through sFnLoggingConfiguration and sFnStorageConfiguration
+		// through sFnAuthConfiguration, sFnLoggingConfiguration and sFnStorageConfiguration
 		_, _, err = sFnUpdateConfigurationStatus(context.Background(), r, s)
 		require.NoError(t, err)
 
@@ -313,16 +512,17 @@ func Test_sFnAccessConfiguration(t *testing.T) {
 			warningBuilder:      warning.NewBuilder(),
 		}
 		r := &reconciler{
-			k8s: k8s{client: fake.NewClientBuilder().WithScheme(testScheme).Build()},
+			k8s: k8s{client: fake.NewClientBuilder().WithScheme(testScheme).WithObjects(fixOperatorPod()).Build()},
 			log: zap.NewNop().Sugar(),
 		}
+		r.managerPod = types.NamespacedName{Name: "operator", Namespace: "kyma-system"}
 
 		// external access is optional, so the access configuration itself succeeds and only records a
 		// warning, yet the registry is not configured the way the CR asks for
 		next, result, err := sFnAccessConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnLoggingConfiguration, next)
+		requireEqualFunc(t, sFnAuthConfiguration, next)
 
 		_, _, err = sFnUpdateConfigurationStatus(context.Background(), r, s)
 		require.NoError(t, err)
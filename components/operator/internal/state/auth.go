@@ -0,0 +1,148 @@
+package state
+
+import (
+	"context"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/token"
+	"github.com/kyma-project/docker-registry/components/operator/internal/validation"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	HtpasswdAuthName = "htpasswd"
+	TokenAuthName    = "token"
+
+	// tokenAuthRetryInterval is how long a token authentication that could not be configured is postponed
+	tokenAuthRetryInterval = time.Minute
+)
+
+// sFnAuthConfiguration makes the registry accept the tokens signed by the operator instead of the htpasswd
+// credentials. The registry keeps the htpasswd authentication when the token authentication can't be configured.
+func sFnAuthConfiguration(ctx context.Context, r *reconciler, s *systemState) (stateFn, *ctrl.Result, error) {
+	if s.instance.Spec.Auth != nil && s.instance.Spec.Auth.Token != nil {
+		if err := prepareTokenAuth(ctx, r, s); err != nil {
+			s.warningBuilder.With("failed to set token authentication: " + err.Error())
+			s.setRetryAfter(tokenAuthRetryInterval)
+			if validation.ExternalAccessEnabled(s.instance.Spec.ExternalAccess) {
+				// the registry falls back to the htpasswd authentication, which can't keep the registry
+				// credentials inside the cluster
+				s.flagsBuilder.WithoutVirtualService()
+			}
+		}
+	} else {
+		warnUnenforcedAccessPolicies(ctx, r, s)
+	}
+
+	return nextState(sFnLoggingConfiguration)
+}
+
+// warnUnenforcedAccessPolicies reports the access policies the registry ignores, only the token server enforces them
+func warnUnenforcedAccessPolicies(ctx context.Context, r *reconciler, s *systemState) {
+	policies := v1alpha1.RegistryAccessPolicyList{}
+	if err := r.client.List(ctx, &policies, client.InNamespace(s.instance.GetNamespace())); err != nil {
+		r.log.Warnf("failed to list registry access policies: %s", err)
+		return
+	}
+
+	if len(policies.Items) > 0 {
+		s.warningBuilder.With("registry access policies are not enforced without the token authentication")
+	}
+}
+
+func prepareTokenAuth(ctx context.Context, r *reconciler, s *systemState) error {
+	spec := s.instance.Spec.Auth.Token
+	if err := validation.TokenTTL(spec); err != nil {
+		return err
+	}
+
+	realm, err := registry.GetTokenRealm(ctx, r.client, r.tokenServer)
+	if err != nil {
+		return err
+	}
+
+	secret, err := ensureTokenKeys(ctx, r, s, time.Now())
+	if err != nil {
+		return err
+	}
+
+	s.flagsBuilder.WithTokenAuth(
+		realm,
+		registry.TokenService(s.instance.GetNamespace()),
+		token.Issuer,
+		secret.GetName(),
+		secret.Data[token.BundleKey],
+	)
+
+	if validation.ExternalAccessEnabled(s.instance.Spec.ExternalAccess) {
+		host, port, err := registry.GetTokenServerDestination(ctx, r.client, r.tokenServer)
+		if err != nil {
+			return err
+		}
+		s.flagsBuilder.WithVirtualServiceTokenServer(host, port)
+	}
+	return nil
+}
+
+// ensureTokenKeys creates the Secret with the token signing keys and rotates them when the rotation interval passed
+func ensureTokenKeys(ctx context.Context, r *reconciler, s *systemState, now time.Time) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(ctx, client.ObjectKey{
+		Name:      s.resourceNames().TokenKeySecretName,
+		Namespace: s.instance.GetNamespace(),
+	}, secret)
+	if client.IgnoreNotFound(err) != nil {
+		return nil, errors.Wrap(err, "while fetching token signing keys")
+	}
+	notFound := apierrors.IsNotFound(err)
+
+	interval := s.instance.Spec.Auth.Token.GetKeyRotationInterval()
+	rotationTime := token.RotationTime(secret)
+	if next := rotationTime.Add(interval); !notFound && now.Before(next) {
+		s.setRetryAfter(next.Sub(now))
+		return secret, nil
+	}
+
+	data, err := token.RotateKeys(secret.Data, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "while generating token signing key")
+	}
+
+	if notFound {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.resourceNames().TokenKeySecretName,
+				Namespace: s.instance.GetNamespace(),
+				// the keys are useless without the registry, they are removed together with the CR
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(&s.instance, v1alpha1.GroupVersion.WithKind("DockerRegistry")),
+				},
+			},
+			Type: corev1.SecretTypeOpaque,
+		}
+	}
+	secret.Data = data
+	metav1.SetMetaDataAnnotation(&secret.ObjectMeta, token.RotationTimeAnnotation, now.UTC().Format(time.RFC3339))
+
+	if notFound {
+		err = r.client.Create(ctx, secret)
+	} else {
+		err = r.client.Update(ctx, secret)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "while storing token signing keys")
+	}
+
+	if !rotationTime.IsZero() {
+		r.Event(&s.instance, "Normal", "TokenKeyRotated", "token signing key rotated, the previous key is trusted until the next rotation")
+	}
+	s.setRetryAfter(interval)
+	return secret, nil
+}
@@ -0,0 +1,271 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/flags"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/token"
+	"github.com/kyma-project/docker-registry/components/operator/internal/warning"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var testTokenServer = types.NamespacedName{Name: "dockerregistry-token-server", Namespace: "docker-registry"}
+
+func Test_sFnAuthConfiguration(t *testing.T) {
+	t.Run("skip when token authentication is not configured", func(t *testing.T) {
+		s := fixAuthSystemState(nil)
+		r := fixAuthReconciler()
+
+		next, result, err := sFnAuthConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnLoggingConfiguration, next)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+	})
+
+	t.Run("warn about access policies without token authentication", func(t *testing.T) {
+		s := fixAuthSystemState(nil)
+		r := fixAuthReconciler()
+		r.client = fake.NewClientBuilder().
+			WithScheme(fixAccessPolicyScheme(t)).
+			WithObjects(&v1alpha1.RegistryAccessPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "team-a", Namespace: "docker-registry"},
+			}).
+			Build()
+
+		_, _, err := sFnAuthConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Equal(t, "Warning: registry access policies are not enforced without the token authentication", s.warningBuilder.Build())
+	})
+
+	t.Run("create signing keys and configure token authentication", func(t *testing.T) {
+		s := fixAuthSystemState(&v1alpha1.TokenAuth{})
+		r := fixAuthReconciler(fixTokenServerService())
+
+		_, _, err := sFnAuthConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Empty(t, s.warningBuilder.Build())
+
+		secret := corev1.Secret{}
+		require.NoError(t, r.client.Get(context.Background(), client.ObjectKey{
+			Name:      "dockerregistry-token-key",
+			Namespace: "docker-registry",
+		}, &secret))
+		require.NotEmpty(t, secret.Data[token.BundleKey])
+		require.Len(t, secret.GetOwnerReferences(), 1)
+		require.False(t, token.RotationTime(&secret).IsZero())
+
+		expectedFlags, err := flags.NewBuilder().WithTokenAuth(
+			"http://10.0.0.12:8090/token",
+			"dockerregistry.docker-registry.svc.cluster.local",
+			token.Issuer,
+			"dockerregistry-token-key",
+			secret.Data[token.BundleKey],
+		).Build()
+		require.NoError(t, err)
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+		require.Equal(t, v1alpha1.DefaultTokenKeyRotationInterval, s.retryAfter)
+		require.Empty(t, r.EventRecorder.(*record.FakeRecorder).Events)
+	})
+
+	t.Run("keep signing keys until rotation interval passes", func(t *testing.T) {
+		existing := fixTokenKeySecret(t, time.Now().Add(-time.Hour))
+		s := fixAuthSystemState(&v1alpha1.TokenAuth{
+			KeyRotationInterval: &metav1.Duration{Duration: 24 * time.Hour},
+		})
+		r := fixAuthReconciler(fixTokenServerService(), existing.DeepCopy())
+
+		_, _, err := sFnAuthConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		secret := corev1.Secret{}
+		require.NoError(t, r.client.Get(context.Background(), client.ObjectKeyFromObject(existing), &secret))
+		require.Equal(t, existing.Data, secret.Data)
+		require.InDelta(t, 23*time.Hour, s.retryAfter, float64(time.Minute))
+		require.Empty(t, r.EventRecorder.(*record.FakeRecorder).Events)
+	})
+
+	t.Run("rotate signing keys when rotation interval passed", func(t *testing.T) {
+		existing := fixTokenKeySecret(t, time.Now().Add(-25*time.Hour))
+		s := fixAuthSystemState(&v1alpha1.TokenAuth{
+			KeyRotationInterval: &metav1.Duration{Duration: 24 * time.Hour},
+		})
+		r := fixAuthReconciler(fixTokenServerService(), existing.DeepCopy())
+
+		_, _, err := sFnAuthConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		secret := corev1.Secret{}
+		require.NoError(t, r.client.Get(context.Background(), client.ObjectKeyFromObject(existing), &secret))
+		require.NotEqual(t, existing.Data[token.BundleKey], secret.Data[token.BundleKey])
+		require.Contains(t, string(secret.Data[token.BundleKey]), string(existing.Data["tls.crt"]))
+		require.WithinDuration(t, time.Now(), token.RotationTime(&secret), time.Minute)
+		require.Equal(t, 24*time.Hour, s.retryAfter)
+		require.Equal(t, "Normal TokenKeyRotated token signing key rotated, the previous key is trusted until the next rotation",
+			<-r.EventRecorder.(*record.FakeRecorder).Events)
+	})
+
+	t.Run("warn and retry when token server service is missing", func(t *testing.T) {
+		s := fixAuthSystemState(&v1alpha1.TokenAuth{})
+		r := fixAuthReconciler()
+
+		_, _, err := sFnAuthConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+		require.Contains(t, s.warningBuilder.Build(),
+			"failed to set token authentication: while getting token server service docker-registry/dockerregistry-token-server")
+		require.Equal(t, tokenAuthRetryInterval, s.retryAfter)
+	})
+
+	t.Run("route external token requests to token server", func(t *testing.T) {
+		s := fixAuthSystemState(&v1alpha1.TokenAuth{})
+		s.instance.Spec.ExternalAccess = &v1alpha1.ExternalAccess{Enabled: ptr.To(true)}
+		r := fixAuthReconciler(fixTokenServerService())
+
+		_, _, err := sFnAuthConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Empty(t, s.warningBuilder.Build())
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"tokenServer": map[string]interface{}{
+				"enabled": true,
+				"host":    "dockerregistry-token-server.docker-registry.svc.cluster.local",
+				"port":    int64(8090),
+			},
+		}, flags["virtualService"])
+	})
+
+	t.Run("stop exposing registry when token authentication fails", func(t *testing.T) {
+		s := fixAuthSystemState(&v1alpha1.TokenAuth{})
+		s.instance.Spec.ExternalAccess = &v1alpha1.ExternalAccess{Enabled: ptr.To(true)}
+		s.flagsBuilder.WithVirtualService("registry.example.com", "kyma-system/kyma-gateway")
+		r := fixAuthReconciler()
+
+		_, _, err := sFnAuthConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, false, flags["virtualService"].(map[string]interface{})["enabled"])
+		require.Contains(t, s.warningBuilder.Build(), "failed to set token authentication")
+	})
+
+	t.Run("warn about ttl longer than key rotation interval", func(t *testing.T) {
+		s := fixAuthSystemState(&v1alpha1.TokenAuth{
+			TTL:                 &metav1.Duration{Duration: 2 * time.Hour},
+			KeyRotationInterval: &metav1.Duration{Duration: time.Hour},
+		})
+		r := fixAuthReconciler(fixTokenServerService())
+
+		_, _, err := sFnAuthConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Equal(t, "Warning: failed to set token authentication: "+
+			"token ttl '2h0m0s' must be shorter than the key rotation interval '1h0m0s'",
+			s.warningBuilder.Build())
+	})
+}
+
+func fixAuthSystemState(tokenAuth *v1alpha1.TokenAuth) *systemState {
+	var auth *v1alpha1.Auth
+	if tokenAuth != nil {
+		auth = &v1alpha1.Auth{Token: tokenAuth}
+	}
+
+	return &systemState{
+		instance: v1alpha1.DockerRegistry{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "default",
+				Namespace: "docker-registry",
+				UID:       "test-uid",
+			},
+			Spec: v1alpha1.DockerRegistrySpec{
+				Auth: auth,
+			},
+		},
+		flagsBuilder:   flags.NewBuilder(),
+		warningBuilder: warning.NewBuilder(),
+	}
+}
+
+func fixOIDCExternalAccess() *v1alpha1.ExternalAccess {
+	return &v1alpha1.ExternalAccess{
+		Enabled: ptr.To(true),
+		OIDC: &v1alpha1.ExternalAccessOIDC{
+			IssuerURL: "https://idp.example.com",
+			ClientID:  "docker-registry",
+		},
+	}
+}
+
+func fixAccessPolicyScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+	return scheme
+}
+
+func fixAuthReconciler(objs ...client.Object) *reconciler {
+	return &reconciler{
+		cfg: cfg{tokenServer: testTokenServer},
+		k8s: k8s{
+			client:        fake.NewClientBuilder().WithObjects(objs...).Build(),
+			EventRecorder: record.NewFakeRecorder(5),
+		},
+		log: zap.NewNop().Sugar(),
+	}
+}
+
+func fixTokenServerService() *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      testTokenServer.Name,
+			Namespace: testTokenServer.Namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: "10.0.0.12",
+			Ports: []corev1.ServicePort{
+				{Name: registry.TokenServerPortName, Port: 8090},
+			},
+		},
+	}
+}
+
+func fixTokenKeySecret(t *testing.T, rotationTime time.Time) *corev1.Secret {
+	data, err := token.RotateKeys(nil, rotationTime)
+	require.NoError(t, err)
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dockerregistry-token-key",
+			Namespace: "docker-registry",
+			Annotations: map[string]string{
+				token.RotationTimeAnnotation: rotationTime.UTC().Format(time.RFC3339),
+			},
+		},
+		Data: data,
+	}
+}
@@ -9,6 +9,11 @@ import (
 )
 
 func sFnUpdateConfigurationStatus(_ context.Context, _ *reconciler, s *systemState) (stateFn, *controllerruntime.Result, error) {
+	updateConfiguredCondition(s)
+	return nextState(sFnApplyResources)
+}
+
+func updateConfiguredCondition(s *systemState) {
 	// the configuration states record a warning for everything that did not end up configured the way
 	// the CR asks for, so reporting the configuration as ready while any of them is set would hide it
 	if warning := s.warningBuilder.Build(); warning != "" {
@@ -17,8 +22,7 @@ func sFnUpdateConfigurationStatus(_ context.Context, _ *reconciler, s *systemSta
 			v1alpha1.ConditionReasonConfigurationErr,
 			errors.New(warning),
 		)
-
-		return nextState(sFnApplyResources)
+		return
 	}
 
 	s.instance.UpdateConditionTrue(
@@ -26,6 +30,4 @@ func sFnUpdateConfigurationStatus(_ context.Context, _ *reconciler, s *systemSta
 		v1alpha1.ConditionReasonConfigured,
 		"Configuration ready",
 	)
-
-	return nextState(sFnApplyResources)
 }
@@ -0,0 +1,394 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/validation"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// credentialsPropagationPollInterval is how often the copies are checked for the credentials replaced by the rotation
+const credentialsPropagationPollInterval = time.Minute
+
+// setCredentialsConfig takes the registry credentials from the user-owned Secret if the CR points at one, and
+// rotates the generated ones otherwise
+func setCredentialsConfig(ctx context.Context, r *reconciler, s *systemState, existing *corev1.Secret) error {
+	if s.instance.CredentialsSecretName() == "" {
+		return setCredentialsRotationConfig(ctx, r, s, existing)
+	}
+
+	// the registry keeps the current credentials until the Secret is fixed
+	if err := setCredentialsSecretConfig(ctx, r, s, existing); err != nil {
+		s.warningBuilder.With("failed to set credentials configuration: " + err.Error())
+		s.setRetryAfter(storageRetryInterval)
+	}
+	return nil
+}
+
+// setCredentialsSecretConfig passes the credentials from the user-owned Secret to the registry, the replaced ones
+// are accepted until every namespace gets the new ones, the same way as after a rotation. A changed password alone
+// can't overlap, htpasswd holds a single entry per user
+func setCredentialsSecretConfig(ctx context.Context, r *reconciler, s *systemState, existing *corev1.Secret) error {
+	secretName := s.instance.CredentialsSecretName()
+	secret, err := registry.GetSecret(ctx, r.client, secretName, s.instance.Namespace)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("while fetching credentials secret from %s", s.instance.Namespace))
+	}
+
+	username := string(secret.Data["username"])
+	password := string(secret.Data["password"])
+	if username == "" || password == "" {
+		return errors.Errorf("credentials secret '%s' must have username and password", secretName)
+	}
+	if strings.Contains(username, ":") {
+		return errors.Errorf("username in credentials secret '%s' must not contain ':'", secretName)
+	}
+
+	s.flagsBuilder.WithRegistryCredentialsFromSecret(username, password)
+	if existing == nil {
+		return nil
+	}
+
+	currentUsername := string(existing.Data["username"])
+	if currentUsername != username {
+		s.flagsBuilder.WithPreviousRegistryCredentials(currentUsername, string(existing.Data["password"]))
+		status := credentialsStatus(s)
+		status.LastRotationTime = &metav1.Time{Time: time.Now()}
+		status.PreviousCredentialsValid = true
+		r.EventRecorder.Event(&s.instance, "Normal", string(v1alpha1.ConditionReasonConfiguration), "Registry credentials changed")
+		s.setRetryAfter(credentialsPropagationPollInterval)
+		return nil
+	}
+
+	kept, err := keepPreviousCredentials(ctx, r, s, existing)
+	if err != nil || kept {
+		return err
+	}
+	if s.instance.Status.Credentials != nil {
+		s.instance.Status.Credentials.PreviousCredentialsValid = false
+	}
+	return nil
+}
+
+// setCredentialsRotationConfig replaces the registry credentials once the rotation interval passes, the registry
+// accepts the replaced ones until no copy in the namespaces holds them anymore
+func setCredentialsRotationConfig(ctx context.Context, r *reconciler, s *systemState, existing *corev1.Secret) error {
+	if existing == nil {
+		// the chart generates the first credentials
+		return nil
+	}
+
+	kept, err := keepPreviousCredentials(ctx, r, s, existing)
+	if err != nil || kept {
+		return err
+	}
+
+	interval := s.instance.CredentialsRotationInterval()
+	if interval == 0 {
+		s.instance.Status.Credentials = nil
+		return nil
+	}
+	if err := validation.CredentialsRotationInterval(interval); err != nil {
+		s.warningBuilder.With(fmt.Sprintf("credentials are not rotated: %s", err))
+		return nil
+	}
+
+	status := credentialsStatus(s)
+	status.PreviousCredentialsValid = false
+	// the credentials generated by the chart are as old as the Secret holding them
+	lastRotation := existing.GetCreationTimestamp().Time
+	if status.LastRotationTime != nil {
+		lastRotation = status.LastRotationTime.Time
+	}
+
+	now := time.Now()
+	if nextRotation := lastRotation.Add(interval); now.Before(nextRotation) {
+		s.setRetryAfter(nextRotation.Sub(now))
+		return nil
+	}
+
+	username, err := registry.GenerateUsername()
+	if err != nil {
+		return err
+	}
+	password, err := registry.GeneratePassword()
+	if err != nil {
+		return err
+	}
+
+	s.flagsBuilder.
+		WithRegistryCredentials(username, password).
+		WithPreviousRegistryCredentials(string(existing.Data["username"]), string(existing.Data["password"]))
+	status.LastRotationTime = &metav1.Time{Time: now}
+	status.PreviousCredentialsValid = true
+	r.EventRecorder.Event(&s.instance, "Normal", string(v1alpha1.ConditionReasonConfiguration), "Registry credentials rotated")
+	s.setRetryAfter(credentialsPropagationPollInterval)
+	return nil
+}
+
+// keepPreviousCredentials passes the replaced credentials to the registry again until every namespace gets the new
+// ones, and tells if it did
+func keepPreviousCredentials(ctx context.Context, r *reconciler, s *systemState, existing *corev1.Secret) (bool, error) {
+	previousUsername := string(existing.Data[registry.PreviousUsernameKey])
+	previousPassword := string(existing.Data[registry.PreviousPasswordKey])
+	if previousUsername == "" {
+		return false, nil
+	}
+
+	propagated, err := credentialsPropagated(ctx, r, s, previousUsername)
+	if err != nil {
+		return false, err
+	}
+	if !propagated {
+		s.flagsBuilder.WithPreviousRegistryCredentials(previousUsername, previousPassword)
+		credentialsStatus(s).PreviousCredentialsValid = true
+		s.setRetryAfter(credentialsPropagationPollInterval)
+		return true, nil
+	}
+	r.log.Info("every namespace got the new registry credentials, dropping the replaced ones")
+	return false, nil
+}
+
+// credentialsPropagated tells if no copy of the internal access Secret holds the replaced credentials anymore, the copies
+// managed by users are never updated, so they don't hold the replaced credentials back
+func credentialsPropagated(ctx context.Context, r *reconciler, s *systemState, previousUsername string) (bool, error) {
+	secrets := corev1.SecretList{}
+	err := r.client.List(ctx, &secrets, client.MatchingLabels{registry.LabelConfigKey: registry.LabelConfigVal})
+	if err != nil {
+		return false, errors.Wrap(err, "while listing copies of access secrets")
+	}
+
+	// the copies of the external access Secret hold the external access credentials, which are never kept
+	names := s.resourceNames()
+	for _, secret := range secrets.Items {
+		if secret.GetNamespace() == s.instance.GetNamespace() ||
+			secret.GetLabels()[registry.LabelManagedByKey] == registry.LabelManagedByUserVal ||
+			secret.GetName() != names.InternalAccessSecretName {
+			continue
+		}
+		if string(secret.Data["username"]) == previousUsername {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func credentialsStatus(s *systemState) *v1alpha1.CredentialsStatus {
+	if s.instance.Status.Credentials == nil {
+		s.instance.Status.Credentials = &v1alpha1.CredentialsStatus{}
+	}
+	return s.instance.Status.Credentials
+}
+
+// setExternalCredentialsConfig passes the external access credentials to the registry. They are generated apart from
+// the registry credentials, so that the copies of the internal access Secret can't be used from outside the
+// cluster. The replaced ones are rejected right away, the operator can't follow their copies outside the cluster
+func setExternalCredentialsConfig(ctx context.Context, r *reconciler, s *systemState) error {
+	names := s.resourceNames()
+	existing, err := registry.GetDockerRegistryInternalRegistrySecret(ctx, r.client, names.ExternalAccessSecretName, s.instance.Namespace)
+	if err != nil {
+		return errors.Wrap(err, "while fetching existing external access secret")
+	}
+	internal, err := registry.GetDockerRegistryInternalRegistrySecret(ctx, r.client, names.InternalAccessSecretName, s.instance.Namespace)
+	if err != nil {
+		return errors.Wrap(err, "while fetching existing internal access secret")
+	}
+
+	replaced, err := externalCredentialsReplaced(r, s, existing, internal)
+	if err != nil {
+		return err
+	}
+	if !replaced {
+		s.flagsBuilder.WithExternalCredentials(string(existing.Data["username"]), string(existing.Data["password"]))
+		return nil
+	}
+
+	username, err := registry.GenerateUsername()
+	if err != nil {
+		return err
+	}
+	password, err := registry.GeneratePassword()
+	if err != nil {
+		return err
+	}
+	s.flagsBuilder.WithExternalCredentials(username, password)
+	if interval := s.instance.ExternalCredentialsRotationInterval(); interval != 0 {
+		externalCredentialsStatus(s).LastRotationTime = &metav1.Time{Time: time.Now()}
+		s.setRetryAfter(interval)
+	}
+	return nil
+}
+
+// externalCredentialsReplaced tells if the external access credentials are generated again, because there are none
+// yet, the external access Secret was deleted to revoke them, they are the registry credentials the Secret held
+// before it got its own or the rotation interval passed
+func externalCredentialsReplaced(r *reconciler, s *systemState, existing, internal *corev1.Secret) (bool, error) {
+	if existing == nil || len(existing.Data["username"]) == 0 {
+		return true, nil
+	}
+	if !existing.GetDeletionTimestamp().IsZero() {
+		r.EventRecorder.Event(&s.instance, "Normal", string(v1alpha1.ConditionReasonConfiguration), "External access credentials revoked")
+		return true, nil
+	}
+	if internal != nil && string(existing.Data["username"]) == string(internal.Data["username"]) {
+		r.log.Info("generating external access credentials apart from the registry credentials")
+		return true, nil
+	}
+
+	interval := s.instance.ExternalCredentialsRotationInterval()
+	if interval == 0 {
+		s.instance.Status.ExternalCredentials = nil
+		return false, nil
+	}
+	if err := validation.CredentialsRotationInterval(interval); err != nil {
+		s.warningBuilder.With(fmt.Sprintf("external access credentials are not rotated: %s", err))
+		return false, nil
+	}
+
+	// the first credentials are as old as the Secret holding them
+	lastRotation := existing.GetCreationTimestamp().Time
+	if status := s.instance.Status.ExternalCredentials; status != nil && status.LastRotationTime != nil {
+		lastRotation = status.LastRotationTime.Time
+	}
+
+	now := time.Now()
+	if nextRotation := lastRotation.Add(interval); now.Before(nextRotation) {
+		s.setRetryAfter(nextRotation.Sub(now))
+		return false, nil
+	}
+	r.EventRecorder.Event(&s.instance, "Normal", string(v1alpha1.ConditionReasonConfiguration), "External access credentials rotated")
+	return true, nil
+}
+
+func externalCredentialsStatus(s *systemState) *v1alpha1.CredentialsStatus {
+	if s.instance.Status.ExternalCredentials == nil {
+		s.instance.Status.ExternalCredentials = &v1alpha1.CredentialsStatus{}
+	}
+	return s.instance.Status.ExternalCredentials
+}
+
+// setNamespaceCredentialsConfig makes the registry accept the htpasswd entries the Secret controller stores for
+// every namespace it propagates its own credentials to
+func setNamespaceCredentialsConfig(ctx context.Context, r *reconciler, s *systemState) error {
+	secretKey := client.ObjectKey{
+		Name:      s.resourceNames().NamespaceCredentialsSecretName,
+		Namespace: s.instance.GetNamespace(),
+	}
+
+	if !s.instance.PerNamespaceCredentials() {
+		// the namespaces get the shared credentials again, their own ones must not stay valid
+		return deleteSecret(ctx, r, secretKey)
+	}
+
+	if s.instance.GetNamespace() != registry.BaseNamespace {
+		s.warningBuilder.With(fmt.Sprintf("per-namespace credentials are ignored, only the registry served from the %s namespace propagates its credentials",
+			registry.BaseNamespace))
+		return nil
+	}
+
+	secret := &corev1.Secret{}
+	err := r.client.Get(ctx, secretKey, secret)
+	if apierrors.IsNotFound(err) {
+		// the Secret controller only adds the entries, the Secret goes away together with the CR
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      secretKey.Name,
+				Namespace: secretKey.Namespace,
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(&s.instance, v1alpha1.GroupVersion.WithKind("DockerRegistry")),
+				},
+			},
+			Type: corev1.SecretTypeOpaque,
+		}
+		err = r.client.Create(ctx, secret)
+	}
+	if err != nil {
+		return errors.Wrap(err, "while ensuring namespace credentials secret")
+	}
+
+	s.flagsBuilder.WithNamespaceCredentials(secret.GetName(), htpasswdEntries(secret))
+	return nil
+}
+
+// setPullCredentialsConfig adds the pull-only credentials the namespaces get unless they opt in to push, the ones
+// already in the internal access Secret are reused so that the propagated copies stay valid
+func setPullCredentialsConfig(ctx context.Context, r *reconciler, s *systemState) error {
+	if !s.instance.TokenAuth() {
+		// the htpasswd authentication can't tell the credentials apart, the access proxy in front of the registry
+		// rejects the pushes of the pull-only ones
+		image, err := managerImage(ctx, r)
+		if err != nil {
+			// the namespaces keep the credentials allowed to push until the proxy can be run
+			s.warningBuilder.With("failed to set up pull-only credentials: " + err.Error())
+			return nil
+		}
+		s.flagsBuilder.WithAccessProxy(image)
+	}
+
+	names := s.resourceNames()
+	secret, err := registry.GetDockerRegistryInternalRegistrySecret(ctx, r.client, names.InternalAccessSecretName, s.instance.GetNamespace())
+	if err != nil {
+		return errors.Wrap(err, "while fetching existing internal docker registry secret")
+	}
+
+	var username, password string
+	if secret != nil {
+		username = string(secret.Data[registry.PullUsernameKey])
+		password = string(secret.Data[registry.PullPasswordKey])
+	}
+	if username == "" || password == "" {
+		r.log.Debug("generating pull-only credentials")
+		if username, err = registry.GenerateUsername(); err != nil {
+			return err
+		}
+		if password, err = registry.GeneratePassword(); err != nil {
+			return err
+		}
+	}
+
+	s.flagsBuilder.WithPullCredentials(username, password)
+	return nil
+}
+
+// deleteSecret removes the Secret with the htpasswd entries the registry must not accept anymore
+func deleteSecret(ctx context.Context, r *reconciler, secretKey client.ObjectKey) error {
+	secret := &corev1.Secret{}
+	err := r.client.Get(ctx, secretKey, secret)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("while fetching secret %s", secretKey.Name))
+	}
+
+	if err := r.client.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+		return errors.Wrap(err, fmt.Sprintf("while deleting secret %s", secretKey.Name))
+	}
+	return nil
+}
+
+// htpasswdEntries returns the htpasswd entries ordered by namespace or user, so that the registry is rolled
+// only when an entry changes
+func htpasswdEntries(secret *corev1.Secret) []string {
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]string, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, string(secret.Data[key]))
+	}
+	return entries
+}
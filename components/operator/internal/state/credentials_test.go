@@ -0,0 +1,593 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/flags"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/warning"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_setNamespaceCredentialsConfig(t *testing.T) {
+	t.Run("delete namespace credentials when shared credentials are used", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", nil)
+		r := fixCredentialsReconciler(fixNamespaceCredentialsSecret("docker-registry", nil))
+
+		err := setNamespaceCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		err = r.client.Get(context.Background(), client.ObjectKey{
+			Name:      "dockerregistry-namespace-credentials",
+			Namespace: "docker-registry",
+		}, &corev1.Secret{})
+		require.True(t, apierrors.IsNotFound(err))
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+	})
+
+	t.Run("create namespace credentials secret", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", &v1alpha1.Credentials{PerNamespace: true})
+		r := fixCredentialsReconciler()
+
+		err := setNamespaceCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Empty(t, s.warningBuilder.Build())
+
+		secret := corev1.Secret{}
+		require.NoError(t, r.client.Get(context.Background(), client.ObjectKey{
+			Name:      "dockerregistry-namespace-credentials",
+			Namespace: "docker-registry",
+		}, &secret))
+		require.Len(t, secret.GetOwnerReferences(), 1)
+
+		expectedFlags, err := flags.NewBuilder().
+			WithNamespaceCredentials("dockerregistry-namespace-credentials", []string{}).
+			Build()
+		require.NoError(t, err)
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+	})
+
+	t.Run("configure entries ordered by namespace", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", &v1alpha1.Credentials{PerNamespace: true})
+		r := fixCredentialsReconciler(fixNamespaceCredentialsSecret("docker-registry", map[string][]byte{
+			"team-b": []byte("team-b:hash-b\n"),
+			"team-a": []byte("team-a:hash-a\n"),
+		}))
+
+		err := setNamespaceCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		expectedFlags, err := flags.NewBuilder().
+			WithNamespaceCredentials("dockerregistry-namespace-credentials", []string{"team-a:hash-a\n", "team-b:hash-b\n"}).
+			Build()
+		require.NoError(t, err)
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+	})
+
+	t.Run("warn when registry is not served from base namespace", func(t *testing.T) {
+		s := fixCredentialsSystemState("team-a", &v1alpha1.Credentials{PerNamespace: true})
+		r := fixCredentialsReconciler()
+
+		err := setNamespaceCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Equal(t, "Warning: per-namespace credentials are ignored, only the registry served from the docker-registry namespace propagates its credentials",
+			s.warningBuilder.Build())
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+	})
+}
+
+func Test_setPullCredentialsConfig(t *testing.T) {
+	t.Run("run access proxy without token authentication", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", nil)
+		r := fixCredentialsReconciler(fixOperatorPod())
+		r.managerPod = types.NamespacedName{Name: "operator", Namespace: "kyma-system"}
+
+		err := setPullCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"enabled": true,
+			"image":   "dockerregistry-operator:1.0.0",
+		}, flags["accessProxy"])
+		pullCredentials := flags["pullCredentials"].(map[string]interface{})
+		require.Equal(t, true, pullCredentials["enabled"])
+		require.NotEmpty(t, pullCredentials["username"])
+		require.NotEmpty(t, pullCredentials["password"])
+	})
+
+	t.Run("keep push credentials when access proxy image is unknown", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", nil)
+		r := fixCredentialsReconciler()
+
+		err := setPullCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Equal(t, "Warning: failed to set up pull-only credentials: operator pod is unknown, the DOCKERREGISTRY_MANAGER_NAME environment variable is not set",
+			s.warningBuilder.Build())
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+	})
+
+	t.Run("generate pull credentials", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", nil)
+		s.instance.Spec.Auth = &v1alpha1.Auth{Token: &v1alpha1.TokenAuth{}}
+		r := fixCredentialsReconciler()
+
+		err := setPullCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		// the token server tells the credentials apart on its own
+		require.NotContains(t, flags, "accessProxy")
+		pullCredentials := flags["pullCredentials"].(map[string]interface{})
+		require.Equal(t, true, pullCredentials["enabled"])
+		require.NotEmpty(t, pullCredentials["username"])
+		require.NotEmpty(t, pullCredentials["password"])
+	})
+
+	t.Run("reuse existing pull credentials", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", nil)
+		s.instance.Spec.Auth = &v1alpha1.Auth{Token: &v1alpha1.TokenAuth{}}
+		r := fixCredentialsReconciler(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dockerregistry-config",
+				Namespace: "docker-registry",
+				Labels:    map[string]string{registry.LabelConfigKey: registry.LabelConfigVal},
+			},
+			Data: map[string][]byte{
+				registry.PullUsernameKey: []byte("pull-user"),
+				registry.PullPasswordKey: []byte("pull-password"),
+			},
+		})
+
+		err := setPullCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		expectedFlags, err := flags.NewBuilder().WithPullCredentials("pull-user", "pull-password").Build()
+		require.NoError(t, err)
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+	})
+}
+
+func Test_setCredentialsRotationConfig(t *testing.T) {
+	t.Run("skip without rotation interval", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", nil)
+		s.instance.Status.Credentials = &v1alpha1.CredentialsStatus{}
+		r := fixCredentialsReconciler()
+
+		err := setCredentialsRotationConfig(context.Background(), r, s, fixRotatedAccessSecret(time.Now(), nil))
+		require.NoError(t, err)
+		require.Nil(t, s.instance.Status.Credentials)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+	})
+
+	t.Run("wait for rotation interval", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", &v1alpha1.Credentials{
+			RotationInterval: &metav1.Duration{Duration: 24 * time.Hour},
+		})
+		r := fixCredentialsReconciler()
+
+		err := setCredentialsRotationConfig(context.Background(), r, s, fixRotatedAccessSecret(time.Now().Add(-time.Hour), nil))
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+		require.InDelta(t, 23*time.Hour, s.retryAfter, float64(time.Minute))
+		require.Nil(t, s.instance.Status.Credentials.LastRotationTime)
+	})
+
+	t.Run("rotate credentials when rotation interval passes", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", &v1alpha1.Credentials{
+			RotationInterval: &metav1.Duration{Duration: 24 * time.Hour},
+		})
+		s.instance.Status.Credentials = &v1alpha1.CredentialsStatus{
+			LastRotationTime: &metav1.Time{Time: time.Now().Add(-25 * time.Hour)},
+		}
+		r := fixCredentialsReconciler()
+
+		err := setCredentialsRotationConfig(context.Background(), r, s, fixRotatedAccessSecret(time.Now().Add(-48*time.Hour), nil))
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		credentials := flags["dockerRegistry"].(map[string]interface{})
+		require.NotEqual(t, "user", credentials["username"])
+		require.NotEqual(t, "pass", credentials["password"])
+		require.Equal(t, map[string]interface{}{
+			"enabled":  true,
+			"username": "user",
+			"password": "pass",
+		}, flags["previousCredentials"])
+
+		status := s.instance.Status.Credentials
+		require.WithinDuration(t, time.Now(), status.LastRotationTime.Time, time.Minute)
+		require.True(t, status.PreviousCredentialsValid)
+		require.Equal(t, credentialsPropagationPollInterval, s.retryAfter)
+		require.Equal(t, "Normal Configuration Registry credentials rotated", <-r.EventRecorder.(*record.FakeRecorder).Events)
+	})
+
+	t.Run("keep previous credentials until they are propagated", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", &v1alpha1.Credentials{
+			RotationInterval: &metav1.Duration{Duration: 24 * time.Hour},
+		})
+		r := fixCredentialsReconciler(fixCredentialsCopy("deployer", "old-user", false))
+
+		err := setCredentialsRotationConfig(context.Background(), r, s, fixRotatedAccessSecret(time.Now(), map[string][]byte{
+			registry.PreviousUsernameKey: []byte("old-user"),
+			registry.PreviousPasswordKey: []byte("old-pass"),
+		}))
+		require.NoError(t, err)
+
+		expectedFlags, err := flags.NewBuilder().WithPreviousRegistryCredentials("old-user", "old-pass").Build()
+		require.NoError(t, err)
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+		require.True(t, s.instance.Status.Credentials.PreviousCredentialsValid)
+		require.Equal(t, credentialsPropagationPollInterval, s.retryAfter)
+	})
+
+	t.Run("drop previous credentials once they are propagated", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", &v1alpha1.Credentials{
+			RotationInterval: &metav1.Duration{Duration: 24 * time.Hour},
+		})
+		s.instance.Status.Credentials = &v1alpha1.CredentialsStatus{
+			LastRotationTime:         &metav1.Time{Time: time.Now().Add(-time.Hour)},
+			PreviousCredentialsValid: true,
+		}
+		r := fixCredentialsReconciler(
+			fixCredentialsCopy("deployer", "user", false),
+			// the operator never updates the copies managed by users
+			fixCredentialsCopy("custom", "old-user", true),
+		)
+
+		err := setCredentialsRotationConfig(context.Background(), r, s, fixRotatedAccessSecret(time.Now(), map[string][]byte{
+			registry.PreviousUsernameKey: []byte("old-user"),
+			registry.PreviousPasswordKey: []byte("old-pass"),
+		}))
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+		require.False(t, s.instance.Status.Credentials.PreviousCredentialsValid)
+	})
+
+	t.Run("warn about too short rotation interval", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", &v1alpha1.Credentials{
+			RotationInterval: &metav1.Duration{Duration: time.Minute},
+		})
+		r := fixCredentialsReconciler()
+
+		err := setCredentialsRotationConfig(context.Background(), r, s, fixRotatedAccessSecret(time.Now().Add(-time.Hour), nil))
+		require.NoError(t, err)
+		require.Equal(t, "Warning: credentials are not rotated: credentials rotation interval '1m0s' must not be shorter than '1h0m0s'",
+			s.warningBuilder.Build())
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+	})
+}
+
+func Test_setCredentialsConfig(t *testing.T) {
+	secretRef := &v1alpha1.Credentials{SecretRef: &v1alpha1.SecretReference{Name: "registry-credentials"}}
+
+	t.Run("take credentials from secret", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", secretRef)
+		r := fixCredentialsReconciler(fixUserCredentialsSecret("docker-registry", "user", "new-pass"))
+
+		err := setCredentialsConfig(context.Background(), r, s, fixRotatedAccessSecret(time.Now(), nil))
+		require.NoError(t, err)
+
+		expectedFlags, err := flags.NewBuilder().WithRegistryCredentialsFromSecret("user", "new-pass").Build()
+		require.NoError(t, err)
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+		require.Empty(t, r.EventRecorder.(*record.FakeRecorder).Events)
+	})
+
+	t.Run("keep replaced credentials when username changes", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", secretRef)
+		r := fixCredentialsReconciler(fixUserCredentialsSecret("docker-registry", "new-user", "new-pass"))
+
+		err := setCredentialsConfig(context.Background(), r, s, fixRotatedAccessSecret(time.Now(), nil))
+		require.NoError(t, err)
+
+		expectedFlags, err := flags.NewBuilder().
+			WithRegistryCredentialsFromSecret("new-user", "new-pass").
+			WithPreviousRegistryCredentials("user", "pass").
+			Build()
+		require.NoError(t, err)
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+
+		status := s.instance.Status.Credentials
+		require.WithinDuration(t, time.Now(), status.LastRotationTime.Time, time.Minute)
+		require.True(t, status.PreviousCredentialsValid)
+		require.Equal(t, credentialsPropagationPollInterval, s.retryAfter)
+		require.Equal(t, "Normal Configuration Registry credentials changed", <-r.EventRecorder.(*record.FakeRecorder).Events)
+	})
+
+	t.Run("keep previous credentials until they are propagated", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", secretRef)
+		r := fixCredentialsReconciler(
+			fixUserCredentialsSecret("docker-registry", "user", "pass"),
+			fixCredentialsCopy("deployer", "old-user", false),
+		)
+
+		err := setCredentialsConfig(context.Background(), r, s, fixRotatedAccessSecret(time.Now(), map[string][]byte{
+			registry.PreviousUsernameKey: []byte("old-user"),
+			registry.PreviousPasswordKey: []byte("old-pass"),
+		}))
+		require.NoError(t, err)
+
+		expectedFlags, err := flags.NewBuilder().
+			WithRegistryCredentialsFromSecret("user", "pass").
+			WithPreviousRegistryCredentials("old-user", "old-pass").
+			Build()
+		require.NoError(t, err)
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+		require.True(t, s.instance.Status.Credentials.PreviousCredentialsValid)
+	})
+
+	t.Run("warn about missing secret", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", secretRef)
+		r := fixCredentialsReconciler()
+
+		err := setCredentialsConfig(context.Background(), r, s, fixRotatedAccessSecret(time.Now(), nil))
+		require.NoError(t, err)
+		require.Contains(t, s.warningBuilder.Build(), "failed to set credentials configuration: while fetching credentials secret from docker-registry")
+		require.Equal(t, storageRetryInterval, s.retryAfter)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+	})
+
+	t.Run("warn about secret without password", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", secretRef)
+		r := fixCredentialsReconciler(fixUserCredentialsSecret("docker-registry", "user", ""))
+
+		err := setCredentialsConfig(context.Background(), r, s, fixRotatedAccessSecret(time.Now(), nil))
+		require.NoError(t, err)
+		require.Equal(t, "Warning: failed to set credentials configuration: credentials secret 'registry-credentials' must have username and password",
+			s.warningBuilder.Build())
+	})
+}
+
+func Test_setExternalCredentialsConfig(t *testing.T) {
+	t.Run("generate external credentials", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", nil)
+		r := fixCredentialsReconciler(fixLabeledAccessSecret(registry.InternalAccessSecretName, time.Now(), "user", "pass"))
+
+		err := setExternalCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		credentials := flags["externalCredentials"].(map[string]interface{})
+		require.Len(t, credentials["username"], 20)
+		require.Len(t, credentials["password"], 40)
+		require.Nil(t, s.instance.Status.ExternalCredentials)
+	})
+
+	t.Run("reuse existing external credentials", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", nil)
+		r := fixCredentialsReconciler(
+			fixLabeledAccessSecret(registry.InternalAccessSecretName, time.Now(), "user", "pass"),
+			fixLabeledAccessSecret(registry.ExternalAccessSecretName, time.Now(), "external-user", "external-pass"),
+		)
+
+		err := setExternalCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		expectedFlags, err := flags.NewBuilder().WithExternalCredentials("external-user", "external-pass").Build()
+		require.NoError(t, err)
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+	})
+
+	t.Run("replace registry credentials shared with external access", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", nil)
+		r := fixCredentialsReconciler(
+			fixLabeledAccessSecret(registry.InternalAccessSecretName, time.Now(), "user", "pass"),
+			fixLabeledAccessSecret(registry.ExternalAccessSecretName, time.Now(), "user", "pass"),
+		)
+
+		err := setExternalCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		credentials := flags["externalCredentials"].(map[string]interface{})
+		require.NotEqual(t, "user", credentials["username"])
+		require.NotEqual(t, "pass", credentials["password"])
+	})
+
+	t.Run("revoke external credentials of deleted secret", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", nil)
+		external := fixLabeledAccessSecret(registry.ExternalAccessSecretName, time.Now(), "external-user", "external-pass")
+		external.Finalizers = []string{registry.ConfigSecretFinalizer}
+		external.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+		r := fixCredentialsReconciler(external)
+
+		err := setExternalCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		credentials := flags["externalCredentials"].(map[string]interface{})
+		require.NotEqual(t, "external-user", credentials["username"])
+		require.Equal(t, "Normal Configuration External access credentials revoked", <-r.EventRecorder.(*record.FakeRecorder).Events)
+	})
+
+	t.Run("wait for rotation interval", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", nil)
+		s.instance.Spec.ExternalAccess = &v1alpha1.ExternalAccess{
+			CredentialsRotationInterval: &metav1.Duration{Duration: 24 * time.Hour},
+		}
+		r := fixCredentialsReconciler(
+			fixLabeledAccessSecret(registry.ExternalAccessSecretName, time.Now().Add(-time.Hour), "external-user", "external-pass"),
+		)
+
+		err := setExternalCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, "external-user", flags["externalCredentials"].(map[string]interface{})["username"])
+		require.InDelta(t, 23*time.Hour, s.retryAfter, float64(time.Minute))
+	})
+
+	t.Run("rotate external credentials when rotation interval passes", func(t *testing.T) {
+		s := fixCredentialsSystemState("docker-registry", nil)
+		s.instance.Spec.ExternalAccess = &v1alpha1.ExternalAccess{
+			CredentialsRotationInterval: &metav1.Duration{Duration: 24 * time.Hour},
+		}
+		s.instance.Status.ExternalCredentials = &v1alpha1.CredentialsStatus{
+			LastRotationTime: &metav1.Time{Time: time.Now().Add(-25 * time.Hour)},
+		}
+		r := fixCredentialsReconciler(
+			fixLabeledAccessSecret(registry.ExternalAccessSecretName, time.Now().Add(-48*time.Hour), "external-user", "external-pass"),
+		)
+
+		err := setExternalCredentialsConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		credentials := flags["externalCredentials"].(map[string]interface{})
+		require.NotEqual(t, "external-user", credentials["username"])
+		// the replaced external credentials are not kept for the registry
+		require.NotContains(t, flags, "previousCredentials")
+		require.WithinDuration(t, time.Now(), s.instance.Status.ExternalCredentials.LastRotationTime.Time, time.Minute)
+		require.Equal(t, 24*time.Hour, s.retryAfter)
+		require.Equal(t, "Normal Configuration External access credentials rotated", <-r.EventRecorder.(*record.FakeRecorder).Events)
+	})
+}
+
+func fixLabeledAccessSecret(name string, creationTime time.Time, username, password string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "docker-registry",
+			Labels:            map[string]string{registry.LabelConfigKey: registry.LabelConfigVal},
+			CreationTimestamp: metav1.Time{Time: creationTime},
+		},
+		Data: map[string][]byte{
+			"username": []byte(username),
+			"password": []byte(password),
+		},
+	}
+}
+
+func fixUserCredentialsSecret(namespace, username, password string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "registry-credentials",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			"username": []byte(username),
+			"password": []byte(password),
+		},
+	}
+}
+
+func fixRotatedAccessSecret(creationTime time.Time, data map[string][]byte) *corev1.Secret {
+	secret := fixInternalAccessSecret()
+	secret.CreationTimestamp = metav1.Time{Time: creationTime}
+	for key, value := range data {
+		secret.Data[key] = value
+	}
+	return secret
+}
+
+func fixCredentialsCopy(namespace, username string, userManaged bool) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dockerregistry-config",
+			Namespace: namespace,
+			Labels:    map[string]string{registry.LabelConfigKey: registry.LabelConfigVal},
+		},
+		Data: map[string][]byte{"username": []byte(username)},
+	}
+	if userManaged {
+		secret.Labels[registry.LabelManagedByKey] = registry.LabelManagedByUserVal
+	}
+	return secret
+}
+
+func fixCredentialsSystemState(namespace string, credentials *v1alpha1.Credentials) *systemState {
+	return &systemState{
+		instance: v1alpha1.DockerRegistry{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "default",
+				Namespace: namespace,
+				UID:       "test-uid",
+			},
+			Spec: v1alpha1.DockerRegistrySpec{
+				Credentials: credentials,
+			},
+		},
+		flagsBuilder:   flags.NewBuilder(),
+		warningBuilder: warning.NewBuilder(),
+	}
+}
+
+func fixCredentialsReconciler(objs ...client.Object) *reconciler {
+	return &reconciler{
+		k8s: k8s{
+			client:        fake.NewClientBuilder().WithObjects(objs...).Build(),
+			EventRecorder: record.NewFakeRecorder(5),
+		},
+		log: zap.NewNop().Sugar(),
+	}
+}
+
+func fixNamespaceCredentialsSecret(namespace string, data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dockerregistry-namespace-credentials",
+			Namespace: namespace,
+		},
+		Data: data,
+	}
+}
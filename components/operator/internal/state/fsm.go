@@ -22,11 +22,7 @@ import (
 )
 
 var (
-	defaultResult  = ctrl.Result{}
-	secretCacheKey = types.NamespacedName{
-		Name:      "dockerregistry-manifest-cache",
-		Namespace: "docker-registry",
-	}
+	defaultResult = ctrl.Result{}
 )
 
 type stateFn func(context.Context, *reconciler, *systemState) (stateFn, *ctrl.Result, error)
@@ -35,6 +31,12 @@ type cfg struct {
 	finalizer     string
 	chartPath     string
 	managerPodUID string
+	// managerPod is the pod of the operator, the storage migration Job runs its image
+	managerPod types.NamespacedName
+	// tokenServer is the Service the registries with the token authentication send the clients to
+	tokenServer types.NamespacedName
+	// storageAdapter is the Service the registries with the Azure BTP Object Store send their storage requests to
+	storageAdapter types.NamespacedName
 }
 
 type systemState struct {
@@ -45,9 +47,14 @@ type systemState struct {
 	flagsBuilder        *flags.Builder
 	nodePortResolver    *registry.NodePortResolver
 	gatewayHostResolver registry.ExternalAccessResolver
+	registryAPI         registryAPIFactory
 	// retryAfter makes the final state requeue the reconciliation instead of stopping,
 	// so that a configuration that depends on missing cluster resources is retried
 	retryAfter time.Duration
+	// garbageCollectionDue is set when the scheduled garbage collection has to start in this reconciliation
+	garbageCollectionDue bool
+	// storagePreflight is what the storage preflight check probes before the resources are applied
+	storagePreflight storagePreflight
 }
 
 func (s *systemState) saveStatusSnapshot() {
@@ -58,20 +65,33 @@ func (s *systemState) saveStatusSnapshot() {
 	s.statusSnapshot = *result
 }
 
+// setRetryAfter makes the reconciliation requeue after the given duration, unless a sooner retry is already set
+func (s *systemState) setRetryAfter(duration time.Duration) {
+	if s.retryAfter == 0 || duration < s.retryAfter {
+		s.retryAfter = duration
+	}
+}
+
 func (s *systemState) setState(state v1alpha1.State) {
 	s.instance.Status.State = state
 }
 
+// resourceNames returns the names of the resources that are unique for the registry served from the instance namespace
+func (s *systemState) resourceNames() registry.ResourceNames {
+	return registry.NewResourceNames(s.instance.GetNamespace())
+}
+
 func (s *systemState) setServed(served v1alpha1.Served) {
 	s.instance.Status.Served = served
 }
 
 func chartConfig(ctx context.Context, r *reconciler, namespace string) *chart.Config {
+	names := registry.NewResourceNames(namespace)
 	return &chart.Config{
 		Ctx:         ctx,
 		Log:         r.log,
 		Cache:       r.cache,
-		CacheKey:    secretCacheKey,
+		CacheKey:    names.CacheKey,
 		ManagerUID:  r.managerPodUID,
 		ManagerName: "dockerregistry-manager",
 		Cluster: chart.Cluster{
@@ -81,7 +101,7 @@ func chartConfig(ctx context.Context, r *reconciler, namespace string) *chart.Co
 		Release: chart.Release{
 			ChartPath: r.chartPath,
 			Namespace: namespace,
-			Name:      "dockerregistry",
+			Name:      names.ReleaseName,
 		},
 	}
 }
@@ -122,6 +142,7 @@ func (m *reconciler) Reconcile(ctx context.Context, v v1alpha1.DockerRegistry) (
 		gatewayHostResolver: registry.NewExternalAccessResolver(
 			fmt.Sprintf("registry-%s-%s", v.GetName(), v.GetNamespace()),
 		),
+		registryAPI: newRegistryAPI,
 	}
 	state.saveStatusSnapshot()
 	var err error
@@ -0,0 +1,259 @@
+package state
+
+import (
+	"context"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/validation"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// garbageCollectionPollInterval is how often a running garbage collection is checked
+const garbageCollectionPollInterval = 30 * time.Second
+
+// sFnGarbageCollectionConfiguration switches the registry to the read-only mode when a garbage collection is due
+// or still running, the garbage collector must not run while images are pushed
+func sFnGarbageCollectionConfiguration(_ context.Context, _ *reconciler, s *systemState) (stateFn, *ctrl.Result, error) {
+	if s.instance.Spec.GarbageCollection != nil {
+		if err := scheduleGarbageCollection(s); err != nil {
+			s.warningBuilder.With("failed to set garbage collection configuration: " + err.Error())
+		}
+	}
+
+	if storageMigrationRunning(s.instance.Status.StorageMigration) {
+		// the scheduled garbage collection starts when the images are migrated
+		s.garbageCollectionDue = false
+	}
+
+	status := s.instance.Status.GarbageCollection
+	if s.garbageCollectionDue || garbageCollectionRunning(status) {
+		s.flagsBuilder.WithReadOnlyMaintenance()
+	}
+	if status != nil && status.Result == v1alpha1.GarbageCollectionFailed {
+		s.warningBuilder.With("last garbage collection failed: " + status.Message)
+	}
+
+	return nextState(sFnUpdateConfigurationStatus)
+}
+
+// sFnGarbageCollection starts the garbage collection Job once the read-only registry is rolled out and records
+// the result of the Job when it finishes
+func sFnGarbageCollection(ctx context.Context, r *reconciler, s *systemState) (stateFn, *ctrl.Result, error) {
+	if err := runGarbageCollection(ctx, r, s); err != nil {
+		s.warningBuilder.With("failed to run garbage collection: " + err.Error())
+		s.setRetryAfter(garbageCollectionPollInterval)
+	}
+
+	return nextState(sFnStorageMigration)
+}
+
+func scheduleGarbageCollection(s *systemState) error {
+	if err := validation.GarbageCollectionStorage(s.instance.Spec.Storage); err != nil {
+		return err
+	}
+
+	spec := s.instance.Spec.GarbageCollection
+	schedule, err := validation.GarbageCollectionSchedule(spec.Schedule)
+	if err != nil {
+		return err
+	}
+
+	status := s.instance.Status.GarbageCollection
+	if status == nil {
+		status = &v1alpha1.GarbageCollectionStatus{}
+		s.instance.Status.GarbageCollection = status
+	}
+
+	now := time.Now()
+	if status.Schedule != spec.Schedule || status.NextScheduleTime == nil {
+		status.Schedule = spec.Schedule
+		status.NextScheduleTime = &metav1.Time{Time: schedule.Next(now)}
+	}
+
+	s.garbageCollectionDue = !now.Before(status.NextScheduleTime.Time)
+	return nil
+}
+
+func runGarbageCollection(ctx context.Context, r *reconciler, s *systemState) error {
+	status := s.instance.Status.GarbageCollection
+	if s.instance.Spec.GarbageCollection == nil {
+		return removeGarbageCollection(ctx, r, s)
+	}
+
+	if garbageCollectionRunning(status) {
+		return checkGarbageCollectionJob(ctx, r, s)
+	}
+
+	if s.garbageCollectionDue {
+		return startGarbageCollectionJob(ctx, r, s)
+	}
+
+	if status != nil && status.NextScheduleTime != nil {
+		s.setRetryAfter(time.Until(status.NextScheduleTime.Time))
+	}
+	return nil
+}
+
+func removeGarbageCollection(ctx context.Context, r *reconciler, s *systemState) error {
+	status := s.instance.Status.GarbageCollection
+	if garbageCollectionRunning(status) {
+		job := &batchv1.Job{}
+		job.SetName(status.JobName)
+		job.SetNamespace(s.instance.GetNamespace())
+		err := r.client.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if client.IgnoreNotFound(err) != nil {
+			return errors.Wrap(err, "while deleting garbage collection job")
+		}
+		// the registry leaves the read-only mode in the next reconciliation
+		s.setRetryAfter(garbageCollectionPollInterval)
+	}
+
+	s.instance.Status.GarbageCollection = nil
+	return nil
+}
+
+func startGarbageCollectionJob(ctx context.Context, r *reconciler, s *systemState) error {
+	spec := s.instance.Spec.GarbageCollection
+	schedule, err := validation.GarbageCollectionSchedule(spec.Schedule)
+	if err != nil {
+		return err
+	}
+
+	deployment := &appsv1.Deployment{}
+	err = r.client.Get(ctx, client.ObjectKey{Name: registry.DeploymentName, Namespace: s.instance.GetNamespace()}, deployment)
+	if err != nil {
+		return errors.Wrap(err, "while fetching registry deployment")
+	}
+
+	now := time.Now()
+	job, err := registry.NewGarbageCollectionJob(deployment, registry.GarbageCollectionJobName(now), registry.GarbageCollectionOptions{
+		DeleteUntagged: spec.DeleteUntagged,
+		DryRun:         spec.DryRun,
+	})
+	if err != nil {
+		return err
+	}
+	job.SetOwnerReferences([]metav1.OwnerReference{
+		*metav1.NewControllerRef(&s.instance, v1alpha1.GroupVersion.WithKind("DockerRegistry")),
+	})
+
+	if err := r.client.Create(ctx, job); err != nil {
+		return errors.Wrap(err, "while creating garbage collection job")
+	}
+	r.log.Infof("started garbage collection job %s/%s", job.GetNamespace(), job.GetName())
+
+	status := s.instance.Status.GarbageCollection
+	status.LastScheduleTime = &metav1.Time{Time: now}
+	status.NextScheduleTime = &metav1.Time{Time: schedule.Next(now)}
+	status.JobName = job.GetName()
+	status.Result = v1alpha1.GarbageCollectionRunning
+	status.FreedBytes = nil
+	status.DeletedBlobs = nil
+	status.DeletedManifests = nil
+	status.Message = ""
+
+	s.setRetryAfter(garbageCollectionPollInterval)
+	return nil
+}
+
+func checkGarbageCollectionJob(ctx context.Context, r *reconciler, s *systemState) error {
+	status := s.instance.Status.GarbageCollection
+	job := &batchv1.Job{}
+	err := r.client.Get(ctx, client.ObjectKey{Name: status.JobName, Namespace: s.instance.GetNamespace()}, job)
+	if client.IgnoreNotFound(err) != nil {
+		return errors.Wrap(err, "while fetching garbage collection job")
+	}
+	if err != nil {
+		finishGarbageCollection(s, v1alpha1.GarbageCollectionFailed, time.Now(), "garbage collection job was deleted before it finished")
+		return nil
+	}
+
+	condition := getJobFinishedCondition(job)
+	if condition == nil {
+		s.setRetryAfter(garbageCollectionPollInterval)
+		return nil
+	}
+
+	message, err := getGarbageCollectionMessage(ctx, r, job)
+	if err != nil {
+		return err
+	}
+
+	finishedAt := condition.LastTransitionTime.Time
+	if condition.Type == batchv1.JobFailed {
+		if message == "" {
+			// the pod was killed, for example because the job timed out
+			message = condition.Message
+		}
+		finishGarbageCollection(s, v1alpha1.GarbageCollectionFailed, finishedAt, message)
+		return nil
+	}
+
+	summary, err := registry.ParseGarbageCollectionSummary(message)
+	if err != nil {
+		finishGarbageCollection(s, v1alpha1.GarbageCollectionFailed, finishedAt, err.Error())
+		return nil
+	}
+
+	finishGarbageCollection(s, v1alpha1.GarbageCollectionSucceeded, finishedAt, "")
+	status.FreedBytes = summary.FreedBytes
+	status.DeletedBlobs = &summary.DeletedBlobs
+	status.DeletedManifests = &summary.DeletedManifests
+	return nil
+}
+
+func finishGarbageCollection(s *systemState, result v1alpha1.GarbageCollectionResult, finishedAt time.Time, message string) {
+	status := s.instance.Status.GarbageCollection
+	status.Result = result
+	status.LastRunTime = &metav1.Time{Time: finishedAt}
+	status.Message = message
+
+	// the registry leaves the read-only mode in the next reconciliation
+	s.setRetryAfter(time.Second)
+}
+
+// getGarbageCollectionMessage returns the termination message of the garbage collector, it holds the summary of a
+// successful run or the last logs of a failed one
+func getGarbageCollectionMessage(ctx context.Context, r *reconciler, job *batchv1.Job) (string, error) {
+	pods := &corev1.PodList{}
+	err := r.client.List(ctx, pods,
+		client.InNamespace(job.GetNamespace()),
+		client.MatchingLabels{registry.GarbageCollectionLabelKey: job.GetName()},
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "while listing garbage collection pods")
+	}
+
+	for _, pod := range pods.Items {
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			terminated := containerStatus.State.Terminated
+			if containerStatus.Name == registry.GarbageCollectionContainerName && terminated != nil {
+				return terminated.Message, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func getJobFinishedCondition(job *batchv1.Job) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		condition := &job.Status.Conditions[i]
+		if condition.Status == corev1.ConditionTrue &&
+			(condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) {
+			return condition
+		}
+	}
+	return nil
+}
+
+func garbageCollectionRunning(status *v1alpha1.GarbageCollectionStatus) bool {
+	return status != nil && status.Result == v1alpha1.GarbageCollectionRunning
+}
@@ -0,0 +1,356 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/flags"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/warning"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_sFnGarbageCollectionConfiguration(t *testing.T) {
+	t.Run("skip when garbage collection is not configured", func(t *testing.T) {
+		s := fixGarbageCollectionSystemState(nil, nil)
+
+		next, result, err := sFnGarbageCollectionConfiguration(context.Background(), nil, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnUpdateConfigurationStatus, next)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+		require.Nil(t, s.instance.Status.GarbageCollection)
+	})
+
+	t.Run("schedule first run without read-only mode", func(t *testing.T) {
+		s := fixGarbageCollectionSystemState(&v1alpha1.GarbageCollection{Schedule: "0 3 * * *"}, nil)
+
+		_, _, err := sFnGarbageCollectionConfiguration(context.Background(), nil, s)
+		require.NoError(t, err)
+
+		status := s.instance.Status.GarbageCollection
+		require.Equal(t, "0 3 * * *", status.Schedule)
+		require.True(t, status.NextScheduleTime.After(time.Now()))
+		require.False(t, s.garbageCollectionDue)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+	})
+
+	t.Run("switch registry to read-only mode when run is due", func(t *testing.T) {
+		s := fixGarbageCollectionSystemState(&v1alpha1.GarbageCollection{Schedule: "0 3 * * *"}, &v1alpha1.GarbageCollectionStatus{
+			Schedule:         "0 3 * * *",
+			NextScheduleTime: &metav1.Time{Time: time.Now().Add(-time.Minute)},
+		})
+
+		_, _, err := sFnGarbageCollectionConfiguration(context.Background(), nil, s)
+		require.NoError(t, err)
+
+		require.True(t, s.garbageCollectionDue)
+		requireReadOnlyFlags(t, s)
+	})
+
+	t.Run("keep read-only mode while run is in progress", func(t *testing.T) {
+		s := fixGarbageCollectionSystemState(nil, &v1alpha1.GarbageCollectionStatus{
+			JobName: "dockerregistry-gc-1",
+			Result:  v1alpha1.GarbageCollectionRunning,
+		})
+
+		_, _, err := sFnGarbageCollectionConfiguration(context.Background(), nil, s)
+		require.NoError(t, err)
+
+		require.False(t, s.garbageCollectionDue)
+		requireReadOnlyFlags(t, s)
+	})
+
+	t.Run("reschedule when schedule changes", func(t *testing.T) {
+		s := fixGarbageCollectionSystemState(&v1alpha1.GarbageCollection{Schedule: "0 3 * * *"}, &v1alpha1.GarbageCollectionStatus{
+			Schedule:         "0 4 * * 0",
+			NextScheduleTime: &metav1.Time{Time: time.Now().Add(-time.Minute)},
+		})
+
+		_, _, err := sFnGarbageCollectionConfiguration(context.Background(), nil, s)
+		require.NoError(t, err)
+
+		require.False(t, s.garbageCollectionDue)
+		require.Equal(t, "0 3 * * *", s.instance.Status.GarbageCollection.Schedule)
+		require.True(t, s.instance.Status.GarbageCollection.NextScheduleTime.After(time.Now()))
+	})
+
+	t.Run("warn about invalid schedule", func(t *testing.T) {
+		s := fixGarbageCollectionSystemState(&v1alpha1.GarbageCollection{Schedule: "every sunday"}, nil)
+
+		_, _, err := sFnGarbageCollectionConfiguration(context.Background(), nil, s)
+		require.NoError(t, err)
+
+		require.Contains(t, s.warningBuilder.Build(),
+			"failed to set garbage collection configuration: schedule 'every sunday' is not a valid cron expression")
+		require.Nil(t, s.instance.Status.GarbageCollection)
+	})
+
+	t.Run("warn about failed run", func(t *testing.T) {
+		s := fixGarbageCollectionSystemState(&v1alpha1.GarbageCollection{Schedule: "0 3 * * *"}, &v1alpha1.GarbageCollectionStatus{
+			Schedule:         "0 3 * * *",
+			NextScheduleTime: &metav1.Time{Time: time.Now().Add(time.Hour)},
+			Result:           v1alpha1.GarbageCollectionFailed,
+			Message:          "storage unreachable",
+		})
+
+		_, _, err := sFnGarbageCollectionConfiguration(context.Background(), nil, s)
+		require.NoError(t, err)
+
+		require.Equal(t, "Warning: last garbage collection failed: storage unreachable", s.warningBuilder.Build())
+	})
+}
+
+func Test_sFnGarbageCollection(t *testing.T) {
+	t.Run("start job when run is due", func(t *testing.T) {
+		s := fixGarbageCollectionSystemState(&v1alpha1.GarbageCollection{Schedule: "0 3 * * *", DeleteUntagged: true}, &v1alpha1.GarbageCollectionStatus{
+			Schedule:         "0 3 * * *",
+			NextScheduleTime: &metav1.Time{Time: time.Now().Add(-time.Minute)},
+			Result:           v1alpha1.GarbageCollectionSucceeded,
+			FreedBytes:       ptr.To[int64](1024),
+		})
+		s.garbageCollectionDue = true
+		c := fake.NewClientBuilder().WithObjects(fixGarbageCollectionDeployment()).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+
+		next, result, err := sFnGarbageCollection(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnStorageMigration, next)
+
+		status := s.instance.Status.GarbageCollection
+		require.Equal(t, v1alpha1.GarbageCollectionRunning, status.Result)
+		require.NotNil(t, status.LastScheduleTime)
+		require.True(t, status.NextScheduleTime.After(time.Now()))
+		require.Nil(t, status.FreedBytes)
+		require.Equal(t, garbageCollectionPollInterval, s.retryAfter)
+
+		job := &batchv1.Job{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: status.JobName, Namespace: "docker-registry"}, job))
+		require.Equal(t, "test", job.GetOwnerReferences()[0].Name)
+		require.Contains(t, job.Spec.Template.Spec.Containers[0].Command[2], "--delete-untagged")
+	})
+
+	t.Run("wait for next run", func(t *testing.T) {
+		nextRun := time.Now().Add(time.Hour)
+		s := fixGarbageCollectionSystemState(&v1alpha1.GarbageCollection{Schedule: "0 3 * * *"}, &v1alpha1.GarbageCollectionStatus{
+			Schedule:         "0 3 * * *",
+			NextScheduleTime: &metav1.Time{Time: nextRun},
+		})
+		r := &reconciler{k8s: k8s{client: fake.NewClientBuilder().Build()}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnGarbageCollection(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.InDelta(t, time.Hour, s.retryAfter, float64(time.Minute))
+	})
+
+	t.Run("poll running job", func(t *testing.T) {
+		s := fixGarbageCollectionSystemState(&v1alpha1.GarbageCollection{Schedule: "0 3 * * *"}, &v1alpha1.GarbageCollectionStatus{
+			JobName: "dockerregistry-gc-1",
+			Result:  v1alpha1.GarbageCollectionRunning,
+		})
+		c := fake.NewClientBuilder().WithObjects(fixGarbageCollectionJob(nil)).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnGarbageCollection(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Equal(t, v1alpha1.GarbageCollectionRunning, s.instance.Status.GarbageCollection.Result)
+		require.Equal(t, garbageCollectionPollInterval, s.retryAfter)
+	})
+
+	t.Run("record result of finished job", func(t *testing.T) {
+		finishedAt := metav1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))
+		s := fixGarbageCollectionSystemState(&v1alpha1.GarbageCollection{Schedule: "0 3 * * *"}, &v1alpha1.GarbageCollectionStatus{
+			JobName: "dockerregistry-gc-1",
+			Result:  v1alpha1.GarbageCollectionRunning,
+		})
+		c := fake.NewClientBuilder().WithObjects(
+			fixGarbageCollectionJob(&batchv1.JobCondition{
+				Type:               batchv1.JobComplete,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: finishedAt,
+			}),
+			fixGarbageCollectionPod("5 blobs marked, 2 blobs and 1 manifests eligible for deletion\nfreed-kilobytes: 10"),
+		).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnGarbageCollection(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Equal(t, &v1alpha1.GarbageCollectionStatus{
+			JobName:          "dockerregistry-gc-1",
+			Result:           v1alpha1.GarbageCollectionSucceeded,
+			LastRunTime:      &finishedAt,
+			FreedBytes:       ptr.To[int64](10 * 1024),
+			DeletedBlobs:     ptr.To[int64](2),
+			DeletedManifests: ptr.To[int64](1),
+		}, s.instance.Status.GarbageCollection)
+		// the registry leaves the read-only mode right away
+		require.Equal(t, time.Second, s.retryAfter)
+	})
+
+	t.Run("record logs of failed job", func(t *testing.T) {
+		s := fixGarbageCollectionSystemState(&v1alpha1.GarbageCollection{Schedule: "0 3 * * *"}, &v1alpha1.GarbageCollectionStatus{
+			JobName: "dockerregistry-gc-1",
+			Result:  v1alpha1.GarbageCollectionRunning,
+		})
+		c := fake.NewClientBuilder().WithObjects(
+			fixGarbageCollectionJob(&batchv1.JobCondition{
+				Type:   batchv1.JobFailed,
+				Status: corev1.ConditionTrue,
+			}),
+			fixGarbageCollectionPod("failed to mark: storage unreachable"),
+		).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnGarbageCollection(context.Background(), r, s)
+		require.NoError(t, err)
+
+		status := s.instance.Status.GarbageCollection
+		require.Equal(t, v1alpha1.GarbageCollectionFailed, status.Result)
+		require.Equal(t, "failed to mark: storage unreachable", status.Message)
+	})
+
+	t.Run("fail run of deleted job", func(t *testing.T) {
+		s := fixGarbageCollectionSystemState(&v1alpha1.GarbageCollection{Schedule: "0 3 * * *"}, &v1alpha1.GarbageCollectionStatus{
+			JobName: "dockerregistry-gc-1",
+			Result:  v1alpha1.GarbageCollectionRunning,
+		})
+		r := &reconciler{k8s: k8s{client: fake.NewClientBuilder().Build()}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnGarbageCollection(context.Background(), r, s)
+		require.NoError(t, err)
+
+		status := s.instance.Status.GarbageCollection
+		require.Equal(t, v1alpha1.GarbageCollectionFailed, status.Result)
+		require.Equal(t, "garbage collection job was deleted before it finished", status.Message)
+	})
+
+	t.Run("stop running job when garbage collection is removed", func(t *testing.T) {
+		s := fixGarbageCollectionSystemState(nil, &v1alpha1.GarbageCollectionStatus{
+			JobName: "dockerregistry-gc-1",
+			Result:  v1alpha1.GarbageCollectionRunning,
+		})
+		c := fake.NewClientBuilder().WithObjects(fixGarbageCollectionJob(nil)).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnGarbageCollection(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Nil(t, s.instance.Status.GarbageCollection)
+		err = c.Get(context.Background(), client.ObjectKey{Name: "dockerregistry-gc-1", Namespace: "docker-registry"}, &batchv1.Job{})
+		require.True(t, apierrors.IsNotFound(err))
+	})
+
+	t.Run("warn when registry deployment is missing", func(t *testing.T) {
+		s := fixGarbageCollectionSystemState(&v1alpha1.GarbageCollection{Schedule: "0 3 * * *"}, &v1alpha1.GarbageCollectionStatus{
+			Schedule:         "0 3 * * *",
+			NextScheduleTime: &metav1.Time{Time: time.Now().Add(-time.Minute)},
+		})
+		s.garbageCollectionDue = true
+		r := &reconciler{k8s: k8s{client: fake.NewClientBuilder().Build()}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnGarbageCollection(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Contains(t, s.warningBuilder.Build(), "failed to run garbage collection: while fetching registry deployment")
+		require.Empty(t, s.instance.Status.GarbageCollection.Result)
+		require.Equal(t, garbageCollectionPollInterval, s.retryAfter)
+	})
+}
+
+func requireReadOnlyFlags(t *testing.T, s *systemState) {
+	expectedFlags, err := flags.NewBuilder().WithReadOnlyMaintenance().Build()
+	require.NoError(t, err)
+	flags, err := s.flagsBuilder.Build()
+	require.NoError(t, err)
+	require.Equal(t, expectedFlags, flags)
+}
+
+func fixGarbageCollectionSystemState(spec *v1alpha1.GarbageCollection, status *v1alpha1.GarbageCollectionStatus) *systemState {
+	return &systemState{
+		instance: v1alpha1.DockerRegistry{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test",
+				Namespace: "docker-registry",
+				UID:       "test-uid",
+			},
+			Spec: v1alpha1.DockerRegistrySpec{
+				GarbageCollection: spec,
+			},
+			Status: v1alpha1.DockerRegistryStatus{
+				GarbageCollection: status,
+			},
+		},
+		flagsBuilder:   flags.NewBuilder(),
+		warningBuilder: warning.NewBuilder(),
+	}
+}
+
+func fixGarbageCollectionDeployment() *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      registry.DeploymentName,
+			Namespace: "docker-registry",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "docker-registry", Image: "registry:3.1.1"}},
+				},
+			},
+		},
+	}
+}
+
+func fixGarbageCollectionJob(condition *batchv1.JobCondition) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dockerregistry-gc-1",
+			Namespace: "docker-registry",
+		},
+	}
+	if condition != nil {
+		job.Status.Conditions = []batchv1.JobCondition{*condition}
+	}
+	return job
+}
+
+func fixGarbageCollectionPod(terminationMessage string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dockerregistry-gc-1-abcde",
+			Namespace: "docker-registry",
+			Labels:    map[string]string{registry.GarbageCollectionLabelKey: "dockerregistry-gc-1"},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name: registry.GarbageCollectionContainerName,
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{Message: terminationMessage},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,151 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	eventReasonTakenOver = "TakenOver"
+)
+
+// sFnHandover passes the registry to the standby DockerRegistry that waits in the same namespace, so that the
+// deleted CR goes away without uninstalling the release the successor keeps serving
+func sFnHandover(ctx context.Context, r *reconciler, s *systemState) (stateFn, *ctrl.Result, error) {
+	if s.instance.Status.Served != v1alpha1.ServedTrue {
+		return nextState(sFnDeleteResources)
+	}
+
+	successor, err := electSuccessor(ctx, r, &s.instance)
+	if err != nil {
+		return stopWithEventualError(err)
+	}
+	if successor == nil {
+		return nextState(sFnDeleteResources)
+	}
+
+	// the chart release and the storage belong to the namespace, only the resources owned by the CR have to move
+	if err := transferOwnership(ctx, r, &s.instance, successor); err != nil {
+		return handoverError(s, err)
+	}
+
+	if err := takeOverStatus(ctx, r, &s.instance, successor); err != nil {
+		return handoverError(s, err)
+	}
+
+	r.log.Infof("handed the registry in namespace %s over to %s", successor.GetNamespace(), successor.GetName())
+	r.Eventf(successor, corev1.EventTypeNormal, eventReasonTakenOver,
+		"Took the registry over from %s, the release, credentials and storage are kept", s.instance.GetName())
+	if !reflect.DeepEqual(s.instance.Spec.Storage, successor.Spec.Storage) {
+		r.Eventf(successor, corev1.EventTypeWarning, eventReasonTakenOver,
+			"Storage configuration differs from %s, images pushed to the previous storage are not served after the takeover", s.instance.GetName())
+	}
+
+	s.setState(v1alpha1.StateDeleting)
+	s.instance.UpdateConditionTrue(
+		v1alpha1.ConditionTypeDeleted,
+		v1alpha1.ConditionReasonHandedOver,
+		fmt.Sprintf("DockerRegistry handed over to %s", successor.GetName()),
+	)
+
+	return nextState(sFnRemoveFinalizer)
+}
+
+// electSuccessor returns the oldest DockerRegistry in the namespace of the instance that is not being deleted
+func electSuccessor(ctx context.Context, r *reconciler, instance *v1alpha1.DockerRegistry) (*v1alpha1.DockerRegistry, error) {
+	list := v1alpha1.DockerRegistryList{}
+	if err := r.client.List(ctx, &list, client.InNamespace(instance.GetNamespace())); err != nil {
+		return nil, errors.Wrap(err, "while listing dockerregistries")
+	}
+
+	candidates := []v1alpha1.DockerRegistry{}
+	for _, item := range list.Items {
+		if item.GetName() == instance.GetName() || !item.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		candidates = append(candidates, item)
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		iTime, jTime := candidates[i].GetCreationTimestamp(), candidates[j].GetCreationTimestamp()
+		if !iTime.Equal(&jTime) {
+			return iTime.Before(&jTime)
+		}
+		return candidates[i].GetName() < candidates[j].GetName()
+	})
+	return &candidates[0], nil
+}
+
+// transferOwnership moves the Secrets, Jobs and the storage migration source the instance controls to the successor,
+// so that the garbage collector does not remove them together with the instance
+func transferOwnership(ctx context.Context, r *reconciler, from, to *v1alpha1.DockerRegistry) error {
+	controllerRef := metav1.NewControllerRef(to, v1alpha1.GroupVersion.WithKind("DockerRegistry"))
+	for _, list := range []client.ObjectList{&corev1.SecretList{}, &batchv1.JobList{}, &corev1.ConfigMapList{}, &appsv1.DeploymentList{}} {
+		if err := r.client.List(ctx, list, client.InNamespace(from.GetNamespace())); err != nil {
+			return errors.Wrap(err, "while listing owned resources")
+		}
+
+		err := meta.EachListItem(list, func(item runtime.Object) error {
+			obj, ok := item.(client.Object)
+			if !ok || !metav1.IsControlledBy(obj, from) {
+				return nil
+			}
+
+			ownerRefs := obj.GetOwnerReferences()
+			for i := range ownerRefs {
+				if ownerRefs[i].UID == from.GetUID() {
+					ownerRefs[i] = *controllerRef
+				}
+			}
+			obj.SetOwnerReferences(ownerRefs)
+			return errors.Wrapf(r.client.Update(ctx, obj), "while transferring ownership of %s", obj.GetName())
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takeOverStatus makes the successor served with the status of the instance, the credentials, rotation and
+// schedules in it stay valid because the successor keeps the same resources
+func takeOverStatus(ctx context.Context, r *reconciler, from, to *v1alpha1.DockerRegistry) error {
+	to.Status = *from.Status.DeepCopy()
+	to.Status.Served = v1alpha1.ServedTrue
+	to.Status.State = v1alpha1.StateProcessing
+	to.Status.ObservedGeneration = 0
+	to.Status.Conditions = nil
+	to.UpdateConditionUnknown(
+		v1alpha1.ConditionTypeConfigured,
+		v1alpha1.ConditionReasonConfiguration,
+		fmt.Sprintf("Taking the registry over from %s", from.GetName()),
+	)
+
+	return errors.Wrap(r.client.Status().Update(ctx, to), "while updating status of the successor")
+}
+
+func handoverError(s *systemState, err error) (stateFn, *ctrl.Result, error) {
+	s.setState(v1alpha1.StateError)
+	s.instance.UpdateConditionFalse(
+		v1alpha1.ConditionTypeDeleted,
+		v1alpha1.ConditionReasonDeletionErr,
+		err,
+	)
+	return stopWithEventualError(err)
+}
@@ -0,0 +1,199 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_sFnHandover(t *testing.T) {
+	created := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+
+	t.Run("delete resources when there is no standby", func(t *testing.T) {
+		instance := fixHandoverDockerRegistry("default", "uid-default", created)
+		instance.Status.Served = v1alpha1.ServedTrue
+		r := fixHandoverReconciler(t, instance)
+		s := &systemState{instance: *instance}
+
+		next, result, err := sFnHandover(context.Background(), r, s)
+
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnDeleteResources, next)
+	})
+
+	t.Run("delete resources of not served instance", func(t *testing.T) {
+		instance := fixHandoverDockerRegistry("default", "uid-default", created)
+		instance.Status.Served = v1alpha1.ServedFalse
+		r := fixHandoverReconciler(t, instance, fixHandoverDockerRegistry("standby", "uid-standby", created))
+		s := &systemState{instance: *instance}
+
+		next, result, err := sFnHandover(context.Background(), r, s)
+
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnDeleteResources, next)
+	})
+
+	t.Run("hand registry over to the oldest standby", func(t *testing.T) {
+		instance := fixHandoverDockerRegistry("default", "uid-default", created)
+		instance.Status = v1alpha1.DockerRegistryStatus{
+			Served: v1alpha1.ServedTrue,
+			State:  v1alpha1.StateReady,
+			Credentials: &v1alpha1.CredentialsStatus{
+				LastRotationTime: &metav1.Time{Time: created},
+			},
+			ObservedGeneration: 3,
+		}
+		instance.UpdateConditionTrue(v1alpha1.ConditionTypeInstalled, v1alpha1.ConditionReasonInstalled, "installed")
+		successor := fixHandoverDockerRegistry("standby-b", "uid-b", created.Add(time.Hour))
+		younger := fixHandoverDockerRegistry("standby-a", "uid-a", created.Add(2*time.Hour))
+		deleted := fixHandoverDockerRegistry("deleted", "uid-deleted", created)
+		deleted.DeletionTimestamp = ptr.To(metav1.Now())
+		deleted.Finalizers = []string{v1alpha1.Finalizer}
+		r := fixHandoverReconciler(t, instance, successor, younger, deleted,
+			fixOwnedSecret("users", instance), fixOwnedSecret("other", deleted), fixOwnedJob("gc", instance),
+			fixOwnedDeployment("dockerregistry-migration-source", instance))
+		s := &systemState{instance: *instance}
+
+		next, result, err := sFnHandover(context.Background(), r, s)
+
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnRemoveFinalizer, next)
+		require.Equal(t, v1alpha1.StateDeleting, s.instance.Status.State)
+		requireContainsCondition(t, s.instance.Status,
+			v1alpha1.ConditionTypeDeleted,
+			metav1.ConditionTrue,
+			v1alpha1.ConditionReasonHandedOver,
+			"DockerRegistry handed over to standby-b",
+		)
+
+		takenOver := &v1alpha1.DockerRegistry{}
+		require.NoError(t, r.client.Get(context.Background(), client.ObjectKeyFromObject(successor), takenOver))
+		require.Equal(t, v1alpha1.ServedTrue, takenOver.Status.Served)
+		require.Equal(t, v1alpha1.StateProcessing, takenOver.Status.State)
+		require.Equal(t, instance.Status.Credentials.LastRotationTime.Unix(), takenOver.Status.Credentials.LastRotationTime.Unix())
+		require.Zero(t, takenOver.Status.ObservedGeneration)
+		require.Len(t, takenOver.Status.Conditions, 1)
+		requireContainsCondition(t, takenOver.Status,
+			v1alpha1.ConditionTypeConfigured,
+			metav1.ConditionUnknown,
+			v1alpha1.ConditionReasonConfiguration,
+			"Taking the registry over from default",
+		)
+
+		secret := &corev1.Secret{}
+		require.NoError(t, r.client.Get(context.Background(), types.NamespacedName{Name: "users", Namespace: "docker-registry"}, secret))
+		require.True(t, metav1.IsControlledBy(secret, successor))
+		require.NoError(t, r.client.Get(context.Background(), types.NamespacedName{Name: "other", Namespace: "docker-registry"}, secret))
+		require.True(t, metav1.IsControlledBy(secret, deleted))
+		job := &batchv1.Job{}
+		require.NoError(t, r.client.Get(context.Background(), types.NamespacedName{Name: "gc", Namespace: "docker-registry"}, job))
+		require.True(t, metav1.IsControlledBy(job, successor))
+		source := &appsv1.Deployment{}
+		require.NoError(t, r.client.Get(context.Background(), types.NamespacedName{Name: "dockerregistry-migration-source", Namespace: "docker-registry"}, source))
+		require.True(t, metav1.IsControlledBy(source, successor))
+
+		events := r.EventRecorder.(*record.FakeRecorder).Events
+		require.Equal(t, "Normal TakenOver Took the registry over from default, the release, credentials and storage are kept", <-events)
+		require.Empty(t, events)
+	})
+
+	t.Run("warn when successor uses another storage", func(t *testing.T) {
+		instance := fixHandoverDockerRegistry("default", "uid-default", created)
+		instance.Status.Served = v1alpha1.ServedTrue
+		successor := fixHandoverDockerRegistry("standby", "uid-standby", created)
+		successor.Spec.Storage = &v1alpha1.Storage{GCS: &v1alpha1.StorageGCS{Bucket: "bucket"}}
+		r := fixHandoverReconciler(t, instance, successor)
+		s := &systemState{instance: *instance}
+
+		_, _, err := sFnHandover(context.Background(), r, s)
+
+		require.NoError(t, err)
+		events := r.EventRecorder.(*record.FakeRecorder).Events
+		<-events
+		require.Equal(t, "Warning TakenOver Storage configuration differs from default, images pushed to the previous storage are not served after the takeover", <-events)
+	})
+}
+
+func fixHandoverReconciler(t *testing.T, objs ...client.Object) *reconciler {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, batchv1.AddToScheme(scheme))
+	require.NoError(t, appsv1.AddToScheme(scheme))
+
+	return &reconciler{
+		k8s: k8s{
+			client: fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(objs...).
+				WithStatusSubresource(&v1alpha1.DockerRegistry{}).
+				Build(),
+			EventRecorder: record.NewFakeRecorder(5),
+		},
+		log: zap.NewNop().Sugar(),
+	}
+}
+
+func fixHandoverDockerRegistry(name string, uid types.UID, created time.Time) *v1alpha1.DockerRegistry {
+	return &v1alpha1.DockerRegistry{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "docker-registry",
+			UID:               uid,
+			CreationTimestamp: metav1.Time{Time: created},
+		},
+	}
+}
+
+func fixOwnedSecret(name string, owner *v1alpha1.DockerRegistry) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: owner.GetNamespace(),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(owner, v1alpha1.GroupVersion.WithKind("DockerRegistry")),
+			},
+		},
+	}
+}
+
+func fixOwnedJob(name string, owner *v1alpha1.DockerRegistry) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: owner.GetNamespace(),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(owner, v1alpha1.GroupVersion.WithKind("DockerRegistry")),
+			},
+		},
+	}
+}
+
+func fixOwnedDeployment(name string, owner *v1alpha1.DockerRegistry) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: owner.GetNamespace(),
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(owner, v1alpha1.GroupVersion.WithKind("DockerRegistry")),
+			},
+		},
+	}
+}
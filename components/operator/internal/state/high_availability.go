@@ -0,0 +1,71 @@
+package state
+
+import (
+	"context"
+	"slices"
+
+	"github.com/kyma-project/docker-registry/components/operator/internal/validation"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	defaultHighAvailabilityReplicas = int32(2)
+	defaultTopologyKey              = corev1.LabelHostname
+)
+
+func sFnHighAvailabilityConfiguration(ctx context.Context, r *reconciler, s *systemState) (stateFn, *ctrl.Result, error) {
+	if s.instance.Spec.HighAvailability != nil {
+		if err := prepareHighAvailability(ctx, r, s); err != nil {
+			// the registry keeps running with a single replica
+			s.warningBuilder.With("failed to set high availability configuration: " + err.Error())
+		}
+	}
+
+	return nextState(sFnStoragePreflight)
+}
+
+func prepareHighAvailability(ctx context.Context, r *reconciler, s *systemState) error {
+	storage := s.instance.Spec.Storage
+	if err := validation.HighAvailabilityStorage(storage); err != nil {
+		return err
+	}
+
+	if storage.PVC != nil {
+		if err := verifyPVCShared(ctx, r, s); err != nil {
+			return err
+		}
+	}
+
+	replicas := defaultHighAvailabilityReplicas
+	if s.instance.Spec.HighAvailability.Replicas != nil {
+		replicas = *s.instance.Spec.HighAvailability.Replicas
+	}
+	topologyKey := defaultTopologyKey
+	if s.instance.Spec.HighAvailability.TopologyKey != nil && *s.instance.Spec.HighAvailability.TopologyKey != "" {
+		topologyKey = *s.instance.Spec.HighAvailability.TopologyKey
+	}
+
+	s.flagsBuilder.WithHighAvailability(replicas, topologyKey)
+	return nil
+}
+
+// verifyPVCShared makes sure all replicas, which may run on different nodes, can mount the PVC
+func verifyPVCShared(ctx context.Context, r *reconciler, s *systemState) error {
+	pvcName := s.instance.Spec.Storage.PVC.Name
+	pvc := corev1.PersistentVolumeClaim{}
+	err := r.client.Get(ctx, types.NamespacedName{
+		Name:      pvcName,
+		Namespace: s.instance.GetNamespace(),
+	}, &pvc)
+	if err != nil {
+		return errors.Wrap(err, "while fetching pvc to verify its access mode")
+	}
+
+	if !slices.Contains(pvc.Spec.AccessModes, corev1.ReadWriteMany) {
+		return errors.Errorf("pvc '%s' can't be shared by the replicas, it does not have the %s access mode", pvcName, corev1.ReadWriteMany)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/flags"
+	"github.com/kyma-project/docker-registry/components/operator/internal/warning"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_sFnHighAvailabilityConfiguration(t *testing.T) {
+	t.Run("skip when high availability is not configured", func(t *testing.T) {
+		s := fixHighAvailabilitySystemState(&v1alpha1.Storage{
+			S3: &v1alpha1.StorageS3{Bucket: "bucket", Region: "region"},
+		}, nil)
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		next, result, err := sFnHighAvailabilityConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnStoragePreflight, next)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+	})
+
+	t.Run("run replicas on object storage with defaults", func(t *testing.T) {
+		s := fixHighAvailabilitySystemState(&v1alpha1.Storage{
+			GCS: &v1alpha1.StorageGCS{Bucket: "bucket"},
+		}, &v1alpha1.HighAvailability{})
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		_, _, err := sFnHighAvailabilityConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		expectedFlags, err := flags.NewBuilder().WithHighAvailability(2, "kubernetes.io/hostname").Build()
+		require.NoError(t, err)
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+		require.Empty(t, s.warningBuilder.Build())
+	})
+
+	t.Run("run replicas on ReadWriteMany pvc", func(t *testing.T) {
+		s := fixHighAvailabilitySystemState(&v1alpha1.Storage{
+			PVC: &v1alpha1.StoragePVC{Name: "shared"},
+		}, &v1alpha1.HighAvailability{
+			Replicas:    ptr.To[int32](3),
+			TopologyKey: ptr.To("topology.kubernetes.io/zone"),
+		})
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().
+				WithObjects(fixPVC("shared", corev1.ReadWriteMany)).
+				Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		_, _, err := sFnHighAvailabilityConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		expectedFlags, err := flags.NewBuilder().WithHighAvailability(3, "topology.kubernetes.io/zone").Build()
+		require.NoError(t, err)
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+	})
+
+	t.Run("keep single replica on filesystem storage", func(t *testing.T) {
+		s := fixHighAvailabilitySystemState(nil, &v1alpha1.HighAvailability{})
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		next, _, err := sFnHighAvailabilityConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		requireEqualFunc(t, sFnStoragePreflight, next)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+		require.Equal(t, "Warning: failed to set high availability configuration: "+
+			"high availability can't be used with the filesystem storage, replicas must share an object storage or a ReadWriteMany pvc",
+			s.warningBuilder.Build())
+	})
+
+	t.Run("keep single replica on ReadWriteOnce pvc", func(t *testing.T) {
+		s := fixHighAvailabilitySystemState(&v1alpha1.Storage{
+			PVC: &v1alpha1.StoragePVC{Name: "single"},
+		}, &v1alpha1.HighAvailability{})
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().
+				WithObjects(fixPVC("single", corev1.ReadWriteOnce)).
+				Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		_, _, err := sFnHighAvailabilityConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+		require.Equal(t, "Warning: failed to set high availability configuration: "+
+			"pvc 'single' can't be shared by the replicas, it does not have the ReadWriteMany access mode",
+			s.warningBuilder.Build())
+	})
+}
+
+func fixHighAvailabilitySystemState(storage *v1alpha1.Storage, highAvailability *v1alpha1.HighAvailability) *systemState {
+	return &systemState{
+		instance: v1alpha1.DockerRegistry{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "docker-registry",
+			},
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage:          storage,
+				HighAvailability: highAvailability,
+			},
+		},
+		flagsBuilder:   flags.NewBuilder(),
+		warningBuilder: warning.NewBuilder(),
+	}
+}
+
+func fixPVC(name string, accessMode corev1.PersistentVolumeAccessMode) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "docker-registry",
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes: []corev1.PersistentVolumeAccessMode{accessMode},
+		},
+	}
+}
@@ -11,10 +11,10 @@ import (
 func sFnInitialize(_ context.Context, _ *reconciler, s *systemState) (stateFn, *ctrl.Result, error) {
 	s.setState(v1alpha1.StateProcessing)
 
-	// in case instance is being deleted and has finalizer - delete all resources
+	// in case instance is being deleted and has finalizer - hand the registry over or delete all resources
 	instanceIsBeingDeleted := !s.instance.GetDeletionTimestamp().IsZero()
 	if instanceIsBeingDeleted {
-		return nextState(sFnDeleteResources)
+		return nextState(sFnHandover)
 	}
 
 	return nextState(sFnAccessConfiguration)
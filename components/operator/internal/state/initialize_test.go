@@ -39,7 +39,7 @@ func Test_sFnInitialize(t *testing.T) {
 		require.Equal(t, v1alpha1.StateProcessing, s.instance.Status.State)
 	})
 
-	t.Run("setup and return next step sFnDeleteResources", func(t *testing.T) {
+	t.Run("setup and return next step sFnHandover", func(t *testing.T) {
 		r := &reconciler{
 			cfg: cfg{
 				finalizer: v1alpha1.Finalizer,
@@ -60,11 +60,11 @@ func Test_sFnInitialize(t *testing.T) {
 			},
 		}
 
-		// setup and return buildSFnDeleteResources
+		// setup and return sFnHandover
 		next, result, err := sFnInitialize(context.Background(), r, s)
 		require.Nil(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnDeleteResources, next)
+		requireEqualFunc(t, sFnHandover, next)
 
 		require.Equal(t, v1alpha1.StateProcessing, s.instance.Status.State)
 	})
@@ -23,6 +23,9 @@ const (
 	S3StorageName         = "s3"
 	FilesystemStorageName = "filesystem"
 	PVCStorageName        = "pvc"
+
+	SecretStorageAuthName           = "secret"
+	WorkloadIdentityStorageAuthName = "workloadIdentity"
 )
 
 func sFnUpdateFinalStatus(ctx context.Context, r *reconciler, s *systemState) (stateFn, *controllerruntime.Result, error) {
@@ -30,6 +33,7 @@ func sFnUpdateFinalStatus(ctx context.Context, r *reconciler, s *systemState) (s
 	if err != nil {
 		return stopWithEventualError(err)
 	}
+	s.instance.Status.ObservedGeneration = s.instance.GetGeneration()
 
 	warning := s.warningBuilder.Build()
 	if warning != "" {
@@ -63,6 +67,8 @@ func updateStatus(ctx context.Context, r *reconciler, s *systemState) error {
 	}
 
 	pvcField := getPVCField(spec.Storage, &s.instance)
+	proxyField := getProxyField(spec.Proxy, &s.instance)
+	authField := getAuthField(spec.Auth, &s.instance)
 
 	externalAddressFields := getExternalAccessFields(ctx, r, s)
 
@@ -77,8 +83,10 @@ func updateStatus(ctx context.Context, r *reconciler, s *systemState) error {
 		{"True", &s.instance.Status.InternalAccess.Enabled, "Internal access enabled", ""},
 		{pulladdress, &s.instance.Status.InternalAccess.PullAddress, "Internal pull address", ""},
 		{pushAddress, &s.instance.Status.InternalAccess.PushAddress, "Internal push address", ""},
-		{registry.InternalAccessSecretName, &s.instance.Status.InternalAccess.SecretName, "Name of secret with registry access data", ""},
+		{s.resourceNames().InternalAccessSecretName, &s.instance.Status.InternalAccess.SecretName, "Name of secret with registry access data", ""},
 		pvcField,
+		proxyField,
+		authField,
 	}...)
 	fields = append(fields, storageFields...)
 
@@ -111,7 +119,7 @@ func getExternalAccessFields(ctx context.Context, r *reconciler, s *systemState)
 		{resolvedAccess.Host, &s.instance.Status.ExternalAccess.PullAddress, "External pull address", ""},
 		{resolvedAccess.Host, &s.instance.Status.ExternalAccess.PushAddress, "External push address", ""},
 		{resolvedAccess.Gateway, &s.instance.Status.ExternalAccess.Gateway, "External gateway namespaced name", ""},
-		{registry.ExternalAccessSecretName, &s.instance.Status.ExternalAccess.SecretName, "Name of secret with registry external access data", ""},
+		{s.resourceNames().ExternalAccessSecretName, &s.instance.Status.ExternalAccess.SecretName, "Name of secret with registry external access data", ""},
 	}
 }
 
@@ -141,9 +149,25 @@ func getStorageFields(ctx context.Context, storage *v1alpha1.Storage, instance *
 	return fieldsToUpdate{
 		{storageName, &instance.Status.Storage, "Storage type", ""},
 		{deleteEnabled, &instance.Status.DeleteEnabled, "Enable image blobs and manifests by digest", ""},
+		getStorageAuthField(storage, instance),
 	}, nil
 }
 
+func getStorageAuthField(storage *v1alpha1.Storage, instance *v1alpha1.DockerRegistry) fieldToUpdate {
+	storageAuth := ""
+	if storage != nil {
+		switch {
+		case storage.Azure != nil && storage.Azure.WorkloadIdentity != nil,
+			storage.S3 != nil && storage.S3.WorkloadIdentity != nil,
+			storage.GCS != nil && storage.GCS.WorkloadIdentity != nil:
+			storageAuth = WorkloadIdentityStorageAuthName
+		case storage.Azure != nil, storage.S3 != nil, storage.GCS != nil, storage.BTPObjectStore != nil:
+			storageAuth = SecretStorageAuthName
+		}
+	}
+	return fieldToUpdate{storageAuth, &instance.Status.StorageAuth, "Storage authentication", ""}
+}
+
 func getPVCField(storage *v1alpha1.Storage, instance *v1alpha1.DockerRegistry) fieldToUpdate {
 	if storage != nil && storage.PVC != nil {
 		return fieldToUpdate{storage.PVC.Name, &instance.Status.PVC, "PVC name", ""}
@@ -151,6 +175,20 @@ func getPVCField(storage *v1alpha1.Storage, instance *v1alpha1.DockerRegistry) f
 	return fieldToUpdate{"", &instance.Status.PVC, "PVC name", ""}
 }
 
+func getProxyField(proxy *v1alpha1.Proxy, instance *v1alpha1.DockerRegistry) fieldToUpdate {
+	if proxy != nil {
+		return fieldToUpdate{proxy.RemoteURL, &instance.Status.Proxy, "Proxy remote URL", ""}
+	}
+	return fieldToUpdate{"", &instance.Status.Proxy, "Proxy remote URL", ""}
+}
+
+func getAuthField(auth *v1alpha1.Auth, instance *v1alpha1.DockerRegistry) fieldToUpdate {
+	if auth != nil && auth.Token != nil {
+		return fieldToUpdate{TokenAuthName, &instance.Status.Auth, "Authentication", ""}
+	}
+	return fieldToUpdate{HtpasswdAuthName, &instance.Status.Auth, "Authentication", ""}
+}
+
 type fieldsToUpdate []fieldToUpdate
 
 type fieldToUpdate struct {
@@ -25,8 +25,9 @@ func Test_sFnConfigurationStatus(t *testing.T) {
 		s := &systemState{
 			instance: v1alpha1.DockerRegistry{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      "test-name",
-					Namespace: "test-namespace",
+					Name:       "test-name",
+					Namespace:  "test-namespace",
+					Generation: 2,
 				},
 				Spec: v1alpha1.DockerRegistrySpec{
 					Storage: &v1alpha1.Storage{
@@ -47,7 +48,7 @@ func Test_sFnConfigurationStatus(t *testing.T) {
 		}
 
 		c := fake.NewClientBuilder().Build()
-		eventRecorder := record.NewFakeRecorder(11)
+		eventRecorder := record.NewFakeRecorder(12)
 		r := &reconciler{log: zap.NewNop().Sugar(), k8s: k8s{client: c, EventRecorder: eventRecorder}}
 		next, result, err := sFnUpdateFinalStatus(context.TODO(), r, s)
 		require.NoError(t, err)
@@ -56,16 +57,18 @@ func Test_sFnConfigurationStatus(t *testing.T) {
 
 		status := s.instance.Status
 		require.Equal(t, "True", status.InternalAccess.Enabled)
-		require.Equal(t, registry.InternalAccessSecretName, status.InternalAccess.SecretName)
+		require.Equal(t, "dockerregistry-config-test-namespace", status.InternalAccess.SecretName)
 		require.Equal(t, "localhost:32137", status.InternalAccess.PullAddress)
 		require.Equal(t, "dockerregistry.test-namespace.svc.cluster.local:5000", status.InternalAccess.PushAddress)
 		require.Equal(t, "True", status.ExternalAccess.Enabled)
-		require.Equal(t, registry.ExternalAccessSecretName, status.ExternalAccess.SecretName)
+		require.Equal(t, "dockerregistry-config-external-test-namespace", status.ExternalAccess.SecretName)
 		require.Equal(t, "registry-test-name-test-namespace.cluster.local", status.ExternalAccess.PushAddress)
 		require.Equal(t, "kyma-system/kyma-gateway", status.ExternalAccess.Gateway)
 		require.Equal(t, "True", status.DeleteEnabled)
 
 		require.Equal(t, FilesystemStorageName, status.Storage)
+		require.Equal(t, HtpasswdAuthName, status.Auth)
+		require.Equal(t, int64(2), status.ObservedGeneration)
 
 		require.Equal(t, v1alpha1.StateReady, status.State)
 		requireContainsCondition(t, status,
@@ -98,7 +101,7 @@ func Test_sFnConfigurationStatus(t *testing.T) {
 
 		s.warningBuilder.With("test warning")
 		c := fake.NewClientBuilder().Build()
-		eventRecorder := record.NewFakeRecorder(11)
+		eventRecorder := record.NewFakeRecorder(12)
 		r := &reconciler{log: zap.NewNop().Sugar(), k8s: k8s{client: c, EventRecorder: eventRecorder}}
 		next, result, err := sFnUpdateFinalStatus(context.TODO(), r, s)
 		require.NoError(t, err)
@@ -113,6 +116,7 @@ func Test_sFnConfigurationStatus(t *testing.T) {
 		require.Equal(t, "False", status.DeleteEnabled)
 
 		require.Equal(t, AzureStorageName, status.Storage)
+		require.Equal(t, SecretStorageAuthName, status.StorageAuth)
 
 		require.Equal(t, v1alpha1.StateWarning, status.State)
 		requireContainsCondition(t, status,
@@ -123,6 +127,45 @@ func Test_sFnConfigurationStatus(t *testing.T) {
 		)
 	})
 
+	t.Run("update status storage workload identity", func(t *testing.T) {
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "test-namespace",
+				},
+				Spec: v1alpha1.DockerRegistrySpec{
+					Storage: &v1alpha1.Storage{
+						S3: &v1alpha1.StorageS3{
+							Bucket:           "bucket",
+							Region:           "region",
+							WorkloadIdentity: &v1alpha1.S3WorkloadIdentity{},
+						},
+					},
+				},
+				Status: v1alpha1.DockerRegistryStatus{
+					StorageAuth: SecretStorageAuthName,
+				},
+			},
+			flagsBuilder:        flags.NewBuilder(),
+			nodePortResolver:    registry.NewNodePortResolver(registry.RandomNodePort),
+			gatewayHostResolver: &testExternalAddressResolver{expectedError: errors.New("test-error")},
+			warningBuilder:      warning.NewBuilder(),
+		}
+
+		eventRecorder := record.NewFakeRecorder(12)
+		r := &reconciler{log: zap.NewNop().Sugar(), k8s: k8s{client: fake.NewClientBuilder().Build(), EventRecorder: eventRecorder}}
+		_, _, err := sFnUpdateFinalStatus(context.TODO(), r, s)
+		require.NoError(t, err)
+
+		require.Equal(t, S3StorageName, s.instance.Status.Storage)
+		require.Equal(t, WorkloadIdentityStorageAuthName, s.instance.Status.StorageAuth)
+		events := []string{}
+		for len(eventRecorder.Events) > 0 {
+			events = append(events, <-eventRecorder.Events)
+		}
+		require.Contains(t, events, "Normal Configuration Storage authentication set from 'secret' to 'workloadIdentity'")
+	})
+
 	t.Run("update status pvc storage configuration", func(t *testing.T) {
 		s := &systemState{
 			instance: v1alpha1.DockerRegistry{
@@ -144,7 +187,7 @@ func Test_sFnConfigurationStatus(t *testing.T) {
 		}
 
 		c := fake.NewClientBuilder().Build()
-		eventRecorder := record.NewFakeRecorder(11)
+		eventRecorder := record.NewFakeRecorder(12)
 		r := &reconciler{log: zap.NewNop().Sugar(), k8s: k8s{client: c, EventRecorder: eventRecorder}}
 		next, result, err := sFnUpdateFinalStatus(context.TODO(), r, s)
 		require.NoError(t, err)
@@ -162,6 +205,57 @@ func Test_sFnConfigurationStatus(t *testing.T) {
 		require.Equal(t, "test-pvc", status.PVC)
 	})
 
+	t.Run("update status proxy configuration", func(t *testing.T) {
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "test-namespace",
+				},
+				Spec: v1alpha1.DockerRegistrySpec{
+					Proxy: &v1alpha1.Proxy{RemoteURL: "https://registry-1.docker.io"},
+				},
+			},
+			flagsBuilder:        flags.NewBuilder(),
+			nodePortResolver:    registry.NewNodePortResolver(registry.RandomNodePort),
+			gatewayHostResolver: &testExternalAddressResolver{expectedError: errors.New("test-error")},
+			warningBuilder:      warning.NewBuilder(),
+		}
+
+		c := fake.NewClientBuilder().Build()
+		eventRecorder := record.NewFakeRecorder(12)
+		r := &reconciler{log: zap.NewNop().Sugar(), k8s: k8s{client: c, EventRecorder: eventRecorder}}
+		_, _, err := sFnUpdateFinalStatus(context.TODO(), r, s)
+		require.NoError(t, err)
+
+		require.Equal(t, "https://registry-1.docker.io", s.instance.Status.Proxy)
+		require.Equal(t, FilesystemStorageName, s.instance.Status.Storage)
+	})
+
+	t.Run("update status token authentication", func(t *testing.T) {
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "test-namespace",
+				},
+				Spec: v1alpha1.DockerRegistrySpec{
+					Auth: &v1alpha1.Auth{Token: &v1alpha1.TokenAuth{}},
+				},
+			},
+			flagsBuilder:        flags.NewBuilder(),
+			nodePortResolver:    registry.NewNodePortResolver(registry.RandomNodePort),
+			gatewayHostResolver: &testExternalAddressResolver{expectedError: errors.New("test-error")},
+			warningBuilder:      warning.NewBuilder(),
+		}
+
+		c := fake.NewClientBuilder().Build()
+		eventRecorder := record.NewFakeRecorder(12)
+		r := &reconciler{log: zap.NewNop().Sugar(), k8s: k8s{client: c, EventRecorder: eventRecorder}}
+		_, _, err := sFnUpdateFinalStatus(context.TODO(), r, s)
+		require.NoError(t, err)
+
+		require.Equal(t, TokenAuthName, s.instance.Status.Auth)
+	})
+
 	t.Run("reconcile from configurationError", func(t *testing.T) {
 		s := &systemState{
 			instance: v1alpha1.DockerRegistry{
@@ -198,7 +292,7 @@ func Test_sFnConfigurationStatus(t *testing.T) {
 			log: zap.NewNop().Sugar(),
 			k8s: k8s{
 				client:        fake.NewClientBuilder().WithObjects(secret).Build(),
-				EventRecorder: record.NewFakeRecorder(11),
+				EventRecorder: record.NewFakeRecorder(12),
 			},
 		}
 
@@ -237,7 +331,7 @@ func Test_sFnConfigurationStatus(t *testing.T) {
 
 		r := &reconciler{
 			log: zap.NewNop().Sugar(),
-			k8s: k8s{client: fake.NewClientBuilder().Build(), EventRecorder: record.NewFakeRecorder(11)},
+			k8s: k8s{client: fake.NewClientBuilder().Build(), EventRecorder: record.NewFakeRecorder(12)},
 		}
 
 		next, result, err := sFnUpdateFinalStatus(context.TODO(), r, s)
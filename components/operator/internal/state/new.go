@@ -7,6 +7,7 @@ import (
 	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
 	"github.com/kyma-project/manager-toolkit/installation/chart"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -17,7 +18,7 @@ type StateReconciler interface {
 	Reconcile(ctx context.Context, v v1alpha1.DockerRegistry) (ctrl.Result, error)
 }
 
-func NewMachine(client client.Client, config *rest.Config, recorder record.EventRecorder, log *zap.SugaredLogger, cache chart.ManifestCache, chartPath string) StateReconciler {
+func NewMachine(client client.Client, config *rest.Config, recorder record.EventRecorder, log *zap.SugaredLogger, cache chart.ManifestCache, chartPath string, tokenServer, storageAdapter types.NamespacedName) StateReconciler {
 	return &reconciler{
 		fn:    sFnServedFilter,
 		cache: cache,
@@ -26,6 +27,12 @@ func NewMachine(client client.Client, config *rest.Config, recorder record.Event
 			finalizer:     v1alpha1.Finalizer,
 			chartPath:     chartPath,
 			managerPodUID: os.Getenv("DOCKERREGISTRY_MANAGER_UID"),
+			managerPod: types.NamespacedName{
+				Name:      os.Getenv("DOCKERREGISTRY_MANAGER_NAME"),
+				Namespace: os.Getenv("POD_NAMESPACE"),
+			},
+			tokenServer:    tokenServer,
+			storageAdapter: storageAdapter,
 		},
 		k8s: k8s{
 			client:        client,
@@ -0,0 +1,57 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/validation"
+	"github.com/pkg/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// defaultProxyTTL is the time distribution keeps the cached content for when no ttl is set
+const defaultProxyTTL = 168 * time.Hour
+
+func sFnProxyConfiguration(ctx context.Context, r *reconciler, s *systemState) (stateFn, *ctrl.Result, error) {
+	if s.instance.Spec.Proxy != nil {
+		if err := prepareProxy(ctx, r, s); err != nil {
+			s.warningBuilder.With("failed to set proxy configuration: " + err.Error())
+			s.setRetryAfter(storageRetryInterval)
+		}
+	}
+
+	return nextState(sFnHighAvailabilityConfiguration)
+}
+
+func prepareProxy(ctx context.Context, r *reconciler, s *systemState) error {
+	proxy := s.instance.Spec.Proxy
+	if err := validation.ProxyRemoteURL(proxy.RemoteURL); err != nil {
+		return err
+	}
+
+	ttl := defaultProxyTTL
+	if proxy.TTL != nil && proxy.TTL.Duration > 0 {
+		ttl = proxy.TTL.Duration
+	}
+
+	var proxySecrets *v1alpha1.ProxySecrets
+	if proxy.SecretName != "" {
+		secret, err := registry.GetSecret(ctx, r.client, proxy.SecretName, s.instance.Namespace)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("while fetching proxy secret from %s", s.instance.Namespace))
+		}
+		proxySecrets = &v1alpha1.ProxySecrets{
+			Username: string(secret.Data["username"]),
+			Password: string(secret.Data["password"]),
+		}
+		if proxySecrets.Username == "" {
+			return errors.Errorf("proxy secret '%s' has no username", proxy.SecretName)
+		}
+	}
+
+	s.flagsBuilder.WithProxy(proxy.RemoteURL, ttl, proxySecrets)
+	return nil
+}
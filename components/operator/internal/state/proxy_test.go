@@ -0,0 +1,162 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/flags"
+	"github.com/kyma-project/docker-registry/components/operator/internal/warning"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_sFnProxyConfiguration(t *testing.T) {
+	t.Run("skip when proxy is not configured", func(t *testing.T) {
+		s := fixProxySystemState(nil)
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		next, result, err := sFnProxyConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnHighAvailabilityConfiguration, next)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+	})
+
+	t.Run("pull anonymously with default ttl", func(t *testing.T) {
+		s := fixProxySystemState(&v1alpha1.Proxy{RemoteURL: "https://registry-1.docker.io"})
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		_, _, err := sFnProxyConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		expectedFlags, err := flags.NewBuilder().WithProxy("https://registry-1.docker.io", 168*time.Hour, nil).Build()
+		require.NoError(t, err)
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+		require.Empty(t, s.warningBuilder.Build())
+	})
+
+	t.Run("pull with credentials from secret", func(t *testing.T) {
+		s := fixProxySystemState(&v1alpha1.Proxy{
+			RemoteURL:  "https://registry-1.docker.io",
+			SecretName: "docker-hub",
+			TTL:        &metav1.Duration{Duration: 24 * time.Hour},
+		})
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().
+				WithObjects(fixProxySecret(map[string][]byte{
+					"username": []byte("user"),
+					"password": []byte("token"),
+				})).
+				Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		_, _, err := sFnProxyConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		expectedFlags, err := flags.NewBuilder().WithProxy("https://registry-1.docker.io", 24*time.Hour, &v1alpha1.ProxySecrets{
+			Username: "user",
+			Password: "token",
+		}).Build()
+		require.NoError(t, err)
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, expectedFlags, flags)
+		require.Empty(t, s.warningBuilder.Build())
+	})
+
+	t.Run("warn and retry when secret is missing", func(t *testing.T) {
+		s := fixProxySystemState(&v1alpha1.Proxy{
+			RemoteURL:  "https://registry-1.docker.io",
+			SecretName: "docker-hub",
+		})
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		_, _, err := sFnProxyConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+		require.Contains(t, s.warningBuilder.Build(), "failed to set proxy configuration: while fetching proxy secret from docker-registry")
+		require.Equal(t, storageRetryInterval, s.retryAfter)
+	})
+
+	t.Run("warn when secret has no username", func(t *testing.T) {
+		s := fixProxySystemState(&v1alpha1.Proxy{
+			RemoteURL:  "https://registry-1.docker.io",
+			SecretName: "docker-hub",
+		})
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().
+				WithObjects(fixProxySecret(map[string][]byte{"token": []byte("token")})).
+				Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		_, _, err := sFnProxyConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Equal(t, "Warning: failed to set proxy configuration: proxy secret 'docker-hub' has no username",
+			s.warningBuilder.Build())
+	})
+
+	t.Run("warn about invalid remote url", func(t *testing.T) {
+		s := fixProxySystemState(&v1alpha1.Proxy{RemoteURL: "registry-1.docker.io"})
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		_, _, err := sFnProxyConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Equal(t, "Warning: failed to set proxy configuration: "+
+			"remote url 'registry-1.docker.io' is not an absolute http or https url",
+			s.warningBuilder.Build())
+	})
+}
+
+func fixProxySystemState(proxy *v1alpha1.Proxy) *systemState {
+	return &systemState{
+		instance: v1alpha1.DockerRegistry{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "docker-registry",
+			},
+			Spec: v1alpha1.DockerRegistrySpec{
+				Proxy: proxy,
+			},
+		},
+		flagsBuilder:   flags.NewBuilder(),
+		warningBuilder: warning.NewBuilder(),
+	}
+}
+
+func fixProxySecret(data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "docker-hub",
+			Namespace: "docker-registry",
+		},
+		Data: data,
+	}
+}
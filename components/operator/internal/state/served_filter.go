@@ -2,9 +2,9 @@ package state
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/validation"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
@@ -19,7 +19,7 @@ func sFnServedFilter(ctx context.Context, r *reconciler, s *systemState) (stateF
 }
 
 func calculateServed(ctx context.Context, r *reconciler, s *systemState) error {
-	servedDockerRegistry, err := GetServedDockerRegistry(ctx, r.k8s.client)
+	servedDockerRegistry, err := GetServedDockerRegistry(ctx, r.k8s.client, s.instance.GetNamespace())
 	if err != nil {
 		return err
 	}
@@ -35,9 +35,7 @@ func setServed(servedDockerRegistry *v1alpha1.DockerRegistry, s *systemState) er
 
 	s.setServed(v1alpha1.ServedFalse)
 	s.setState(v1alpha1.StateWarning)
-	err := fmt.Errorf(
-		"only one instance of DockerRegistry is allowed (current served instance: %s/%s) - this DockerRegistry CR is redundant - remove it to fix the problem",
-		servedDockerRegistry.GetNamespace(), servedDockerRegistry.GetName())
+	err := validation.Duplicated(servedDockerRegistry)
 	s.instance.UpdateConditionFalse(
 		v1alpha1.ConditionTypeConfigured,
 		v1alpha1.ConditionReasonDuplicated,
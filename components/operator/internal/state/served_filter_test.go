@@ -75,9 +75,38 @@ func Test_sFnServedFilter(t *testing.T) {
 		require.Equal(t, v1alpha1.ServedTrue, s.instance.Status.Served)
 	})
 
-	t.Run("set served value from nil to false and set condition to error when there is at lease one served dockerregistry on cluster", func(t *testing.T) {
+	t.Run("set served value from nil to true when the served dockerregistry is in another namespace", func(t *testing.T) {
 		s := &systemState{
 			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "tenant",
+				},
+				Status: v1alpha1.DockerRegistryStatus{},
+			},
+		}
+
+		r := &reconciler{
+			k8s: k8s{
+				client: fixClient(t,
+					fixServedDockerRegistry("test-1", "default", v1alpha1.ServedTrue),
+					fixServedDockerRegistry("test-2", "dockerregistry-test", v1alpha1.ServedTrue),
+				),
+			},
+		}
+
+		nextFn, result, err := sFnServedFilter(context.TODO(), r, s)
+		require.Nil(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnAddFinalizer, nextFn)
+		require.Equal(t, v1alpha1.ServedTrue, s.instance.Status.Served)
+	})
+
+	t.Run("set served value from nil to false and set condition to error when there is served dockerregistry in the namespace", func(t *testing.T) {
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "dockerregistry-test",
+				},
 				Status: v1alpha1.DockerRegistryStatus{},
 			},
 		}
@@ -95,7 +124,7 @@ func Test_sFnServedFilter(t *testing.T) {
 
 		nextFn, result, err := sFnServedFilter(context.TODO(), r, s)
 
-		expectedErrorMessage := "only one instance of DockerRegistry is allowed (current served instance: dockerregistry-test/test-2) - this DockerRegistry CR is redundant - remove it to fix the problem"
+		expectedErrorMessage := "only one instance of DockerRegistry per namespace is served (current served instance: dockerregistry-test/test-2) - this DockerRegistry CR is a standby and takes the registry over when the served one is deleted"
 		require.EqualError(t, err, expectedErrorMessage)
 		require.Nil(t, result)
 		require.Nil(t, nextFn)
@@ -8,10 +8,14 @@ import (
 
 	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
 	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/storageprobe"
+	"github.com/kyma-project/docker-registry/components/operator/internal/validation"
 	"github.com/pkg/errors"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // storageRetryInterval is how often the storage configuration is retried when it fails, for example
@@ -23,9 +27,10 @@ func sFnStorageConfiguration(ctx context.Context, r *reconciler, s *systemState)
 	if err != nil {
 		s.warningBuilder.With("failed to set storage configuration: " + err.Error())
 		s.retryAfter = storageRetryInterval
+		s.storagePreflight = storagePreflight{err: err}
 	}
 
-	return nextState(sFnUpdateConfigurationStatus)
+	return nextState(sFnProxyConfiguration)
 }
 
 func prepareStorage(ctx context.Context, r *reconciler, s *systemState) error {
@@ -35,6 +40,9 @@ func prepareStorage(ctx context.Context, r *reconciler, s *systemState) error {
 		if err := prepareStorageUnique(s); err != nil {
 			return err
 		}
+		if err := validation.StorageAuth(s.instance.Spec.Storage); err != nil {
+			return err
+		}
 		s.flagsBuilder.WithPVCDisabled()
 		if s.instance.Spec.Storage.Azure != nil {
 			return prepareAzureStorage(ctx, r, s)
@@ -45,39 +53,28 @@ func prepareStorage(ctx context.Context, r *reconciler, s *systemState) error {
 		} else if s.instance.Spec.Storage.BTPObjectStore != nil {
 			return prepareBTPStorage(ctx, r, s)
 		} else if s.instance.Spec.Storage.PVC != nil {
+			s.storagePreflight = skipStorageProbe("the volume is checked by the registry")
 			return preparePVCStorage(ctx, r, s)
 		}
 	}
 
+	s.storagePreflight = skipStorageProbe("the volume is checked by the registry")
 	s.flagsBuilder.WithFilesystem()
 	return nil
 }
 
 func prepareStorageUnique(s *systemState) error {
 	// make sure only one of the storage options is used
-	storages := 0
-	if s.instance.Spec.Storage.Azure != nil {
-		storages++
-	}
-	if s.instance.Spec.Storage.S3 != nil {
-		storages++
-	}
-	if s.instance.Spec.Storage.GCS != nil {
-		storages++
-	}
-	if s.instance.Spec.Storage.BTPObjectStore != nil {
-		storages++
-	}
-	if s.instance.Spec.Storage.PVC != nil {
-		storages++
-	}
-	if storages > 1 {
-		return errors.New("only one storage option can be used")
-	}
-	return nil
+	return validation.StorageUnique(s.instance.Spec.Storage)
 }
 
 func prepareAzureStorage(ctx context.Context, r *reconciler, s *systemState) error {
+	if workloadIdentity := s.instance.Spec.Storage.Azure.WorkloadIdentity; workloadIdentity != nil {
+		s.flagsBuilder.WithAzureWorkloadIdentity(workloadIdentity)
+		s.storagePreflight = skipStorageProbe(workloadIdentitySkipReason)
+		return nil
+	}
+
 	azureSecret, err := registry.GetSecret(ctx, r.client, s.instance.Spec.Storage.Azure.SecretName, s.instance.Namespace)
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("while fetching azure storage secret from %s", s.instance.Namespace))
@@ -88,10 +85,24 @@ func prepareAzureStorage(ctx context.Context, r *reconciler, s *systemState) err
 		Container:   string(azureSecret.Data["container"]),
 	}
 	s.flagsBuilder.WithAzure(storageAzureSecret)
+	probe, err := storageprobe.NewAzure(storageAzureSecret)
+	s.storagePreflight = probeStorage(probe, err, storageAzureSecret)
 	return nil
 }
 
 func prepareS3Storage(ctx context.Context, r *reconciler, s *systemState) error {
+	if err := validation.S3Options(s.instance.Spec.Storage.S3); err != nil {
+		return err
+	}
+	if workloadIdentity := s.instance.Spec.Storage.S3.WorkloadIdentity; workloadIdentity != nil {
+		s.flagsBuilder.WithS3(s.instance.Spec.Storage.S3, nil)
+		// the EKS Pod Identity associates the role with the ServiceAccount on the AWS side, IRSA needs the annotation
+		if workloadIdentity.RoleARN != "" {
+			s.flagsBuilder.WithWorkloadIdentity(map[string]string{"eks.amazonaws.com/role-arn": workloadIdentity.RoleARN}, nil)
+		}
+		s.storagePreflight = skipStorageProbe(workloadIdentitySkipReason)
+		return nil
+	}
 	s3Secret, err := registry.GetSecret(ctx, r.client, s.instance.Spec.Storage.S3.SecretName, s.instance.Namespace)
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("while fetching s3 storage secret from %s", s.instance.Namespace))
@@ -101,10 +112,18 @@ func prepareS3Storage(ctx context.Context, r *reconciler, s *systemState) error
 		SecretKey: string(s3Secret.Data["secretKey"]),
 	}
 	s.flagsBuilder.WithS3(s.instance.Spec.Storage.S3, storageS3Secret)
+	s.storagePreflight = probeS3Storage(s.instance.Spec.Storage.S3, storageS3Secret)
 	return nil
 }
 
 func prepareGCSStorage(ctx context.Context, r *reconciler, s *systemState) error {
+	if workloadIdentity := s.instance.Spec.Storage.GCS.WorkloadIdentity; workloadIdentity != nil {
+		s.flagsBuilder.WithGCS(s.instance.Spec.Storage.GCS, nil)
+		s.flagsBuilder.WithWorkloadIdentity(map[string]string{"iam.gke.io/gcp-service-account": workloadIdentity.ServiceAccount}, nil)
+		s.storagePreflight = skipStorageProbe(workloadIdentitySkipReason)
+		return nil
+	}
+
 	gcsSecret, err := registry.GetSecret(ctx, r.client, s.instance.Spec.Storage.GCS.SecretName, s.instance.Namespace)
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("while fetching gcs storage secret from %s", s.instance.Namespace))
@@ -113,6 +132,7 @@ func prepareGCSStorage(ctx context.Context, r *reconciler, s *systemState) error
 		AccountKey: string(gcsSecret.Data["accountkey"]),
 	}
 	s.flagsBuilder.WithGCS(s.instance.Spec.Storage.GCS, storageGCSSecret)
+	s.storagePreflight = probeGCSStorage(s.instance.Spec.Storage.GCS, storageGCSSecret)
 	return nil
 }
 
@@ -135,9 +155,9 @@ func prepareBTPStorage(ctx context.Context, r *reconciler, s *systemState) error
 			SecretKey: string(btpSecret.Data["secret_access_key"]),
 		}
 		s.flagsBuilder.WithS3(storage, storageSecret)
+		s.storagePreflight = probeS3Storage(storage, storageSecret)
 	case "azure":
-		// Azure storage uses Azure DNS zone endpoints, which are not supported by distribution
-		return errors.New("Azure storage is not supported for BTPObjectStore")
+		return prepareBTPAzureStorage(ctx, r, s, btpSecret.Data)
 	case "gcp":
 		storage := &v1alpha1.StorageGCS{
 			Bucket: string(btpSecret.Data["bucket"]),
@@ -151,12 +171,101 @@ func prepareBTPStorage(ctx context.Context, r *reconciler, s *systemState) error
 			AccountKey: string(decodedKey),
 		}
 		s.flagsBuilder.WithGCS(storage, storageSecret)
+		s.storagePreflight = probeGCSStorage(storage, storageSecret)
 	default:
 		return errors.New("unknown storage type")
 	}
 	return nil
 }
 
+// prepareBTPAzureStorage points the registry to the storage adapter of the operator, the binding authorizes the
+// requests with a SAS token on a DNS zone endpoint, and the azure storage driver supports neither of them
+func prepareBTPAzureStorage(ctx context.Context, r *reconciler, s *systemState, data map[string][]byte) error {
+	binding, err := registry.ParseBTPAzureBinding(data)
+	if err != nil {
+		return err
+	}
+
+	serviceURL, err := registry.GetStorageAdapterURL(ctx, r.client, r.storageAdapter, s.instance.GetNamespace())
+	if err != nil {
+		return err
+	}
+
+	account, err := ensureStorageAdapterAccount(ctx, r, s)
+	if err != nil {
+		return err
+	}
+
+	s.flagsBuilder.WithAzureStorageAdapter(serviceURL,
+		string(account.Data[registry.StorageAdapterAccountKey]),
+		string(account.Data[registry.StorageAdapterSharedKey]),
+		binding.Container)
+	// the container is probed with the SAS token, the adapter forwards the requests of the registry the same way
+	s.storagePreflight = probeStorage(storageprobe.NewAzureSAS(binding), nil, binding.ContainerURI.String(), binding.SASToken)
+	return nil
+}
+
+// ensureStorageAdapterAccount returns the Secret with the account name the storage adapter recognizes the registry
+// by and the account key the registry signs the requests with, they are generated once and kept in a Secret owned
+// by the CR
+func ensureStorageAdapterAccount(ctx context.Context, r *reconciler, s *systemState) (*v1.Secret, error) {
+	secret := &v1.Secret{}
+	err := r.client.Get(ctx, types.NamespacedName{
+		Name:      s.resourceNames().StorageAdapterSecretName,
+		Namespace: s.instance.GetNamespace(),
+	}, secret)
+	if client.IgnoreNotFound(err) != nil {
+		return nil, errors.Wrap(err, "while fetching storage adapter secret")
+	}
+	found := err == nil
+	if found && len(secret.Data[registry.StorageAdapterAccountKey]) != 0 && len(secret.Data[registry.StorageAdapterSharedKey]) != 0 {
+		return secret, nil
+	}
+
+	accountName := string(secret.Data[registry.StorageAdapterAccountKey])
+	if accountName == "" {
+		if accountName, err = registry.GenerateUsername(); err != nil {
+			return nil, err
+		}
+	}
+	accountKey, err := registry.GenerateStorageAdapterKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if found {
+		// the secrets created before the adapter checked the signatures hold the account name only
+		secret.Data = map[string][]byte{
+			registry.StorageAdapterAccountKey: []byte(accountName),
+			registry.StorageAdapterSharedKey:  []byte(accountKey),
+		}
+		if err := r.client.Update(ctx, secret); err != nil {
+			return nil, errors.Wrap(err, "while updating storage adapter secret")
+		}
+		return secret, nil
+	}
+
+	secret = &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.resourceNames().StorageAdapterSecretName,
+			Namespace: s.instance.GetNamespace(),
+			// the account is useless without the registry, it is removed together with the CR
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(&s.instance, v1alpha1.GroupVersion.WithKind("DockerRegistry")),
+			},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			registry.StorageAdapterAccountKey: []byte(accountName),
+			registry.StorageAdapterSharedKey:  []byte(accountKey),
+		},
+	}
+	if err := r.client.Create(ctx, secret); err != nil {
+		return nil, errors.Wrap(err, "while creating storage adapter secret")
+	}
+	return secret, nil
+}
+
 func preparePVCStorage(ctx context.Context, r *reconciler, s *systemState) error {
 	s.flagsBuilder.WithFilesystem()
 	s.flagsBuilder.WithPVC(s.instance.Spec.Storage.PVC)
@@ -0,0 +1,463 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/storagemigration"
+	"github.com/kyma-project/manager-toolkit/installation/base/resource"
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// storageMigrationPollInterval is how often a running storage migration is checked
+	storageMigrationPollInterval = 30 * time.Second
+	// storageMigrationRetryInterval is how long a failed copy waits before it is started again
+	storageMigrationRetryInterval = 5 * time.Minute
+	// managerContainerName is the container of the operator pod, the migration Job copies the images with its image
+	managerContainerName = "manager"
+)
+
+// sFnStorageMigrationConfiguration starts the migration of the images when the storage of the registry changes. The
+// registry deployment is scaled down while the images are copied, the old storage is served by a read-only copy of
+// it. The new storage is not rolled out when the copy can't be made, the registry would come up without the images.
+// The new storage has passed the preflight check by then, a migration to a storage that can't be written is not
+// started.
+func sFnStorageMigrationConfiguration(ctx context.Context, r *reconciler, s *systemState) (stateFn, *ctrl.Result, error) {
+	if err := configureStorageMigration(ctx, r, s); err != nil {
+		r.log.Warnf("error while starting storage migration: %s", err.Error())
+		s.setState(v1alpha1.StateWarning)
+		s.instance.UpdateConditionFalse(
+			v1alpha1.ConditionTypeStorageMigrated,
+			v1alpha1.ConditionReasonStorageMigrationErr,
+			errors.Wrap(err, "failed to start storage migration"),
+		)
+		return requeueAfter(storageMigrationPollInterval)
+	}
+
+	return nextState(sFnGarbageCollectionConfiguration)
+}
+
+// sFnStorageMigration starts the copy Job once the read-only source and the scaled down registry are rolled out,
+// and switches the registry over to the new storage when the Job verified the copied images
+func sFnStorageMigration(ctx context.Context, r *reconciler, s *systemState) (stateFn, *ctrl.Result, error) {
+	if err := runStorageMigration(ctx, r, s); err != nil {
+		s.warningBuilder.With("storage migration failed: " + err.Error())
+		s.instance.UpdateConditionFalse(
+			v1alpha1.ConditionTypeStorageMigrated,
+			v1alpha1.ConditionReasonStorageMigrationErr,
+			err,
+		)
+		s.setRetryAfter(storageMigrationRetryAfter(err))
+	}
+
+	return nextState(sFnUpdateFinalStatus)
+}
+
+func configureStorageMigration(ctx context.Context, r *reconciler, s *systemState) error {
+	storage := s.instance.Spec.Storage
+	migrate := storage != nil && storage.Migrate
+	target := registry.StorageLocation(storage)
+	status := s.instance.Status.StorageMigration
+
+	if storageMigrationRunning(status) {
+		if !migrate || target == status.Source {
+			cancelStorageMigration(s)
+			return nil
+		}
+		if target != status.Target {
+			// the images are still served from the old storage, the copy starts over to the new target
+			if err := deleteStorageMigrationJob(ctx, r, s); err != nil {
+				return err
+			}
+			startStorageMigration(s, status.Source, target)
+		}
+		s.flagsBuilder.WithStorageMigration(status.Source == registry.StorageLocation(nil))
+		return nil
+	}
+
+	applied := s.instance.Status.StorageLocation
+	if !migrate || applied == "" || applied == target {
+		return nil
+	}
+
+	if err := createStorageMigrationSource(ctx, r, s); err != nil {
+		return err
+	}
+	r.log.Infof("migrating registry storage from %s to %s", applied, target)
+	startStorageMigration(s, applied, target)
+	s.flagsBuilder.WithStorageMigration(applied == registry.StorageLocation(nil))
+	return nil
+}
+
+func startStorageMigration(s *systemState, source, target string) {
+	s.instance.Status.StorageMigration = &v1alpha1.StorageMigrationStatus{
+		Source:    source,
+		Target:    target,
+		Phase:     v1alpha1.StorageMigrationRunning,
+		StartTime: &metav1.Time{Time: time.Now()},
+	}
+	s.instance.UpdateConditionUnknown(
+		v1alpha1.ConditionTypeStorageMigrated,
+		v1alpha1.ConditionReasonStorageMigration,
+		fmt.Sprintf("Copying images from %s to %s, the registry is read-only", source, target),
+	)
+}
+
+// cancelStorageMigration returns the registry to the storage the migration started from, the copy Job and the
+// read-only source are removed once the registry serves the images again
+func cancelStorageMigration(s *systemState) {
+	status := s.instance.Status.StorageMigration
+	status.Phase = v1alpha1.StorageMigrationCanceled
+	status.CompletionTime = &metav1.Time{Time: time.Now()}
+	s.instance.UpdateConditionFalse(
+		v1alpha1.ConditionTypeStorageMigrated,
+		v1alpha1.ConditionReasonStorageMigrationCanceled,
+		errors.Errorf("storage migration from %s to %s was canceled", status.Source, status.Target),
+	)
+}
+
+// createStorageMigrationSource copies the registry deployment and its configuration before the chart replaces them
+// with the new storage
+func createStorageMigrationSource(ctx context.Context, r *reconciler, s *systemState) error {
+	namespace := s.instance.GetNamespace()
+	deployment := &appsv1.Deployment{}
+	err := r.client.Get(ctx, client.ObjectKey{Name: registry.DeploymentName, Namespace: namespace}, deployment)
+	if err != nil {
+		return errors.Wrap(err, "while fetching registry deployment")
+	}
+
+	config := &corev1.ConfigMap{}
+	err = r.client.Get(ctx, client.ObjectKey{Name: registry.RegistryConfigMapName, Namespace: namespace}, config)
+	if err != nil {
+		return errors.Wrap(err, "while fetching registry configuration")
+	}
+
+	var secret *corev1.Secret
+	storageSecret := &corev1.Secret{}
+	err = r.client.Get(ctx, client.ObjectKey{Name: registry.RegistrySecretName, Namespace: namespace}, storageSecret)
+	if client.IgnoreNotFound(err) != nil {
+		return errors.Wrap(err, "while fetching registry storage secret")
+	}
+	if err == nil {
+		secret = storageSecret
+	}
+
+	source, err := registry.NewStorageMigrationSource(deployment, config, secret)
+	if err != nil {
+		return err
+	}
+
+	objects := []client.Object{source.ConfigMap, source.Deployment}
+	if source.Secret != nil {
+		objects = []client.Object{source.Secret, source.ConfigMap, source.Deployment}
+	}
+	for _, obj := range objects {
+		obj.SetOwnerReferences([]metav1.OwnerReference{
+			*metav1.NewControllerRef(&s.instance, v1alpha1.GroupVersion.WithKind("DockerRegistry")),
+		})
+		if err := createOrReplace(ctx, r, obj); err != nil {
+			return errors.Wrapf(err, "while creating storage migration source %s", obj.GetName())
+		}
+	}
+	return nil
+}
+
+// createOrReplace replaces the leftovers of a previous migration, they may hold another storage
+func createOrReplace(ctx context.Context, r *reconciler, obj client.Object) error {
+	err := r.client.Create(ctx, obj)
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	current, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return errors.Errorf("unexpected object %T", obj)
+	}
+	if err := r.client.Get(ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+		return err
+	}
+	obj.SetResourceVersion(current.GetResourceVersion())
+	return r.client.Update(ctx, obj)
+}
+
+func runStorageMigration(ctx context.Context, r *reconciler, s *systemState) error {
+	status := s.instance.Status.StorageMigration
+	if storageMigrationRunning(status) {
+		return checkStorageMigration(ctx, r, s)
+	}
+
+	// the registry serves the storage of the spec, it is where the images are copied from by the next migration
+	s.instance.Status.StorageLocation = registry.StorageLocation(s.instance.Spec.Storage)
+	return removeStorageMigration(ctx, r, s)
+}
+
+func checkStorageMigration(ctx context.Context, r *reconciler, s *systemState) error {
+	status := s.instance.Status.StorageMigration
+	namespace := s.instance.GetNamespace()
+
+	source := &appsv1.Deployment{}
+	err := r.client.Get(ctx, client.ObjectKey{Name: registry.StorageMigrationSourceName, Namespace: namespace}, source)
+	if err != nil {
+		return errors.Wrap(err, "while fetching storage migration source")
+	}
+	if !resource.IsDeploymentReady(*source) {
+		// the old storage is not served yet, the copy would compete with the rollout
+		s.setRetryAfter(storageMigrationPollInterval)
+		return nil
+	}
+
+	if status.JobName == "" {
+		return startStorageMigrationJob(ctx, r, s, source)
+	}
+
+	job := &batchv1.Job{}
+	err = r.client.Get(ctx, client.ObjectKey{Name: status.JobName, Namespace: namespace}, job)
+	if client.IgnoreNotFound(err) != nil {
+		return errors.Wrap(err, "while fetching storage migration job")
+	}
+	if err != nil {
+		r.log.Infof("storage migration job %s/%s is gone, starting it again", namespace, status.JobName)
+		return startStorageMigrationJob(ctx, r, s, source)
+	}
+
+	condition := getJobFinishedCondition(job)
+	if condition == nil {
+		s.setRetryAfter(storageMigrationPollInterval)
+		return nil
+	}
+
+	message, err := getStorageMigrationMessage(ctx, r, job)
+	if err != nil {
+		return err
+	}
+	if condition.Type == batchv1.JobFailed {
+		if message == "" {
+			// the pod was killed, for example because the job timed out
+			message = condition.Message
+		}
+		return retryStorageMigration(ctx, r, s, source, condition.LastTransitionTime.Time, message)
+	}
+
+	summary, err := storagemigration.ParseSummary(message)
+	if err != nil {
+		return retryStorageMigration(ctx, r, s, source, condition.LastTransitionTime.Time, err.Error())
+	}
+
+	r.log.Infof("migrated registry storage from %s to %s: %s", status.Source, status.Target, summary)
+	status.Phase = v1alpha1.StorageMigrationSucceeded
+	status.CompletionTime = &metav1.Time{Time: condition.LastTransitionTime.Time}
+	s.instance.Status.StorageLocation = status.Target
+	s.instance.UpdateConditionTrue(
+		v1alpha1.ConditionTypeStorageMigrated,
+		v1alpha1.ConditionReasonStorageMigrated,
+		fmt.Sprintf("Images migrated from %s to %s: %s", status.Source, status.Target, summary),
+	)
+
+	// the registry is scaled up with the new storage in the next reconciliation
+	s.setRetryAfter(time.Second)
+	return nil
+}
+
+// storageMigrationAttemptErr is returned for a failed copy that is started again once the retry interval has passed
+type storageMigrationAttemptErr struct {
+	retryAfter time.Duration
+	err        error
+}
+
+func (e *storageMigrationAttemptErr) Error() string {
+	return e.err.Error()
+}
+
+func storageMigrationRetryAfter(err error) time.Duration {
+	if attemptErr, ok := err.(*storageMigrationAttemptErr); ok {
+		return attemptErr.retryAfter
+	}
+	return storageMigrationPollInterval
+}
+
+// retryStorageMigration starts the copy again a while after the failed attempt, the images copied by the failed Job
+// are not copied again
+func retryStorageMigration(ctx context.Context, r *reconciler, s *systemState, source *appsv1.Deployment, failedAt time.Time, message string) error {
+	status := s.instance.Status.StorageMigration
+	retryAt := failedAt.Add(storageMigrationRetryInterval)
+	if wait := time.Until(retryAt); wait > 0 {
+		return &storageMigrationAttemptErr{
+			retryAfter: wait,
+			err:        errors.Errorf("attempt %d failed, retrying at %s: %s", status.Attempts, retryAt.UTC().Format(time.RFC3339), message),
+		}
+	}
+
+	return startStorageMigrationJob(ctx, r, s, source)
+}
+
+func startStorageMigrationJob(ctx context.Context, r *reconciler, s *systemState, source *appsv1.Deployment) error {
+	status := s.instance.Status.StorageMigration
+	namespace := s.instance.GetNamespace()
+
+	target := &appsv1.Deployment{}
+	err := r.client.Get(ctx, client.ObjectKey{Name: registry.DeploymentName, Namespace: namespace}, target)
+	if err != nil {
+		return errors.Wrap(err, "while fetching registry deployment")
+	}
+
+	if err := addStorageMigrationTargetConfig(ctx, r, namespace); err != nil {
+		return err
+	}
+
+	image, err := managerImage(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	job, err := registry.NewStorageMigrationJob(target, source, image, registry.StorageMigrationJobName(now))
+	if err != nil {
+		return err
+	}
+	job.SetOwnerReferences([]metav1.OwnerReference{
+		*metav1.NewControllerRef(&s.instance, v1alpha1.GroupVersion.WithKind("DockerRegistry")),
+	})
+
+	if err := r.client.Create(ctx, job); err != nil {
+		return errors.Wrap(err, "while creating storage migration job")
+	}
+	r.log.Infof("started storage migration job %s/%s", job.GetNamespace(), job.GetName())
+
+	status.JobName = job.GetName()
+	status.Attempts++
+	s.instance.UpdateConditionUnknown(
+		v1alpha1.ConditionTypeStorageMigrated,
+		v1alpha1.ConditionReasonStorageMigration,
+		fmt.Sprintf("Copying images from %s to %s in job %s, the registry is read-only", status.Source, status.Target, job.GetName()),
+	)
+
+	s.setRetryAfter(storageMigrationPollInterval)
+	return nil
+}
+
+// addStorageMigrationTargetConfig puts the configuration of the new storage next to the one of the old storage, the
+// target registry of the Job reads it from the source ConfigMap
+func addStorageMigrationTargetConfig(ctx context.Context, r *reconciler, namespace string) error {
+	config := &corev1.ConfigMap{}
+	err := r.client.Get(ctx, client.ObjectKey{Name: registry.RegistryConfigMapName, Namespace: namespace}, config)
+	if err != nil {
+		return errors.Wrap(err, "while fetching registry configuration")
+	}
+
+	targetConfig, err := registry.NewStorageMigrationTargetConfig(config)
+	if err != nil {
+		return err
+	}
+
+	source := &corev1.ConfigMap{}
+	err = r.client.Get(ctx, client.ObjectKey{Name: registry.StorageMigrationSourceName, Namespace: namespace}, source)
+	if err != nil {
+		return errors.Wrap(err, "while fetching storage migration source configuration")
+	}
+	if source.Data == nil {
+		source.Data = map[string]string{}
+	}
+	source.Data[registry.StorageMigrationTargetConfigKey] = targetConfig
+	return errors.Wrap(r.client.Update(ctx, source), "while updating storage migration source configuration")
+}
+
+// managerImage returns the image of the operator, the migration Job runs it to copy the images
+func managerImage(ctx context.Context, r *reconciler) (string, error) {
+	if r.managerPod.Name == "" {
+		return "", errors.New("operator pod is unknown, the DOCKERREGISTRY_MANAGER_NAME environment variable is not set")
+	}
+
+	pod := &corev1.Pod{}
+	if err := r.client.Get(ctx, r.managerPod, pod); err != nil {
+		return "", errors.Wrap(err, "while fetching operator pod")
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == managerContainerName {
+			return container.Image, nil
+		}
+	}
+	return "", errors.Errorf("operator pod %s has no %s container", pod.GetName(), managerContainerName)
+}
+
+// getStorageMigrationMessage returns the termination message of the copy container, it holds the summary of a
+// successful copy or the error of a failed one
+func getStorageMigrationMessage(ctx context.Context, r *reconciler, job *batchv1.Job) (string, error) {
+	pods := &corev1.PodList{}
+	err := r.client.List(ctx, pods,
+		client.InNamespace(job.GetNamespace()),
+		client.MatchingLabels{registry.StorageMigrationLabelKey: job.GetName()},
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "while listing storage migration pods")
+	}
+
+	for _, pod := range pods.Items {
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			terminated := containerStatus.State.Terminated
+			if containerStatus.Name == registry.StorageMigrationContainerName && terminated != nil {
+				return terminated.Message, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func deleteStorageMigrationJob(ctx context.Context, r *reconciler, s *systemState) error {
+	status := s.instance.Status.StorageMigration
+	if status == nil || status.JobName == "" {
+		return nil
+	}
+
+	job := &batchv1.Job{}
+	job.SetName(status.JobName)
+	job.SetNamespace(s.instance.GetNamespace())
+	err := r.client.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	return errors.Wrap(client.IgnoreNotFound(err), "while deleting storage migration job")
+}
+
+// removeStorageMigration removes the read-only source once the registry serves the images from the storage of the
+// spec again, the Job of a successful migration is kept for its logs
+func removeStorageMigration(ctx context.Context, r *reconciler, s *systemState) error {
+	status := s.instance.Status.StorageMigration
+	if status != nil && status.Phase == v1alpha1.StorageMigrationCanceled {
+		if err := deleteStorageMigrationJob(ctx, r, s); err != nil {
+			return err
+		}
+	}
+
+	namespace := s.instance.GetNamespace()
+	source := &appsv1.Deployment{}
+	err := r.client.Get(ctx, client.ObjectKey{Name: registry.StorageMigrationSourceName, Namespace: namespace}, source)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "while fetching storage migration source")
+	}
+
+	for _, obj := range []client.Object{&appsv1.Deployment{}, &corev1.ConfigMap{}, &corev1.Secret{}} {
+		obj.SetName(registry.StorageMigrationSourceName)
+		obj.SetNamespace(namespace)
+		err := r.client.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if client.IgnoreNotFound(err) != nil {
+			return errors.Wrapf(err, "while deleting storage migration source %T", obj)
+		}
+	}
+	r.log.Infof("removed storage migration source %s/%s", namespace, registry.StorageMigrationSourceName)
+	return nil
+}
+
+func storageMigrationRunning(status *v1alpha1.StorageMigrationStatus) bool {
+	return status != nil && status.Phase == v1alpha1.StorageMigrationRunning
+}
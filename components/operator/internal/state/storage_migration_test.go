@@ -0,0 +1,517 @@
+package state
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/flags"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/warning"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_sFnStorageMigrationConfiguration(t *testing.T) {
+	t.Run("start migration when storage changes", func(t *testing.T) {
+		s := fixStorageMigrationSystemState(fixMigratedS3Storage("images"), nil)
+		s.instance.Status.StorageLocation = "filesystem"
+		c := fake.NewClientBuilder().WithObjects(fixStorageMigrationDeployment(registry.DeploymentName), fixRegistryConfigMap()).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+
+		next, result, err := sFnStorageMigrationConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnGarbageCollectionConfiguration, next)
+
+		status := s.instance.Status.StorageMigration
+		require.Equal(t, "filesystem", status.Source)
+		require.Equal(t, "s3:images", status.Target)
+		require.Equal(t, v1alpha1.StorageMigrationRunning, status.Phase)
+		require.NotNil(t, status.StartTime)
+		requireContainsCondition(t, s.instance.Status,
+			v1alpha1.ConditionTypeStorageMigrated,
+			metav1.ConditionUnknown,
+			v1alpha1.ConditionReasonStorageMigration,
+			"Copying images from filesystem to s3:images, the registry is read-only",
+		)
+		requireStorageMigrationFlags(t, s, true)
+
+		source := &appsv1.Deployment{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: registry.StorageMigrationSourceName, Namespace: "docker-registry"}, source))
+		require.Equal(t, "test", source.GetOwnerReferences()[0].Name)
+		config := &corev1.ConfigMap{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: registry.StorageMigrationSourceName, Namespace: "docker-registry"}, config))
+		require.Contains(t, config.Data["config.yml"], "readonly")
+	})
+
+	t.Run("switch storage without migration", func(t *testing.T) {
+		storage := fixMigratedS3Storage("images")
+		storage.Migrate = false
+		s := fixStorageMigrationSystemState(storage, nil)
+		s.instance.Status.StorageLocation = "filesystem"
+		r := &reconciler{k8s: k8s{client: fake.NewClientBuilder().Build()}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnStorageMigrationConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Nil(t, s.instance.Status.StorageMigration)
+		requireNoStorageMigrationFlags(t, s)
+	})
+
+	t.Run("skip migration of unknown storage", func(t *testing.T) {
+		s := fixStorageMigrationSystemState(fixMigratedS3Storage("images"), nil)
+		r := &reconciler{k8s: k8s{client: fake.NewClientBuilder().Build()}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnStorageMigrationConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Nil(t, s.instance.Status.StorageMigration)
+		requireNoStorageMigrationFlags(t, s)
+	})
+
+	t.Run("keep registry scaled down while images are copied", func(t *testing.T) {
+		s := fixStorageMigrationSystemState(fixMigratedS3Storage("images"), fixRunningStorageMigration("s3:old", "s3:images"))
+		s.instance.Status.StorageLocation = "s3:old"
+		r := &reconciler{k8s: k8s{client: fake.NewClientBuilder().Build()}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnStorageMigrationConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Equal(t, "dockerregistry-migration-1", s.instance.Status.StorageMigration.JobName)
+		requireStorageMigrationFlags(t, s, false)
+	})
+
+	t.Run("start copy over when target changes", func(t *testing.T) {
+		s := fixStorageMigrationSystemState(fixMigratedS3Storage("other"), fixRunningStorageMigration("filesystem", "s3:images"))
+		c := fake.NewClientBuilder().WithObjects(fixStorageMigrationJob(nil)).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnStorageMigrationConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		status := s.instance.Status.StorageMigration
+		require.Equal(t, "filesystem", status.Source)
+		require.Equal(t, "s3:other", status.Target)
+		require.Empty(t, status.JobName)
+		require.Zero(t, status.Attempts)
+		requireStorageMigrationFlags(t, s, true)
+		err = c.Get(context.Background(), client.ObjectKey{Name: "dockerregistry-migration-1", Namespace: "docker-registry"}, &batchv1.Job{})
+		require.True(t, apierrors.IsNotFound(err))
+	})
+
+	t.Run("cancel migration when storage is reverted", func(t *testing.T) {
+		s := fixStorageMigrationSystemState(nil, fixRunningStorageMigration("filesystem", "s3:images"))
+		r := &reconciler{k8s: k8s{client: fake.NewClientBuilder().Build()}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnStorageMigrationConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		status := s.instance.Status.StorageMigration
+		require.Equal(t, v1alpha1.StorageMigrationCanceled, status.Phase)
+		require.NotNil(t, status.CompletionTime)
+		requireContainsCondition(t, s.instance.Status,
+			v1alpha1.ConditionTypeStorageMigrated,
+			metav1.ConditionFalse,
+			v1alpha1.ConditionReasonStorageMigrationCanceled,
+			"storage migration from filesystem to s3:images was canceled",
+		)
+		requireNoStorageMigrationFlags(t, s)
+	})
+
+	t.Run("keep old storage when source can't be copied", func(t *testing.T) {
+		s := fixStorageMigrationSystemState(fixMigratedS3Storage("images"), nil)
+		s.instance.Status.StorageLocation = "filesystem"
+		r := &reconciler{k8s: k8s{client: fake.NewClientBuilder().Build()}, log: zap.NewNop().Sugar()}
+
+		next, result, err := sFnStorageMigrationConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, next)
+		require.Equal(t, storageMigrationPollInterval, result.RequeueAfter)
+
+		require.Nil(t, s.instance.Status.StorageMigration)
+		require.Equal(t, v1alpha1.StateWarning, s.instance.Status.State)
+		requireContainsCondition(t, s.instance.Status,
+			v1alpha1.ConditionTypeStorageMigrated,
+			metav1.ConditionFalse,
+			v1alpha1.ConditionReasonStorageMigrationErr,
+			`failed to start storage migration: while fetching registry deployment: deployments.apps "dockerregistry" not found`,
+		)
+	})
+
+	t.Run("postpone garbage collection while images are copied", func(t *testing.T) {
+		s := fixStorageMigrationSystemState(fixMigratedS3Storage("images"), fixRunningStorageMigration("filesystem", "s3:images"))
+		s.instance.Spec.GarbageCollection = &v1alpha1.GarbageCollection{Schedule: "0 3 * * *"}
+		s.instance.Status.GarbageCollection = &v1alpha1.GarbageCollectionStatus{
+			Schedule:         "0 3 * * *",
+			NextScheduleTime: &metav1.Time{Time: time.Now().Add(-time.Minute)},
+		}
+
+		_, _, err := sFnGarbageCollectionConfiguration(context.Background(), nil, s)
+		require.NoError(t, err)
+
+		require.False(t, s.garbageCollectionDue)
+	})
+}
+
+func Test_sFnStorageMigration(t *testing.T) {
+	t.Run("record storage location without migration", func(t *testing.T) {
+		s := fixStorageMigrationSystemState(fixMigratedS3Storage("images"), nil)
+		r := &reconciler{k8s: k8s{client: fake.NewClientBuilder().Build()}, log: zap.NewNop().Sugar()}
+
+		next, result, err := sFnStorageMigration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnUpdateFinalStatus, next)
+
+		require.Equal(t, "s3:images", s.instance.Status.StorageLocation)
+		require.Empty(t, s.warningBuilder.Build())
+	})
+
+	t.Run("wait for source to serve the images", func(t *testing.T) {
+		status := fixRunningStorageMigration("filesystem", "s3:images")
+		status.JobName = ""
+		s := fixStorageMigrationSystemState(fixMigratedS3Storage("images"), status)
+		c := fake.NewClientBuilder().WithObjects(fixStorageMigrationDeployment(registry.StorageMigrationSourceName)).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnStorageMigration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Empty(t, s.instance.Status.StorageMigration.JobName)
+		require.Equal(t, storageMigrationPollInterval, s.retryAfter)
+	})
+
+	t.Run("start job with operator image", func(t *testing.T) {
+		status := fixRunningStorageMigration("filesystem", "s3:images")
+		status.JobName = ""
+		status.Attempts = 0
+		s := fixStorageMigrationSystemState(fixMigratedS3Storage("images"), status)
+		c := fake.NewClientBuilder().WithObjects(
+			fixReadyStorageMigrationSource(),
+			fixStorageMigrationDeployment(registry.DeploymentName),
+			fixRegistryConfigMap(),
+			fixStorageMigrationSourceConfigMap(),
+			fixOperatorPod(),
+		).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+		r.managerPod = types.NamespacedName{Name: "operator", Namespace: "kyma-system"}
+
+		_, _, err := sFnStorageMigration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		status = s.instance.Status.StorageMigration
+		require.NotEmpty(t, status.JobName)
+		require.Equal(t, int32(1), status.Attempts)
+		require.Equal(t, storageMigrationPollInterval, s.retryAfter)
+
+		job := &batchv1.Job{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: status.JobName, Namespace: "docker-registry"}, job))
+		require.Equal(t, "test", job.GetOwnerReferences()[0].Name)
+		require.Equal(t, "dockerregistry-operator:1.0.0", job.Spec.Template.Spec.Containers[0].Image)
+
+		config := &corev1.ConfigMap{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: registry.StorageMigrationSourceName, Namespace: "docker-registry"}, config))
+		require.Contains(t, config.Data[registry.StorageMigrationTargetConfigKey], registry.StorageMigrationTargetAddress)
+	})
+
+	t.Run("switch over when images are copied", func(t *testing.T) {
+		finishedAt := metav1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))
+		s := fixStorageMigrationSystemState(fixMigratedS3Storage("images"), fixRunningStorageMigration("filesystem", "s3:images"))
+		s.instance.Status.StorageLocation = "filesystem"
+		c := fake.NewClientBuilder().WithObjects(
+			fixReadyStorageMigrationSource(),
+			fixStorageMigrationJob(&batchv1.JobCondition{
+				Type:               batchv1.JobComplete,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: finishedAt,
+			}),
+			fixStorageMigrationPod(`{"repositories":2,"tags":3,"manifests":3,"blobs":7,"bytes":2048}`),
+		).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnStorageMigration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		status := s.instance.Status.StorageMigration
+		require.Equal(t, v1alpha1.StorageMigrationSucceeded, status.Phase)
+		require.Equal(t, &finishedAt, status.CompletionTime)
+		require.Equal(t, "s3:images", s.instance.Status.StorageLocation)
+		requireContainsCondition(t, s.instance.Status,
+			v1alpha1.ConditionTypeStorageMigrated,
+			metav1.ConditionTrue,
+			v1alpha1.ConditionReasonStorageMigrated,
+			"Images migrated from filesystem to s3:images: 3 tags of 2 repositories verified, 3 manifests and 7 blobs (2048 bytes) copied",
+		)
+		// the registry is scaled up right away, the source serves the images until it is ready
+		require.Equal(t, time.Second, s.retryAfter)
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: registry.StorageMigrationSourceName, Namespace: "docker-registry"}, &appsv1.Deployment{}))
+	})
+
+	t.Run("report failed copy and retry later", func(t *testing.T) {
+		s := fixStorageMigrationSystemState(fixMigratedS3Storage("images"), fixRunningStorageMigration("filesystem", "s3:images"))
+		c := fake.NewClientBuilder().WithObjects(
+			fixReadyStorageMigrationSource(),
+			fixStorageMigrationJob(&batchv1.JobCondition{
+				Type:               batchv1.JobFailed,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.Now(),
+			}),
+			fixStorageMigrationPod("while uploading blob sha256:layer to repository ci/app: access denied"),
+		).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnStorageMigration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		status := s.instance.Status.StorageMigration
+		require.Equal(t, v1alpha1.StorageMigrationRunning, status.Phase)
+		require.Equal(t, "dockerregistry-migration-1", status.JobName)
+		require.Contains(t, s.warningBuilder.Build(), "storage migration failed: attempt 1 failed, retrying at")
+		condition := requireStorageMigratedCondition(t, s)
+		require.Equal(t, metav1.ConditionFalse, condition.Status)
+		require.Equal(t, string(v1alpha1.ConditionReasonStorageMigrationErr), condition.Reason)
+		require.Contains(t, condition.Message, "access denied")
+		require.InDelta(t, storageMigrationRetryInterval, s.retryAfter, float64(time.Minute))
+	})
+
+	t.Run("start copy again after retry interval", func(t *testing.T) {
+		s := fixStorageMigrationSystemState(fixMigratedS3Storage("images"), fixRunningStorageMigration("filesystem", "s3:images"))
+		c := fake.NewClientBuilder().WithObjects(
+			fixReadyStorageMigrationSource(),
+			fixStorageMigrationDeployment(registry.DeploymentName),
+			fixRegistryConfigMap(),
+			fixStorageMigrationSourceConfigMap(),
+			fixOperatorPod(),
+			fixStorageMigrationJob(&batchv1.JobCondition{
+				Type:               batchv1.JobFailed,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.NewTime(time.Now().Add(-storageMigrationRetryInterval)),
+			}),
+		).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+		r.managerPod = types.NamespacedName{Name: "operator", Namespace: "kyma-system"}
+
+		_, _, err := sFnStorageMigration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		status := s.instance.Status.StorageMigration
+		require.NotEqual(t, "dockerregistry-migration-1", status.JobName)
+		require.Equal(t, int32(2), status.Attempts)
+	})
+
+	t.Run("report unknown operator image", func(t *testing.T) {
+		status := fixRunningStorageMigration("filesystem", "s3:images")
+		status.JobName = ""
+		s := fixStorageMigrationSystemState(fixMigratedS3Storage("images"), status)
+		c := fake.NewClientBuilder().WithObjects(
+			fixReadyStorageMigrationSource(),
+			fixStorageMigrationDeployment(registry.DeploymentName),
+			fixRegistryConfigMap(),
+			fixStorageMigrationSourceConfigMap(),
+		).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnStorageMigration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Contains(t, s.warningBuilder.Build(), "operator pod is unknown")
+		require.Equal(t, storageMigrationPollInterval, s.retryAfter)
+	})
+
+	t.Run("remove source and job of canceled migration", func(t *testing.T) {
+		status := fixRunningStorageMigration("filesystem", "s3:images")
+		status.Phase = v1alpha1.StorageMigrationCanceled
+		s := fixStorageMigrationSystemState(nil, status)
+		c := fake.NewClientBuilder().WithObjects(
+			fixReadyStorageMigrationSource(),
+			fixStorageMigrationSourceConfigMap(),
+			fixStorageMigrationJob(nil),
+		).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnStorageMigration(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Equal(t, "filesystem", s.instance.Status.StorageLocation)
+		key := client.ObjectKey{Name: registry.StorageMigrationSourceName, Namespace: "docker-registry"}
+		require.True(t, apierrors.IsNotFound(c.Get(context.Background(), key, &appsv1.Deployment{})))
+		require.True(t, apierrors.IsNotFound(c.Get(context.Background(), key, &corev1.ConfigMap{})))
+		err = c.Get(context.Background(), client.ObjectKey{Name: "dockerregistry-migration-1", Namespace: "docker-registry"}, &batchv1.Job{})
+		require.True(t, apierrors.IsNotFound(err))
+	})
+}
+
+func requireStorageMigrationFlags(t *testing.T, s *systemState, keepPVC bool) {
+	expectedFlags, err := flags.NewBuilder().WithStorageMigration(keepPVC).Build()
+	require.NoError(t, err)
+	flags, err := s.flagsBuilder.Build()
+	require.NoError(t, err)
+	require.Equal(t, expectedFlags, flags)
+}
+
+func requireNoStorageMigrationFlags(t *testing.T, s *systemState) {
+	flags, err := s.flagsBuilder.Build()
+	require.NoError(t, err)
+	require.Empty(t, flags)
+}
+
+func requireStorageMigratedCondition(t *testing.T, s *systemState) metav1.Condition {
+	for _, condition := range s.instance.Status.Conditions {
+		if condition.Type == string(v1alpha1.ConditionTypeStorageMigrated) {
+			return condition
+		}
+	}
+	require.Fail(t, "StorageMigrated condition is missing")
+	return metav1.Condition{}
+}
+
+func fixMigratedS3Storage(bucket string) *v1alpha1.Storage {
+	return &v1alpha1.Storage{
+		Migrate: true,
+		S3:      &v1alpha1.StorageS3{Bucket: bucket, Region: "eu-central-1"},
+	}
+}
+
+func fixRunningStorageMigration(source, target string) *v1alpha1.StorageMigrationStatus {
+	return &v1alpha1.StorageMigrationStatus{
+		Source:    source,
+		Target:    target,
+		Phase:     v1alpha1.StorageMigrationRunning,
+		StartTime: &metav1.Time{Time: time.Now().Add(-time.Hour)},
+		JobName:   "dockerregistry-migration-1",
+		Attempts:  1,
+	}
+}
+
+func fixStorageMigrationSystemState(storage *v1alpha1.Storage, status *v1alpha1.StorageMigrationStatus) *systemState {
+	return &systemState{
+		instance: v1alpha1.DockerRegistry{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test",
+				Namespace: "docker-registry",
+				UID:       "test-uid",
+			},
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage: storage,
+			},
+			Status: v1alpha1.DockerRegistryStatus{
+				StorageMigration: status,
+			},
+		},
+		flagsBuilder:   flags.NewBuilder(),
+		warningBuilder: warning.NewBuilder(),
+	}
+}
+
+func fixStorageMigrationDeployment(name string) *appsv1.Deployment {
+	labels := map[string]string{"app": "docker-registry", "release": "dockerregistry"}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "docker-registry",
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:         "docker-registry",
+						Image:        "registry:3.1.1",
+						VolumeMounts: []corev1.VolumeMount{{Name: "config", MountPath: "/etc/distribution"}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "config",
+						VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{Name: registry.RegistryConfigMapName},
+						}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func fixReadyStorageMigrationSource() *appsv1.Deployment {
+	deployment := fixStorageMigrationDeployment(registry.StorageMigrationSourceName)
+	deployment.Status.Conditions = []appsv1.DeploymentCondition{
+		{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionTrue, Reason: "MinimumReplicasAvailable"},
+		{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionTrue, Reason: "NewReplicaSetAvailable"},
+	}
+	return deployment
+}
+
+func fixRegistryConfigMap() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      registry.RegistryConfigMapName,
+			Namespace: "docker-registry",
+		},
+		Data: map[string]string{"config.yml": "http:\n  addr: :5000\nstorage:\n  s3:\n    bucket: images\n"},
+	}
+}
+
+func fixStorageMigrationSourceConfigMap() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      registry.StorageMigrationSourceName,
+			Namespace: "docker-registry",
+		},
+		Data: map[string]string{"config.yml": "storage:\n  filesystem: {}\n"},
+	}
+}
+
+func fixOperatorPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "operator",
+			Namespace: "kyma-system",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "manager", Image: "dockerregistry-operator:1.0.0"}},
+		},
+	}
+}
+
+func fixStorageMigrationJob(condition *batchv1.JobCondition) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dockerregistry-migration-1",
+			Namespace: "docker-registry",
+		},
+	}
+	if condition != nil {
+		job.Status.Conditions = []batchv1.JobCondition{*condition}
+	}
+	return job
+}
+
+func fixStorageMigrationPod(terminationMessage string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dockerregistry-migration-1-abcde",
+			Namespace: "docker-registry",
+			Labels:    map[string]string{registry.StorageMigrationLabelKey: "dockerregistry-migration-1"},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name: registry.StorageMigrationContainerName,
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{Message: terminationMessage},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,133 @@
+package state
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/storageprobe"
+	"github.com/pkg/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// storagePreflightTimeout bounds the probe, a storage that does not answer would hold the reconciliation
+	storagePreflightTimeout = 30 * time.Second
+
+	// workloadIdentitySkipReason is reported for the storages the registry authenticates to with its own identity
+	workloadIdentitySkipReason = "the registry authenticates with workload identity, which the operator does not have"
+)
+
+// storagePreflight is what the preflight check probes before the resources are applied
+type storagePreflight struct {
+	// probe is the storage the probe object is written to, it is nil for the storages that are not probed
+	probe storageprobe.Storage
+	// skipReason tells why the storage is not probed
+	skipReason string
+	// checksum identifies the configuration and the credentials of the probed storage
+	checksum string
+	// err is the error the storage configuration failed with, the registry is not rolled out with it
+	err error
+}
+
+func probeStorage(probe storageprobe.Storage, err error, configuration ...any) storagePreflight {
+	return storagePreflight{probe: probe, err: err, checksum: storageChecksum(configuration...)}
+}
+
+func skipStorageProbe(reason string) storagePreflight {
+	return storagePreflight{skipReason: reason}
+}
+
+func probeS3Storage(config *v1alpha1.StorageS3, secret *v1alpha1.StorageS3Secrets) storagePreflight {
+	if config.V4Auth != nil && !*config.V4Auth {
+		return skipStorageProbe("the operator signs the probe requests with the signature version 4 only")
+	}
+	if secret.AccessKey == "" && secret.SecretKey == "" {
+		// the storage driver falls back to the credentials of the node then
+		return skipStorageProbe("the registry authenticates without access keys")
+	}
+
+	probe, err := storageprobe.NewS3(config, secret)
+	return probeStorage(probe, err, config, secret)
+}
+
+func probeGCSStorage(config *v1alpha1.StorageGCS, secret *v1alpha1.StorageGCSSecrets) storagePreflight {
+	key, err := storageprobe.ParseGCSKey(secret.AccountKey)
+	if err != nil {
+		return storagePreflight{err: err}
+	}
+
+	return probeStorage(storageprobe.NewGCS(key, config.Bucket, config.Rootdirectory), nil, config, secret)
+}
+
+// storageChecksum identifies the storage configuration and its credentials, the storage is probed again when it
+// changes
+func storageChecksum(configuration ...any) string {
+	data, _ := json.Marshal(configuration)
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])[:16]
+}
+
+// sFnStoragePreflight writes, reads and deletes a probe object in the storage before a storage migration is started
+// and the resources are applied. A configuration the registry can't store the images with is not rolled out, the
+// registry deployment keeps the previous one until the storage passes the check.
+func sFnStoragePreflight(ctx context.Context, r *reconciler, s *systemState) (stateFn, *ctrl.Result, error) {
+	message, err := checkStorage(ctx, s)
+	if err != nil {
+		r.log.Warnf("storage preflight check failed: %s", err.Error())
+		s.instance.UpdateConditionFalse(
+			v1alpha1.ConditionTypeStorageReady,
+			v1alpha1.ConditionReasonStorageErr,
+			err,
+		)
+		if s.instance.IsConditionTrue(v1alpha1.ConditionTypeInstalled) {
+			// the registry keeps serving the images from the storage it is rolled out with
+			s.setState(v1alpha1.StateWarning)
+		} else {
+			s.setState(v1alpha1.StateError)
+		}
+		// the states reporting the configuration are not reached
+		updateConfiguredCondition(s)
+		return requeueAfter(storageRetryInterval)
+	}
+
+	if message != "" {
+		s.instance.UpdateConditionTrue(
+			v1alpha1.ConditionTypeStorageReady,
+			v1alpha1.ConditionReasonStorageReady,
+			message,
+		)
+	}
+	return nextState(sFnStorageMigrationConfiguration)
+}
+
+// checkStorage returns the message of the passed check, it is empty when the storage passed it with the same
+// configuration before
+func checkStorage(ctx context.Context, s *systemState) (string, error) {
+	preflight := s.storagePreflight
+	location := registry.StorageLocation(s.instance.Spec.Storage)
+	if preflight.err != nil {
+		return "", errors.Wrapf(preflight.err, "storage %s is misconfigured", location)
+	}
+	if preflight.probe == nil {
+		s.instance.Status.StorageChecksum = ""
+		return fmt.Sprintf("Storage %s is not probed, %s", location, preflight.skipReason), nil
+	}
+	if s.instance.Status.StorageChecksum == preflight.checksum && s.instance.IsConditionTrue(v1alpha1.ConditionTypeStorageReady) {
+		return "", nil
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, storagePreflightTimeout)
+	defer cancel()
+	if err := storageprobe.Probe(probeCtx, preflight.probe); err != nil {
+		return "", errors.Wrapf(err, "storage %s failed the preflight check", location)
+	}
+
+	s.instance.Status.StorageChecksum = preflight.checksum
+	return fmt.Sprintf("Probe object written, read and deleted in storage %s", location), nil
+}
@@ -0,0 +1,258 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/flags"
+	"github.com/kyma-project/docker-registry/components/operator/internal/warning"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_sFnStoragePreflight(t *testing.T) {
+	t.Run("probe storage and record checksum", func(t *testing.T) {
+		storage := &fakeProbeStorage{objects: map[string][]byte{}}
+		s := fixStoragePreflightSystemState(probeStorage(storage, nil, "s3-configuration"))
+		r := &reconciler{log: zap.NewNop().Sugar()}
+
+		next, result, err := sFnStoragePreflight(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnStorageMigrationConfiguration, next)
+
+		require.Equal(t, 3, storage.requests)
+		require.Equal(t, storageChecksum("s3-configuration"), s.instance.Status.StorageChecksum)
+		requireContainsCondition(t, s.instance.Status,
+			v1alpha1.ConditionTypeStorageReady,
+			metav1.ConditionTrue,
+			v1alpha1.ConditionReasonStorageReady,
+			"Probe object written, read and deleted in storage s3:images",
+		)
+	})
+
+	t.Run("skip probe of checked configuration", func(t *testing.T) {
+		storage := &fakeProbeStorage{objects: map[string][]byte{}}
+		s := fixStoragePreflightSystemState(probeStorage(storage, nil, "s3-configuration"))
+		s.instance.Status.StorageChecksum = storageChecksum("s3-configuration")
+		s.instance.UpdateConditionTrue(v1alpha1.ConditionTypeStorageReady, v1alpha1.ConditionReasonStorageReady, "checked before")
+		r := &reconciler{log: zap.NewNop().Sugar()}
+
+		next, _, err := sFnStoragePreflight(context.Background(), r, s)
+		require.NoError(t, err)
+		requireEqualFunc(t, sFnStorageMigrationConfiguration, next)
+
+		require.Zero(t, storage.requests)
+		requireContainsCondition(t, s.instance.Status,
+			v1alpha1.ConditionTypeStorageReady,
+			metav1.ConditionTrue,
+			v1alpha1.ConditionReasonStorageReady,
+			"checked before",
+		)
+	})
+
+	t.Run("probe storage again when credentials change", func(t *testing.T) {
+		storage := &fakeProbeStorage{objects: map[string][]byte{}}
+		s := fixStoragePreflightSystemState(probeStorage(storage, nil, "s3-configuration", "rotated-key"))
+		s.instance.Status.StorageChecksum = storageChecksum("s3-configuration")
+		s.instance.UpdateConditionTrue(v1alpha1.ConditionTypeStorageReady, v1alpha1.ConditionReasonStorageReady, "checked before")
+		r := &reconciler{log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnStoragePreflight(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Equal(t, 3, storage.requests)
+		require.Equal(t, storageChecksum("s3-configuration", "rotated-key"), s.instance.Status.StorageChecksum)
+	})
+
+	t.Run("keep installed configuration when storage fails", func(t *testing.T) {
+		storage := &fakeProbeStorage{objects: map[string][]byte{}, putErr: errors.New("unexpected status 404 Not Found: NoSuchBucket: The specified bucket does not exist")}
+		s := fixStoragePreflightSystemState(probeStorage(storage, nil, "s3-configuration"))
+		s.instance.Status.StorageChecksum = storageChecksum("previous-configuration")
+		s.instance.UpdateConditionTrue(v1alpha1.ConditionTypeInstalled, v1alpha1.ConditionReasonInstalled, "installed")
+		r := &reconciler{log: zap.NewNop().Sugar()}
+
+		next, result, err := sFnStoragePreflight(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, next)
+		require.Equal(t, storageRetryInterval, result.RequeueAfter)
+
+		require.Equal(t, v1alpha1.StateWarning, s.instance.Status.State)
+		require.Equal(t, storageChecksum("previous-configuration"), s.instance.Status.StorageChecksum)
+		condition := requireStorageReadyCondition(t, s)
+		require.Equal(t, metav1.ConditionFalse, condition.Status)
+		require.Equal(t, string(v1alpha1.ConditionReasonStorageErr), condition.Reason)
+		require.Regexp(t, "^storage s3:images failed the preflight check: while writing probe object dockerregistry-probe-[0-9a-f]+: "+
+			"unexpected status 404 Not Found: NoSuchBucket: The specified bucket does not exist$", condition.Message)
+	})
+
+	t.Run("do not start migration to storage that fails", func(t *testing.T) {
+		storage := &fakeProbeStorage{objects: map[string][]byte{}, putErr: errors.New("access denied")}
+		s := fixStoragePreflightSystemState(probeStorage(storage, nil, "s3-configuration"))
+		s.instance.Spec.Storage.Migrate = true
+		s.instance.Status.StorageLocation = "pvc:previous-pvc"
+		s.instance.UpdateConditionTrue(v1alpha1.ConditionTypeInstalled, v1alpha1.ConditionReasonInstalled, "installed")
+		r := &reconciler{log: zap.NewNop().Sugar()}
+
+		next, result, err := sFnStoragePreflight(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, next)
+		require.Equal(t, storageRetryInterval, result.RequeueAfter)
+
+		require.Nil(t, s.instance.Status.StorageMigration)
+		for _, condition := range s.instance.Status.Conditions {
+			require.NotEqual(t, string(v1alpha1.ConditionTypeStorageMigrated), condition.Type)
+		}
+		require.True(t, s.instance.IsConditionTrue(v1alpha1.ConditionTypeConfigured))
+	})
+
+	t.Run("report error of first installation", func(t *testing.T) {
+		storage := &fakeProbeStorage{objects: map[string][]byte{}, putErr: errors.New("access denied")}
+		s := fixStoragePreflightSystemState(probeStorage(storage, nil, "s3-configuration"))
+		r := &reconciler{log: zap.NewNop().Sugar()}
+
+		_, result, err := sFnStoragePreflight(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Equal(t, storageRetryInterval, result.RequeueAfter)
+
+		require.Equal(t, v1alpha1.StateError, s.instance.Status.State)
+	})
+
+	t.Run("report storage that is not probed", func(t *testing.T) {
+		s := fixStoragePreflightSystemState(skipStorageProbe(workloadIdentitySkipReason))
+		s.instance.Status.StorageChecksum = storageChecksum("previous-configuration")
+		r := &reconciler{log: zap.NewNop().Sugar()}
+
+		next, _, err := sFnStoragePreflight(context.Background(), r, s)
+		require.NoError(t, err)
+		requireEqualFunc(t, sFnStorageMigrationConfiguration, next)
+
+		require.Empty(t, s.instance.Status.StorageChecksum)
+		requireContainsCondition(t, s.instance.Status,
+			v1alpha1.ConditionTypeStorageReady,
+			metav1.ConditionTrue,
+			v1alpha1.ConditionReasonStorageReady,
+			"Storage s3:images is not probed, the registry authenticates with workload identity, which the operator does not have",
+		)
+	})
+
+	t.Run("report invalid gcs key", func(t *testing.T) {
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "docker-registry"},
+				Spec: v1alpha1.DockerRegistrySpec{
+					Storage: &v1alpha1.Storage{GCS: &v1alpha1.StorageGCS{Bucket: "images", SecretName: "gcs-secret"}},
+				},
+			},
+			flagsBuilder:   flags.NewBuilder(),
+			warningBuilder: warning.NewBuilder(),
+		}
+		c := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "gcs-secret", Namespace: "docker-registry"},
+			Data:       map[string][]byte{"accountkey": []byte(`{"type":"authorized_user"}`)},
+		}).Build()
+		r := &reconciler{k8s: k8s{client: c}, log: zap.NewNop().Sugar()}
+
+		_, _, err := sFnStorageConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		_, result, err := sFnStoragePreflight(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Equal(t, storageRetryInterval, result.RequeueAfter)
+
+		requireContainsCondition(t, s.instance.Status,
+			v1alpha1.ConditionTypeStorageReady,
+			metav1.ConditionFalse,
+			v1alpha1.ConditionReasonStorageErr,
+			"storage gcs:images is misconfigured: gcs key of type 'authorized_user' is not a service account key",
+		)
+	})
+}
+
+func Test_probeS3Storage(t *testing.T) {
+	secret := &v1alpha1.StorageS3Secrets{AccessKey: "access", SecretKey: "secret"}
+
+	t.Run("probe bucket with access keys", func(t *testing.T) {
+		preflight := probeS3Storage(&v1alpha1.StorageS3{Bucket: "images", Region: "eu-central-1"}, secret)
+
+		require.NoError(t, preflight.err)
+		require.NotNil(t, preflight.probe)
+		require.NotEmpty(t, preflight.checksum)
+	})
+
+	t.Run("skip bucket with signature version 2", func(t *testing.T) {
+		preflight := probeS3Storage(&v1alpha1.StorageS3{Bucket: "images", Region: "eu-central-1", V4Auth: ptr.To(false)}, secret)
+
+		require.Nil(t, preflight.probe)
+		require.Equal(t, "the operator signs the probe requests with the signature version 4 only", preflight.skipReason)
+	})
+
+	t.Run("skip bucket without access keys", func(t *testing.T) {
+		preflight := probeS3Storage(&v1alpha1.StorageS3{Bucket: "images", Region: "eu-central-1"}, &v1alpha1.StorageS3Secrets{})
+
+		require.Nil(t, preflight.probe)
+		require.Equal(t, "the registry authenticates without access keys", preflight.skipReason)
+	})
+
+	t.Run("change checksum with credentials", func(t *testing.T) {
+		config := &v1alpha1.StorageS3{Bucket: "images", Region: "eu-central-1"}
+		rotated := &v1alpha1.StorageS3Secrets{AccessKey: "access", SecretKey: "rotated"}
+
+		require.NotEqual(t, probeS3Storage(config, secret).checksum, probeS3Storage(config, rotated).checksum)
+	})
+}
+
+func requireStorageReadyCondition(t *testing.T, s *systemState) metav1.Condition {
+	for _, condition := range s.instance.Status.Conditions {
+		if condition.Type == string(v1alpha1.ConditionTypeStorageReady) {
+			return condition
+		}
+	}
+	require.Fail(t, "StorageReady condition is missing")
+	return metav1.Condition{}
+}
+
+func fixStoragePreflightSystemState(preflight storagePreflight) *systemState {
+	return &systemState{
+		instance: v1alpha1.DockerRegistry{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "docker-registry"},
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage: &v1alpha1.Storage{S3: &v1alpha1.StorageS3{Bucket: "images", Region: "eu-central-1"}},
+			},
+		},
+		flagsBuilder:     flags.NewBuilder(),
+		warningBuilder:   warning.NewBuilder(),
+		storagePreflight: preflight,
+	}
+}
+
+type fakeProbeStorage struct {
+	objects  map[string][]byte
+	requests int
+	putErr   error
+}
+
+func (f *fakeProbeStorage) Put(_ context.Context, name string, content []byte) error {
+	f.requests++
+	if f.putErr != nil {
+		return f.putErr
+	}
+	f.objects[name] = content
+	return nil
+}
+
+func (f *fakeProbeStorage) Get(_ context.Context, name string) ([]byte, error) {
+	f.requests++
+	return f.objects[name], nil
+}
+
+func (f *fakeProbeStorage) Delete(_ context.Context, name string) error {
+	f.requests++
+	delete(f.objects, name)
+	return nil
+}
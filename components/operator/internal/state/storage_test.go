@@ -2,15 +2,19 @@ package state
 
 import (
 	"context"
+	"encoding/base64"
 	"testing"
 
 	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
 	"github.com/kyma-project/docker-registry/components/operator/internal/flags"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
 	"github.com/kyma-project/docker-registry/components/operator/internal/warning"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
@@ -40,7 +44,7 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnUpdateConfigurationStatus, next)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
 
 		flags, err := s.flagsBuilder.Build()
 		require.NoError(t, err)
@@ -108,7 +112,7 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnUpdateConfigurationStatus, next)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
 
 		flags, err := s.flagsBuilder.Build()
 		require.NoError(t, err)
@@ -184,7 +188,7 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnUpdateConfigurationStatus, next)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
 
 		flags, err := s.flagsBuilder.Build()
 		require.NoError(t, err)
@@ -254,7 +258,7 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnUpdateConfigurationStatus, next)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
 
 		flags, err := s.flagsBuilder.Build()
 		require.NoError(t, err)
@@ -328,7 +332,7 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnUpdateConfigurationStatus, next)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
 
 		flags, err := s.flagsBuilder.Build()
 		require.NoError(t, err)
@@ -336,21 +340,32 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 	})
 
 	t.Run("internal registry using btp azure storage", func(t *testing.T) {
-		gcsSecret := &corev1.Secret{
+		btpSecret := &corev1.Secret{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "btpSecret",
 				Namespace: "docker-registry",
 			},
 			Data: map[string][]byte{
 				"account_name":   []byte("accountName"),
-				"sas_token":      []byte("accountKey"),
+				"sas_token":      []byte("sv=2022-11-02&sr=c&sig=c2ln"),
 				"container_name": []byte("container"),
+				"container_uri":  []byte("https://accountName.z13.blob.storage.azure.net/container"),
+			},
+		}
+		storageAdapter := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dockerregistry-storage-adapter",
+				Namespace: "kyma-system",
+			},
+			Spec: corev1.ServiceSpec{
+				Ports: []corev1.ServicePort{{Name: registry.StorageAdapterPortName, Port: 8091}},
 			},
 		}
 
 		s := &systemState{
 			instance: v1alpha1.DockerRegistry{
 				ObjectMeta: metav1.ObjectMeta{
+					Name:      "default",
 					Namespace: "docker-registry",
 				},
 				Spec: v1alpha1.DockerRegistrySpec{
@@ -366,7 +381,159 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 			warningBuilder: warning.NewBuilder(),
 		}
 		r := &reconciler{
-			k8s: k8s{client: fake.NewClientBuilder().WithObjects(gcsSecret).Build()},
+			cfg: cfg{storageAdapter: types.NamespacedName{Name: "dockerregistry-storage-adapter", Namespace: "kyma-system"}},
+			k8s: k8s{client: fake.NewClientBuilder().WithObjects(btpSecret, storageAdapter).Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
+		require.Empty(t, s.warningBuilder.Build())
+
+		adapterSecret := &corev1.Secret{}
+		require.NoError(t, r.client.Get(context.Background(), types.NamespacedName{
+			Name:      "dockerregistry-storage-adapter",
+			Namespace: "docker-registry",
+		}, adapterSecret))
+		accountName := string(adapterSecret.Data[registry.StorageAdapterAccountKey])
+		require.Len(t, accountName, 20)
+		// every registry signs the requests to the adapter with its own key
+		accountKey := string(adapterSecret.Data[registry.StorageAdapterSharedKey])
+		decodedKey, err := base64.StdEncoding.DecodeString(accountKey)
+		require.NoError(t, err)
+		require.Len(t, decodedKey, 64)
+
+		values, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, "azure", values["storage"])
+		require.Equal(t, map[string]interface{}{
+			"serviceURL": "http://dockerregistry-storage-adapter.kyma-system.svc.cluster.local:8091/docker-registry",
+		}, values["azure"])
+		require.Equal(t, map[string]interface{}{
+			"azure": map[string]interface{}{
+				"accountName": accountName,
+				"accountKey":  accountKey,
+				"container":   "container",
+			},
+		}, values["secrets"])
+		require.Equal(t, true, values["configData"].(map[string]interface{})["storage"].(map[string]interface{})["redirect"].(map[string]interface{})["disable"])
+
+		// the account is kept, so that the registry is not restarted on every reconciliation
+		s.flagsBuilder = flags.NewBuilder()
+		_, _, err = sFnStorageConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		nextFlags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, values["rollme"], nextFlags["rollme"])
+	})
+
+	t.Run("add account key to storage adapter secret without one", func(t *testing.T) {
+		btpSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "btpSecret",
+				Namespace: "docker-registry",
+			},
+			Data: map[string][]byte{
+				"sas_token":      []byte("sv=2022-11-02&sr=c&sig=c2ln"),
+				"container_name": []byte("container"),
+				"container_uri":  []byte("https://accountName.z13.blob.storage.azure.net/container"),
+			},
+		}
+		storageAdapter := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dockerregistry-storage-adapter",
+				Namespace: "kyma-system",
+			},
+			Spec: corev1.ServiceSpec{
+				Ports: []corev1.ServicePort{{Name: registry.StorageAdapterPortName, Port: 8091}},
+			},
+		}
+		adapterSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dockerregistry-storage-adapter",
+				Namespace: "docker-registry",
+			},
+			Data: map[string][]byte{
+				registry.StorageAdapterAccountKey: []byte("registryaccount"),
+			},
+		}
+
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "default",
+					Namespace: "docker-registry",
+				},
+				Spec: v1alpha1.DockerRegistrySpec{
+					Storage: &v1alpha1.Storage{
+						BTPObjectStore: &v1alpha1.StorageBTPObjectStore{
+							SecretName: "btpSecret",
+						},
+					},
+				},
+			},
+			statusSnapshot: v1alpha1.DockerRegistryStatus{},
+			flagsBuilder:   flags.NewBuilder(),
+			warningBuilder: warning.NewBuilder(),
+		}
+		r := &reconciler{
+			cfg: cfg{storageAdapter: types.NamespacedName{Name: "dockerregistry-storage-adapter", Namespace: "kyma-system"}},
+			k8s: k8s{client: fake.NewClientBuilder().WithObjects(btpSecret, storageAdapter, adapterSecret).Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		_, _, err := sFnStorageConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Empty(t, s.warningBuilder.Build())
+
+		require.NoError(t, r.client.Get(context.Background(), client.ObjectKeyFromObject(adapterSecret), adapterSecret))
+		require.Equal(t, "registryaccount", string(adapterSecret.Data[registry.StorageAdapterAccountKey]))
+		require.NotEmpty(t, adapterSecret.Data[registry.StorageAdapterSharedKey])
+
+		values, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"accountName": "registryaccount",
+			"accountKey":  string(adapterSecret.Data[registry.StorageAdapterSharedKey]),
+			"container":   "container",
+		}, values["secrets"].(map[string]interface{})["azure"])
+	})
+
+	t.Run("internal registry using btp azure storage without sas token", func(t *testing.T) {
+		btpSecret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "btpSecret",
+				Namespace: "docker-registry",
+			},
+			Data: map[string][]byte{
+				"account_name":   []byte("accountName"),
+				"sas_token":      []byte("sv=2022-11-02"),
+				"container_name": []byte("container"),
+				"container_uri":  []byte("https://accountName.blob.core.windows.net/container"),
+			},
+		}
+
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "docker-registry",
+				},
+				Spec: v1alpha1.DockerRegistrySpec{
+					Storage: &v1alpha1.Storage{
+						BTPObjectStore: &v1alpha1.StorageBTPObjectStore{
+							SecretName: "btpSecret",
+						},
+					},
+				},
+			},
+			statusSnapshot: v1alpha1.DockerRegistryStatus{},
+			flagsBuilder:   flags.NewBuilder(),
+			warningBuilder: warning.NewBuilder(),
+		}
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().WithObjects(btpSecret).Build()},
 			log: zap.NewNop().Sugar(),
 		}
 
@@ -375,9 +542,7 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 		require.Nil(t, result)
 		require.NotNil(t, next)
 
-		// Check that warning was recorded
-		warnings := s.warningBuilder.Build()
-		require.Contains(t, warnings, "Azure storage is not supported for BTPObjectStore")
+		require.Contains(t, s.warningBuilder.Build(), "sas token of the azure binding is not signed")
 	})
 
 	t.Run("internal registry using btp gcs storage", func(t *testing.T) {
@@ -440,7 +605,7 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnUpdateConfigurationStatus, next)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
 
 		flags, err := s.flagsBuilder.Build()
 		require.NoError(t, err)
@@ -499,7 +664,7 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnUpdateConfigurationStatus, next)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
 
 		flags, err := s.flagsBuilder.Build()
 		require.NoError(t, err)
@@ -602,7 +767,7 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 				next, result, err := sFnStorageConfiguration(context.Background(), r, s)
 				require.NoError(t, err)
 				require.Nil(t, result)
-				requireEqualFunc(t, sFnUpdateConfigurationStatus, next)
+				requireEqualFunc(t, sFnProxyConfiguration, next)
 
 				require.Contains(t, s.warningBuilder.Build(), `secrets "missing-secret" not found`)
 				require.Equal(t, storageRetryInterval, s.retryAfter)
@@ -610,6 +775,209 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 		}
 	})
 
+	t.Run("internal registry using s3 storage with workload identity", func(t *testing.T) {
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "docker-registry",
+				},
+				Spec: v1alpha1.DockerRegistrySpec{
+					Storage: &v1alpha1.Storage{
+						S3: &v1alpha1.StorageS3{
+							Bucket: "bucket",
+							Region: "region",
+							WorkloadIdentity: &v1alpha1.S3WorkloadIdentity{
+								RoleARN: "arn:aws:iam::123456789012:role/registry",
+							},
+						},
+					},
+				},
+			},
+			statusSnapshot: v1alpha1.DockerRegistryStatus{},
+			flagsBuilder:   flags.NewBuilder(),
+			warningBuilder: warning.NewBuilder(),
+		}
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
+
+		require.Empty(t, s.warningBuilder.Build())
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.NotContains(t, flags, "secrets")
+		require.Equal(t, map[string]interface{}{
+			"serviceAccountAnnotations": map[string]interface{}{
+				"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789012:role/registry",
+			},
+		}, flags["workloadIdentity"])
+	})
+
+	t.Run("internal registry using gcs storage with workload identity", func(t *testing.T) {
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "docker-registry",
+				},
+				Spec: v1alpha1.DockerRegistrySpec{
+					Storage: &v1alpha1.Storage{
+						GCS: &v1alpha1.StorageGCS{
+							Bucket: "bucket",
+							WorkloadIdentity: &v1alpha1.GCSWorkloadIdentity{
+								ServiceAccount: "registry@project.iam.gserviceaccount.com",
+							},
+						},
+					},
+				},
+			},
+			statusSnapshot: v1alpha1.DockerRegistryStatus{},
+			flagsBuilder:   flags.NewBuilder(),
+			warningBuilder: warning.NewBuilder(),
+		}
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
+
+		require.Empty(t, s.warningBuilder.Build())
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, "gcs", flags["storage"])
+		require.NotContains(t, flags, "secrets")
+		require.Equal(t, map[string]interface{}{
+			"serviceAccountAnnotations": map[string]interface{}{
+				"iam.gke.io/gcp-service-account": "registry@project.iam.gserviceaccount.com",
+			},
+		}, flags["workloadIdentity"])
+	})
+
+	t.Run("internal registry using azure storage with workload identity", func(t *testing.T) {
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "docker-registry",
+				},
+				Spec: v1alpha1.DockerRegistrySpec{
+					Storage: &v1alpha1.Storage{
+						Azure: &v1alpha1.StorageAzure{
+							WorkloadIdentity: &v1alpha1.AzureWorkloadIdentity{
+								ClientID:    "client-id",
+								AccountName: "account",
+								Container:   "container",
+							},
+						},
+					},
+				},
+			},
+			statusSnapshot: v1alpha1.DockerRegistryStatus{},
+			flagsBuilder:   flags.NewBuilder(),
+			warningBuilder: warning.NewBuilder(),
+		}
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
+
+		require.Empty(t, s.warningBuilder.Build())
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.NotContains(t, flags, "secrets")
+		require.Equal(t, map[string]interface{}{
+			"accountName":     "account",
+			"container":       "container",
+			"credentialsType": "default_credentials",
+		}, flags["azure"])
+	})
+
+	t.Run("skip storage with workload identity and secret", func(t *testing.T) {
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "docker-registry",
+				},
+				Spec: v1alpha1.DockerRegistrySpec{
+					Storage: &v1alpha1.Storage{
+						GCS: &v1alpha1.StorageGCS{
+							Bucket:     "bucket",
+							SecretName: "gcsSecret",
+							WorkloadIdentity: &v1alpha1.GCSWorkloadIdentity{
+								ServiceAccount: "registry@project.iam.gserviceaccount.com",
+							},
+						},
+					},
+				},
+			},
+			statusSnapshot: v1alpha1.DockerRegistryStatus{},
+			flagsBuilder:   flags.NewBuilder(),
+			warningBuilder: warning.NewBuilder(),
+		}
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
+
+		require.Contains(t, s.warningBuilder.Build(), "spec.storage.gcs.workloadIdentity: Forbidden: secretName can't be used with workloadIdentity")
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.NotContains(t, flags, "gcs")
+	})
+
+	t.Run("skip s3 storage with invalid options", func(t *testing.T) {
+		s := &systemState{
+			instance: v1alpha1.DockerRegistry{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "docker-registry",
+				},
+				Spec: v1alpha1.DockerRegistrySpec{
+					Storage: &v1alpha1.Storage{
+						S3: &v1alpha1.StorageS3{
+							Bucket: "bucket",
+							Region: "region",
+							KeyID:  "key",
+						},
+					},
+				},
+			},
+			statusSnapshot: v1alpha1.DockerRegistryStatus{},
+			flagsBuilder:   flags.NewBuilder(),
+			warningBuilder: warning.NewBuilder(),
+		}
+		r := &reconciler{
+			k8s: k8s{client: fake.NewClientBuilder().Build()},
+			log: zap.NewNop().Sugar(),
+		}
+
+		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
+
+		require.Contains(t, s.warningBuilder.Build(), "spec.storage.s3.keyID: Invalid value: \"key\": kms key id requires encrypt")
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.NotContains(t, flags, "s3")
+	})
+
 	t.Run("reports Configured false when the s3 storage secret is missing", func(t *testing.T) {
 		s := &systemState{
 			instance: v1alpha1.DockerRegistry{
@@ -638,14 +1006,25 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 		next, result, err := sFnStorageConfiguration(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnUpdateConfigurationStatus, next)
+		requireEqualFunc(t, sFnProxyConfiguration, next)
+
+		// the reported condition is what the CR ends up showing, so the failure has to be reported even
+		// though the failed preflight check stops before the state that reports the configuration status
+		next, result, err = next(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnHighAvailabilityConfiguration, next)
 
-		// the reported condition is what the CR ends up showing, so the failure has to survive the
-		// state that reports the configuration status
 		next, result, err = next(context.Background(), r, s)
 		require.NoError(t, err)
 		require.Nil(t, result)
-		requireEqualFunc(t, sFnApplyResources, next)
+		requireEqualFunc(t, sFnStoragePreflight, next)
+
+		// the registry is not rolled out without its storage
+		next, result, err = next(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, next)
+		require.Equal(t, storageRetryInterval, result.RequeueAfter)
 
 		requireContainsCondition(t, s.instance.Status,
 			v1alpha1.ConditionTypeConfigured,
@@ -653,6 +1032,12 @@ func Test_sFnStorageConfiguration(t *testing.T) {
 			v1alpha1.ConditionReasonConfigurationErr,
 			`Warning: failed to set storage configuration: while fetching s3 storage secret from docker-registry: secrets "missing-secret" not found`,
 		)
+		requireContainsCondition(t, s.instance.Status,
+			v1alpha1.ConditionTypeStorageReady,
+			metav1.ConditionFalse,
+			v1alpha1.ConditionReasonStorageErr,
+			`storage s3:bucket is misconfigured: while fetching s3 storage secret from docker-registry: secrets "missing-secret" not found`,
+		)
 	})
 
 	t.Run("no retry when the storage configuration succeeds", func(t *testing.T) {
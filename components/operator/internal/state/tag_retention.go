@@ -0,0 +1,139 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/retention"
+	"github.com/kyma-project/docker-registry/components/operator/internal/validation"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	defaultRetentionInterval = time.Hour
+	// retentionRetryInterval is how long a retention that could not run is postponed
+	retentionRetryInterval = 5 * time.Minute
+)
+
+// registryAPIFactory creates the client the retention policies are applied through
+type registryAPIFactory func(address, username, password string) retention.Registry
+
+func newRegistryAPI(address, username, password string) retention.Registry {
+	return registry.NewAPIClient(address, username, password)
+}
+
+// sFnTagRetention deletes the tags that are not kept by the retention policies, the blobs of deleted tags are freed
+// by the next garbage collection
+func sFnTagRetention(ctx context.Context, r *reconciler, s *systemState) (stateFn, *ctrl.Result, error) {
+	if err := applyTagRetention(ctx, r, s); err != nil {
+		s.warningBuilder.With("failed to apply tag retention: " + err.Error())
+		s.setRetryAfter(retentionRetryInterval)
+	}
+
+	return nextState(sFnGarbageCollection)
+}
+
+func applyTagRetention(ctx context.Context, r *reconciler, s *systemState) error {
+	spec := s.instance.Spec.Retention
+	if spec == nil {
+		s.instance.Status.Retention = nil
+		return nil
+	}
+
+	if s.garbageCollectionDue || garbageCollectionRunning(s.instance.Status.GarbageCollection) {
+		// the registry is read-only, the retention runs when the garbage collection finishes
+		return nil
+	}
+	if storageMigrationRunning(s.instance.Status.StorageMigration) {
+		// the registry is read-only, the retention runs when the images are migrated
+		return nil
+	}
+
+	now := time.Now()
+	status := s.instance.Status.Retention
+	interval := retentionInterval(spec)
+	if status != nil && status.LastRunTime != nil {
+		if wait := status.LastRunTime.Add(interval).Sub(now); wait > 0 {
+			s.setRetryAfter(wait)
+			return nil
+		}
+	}
+
+	if err := validation.RetentionDeleteEnabled(s.instance.Spec.Storage); err != nil {
+		return err
+	}
+	if err := validation.RetentionProxy(s.instance.Spec.Proxy); err != nil {
+		return err
+	}
+
+	api, err := newRetentionRegistryAPI(ctx, r, s)
+	if err != nil {
+		return err
+	}
+
+	results, applyErr := retention.Apply(ctx, api, spec.Policies, now)
+	updateRetentionStatus(s, results, now, applyErr)
+	emitRetentionEvents(r, s, results)
+	if applyErr != nil {
+		return applyErr
+	}
+
+	s.setRetryAfter(interval)
+	return nil
+}
+
+func newRetentionRegistryAPI(ctx context.Context, r *reconciler, s *systemState) (retention.Registry, error) {
+	secret, err := registry.GetSecret(ctx, r.client, s.resourceNames().InternalAccessSecretName, s.instance.GetNamespace())
+	if err != nil {
+		return nil, errors.Wrap(err, "while fetching internal access secret")
+	}
+
+	address := string(secret.Data["pushRegAddr"])
+	if address == "" {
+		return nil, errors.Errorf("internal access secret %s/%s has no registry address", secret.GetNamespace(), secret.GetName())
+	}
+
+	return s.registryAPI(address, string(secret.Data["username"]), string(secret.Data["password"])), nil
+}
+
+func updateRetentionStatus(s *systemState, results []retention.Result, now time.Time, err error) {
+	status := &v1alpha1.RetentionStatus{
+		LastRunTime: &metav1.Time{Time: now},
+	}
+	for _, result := range results {
+		status.Policies = append(status.Policies, v1alpha1.RetentionPolicyStatus{
+			Name:        result.Policy,
+			DeletedTags: result.DeletedTags,
+		})
+	}
+	if err != nil {
+		status.Message = err.Error()
+	}
+	s.instance.Status.Retention = status
+}
+
+func emitRetentionEvents(r *reconciler, s *systemState, results []retention.Result) {
+	for _, result := range results {
+		if result.DeletedTags == 0 {
+			continue
+		}
+		r.Event(
+			&s.instance,
+			"Normal",
+			"TagRetention",
+			fmt.Sprintf("retention policy %s deleted %d tags", result.Policy, result.DeletedTags),
+		)
+	}
+}
+
+func retentionInterval(spec *v1alpha1.Retention) time.Duration {
+	if spec.Interval == nil || spec.Interval.Duration <= 0 {
+		return defaultRetentionInterval
+	}
+	return spec.Interval.Duration
+}
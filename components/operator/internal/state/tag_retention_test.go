@@ -0,0 +1,220 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/flags"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/kyma-project/docker-registry/components/operator/internal/retention"
+	"github.com/kyma-project/docker-registry/components/operator/internal/warning"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_sFnTagRetention(t *testing.T) {
+	t.Run("clear status when retention is not configured", func(t *testing.T) {
+		s := fixTagRetentionSystemState(nil, &v1alpha1.RetentionStatus{Message: "old"})
+
+		next, result, err := sFnTagRetention(context.Background(), nil, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnGarbageCollection, next)
+		require.Nil(t, s.instance.Status.Retention)
+	})
+
+	t.Run("apply policies and report deletions", func(t *testing.T) {
+		api := &fakeRegistryAPI{
+			tags: []string{"commit-1", "commit-2"},
+			created: map[string]time.Time{
+				"commit-1": time.Now().Add(-2 * time.Hour),
+				"commit-2": time.Now().Add(-time.Hour),
+			},
+		}
+		s := fixTagRetentionSystemState(fixRetention(), nil)
+		s.registryAPI = api.factory
+		eventRecorder := record.NewFakeRecorder(5)
+		r := fixTagRetentionReconciler(eventRecorder, fixInternalAccessSecret())
+
+		next, result, err := sFnTagRetention(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Nil(t, result)
+		requireEqualFunc(t, sFnGarbageCollection, next)
+
+		require.Equal(t, "dockerregistry.docker-registry.svc.cluster.local:5000", api.address)
+		require.Equal(t, "user", api.username)
+		require.Equal(t, "pass", api.password)
+		require.Equal(t, []string{"commit-1"}, api.deleted)
+
+		status := s.instance.Status.Retention
+		require.NotNil(t, status.LastRunTime)
+		require.Equal(t, []v1alpha1.RetentionPolicyStatus{{Name: "ci", DeletedTags: 1}}, status.Policies)
+		require.Empty(t, status.Message)
+		require.Equal(t, time.Hour, s.retryAfter)
+		require.Equal(t, "Normal TagRetention retention policy ci deleted 1 tags", <-eventRecorder.Events)
+	})
+
+	t.Run("wait for next run", func(t *testing.T) {
+		api := &fakeRegistryAPI{}
+		s := fixTagRetentionSystemState(fixRetention(), &v1alpha1.RetentionStatus{
+			LastRunTime: &metav1.Time{Time: time.Now().Add(-30 * time.Minute)},
+		})
+		s.registryAPI = api.factory
+
+		_, _, err := sFnTagRetention(context.Background(), nil, s)
+		require.NoError(t, err)
+
+		require.Empty(t, api.address)
+		require.Greater(t, s.retryAfter, 29*time.Minute)
+		require.LessOrEqual(t, s.retryAfter, 30*time.Minute)
+	})
+
+	t.Run("skip while registry is read-only", func(t *testing.T) {
+		api := &fakeRegistryAPI{}
+		s := fixTagRetentionSystemState(fixRetention(), nil)
+		s.registryAPI = api.factory
+		s.instance.Status.GarbageCollection = &v1alpha1.GarbageCollectionStatus{Result: v1alpha1.GarbageCollectionRunning}
+
+		_, _, err := sFnTagRetention(context.Background(), nil, s)
+		require.NoError(t, err)
+
+		require.Empty(t, api.address)
+		require.Nil(t, s.instance.Status.Retention)
+	})
+
+	t.Run("warn when delete is disabled", func(t *testing.T) {
+		s := fixTagRetentionSystemState(fixRetention(), nil)
+		s.instance.Spec.Storage = nil
+
+		_, _, err := sFnTagRetention(context.Background(), nil, s)
+		require.NoError(t, err)
+
+		require.Equal(t, "Warning: failed to apply tag retention: "+
+			"tag retention requires spec.storage.deleteEnabled, the registry refuses to delete tags otherwise",
+			s.warningBuilder.Build())
+		require.Equal(t, retentionRetryInterval, s.retryAfter)
+	})
+
+	t.Run("record failed run", func(t *testing.T) {
+		api := &fakeRegistryAPI{err: errors.New("unexpected status 503 Service Unavailable")}
+		s := fixTagRetentionSystemState(fixRetention(), nil)
+		s.registryAPI = api.factory
+		r := fixTagRetentionReconciler(record.NewFakeRecorder(5), fixInternalAccessSecret())
+
+		_, _, err := sFnTagRetention(context.Background(), r, s)
+		require.NoError(t, err)
+
+		status := s.instance.Status.Retention
+		require.NotNil(t, status.LastRunTime)
+		require.Equal(t, "unexpected status 503 Service Unavailable", status.Message)
+		require.Contains(t, s.warningBuilder.Build(), "failed to apply tag retention: unexpected status 503 Service Unavailable")
+		require.Equal(t, retentionRetryInterval, s.retryAfter)
+	})
+
+	t.Run("warn when internal access secret is missing", func(t *testing.T) {
+		s := fixTagRetentionSystemState(fixRetention(), nil)
+		r := fixTagRetentionReconciler(record.NewFakeRecorder(5))
+
+		_, _, err := sFnTagRetention(context.Background(), r, s)
+		require.NoError(t, err)
+
+		require.Contains(t, s.warningBuilder.Build(), "failed to apply tag retention: while fetching internal access secret")
+		require.Nil(t, s.instance.Status.Retention)
+	})
+}
+
+type fakeRegistryAPI struct {
+	address  string
+	username string
+	password string
+	tags     []string
+	created  map[string]time.Time
+	deleted  []string
+	err      error
+}
+
+func (f *fakeRegistryAPI) factory(address, username, password string) retention.Registry {
+	f.address = address
+	f.username = username
+	f.password = password
+	return f
+}
+
+func (f *fakeRegistryAPI) Repositories(_ context.Context) ([]string, error) {
+	return []string{"ci/app"}, f.err
+}
+
+func (f *fakeRegistryAPI) Tags(_ context.Context, _ string) ([]string, error) {
+	return f.tags, nil
+}
+
+func (f *fakeRegistryAPI) TagCreated(_ context.Context, _, tag string) (time.Time, error) {
+	return f.created[tag], nil
+}
+
+func (f *fakeRegistryAPI) DeleteTag(_ context.Context, _, tag string) error {
+	f.deleted = append(f.deleted, tag)
+	return nil
+}
+
+func fixRetention() *v1alpha1.Retention {
+	return &v1alpha1.Retention{
+		Policies: []v1alpha1.RetentionPolicy{
+			{Name: "ci", KeepLast: ptr.To[int32](1)},
+		},
+	}
+}
+
+func fixTagRetentionSystemState(spec *v1alpha1.Retention, status *v1alpha1.RetentionStatus) *systemState {
+	return &systemState{
+		instance: v1alpha1.DockerRegistry{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test",
+				Namespace: registry.BaseNamespace,
+			},
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage:   &v1alpha1.Storage{DeleteEnabled: true},
+				Retention: spec,
+			},
+			Status: v1alpha1.DockerRegistryStatus{
+				Retention: status,
+			},
+		},
+		flagsBuilder:   flags.NewBuilder(),
+		warningBuilder: warning.NewBuilder(),
+		registryAPI:    newRegistryAPI,
+	}
+}
+
+func fixTagRetentionReconciler(eventRecorder record.EventRecorder, objs ...*corev1.Secret) *reconciler {
+	builder := fake.NewClientBuilder()
+	for _, obj := range objs {
+		builder = builder.WithObjects(obj)
+	}
+	return &reconciler{
+		log: zap.NewNop().Sugar(),
+		k8s: k8s{client: builder.Build(), EventRecorder: eventRecorder},
+	}
+}
+
+func fixInternalAccessSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      registry.InternalAccessSecretName,
+			Namespace: registry.BaseNamespace,
+		},
+		Data: map[string][]byte{
+			"username":    []byte("user"),
+			"password":    []byte("pass"),
+			"pushRegAddr": []byte("dockerregistry.docker-registry.svc.cluster.local:5000"),
+		},
+	}
+}
@@ -0,0 +1,93 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// setUsersConfig renders the users listed in the CR into htpasswd entries the registry loads next to the ones of
+// the access Secrets. The entries are kept in a Secret owned by the CR, an entry is hashed again only when the
+// password of the user changes, so that the registry is not rolled on every reconciliation
+func setUsersConfig(ctx context.Context, r *reconciler, s *systemState) error {
+	secretKey := client.ObjectKey{
+		Name:      s.resourceNames().UsersSecretName,
+		Namespace: s.instance.GetNamespace(),
+	}
+
+	if len(s.instance.Spec.Users) == 0 {
+		// the removed users must not stay valid
+		return deleteSecret(ctx, r, secretKey)
+	}
+
+	secret := &corev1.Secret{}
+	err := r.client.Get(ctx, secretKey, secret)
+	if apierrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      secretKey.Name,
+				Namespace: secretKey.Namespace,
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(&s.instance, v1alpha1.GroupVersion.WithKind("DockerRegistry")),
+				},
+			},
+			Type: corev1.SecretTypeOpaque,
+		}
+	} else if err != nil {
+		return errors.Wrap(err, "while fetching users secret")
+	}
+
+	data := map[string][]byte{}
+	for _, user := range s.instance.Spec.Users {
+		entry, err := userEntry(ctx, r, s, user, string(secret.Data[user.Name]))
+		if err != nil {
+			// the registry keeps the current entry of the user until the Secret is fixed
+			s.warningBuilder.With(fmt.Sprintf("user '%s' is not updated: %s", user.Name, err))
+			s.setRetryAfter(storageRetryInterval)
+			entry = string(secret.Data[user.Name])
+		}
+		if entry != "" {
+			data[user.Name] = []byte(entry)
+		}
+	}
+
+	if secret.GetResourceVersion() == "" {
+		secret.Data = data
+		err = r.client.Create(ctx, secret)
+	} else if !reflect.DeepEqual(secret.Data, data) {
+		secret.Data = data
+		err = r.client.Update(ctx, secret)
+	}
+	if err != nil {
+		return errors.Wrap(err, "while saving users secret")
+	}
+
+	s.flagsBuilder.WithUsers(secret.GetName(), htpasswdEntries(secret))
+	return nil
+}
+
+// userEntry returns the htpasswd entry of the user, the current one is reused while it matches the password
+func userEntry(ctx context.Context, r *reconciler, s *systemState, user v1alpha1.RegistryUser, currentEntry string) (string, error) {
+	secret, err := registry.GetSecret(ctx, r.client, user.SecretRef.Name, s.instance.GetNamespace())
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("while fetching password secret from %s", s.instance.GetNamespace()))
+	}
+
+	password := string(secret.Data["password"])
+	if password == "" {
+		return "", errors.Errorf("password secret '%s' has no password", user.SecretRef.Name)
+	}
+
+	if registry.VerifyHtpasswdEntry(currentEntry, user.Name, password) {
+		return currentEntry, nil
+	}
+	return registry.HtpasswdEntry(user.Name, password)
+}
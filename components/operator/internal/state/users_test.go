@@ -0,0 +1,136 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func Test_setUsersConfig(t *testing.T) {
+	users := []v1alpha1.RegistryUser{
+		{Name: "ci", SecretRef: v1alpha1.SecretReference{Name: "ci-password"}},
+		{Name: "robot", SecretRef: v1alpha1.SecretReference{Name: "robot-password"}},
+	}
+	usersKey := client.ObjectKey{Name: "dockerregistry-users", Namespace: "docker-registry"}
+
+	t.Run("render users into htpasswd entries", func(t *testing.T) {
+		s := fixUsersSystemState(users)
+		r := fixCredentialsReconciler(
+			fixUserPasswordSecret("ci-password", "ci-pass"),
+			fixUserPasswordSecret("robot-password", "robot-pass"),
+		)
+
+		err := setUsersConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		secret := &corev1.Secret{}
+		require.NoError(t, r.client.Get(context.Background(), usersKey, secret))
+		require.True(t, registry.VerifyHtpasswdEntry(string(secret.Data["ci"]), "ci", "ci-pass"))
+		require.True(t, registry.VerifyHtpasswdEntry(string(secret.Data["robot"]), "robot", "robot-pass"))
+		require.Equal(t, "test-uid", string(secret.OwnerReferences[0].UID))
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{
+			"enabled":    true,
+			"secretName": "dockerregistry-users",
+		}, flags["users"])
+	})
+
+	t.Run("reuse entry while password is unchanged", func(t *testing.T) {
+		entry, err := registry.HtpasswdEntry("ci", "ci-pass")
+		require.NoError(t, err)
+		s := fixUsersSystemState(users[:1])
+		r := fixCredentialsReconciler(
+			fixUserPasswordSecret("ci-password", "ci-pass"),
+			fixUsersSecret(map[string][]byte{"ci": []byte(entry)}),
+		)
+
+		err = setUsersConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		secret := &corev1.Secret{}
+		require.NoError(t, r.client.Get(context.Background(), usersKey, secret))
+		require.Equal(t, map[string][]byte{"ci": []byte(entry)}, secret.Data)
+	})
+
+	t.Run("hash changed password and drop removed user", func(t *testing.T) {
+		entry, err := registry.HtpasswdEntry("ci", "old-pass")
+		require.NoError(t, err)
+		s := fixUsersSystemState(users[:1])
+		r := fixCredentialsReconciler(
+			fixUserPasswordSecret("ci-password", "ci-pass"),
+			fixUsersSecret(map[string][]byte{"ci": []byte(entry), "robot": []byte("robot:hash\n")}),
+		)
+
+		err = setUsersConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		secret := &corev1.Secret{}
+		require.NoError(t, r.client.Get(context.Background(), usersKey, secret))
+		require.Len(t, secret.Data, 1)
+		require.True(t, registry.VerifyHtpasswdEntry(string(secret.Data["ci"]), "ci", "ci-pass"))
+	})
+
+	t.Run("keep entry and warn when password secret is missing", func(t *testing.T) {
+		s := fixUsersSystemState(users[:1])
+		r := fixCredentialsReconciler(fixUsersSecret(map[string][]byte{"ci": []byte("ci:hash\n")}))
+
+		err := setUsersConfig(context.Background(), r, s)
+		require.NoError(t, err)
+		require.Contains(t, s.warningBuilder.Build(), "user 'ci' is not updated: while fetching password secret from docker-registry")
+		require.Equal(t, storageRetryInterval, s.retryAfter)
+
+		secret := &corev1.Secret{}
+		require.NoError(t, r.client.Get(context.Background(), usersKey, secret))
+		require.Equal(t, map[string][]byte{"ci": []byte("ci:hash\n")}, secret.Data)
+	})
+
+	t.Run("delete users secret without users", func(t *testing.T) {
+		s := fixUsersSystemState(nil)
+		r := fixCredentialsReconciler(fixUsersSecret(map[string][]byte{"ci": []byte("ci:hash\n")}))
+
+		err := setUsersConfig(context.Background(), r, s)
+		require.NoError(t, err)
+
+		err = r.client.Get(context.Background(), usersKey, &corev1.Secret{})
+		require.True(t, apierrors.IsNotFound(err))
+
+		flags, err := s.flagsBuilder.Build()
+		require.NoError(t, err)
+		require.Empty(t, flags)
+	})
+}
+
+func fixUsersSystemState(users []v1alpha1.RegistryUser) *systemState {
+	s := fixCredentialsSystemState("docker-registry", nil)
+	s.instance.Spec.Users = users
+	return s
+}
+
+func fixUserPasswordSecret(name, password string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "docker-registry",
+		},
+		Data: map[string][]byte{"password": []byte(password)},
+	}
+}
+
+func fixUsersSecret(data map[string][]byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dockerregistry-users",
+			Namespace: "docker-registry",
+		},
+		Data: data,
+	}
+}
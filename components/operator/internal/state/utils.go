@@ -25,17 +25,18 @@ func GetDockerRegistryOrServed(ctx context.Context, req ctrl.Request, c client.C
 		return nil, errors.Wrap(err, "while fetching dockerregistry instance")
 	}
 
-	instance, err = GetServedDockerRegistry(ctx, c)
+	instance, err = GetServedDockerRegistry(ctx, c, req.Namespace)
 	if err != nil {
 		return nil, errors.Wrap(err, "while fetching served dockerregistry instance")
 	}
 	return instance, nil
 }
 
-func GetServedDockerRegistry(ctx context.Context, c client.Client) (*v1alpha1.DockerRegistry, error) {
+// GetServedDockerRegistry returns the DockerRegistry served from the given namespace, every namespace can host its own registry
+func GetServedDockerRegistry(ctx context.Context, c client.Client, namespace string) (*v1alpha1.DockerRegistry, error) {
 	var dockerRegistryList v1alpha1.DockerRegistryList
 
-	err := c.List(ctx, &dockerRegistryList)
+	err := c.List(ctx, &dockerRegistryList, client.InNamespace(namespace))
 
 	if err != nil {
 		return nil, err
@@ -43,5 +43,5 @@ func sFnVerifyResources(_ context.Context, r *reconciler, s *systemState) (state
 	// remove possible previous DeploymentFailure condition
 	s.instance.RemoveCondition(v1alpha1.ConditionTypeDeploymentFailure)
 
-	return nextState(sFnUpdateFinalStatus)
+	return nextState(sFnTagRetention)
 }
@@ -0,0 +1,216 @@
+package storageadapter
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	DefaultBindAddress = ":8091"
+	DefaultServiceName = "dockerregistry-storage-adapter"
+
+	readHeaderTimeout = 10 * time.Second
+	shutdownTimeout   = 10 * time.Second
+
+	sharedKeyScheme = "SharedKey "
+	// errorCodeHeader carries the error code the azure storage clients read, the body of the errors is not parsed
+	errorCodeHeader = "x-ms-error-code"
+)
+
+// forbiddenError is returned for the requests the adapter does not forward
+type forbiddenError string
+
+func (e forbiddenError) Error() string {
+	return string(e)
+}
+
+// Server forwards the requests of the azure storage driver of the registries to the containers of their BTP Object
+// Store bindings. The driver signs the requests with a shared key, which the bindings don't have, so the server checks
+// the signature with the account key generated for the registry and authorizes them with the SAS token of the binding
+// instead. The registry is recognized by the account name it signs the requests for. Every operator replica serves
+// the requests.
+type Server struct {
+	addr      string
+	client    client.Client
+	transport http.RoundTripper
+	log       *zap.SugaredLogger
+}
+
+func NewServer(addr string, client client.Client, log *zap.SugaredLogger) *Server {
+	return &Server{
+		addr:      addr,
+		client:    client,
+		transport: http.DefaultTransport,
+		log:       log.Named("storage-adapter"),
+	}
+}
+
+// NeedLeaderElection makes the standby replicas forward the requests too, so that the adapter Service has endpoints
+// while the leader is replaced
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start forwards the storage requests until the context is done
+func (s *Server) Start(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              s.addr,
+		Handler:           s,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		s.log.Infof("serving storage adapter on %s", s.addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return errors.Wrap(err, "while serving storage adapter")
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the path is /<namespace>/<container>[/<blob>], the namespace is the one of the registry
+	namespace, containerPath, _ := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	container, blobPath, _ := strings.Cut(containerPath, "/")
+	if namespace == "" || container == "" {
+		writeError(w, http.StatusNotFound, "ResourceNotFound", "the path does not name a namespace and a container")
+		return
+	}
+
+	binding, err := s.authorize(r.Context(), namespace, r)
+	var forbidden forbiddenError
+	switch {
+	case errors.As(err, &forbidden):
+		writeError(w, http.StatusForbidden, "AuthenticationFailed", err.Error())
+		return
+	case err != nil:
+		s.log.Errorf("failed to authorize storage request of namespace %s: %s", namespace, err)
+		writeError(w, http.StatusInternalServerError, "InternalError", "failed to authorize the request")
+		return
+	}
+	if container != url.PathEscape(binding.Container) {
+		writeError(w, http.StatusForbidden, "AuthorizationFailure",
+			fmt.Sprintf("the binding of the registry in namespace %s grants access to container %s only", namespace, binding.Container))
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			rewrite(pr, binding, blobPath)
+		},
+		Transport: s.transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			s.log.Warnf("failed to forward storage request of namespace %s: %s", namespace, err)
+			writeError(w, http.StatusBadGateway, "ServerBusy", "failed to reach the storage")
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// authorize returns the binding of the registry served from the namespace, when the request is signed for the
+// account name of its adapter Secret with the account key stored next to it
+func (s *Server) authorize(ctx context.Context, namespace string, r *http.Request) (*registry.BTPAzureBinding, error) {
+	credentials, ok := strings.CutPrefix(r.Header.Get("Authorization"), sharedKeyScheme)
+	accountName, signature, _ := strings.Cut(credentials, ":")
+	if !ok || accountName == "" || signature == "" {
+		return nil, forbiddenError("the request is not signed with a shared key")
+	}
+
+	adapterSecret, err := registry.GetSecret(ctx, s.client, registry.NewResourceNames(namespace).StorageAdapterSecretName, namespace)
+	if client.IgnoreNotFound(err) != nil {
+		return nil, errors.Wrap(err, "while fetching storage adapter secret")
+	}
+	if err != nil || subtle.ConstantTimeCompare([]byte(accountName), adapterSecret.Data[registry.StorageAdapterAccountKey]) != 1 {
+		return nil, forbiddenError(fmt.Sprintf("the account name is not the one of the registry in namespace %s", namespace))
+	}
+
+	accountKey, err := base64.StdEncoding.DecodeString(string(adapterSecret.Data[registry.StorageAdapterSharedKey]))
+	if err != nil || len(accountKey) == 0 {
+		// the key is generated on the next reconciliation of the registry
+		return nil, forbiddenError(fmt.Sprintf("the registry in namespace %s has no account key yet", namespace))
+	}
+	expected := registry.AzureSharedKeySignature(r, accountName, accountKey)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, forbiddenError(fmt.Sprintf("the request is not signed with the account key of the registry in namespace %s", namespace))
+	}
+
+	instance, err := s.servedInstance(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	btpSecret, err := registry.GetSecret(ctx, s.client, instance.Spec.Storage.BTPObjectStore.SecretName, namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "while fetching btp storage secret")
+	}
+	return registry.ParseBTPAzureBinding(btpSecret.Data)
+}
+
+// servedInstance returns the DockerRegistry served from the namespace, the adapter forwards only the requests of
+// the registries with the BTP Object Store
+func (s *Server) servedInstance(ctx context.Context, namespace string) (*v1alpha1.DockerRegistry, error) {
+	instances := v1alpha1.DockerRegistryList{}
+	if err := s.client.List(ctx, &instances, client.InNamespace(namespace)); err != nil {
+		return nil, errors.Wrap(err, "while listing dockerregistries")
+	}
+
+	for i := range instances.Items {
+		instance := &instances.Items[i]
+		if instance.Status.Served != v1alpha1.ServedTrue {
+			continue
+		}
+		if instance.Spec.Storage == nil || instance.Spec.Storage.BTPObjectStore == nil {
+			return nil, forbiddenError(fmt.Sprintf("the registry in namespace %s does not use the btp object store", namespace))
+		}
+		return instance, nil
+	}
+	return nil, forbiddenError(fmt.Sprintf("no registry is served in namespace %s", namespace))
+}
+
+// rewrite sends the request to the container of the binding with the SAS token added to its query, the shared key
+// signature is dropped, it is made with a key the storage does not know
+func rewrite(pr *httputil.ProxyRequest, binding *registry.BTPAzureBinding, blobPath string) {
+	target := *binding.ContainerURI
+	target.RawPath = strings.TrimSuffix(target.EscapedPath(), "/")
+	if blobPath != "" {
+		target.RawPath += "/" + blobPath
+	}
+	// the path was escaped by the client, it is a valid one
+	target.Path, _ = url.PathUnescape(target.RawPath)
+
+	query := pr.In.URL.Query()
+	for key, values := range binding.SASToken {
+		query[key] = values
+	}
+	target.RawQuery = query.Encode()
+
+	pr.Out.URL = &target
+	pr.Out.Host = ""
+	pr.Out.Header.Del("Authorization")
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set(errorCodeHeader, code)
+	http.Error(w, message, status)
+}
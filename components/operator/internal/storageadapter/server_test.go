@@ -0,0 +1,285 @@
+package storageadapter
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	testAccountName = "registryaccount"
+	// testAccountKey is the base64 encoded "account-key"
+	testAccountKey = "YWNjb3VudC1rZXk="
+	testSignature  = "c2lnbmF0dXJl"
+)
+
+func TestServer_ServeHTTP(t *testing.T) {
+	t.Run("forward blob requests with the sas token", func(t *testing.T) {
+		store := newBlobStore(t)
+		server := fixServer(t, store, fixServedInstance(), fixBindingSecret(store.URL), fixAdapterSecret())
+
+		blob := "/test-namespace/images/docker/registry/v2/blobs/sha256/ab/data"
+		resp := serve(server, fixStorageRequest(http.MethodPut, blob, "layer"))
+		require.Equal(t, http.StatusCreated, resp.Code)
+
+		resp = serve(server, fixStorageRequest(http.MethodGet, blob, ""))
+		require.Equal(t, http.StatusOK, resp.Code)
+		require.Equal(t, "layer", resp.Body.String())
+
+		resp = serve(server, fixStorageRequest(http.MethodDelete, blob, ""))
+		require.Equal(t, http.StatusAccepted, resp.Code)
+
+		resp = serve(server, fixStorageRequest(http.MethodGet, blob, ""))
+		require.Equal(t, http.StatusNotFound, resp.Code)
+		require.Equal(t, "BlobNotFound", resp.Header().Get(errorCodeHeader))
+	})
+
+	t.Run("keep the query of the driver", func(t *testing.T) {
+		store := newBlobStore(t)
+		server := fixServer(t, store, fixServedInstance(), fixBindingSecret(store.URL), fixAdapterSecret())
+
+		resp := serve(server, fixStorageRequest(http.MethodGet, "/test-namespace/images?restype=container&comp=list&prefix=docker%2F", ""))
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		require.Equal(t, "/images", store.lastRequest.URL.Path)
+		require.Equal(t, "list", store.lastRequest.URL.Query().Get("comp"))
+		require.Equal(t, "docker/", store.lastRequest.URL.Query().Get("prefix"))
+		require.Equal(t, "c", store.lastRequest.URL.Query().Get("sr"))
+	})
+
+	t.Run("reject another account name", func(t *testing.T) {
+		store := newBlobStore(t)
+		server := fixServer(t, store, fixServedInstance(), fixBindingSecret(store.URL), fixAdapterSecret())
+
+		req := fixStorageRequest(http.MethodGet, "/test-namespace/images/blob", "")
+		req.Header.Set("Authorization", "SharedKey otheraccount:c2ln")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusForbidden, resp.Code)
+		require.Equal(t, "AuthenticationFailed", resp.Header().Get(errorCodeHeader))
+		require.Nil(t, store.lastRequest)
+	})
+
+	t.Run("reject signature made with another key", func(t *testing.T) {
+		store := newBlobStore(t)
+		server := fixServer(t, store, fixServedInstance(), fixBindingSecret(store.URL), fixAdapterSecret())
+
+		req := fixStorageRequest(http.MethodGet, "/test-namespace/images/blob", "")
+		signature := registry.AzureSharedKeySignature(req, testAccountName, []byte("other-key"))
+		req.Header.Set("Authorization", "SharedKey "+testAccountName+":"+signature)
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusForbidden, resp.Code)
+		require.Equal(t, "AuthenticationFailed", resp.Header().Get(errorCodeHeader))
+		require.Contains(t, resp.Body.String(), "the request is not signed with the account key of the registry in namespace test-namespace")
+		require.Nil(t, store.lastRequest)
+	})
+
+	t.Run("reject request changed after signing", func(t *testing.T) {
+		store := newBlobStore(t)
+		server := fixServer(t, store, fixServedInstance(), fixBindingSecret(store.URL), fixAdapterSecret())
+
+		req := fixStorageRequest(http.MethodGet, "/test-namespace/images/blob", "")
+		req.URL.Path = "/test-namespace/images/other"
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusForbidden, resp.Code)
+		require.Nil(t, store.lastRequest)
+	})
+
+	t.Run("reject request of registry without account key", func(t *testing.T) {
+		store := newBlobStore(t)
+		adapterSecret := fixAdapterSecret()
+		delete(adapterSecret.Data, registry.StorageAdapterSharedKey)
+		server := fixServer(t, store, fixServedInstance(), fixBindingSecret(store.URL), adapterSecret)
+
+		resp := serve(server, fixStorageRequest(http.MethodGet, "/test-namespace/images/blob", ""))
+
+		require.Equal(t, http.StatusForbidden, resp.Code)
+		require.Contains(t, resp.Body.String(), "the registry in namespace test-namespace has no account key yet")
+		require.Nil(t, store.lastRequest)
+	})
+
+	t.Run("reject unsigned request", func(t *testing.T) {
+		store := newBlobStore(t)
+		server := fixServer(t, store, fixServedInstance(), fixBindingSecret(store.URL), fixAdapterSecret())
+
+		req := fixStorageRequest(http.MethodGet, "/test-namespace/images/blob", "")
+		req.Header.Del("Authorization")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusForbidden, resp.Code)
+		require.Nil(t, store.lastRequest)
+	})
+
+	t.Run("reject another container", func(t *testing.T) {
+		store := newBlobStore(t)
+		server := fixServer(t, store, fixServedInstance(), fixBindingSecret(store.URL), fixAdapterSecret())
+
+		resp := serve(server, fixStorageRequest(http.MethodGet, "/test-namespace/other/blob", ""))
+
+		require.Equal(t, http.StatusForbidden, resp.Code)
+		require.Equal(t, "AuthorizationFailure", resp.Header().Get(errorCodeHeader))
+		require.Nil(t, store.lastRequest)
+	})
+
+	t.Run("reject registry without btp object store", func(t *testing.T) {
+		store := newBlobStore(t)
+		instance := fixServedInstance()
+		instance.Spec.Storage = nil
+		server := fixServer(t, store, instance, fixBindingSecret(store.URL), fixAdapterSecret())
+
+		resp := serve(server, fixStorageRequest(http.MethodGet, "/test-namespace/images/blob", ""))
+
+		require.Equal(t, http.StatusForbidden, resp.Code)
+		require.Contains(t, resp.Body.String(), "the registry in namespace test-namespace does not use the btp object store")
+	})
+
+	t.Run("reject path without container", func(t *testing.T) {
+		store := newBlobStore(t)
+		server := fixServer(t, store, fixServedInstance(), fixBindingSecret(store.URL), fixAdapterSecret())
+
+		resp := serve(server, fixStorageRequest(http.MethodGet, "/test-namespace", ""))
+
+		require.Equal(t, http.StatusNotFound, resp.Code)
+	})
+}
+
+// blobStore stands in for the blob service of a storage account, like Azurite does, it accepts only the requests
+// authorized with the SAS token of the test binding
+type blobStore struct {
+	*httptest.Server
+	mu          sync.Mutex
+	blobs       map[string][]byte
+	lastRequest *http.Request
+}
+
+func newBlobStore(t *testing.T) *blobStore {
+	store := &blobStore{blobs: map[string][]byte{}}
+	store.Server = httptest.NewTLSServer(http.HandlerFunc(store.serve))
+	t.Cleanup(store.Close)
+	return store
+}
+
+func (b *blobStore) serve(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastRequest = r
+
+	if r.URL.Query().Get("sig") != testSignature || r.Header.Get("Authorization") != "" {
+		w.Header().Set(errorCodeHeader, "AuthenticationFailed")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.URL.Query().Get("comp") == "list":
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		b.blobs[r.URL.Path] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodDelete:
+		delete(b.blobs, r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		data, ok := b.blobs[r.URL.Path]
+		if !ok {
+			w.Header().Set(errorCodeHeader, "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	}
+}
+
+func serve(server *Server, req *http.Request) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	return resp
+}
+
+func fixServer(t *testing.T, store *blobStore, objs ...client.Object) *Server {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		Build()
+
+	server := NewServer(DefaultBindAddress, c, zap.NewNop().Sugar())
+	server.transport = store.Client().Transport
+	return server
+}
+
+// fixStorageRequest returns the request signed by the azure storage driver of the registry with its account key
+func fixStorageRequest(method, path, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("x-ms-version", "2023-11-03")
+	req.Header.Set("x-ms-date", "Fri, 02 Jan 2026 03:04:05 GMT")
+	accountKey, _ := base64.StdEncoding.DecodeString(testAccountKey)
+	signature := registry.AzureSharedKeySignature(req, testAccountName, accountKey)
+	req.Header.Set("Authorization", "SharedKey "+testAccountName+":"+signature)
+	return req
+}
+
+func fixServedInstance() *v1alpha1.DockerRegistry {
+	return &v1alpha1.DockerRegistry{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "default",
+			Namespace: "test-namespace",
+		},
+		Spec: v1alpha1.DockerRegistrySpec{
+			Storage: &v1alpha1.Storage{
+				BTPObjectStore: &v1alpha1.StorageBTPObjectStore{SecretName: "btp-binding"},
+			},
+		},
+		Status: v1alpha1.DockerRegistryStatus{
+			Served: v1alpha1.ServedTrue,
+		},
+	}
+}
+
+func fixBindingSecret(storeURL string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "btp-binding",
+			Namespace: "test-namespace",
+		},
+		Data: map[string][]byte{
+			"account_name":   []byte("account"),
+			"container_name": []byte("images"),
+			"container_uri":  []byte(storeURL + "/images"),
+			"sas_token":      []byte("?sv=2022-11-02&sr=c&sp=racwdl&sig=" + testSignature),
+		},
+	}
+}
+
+func fixAdapterSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dockerregistry-storage-adapter-test-namespace",
+			Namespace: "test-namespace",
+		},
+		Data: map[string][]byte{
+			"accountName": []byte(testAccountName),
+			"accountKey":  []byte(testAccountKey),
+		},
+	}
+}
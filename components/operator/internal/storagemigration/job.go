@@ -0,0 +1,83 @@
+package storagemigration
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultTerminationLog = "/dev/termination-log"
+	// the registries are sidecars of the Job, they are started but not necessarily listening yet
+	registryStartTimeout = 2 * time.Minute
+	registryStartRetry   = time.Second
+)
+
+// Run copies the images between the registries of the migration Job. The summary of the copy or the error is written
+// to the termination message the operator reads from the status of the pod.
+func Run(ctx context.Context, args []string, log *zap.SugaredLogger) error {
+	flags := flag.NewFlagSet(registry.StorageMigrationCommand, flag.ContinueOnError)
+	sourceAddr := flags.String("source", registry.StorageMigrationSourceAddress, "Address of the registry the images are copied from.")
+	targetAddr := flags.String("target", registry.StorageMigrationTargetAddress, "Address of the registry the images are copied to.")
+	terminationLog := flags.String("termination-log", defaultTerminationLog, "Path the summary of the copy is written to.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	summary, err := copyImages(ctx, *sourceAddr, *targetAddr, log)
+	if err != nil {
+		return writeTerminationMessage(*terminationLog, []byte(err.Error()), err)
+	}
+
+	log.Infof("storage migration finished: %s", summary)
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return writeTerminationMessage(*terminationLog, data, nil)
+}
+
+func copyImages(ctx context.Context, sourceAddr, targetAddr string, log *zap.SugaredLogger) (*Summary, error) {
+	// the registries of the Job accept every request, the credentials are never checked
+	source := registry.NewAPIClient(sourceAddr, "", "")
+	target := registry.NewAPIClient(targetAddr, "", "")
+
+	for name, client := range map[string]*registry.APIClient{"source": source, "target": target} {
+		if err := waitForRegistry(ctx, client); err != nil {
+			return nil, errors.Wrapf(err, "%s registry is not reachable", name)
+		}
+	}
+
+	return Copy(ctx, source, target, log)
+}
+
+func waitForRegistry(ctx context.Context, client *registry.APIClient) error {
+	ctx, cancel := context.WithTimeout(ctx, registryStartTimeout)
+	defer cancel()
+
+	for {
+		_, err := client.Repositories(ctx)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(registryStartRetry):
+		}
+	}
+}
+
+func writeTerminationMessage(path string, message []byte, result error) error {
+	if err := os.WriteFile(path, message, 0o644); err != nil && result == nil {
+		return errors.Wrap(err, "while writing termination message")
+	}
+	return result
+}
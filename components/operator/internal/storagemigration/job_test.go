@@ -0,0 +1,60 @@
+package storagemigration
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestRun(t *testing.T) {
+	t.Run("write summary to termination message", func(t *testing.T) {
+		source := fixRegistryServer(t, map[string]string{"/v2/_catalog": `{"repositories":[]}`})
+		target := fixRegistryServer(t, map[string]string{"/v2/_catalog": `{"repositories":[]}`})
+		terminationLog := filepath.Join(t.TempDir(), "termination-log")
+
+		err := Run(context.Background(), []string{
+			"--source=" + source, "--target=" + target, "--termination-log=" + terminationLog,
+		}, zap.NewNop().Sugar())
+
+		require.NoError(t, err)
+		message, err := os.ReadFile(terminationLog)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"repositories":0,"tags":0,"manifests":0,"blobs":0,"bytes":0}`, string(message))
+	})
+
+	t.Run("write error to termination message", func(t *testing.T) {
+		source := fixRegistryServer(t, map[string]string{"/v2/_catalog": `{"repositories":["ci/app"]}`})
+		target := fixRegistryServer(t, map[string]string{"/v2/_catalog": `{"repositories":[]}`})
+		terminationLog := filepath.Join(t.TempDir(), "termination-log")
+
+		err := Run(context.Background(), []string{
+			"--source=" + source, "--target=" + target, "--termination-log=" + terminationLog,
+		}, zap.NewNop().Sugar())
+
+		require.ErrorContains(t, err, "while listing tags of repository ci/app")
+		message, err := os.ReadFile(terminationLog)
+		require.NoError(t, err)
+		require.Contains(t, string(message), "while listing tags of repository ci/app: unexpected status 404 Not Found")
+	})
+}
+
+func fixRegistryServer(t *testing.T, responses map[string]string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	return strings.TrimPrefix(server.URL, "http://")
+}
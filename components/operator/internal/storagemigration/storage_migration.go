@@ -0,0 +1,300 @@
+package storagemigration
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Source is the part of the registry API the images are read through
+type Source interface {
+	Repositories(ctx context.Context) ([]string, error)
+	Tags(ctx context.Context, repository string) ([]string, error)
+	Manifest(ctx context.Context, repository, reference string) (*registry.Manifest, error)
+	ManifestDigest(ctx context.Context, repository, reference string) (string, error)
+	Blob(ctx context.Context, repository, digest string) (io.ReadCloser, error)
+}
+
+// Target is the part of the registry API the images are pushed through
+type Target interface {
+	ManifestDigest(ctx context.Context, repository, reference string) (string, error)
+	PutManifest(ctx context.Context, repository, reference string, manifest *registry.Manifest) (string, error)
+	BlobExists(ctx context.Context, repository, digest string) (bool, error)
+	MountBlob(ctx context.Context, repository, digest, from string) (bool, error)
+	PutBlob(ctx context.Context, repository, digest string, size int64, content io.Reader) error
+}
+
+// Summary is what the migration copied, the blobs and manifests the target already held are not counted
+type Summary struct {
+	Repositories int64 `json:"repositories"`
+	Tags         int64 `json:"tags"`
+	Manifests    int64 `json:"manifests"`
+	Blobs        int64 `json:"blobs"`
+	Bytes        int64 `json:"bytes"`
+}
+
+func (s *Summary) String() string {
+	return fmt.Sprintf("%d tags of %d repositories verified, %d manifests and %d blobs (%d bytes) copied",
+		s.Tags, s.Repositories, s.Manifests, s.Blobs, s.Bytes)
+}
+
+// ParseSummary reads the summary the migration Job reported in its termination message
+func ParseSummary(message string) (*Summary, error) {
+	summary := &Summary{}
+	if err := json.Unmarshal([]byte(message), summary); err != nil {
+		return nil, errors.Wrap(err, "while decoding storage migration summary")
+	}
+	return summary, nil
+}
+
+type copier struct {
+	source Source
+	target Target
+	log    *zap.SugaredLogger
+
+	summary *Summary
+	// blobRepositories is a repository of the target every copied blob is in, it is mounted to the other ones
+	blobRepositories map[string]string
+	// copiedManifests are the digests of the manifests copied to a repository, they are pushed once
+	copiedManifests map[string]bool
+}
+
+// Copy copies the tagged images of all repositories from the source to the target registry and verifies that every
+// tag of the target points to the manifest of the source. The manifests are pushed unchanged and the blobs are
+// checked against their digests, so the images keep their digests. The images the target already holds are
+// skipped, a failed copy is resumed where it stopped. The manifests without a tag are not copied.
+func Copy(ctx context.Context, source Source, target Target, log *zap.SugaredLogger) (*Summary, error) {
+	c := &copier{
+		source:           source,
+		target:           target,
+		log:              log,
+		summary:          &Summary{},
+		blobRepositories: map[string]string{},
+		copiedManifests:  map[string]bool{},
+	}
+
+	repositories, err := source.Repositories(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "while listing source repositories")
+	}
+
+	tags := map[string][]string{}
+	for _, repository := range repositories {
+		repositoryTags, err := source.Tags(ctx, repository)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while listing tags of repository %s", repository)
+		}
+		tags[repository] = repositoryTags
+
+		log.Infof("copying %d tags of repository %s", len(repositoryTags), repository)
+		for _, tag := range repositoryTags {
+			if err := c.copyTag(ctx, repository, tag); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, repository := range repositories {
+		for _, tag := range tags[repository] {
+			if err := c.verifyTag(ctx, repository, tag); err != nil {
+				return nil, err
+			}
+			c.summary.Tags++
+		}
+		c.summary.Repositories++
+	}
+
+	return c.summary, nil
+}
+
+func (c *copier) copyTag(ctx context.Context, repository, tag string) error {
+	manifest, err := c.fetchManifest(ctx, repository, tag)
+	if err != nil {
+		return err
+	}
+
+	targetDigest, err := c.target.ManifestDigest(ctx, repository, tag)
+	if err != nil {
+		return err
+	}
+	if targetDigest == manifest.Digest {
+		c.log.Debugf("tag %s:%s is already copied", repository, tag)
+		return nil
+	}
+
+	return c.copyManifest(ctx, repository, tag, manifest)
+}
+
+// copyManifest pushes the manifest after the manifests and blobs it references, the registry rejects it otherwise
+func (c *copier) copyManifest(ctx context.Context, repository, reference string, manifest *registry.Manifest) error {
+	content := ocispec.Manifest{}
+	index := ocispec.Index{}
+	if err := json.Unmarshal(manifest.Data, &content); err != nil {
+		return errors.Wrapf(err, "while decoding manifest %s@%s", repository, manifest.Digest)
+	}
+	if err := json.Unmarshal(manifest.Data, &index); err != nil {
+		return errors.Wrapf(err, "while decoding manifest %s@%s", repository, manifest.Digest)
+	}
+
+	for _, child := range index.Manifests {
+		if err := c.copyChildManifest(ctx, repository, child.Digest.String()); err != nil {
+			return err
+		}
+	}
+
+	blobs := content.Layers
+	if content.Config.Digest != "" {
+		blobs = append([]ocispec.Descriptor{content.Config}, blobs...)
+	}
+	for _, blob := range blobs {
+		if len(blob.URLs) > 0 {
+			// the foreign layers are pulled from their URLs, the registry does not hold them
+			continue
+		}
+		if err := c.copyBlob(ctx, repository, blob); err != nil {
+			return err
+		}
+	}
+
+	pushed, err := c.target.PutManifest(ctx, repository, reference, manifest)
+	if err != nil {
+		return err
+	}
+	if pushed != "" && pushed != manifest.Digest {
+		return errors.Errorf("manifest %s:%s was pushed with digest %s instead of %s", repository, reference, pushed, manifest.Digest)
+	}
+
+	c.copiedManifests[repository+"@"+manifest.Digest] = true
+	c.summary.Manifests++
+	return nil
+}
+
+func (c *copier) copyChildManifest(ctx context.Context, repository, reference string) error {
+	if c.copiedManifests[repository+"@"+reference] {
+		return nil
+	}
+
+	targetDigest, err := c.target.ManifestDigest(ctx, repository, reference)
+	if err != nil {
+		return err
+	}
+	if targetDigest != "" {
+		c.copiedManifests[repository+"@"+reference] = true
+		return nil
+	}
+
+	manifest, err := c.fetchManifest(ctx, repository, reference)
+	if err != nil {
+		return err
+	}
+	return c.copyManifest(ctx, repository, reference, manifest)
+}
+
+// fetchManifest returns the manifest of the source with its digest checked against the content
+func (c *copier) fetchManifest(ctx context.Context, repository, reference string) (*registry.Manifest, error) {
+	manifest, err := c.source.Manifest(ctx, repository, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest.Digest == "" {
+		manifest.Digest = digest.FromBytes(manifest.Data).String()
+		return manifest, nil
+	}
+
+	expected, err := digest.Parse(manifest.Digest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid digest of manifest %s:%s", repository, reference)
+	}
+	if expected.Algorithm().FromBytes(manifest.Data) != expected {
+		return nil, errors.Errorf("content of manifest %s:%s does not match its digest %s", repository, reference, expected)
+	}
+	return manifest, nil
+}
+
+// copyBlob mounts the blob from another repository of the target when it was copied before, it is streamed from
+// the source otherwise
+func (c *copier) copyBlob(ctx context.Context, repository string, blob ocispec.Descriptor) error {
+	if err := blob.Digest.Validate(); err != nil {
+		return errors.Wrapf(err, "invalid digest of blob %s of repository %s", blob.Digest, repository)
+	}
+	blobDigest := blob.Digest.String()
+
+	exists, err := c.target.BlobExists(ctx, repository, blobDigest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		c.rememberBlob(repository, blobDigest)
+		return nil
+	}
+
+	if from, ok := c.blobRepositories[blobDigest]; ok {
+		mounted, err := c.target.MountBlob(ctx, repository, blobDigest, from)
+		if err != nil {
+			return err
+		}
+		if mounted {
+			return nil
+		}
+	}
+
+	content, err := c.source.Blob(ctx, repository, blobDigest)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	verifier := blob.Digest.Verifier()
+	read := &byteCounter{}
+	err = c.target.PutBlob(ctx, repository, blobDigest, blob.Size, io.TeeReader(content, io.MultiWriter(verifier, read)))
+	if (err == nil || read.n >= blob.Size) && !verifier.Verified() {
+		// the registry rejects such an upload too, the error tells that the source is broken
+		return errors.Errorf("content of blob %s of repository %s does not match its digest", blobDigest, repository)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.rememberBlob(repository, blobDigest)
+	c.summary.Blobs++
+	c.summary.Bytes += blob.Size
+	return nil
+}
+
+func (c *copier) rememberBlob(repository, blobDigest string) {
+	if _, ok := c.blobRepositories[blobDigest]; !ok {
+		c.blobRepositories[blobDigest] = repository
+	}
+}
+
+func (c *copier) verifyTag(ctx context.Context, repository, tag string) error {
+	sourceDigest, err := c.source.ManifestDigest(ctx, repository, tag)
+	if err != nil {
+		return err
+	}
+	targetDigest, err := c.target.ManifestDigest(ctx, repository, tag)
+	if err != nil {
+		return err
+	}
+	if sourceDigest != targetDigest {
+		return errors.Errorf("tag %s:%s points to %s in the target registry instead of %s", repository, tag, targetDigest, sourceDigest)
+	}
+	return nil
+}
+
+type byteCounter struct {
+	n int64
+}
+
+func (b *byteCounter) Write(p []byte) (int, error) {
+	b.n += int64(len(p))
+	return len(p), nil
+}
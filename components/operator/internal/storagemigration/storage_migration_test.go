@@ -0,0 +1,267 @@
+package storagemigration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCopy(t *testing.T) {
+	t.Run("copy tagged images with their digests", func(t *testing.T) {
+		source := newFakeRegistry()
+		app := source.pushImage("ci/app", "latest", "base-layer", "app-layer")
+		tool := source.pushImage("ci/tool", "1.0.0", "base-layer", "tool-layer")
+		index := source.pushIndex("ci/tool", "multi-arch", "base-layer", "arm-layer")
+		target := newFakeRegistry()
+
+		summary, err := Copy(context.Background(), source, target, zap.NewNop().Sugar())
+
+		require.NoError(t, err)
+		require.Equal(t, &Summary{
+			Repositories: 2,
+			Tags:         3,
+			Manifests:    5,
+			Blobs:        8,
+			Bytes:        summary.Bytes,
+		}, summary)
+		require.Equal(t, app, target.tagDigest("ci/app", "latest"))
+		require.Equal(t, tool, target.tagDigest("ci/tool", "1.0.0"))
+		require.Equal(t, index, target.tagDigest("ci/tool", "multi-arch"))
+		require.Equal(t, source.blobs, target.blobs)
+		// the base layer is uploaded once and linked to the other repository
+		require.Equal(t, 1, target.mounts)
+	})
+
+	t.Run("skip images the target already holds", func(t *testing.T) {
+		source := newFakeRegistry()
+		source.pushImage("ci/app", "latest", "app-layer")
+		target := newFakeRegistry()
+		_, err := Copy(context.Background(), source, target, zap.NewNop().Sugar())
+		require.NoError(t, err)
+		source.pushImage("ci/app", "next", "app-layer", "next-layer")
+
+		summary, err := Copy(context.Background(), source, target, zap.NewNop().Sugar())
+
+		require.NoError(t, err)
+		require.Equal(t, int64(2), summary.Tags)
+		require.Equal(t, int64(1), summary.Manifests)
+		// the config and the new layer, the shared layer is in the repository already
+		require.Equal(t, int64(2), summary.Blobs)
+	})
+
+	t.Run("return error when blob does not match its digest", func(t *testing.T) {
+		source := newFakeRegistry()
+		source.pushImage("ci/app", "latest", "app-layer")
+		source.blobs["ci/app"][digest.FromString("app-layer").String()] = []byte("broken-layer")
+		target := newFakeRegistry()
+
+		_, err := Copy(context.Background(), source, target, zap.NewNop().Sugar())
+
+		require.ErrorContains(t, err, fmt.Sprintf("content of blob %s of repository ci/app does not match its digest", digest.FromString("app-layer")))
+		require.Empty(t, target.manifests)
+	})
+
+	t.Run("return error when manifest does not match its digest", func(t *testing.T) {
+		source := newFakeRegistry()
+		app := source.pushImage("ci/app", "latest", "app-layer")
+		source.manifests["ci/app"]["latest"] = &registry.Manifest{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    app,
+			Data:      []byte(`{"layers":[]}`),
+		}
+		target := newFakeRegistry()
+
+		_, err := Copy(context.Background(), source, target, zap.NewNop().Sugar())
+
+		require.ErrorContains(t, err, "content of manifest ci/app:latest does not match its digest "+app)
+	})
+}
+
+func TestParseSummary(t *testing.T) {
+	t.Run("parse termination message", func(t *testing.T) {
+		summary, err := ParseSummary(`{"repositories":2,"tags":3,"manifests":4,"blobs":5,"bytes":1024}`)
+
+		require.NoError(t, err)
+		require.Equal(t, &Summary{Repositories: 2, Tags: 3, Manifests: 4, Blobs: 5, Bytes: 1024}, summary)
+		require.Equal(t, "3 tags of 2 repositories verified, 4 manifests and 5 blobs (1024 bytes) copied", summary.String())
+	})
+
+	t.Run("return error for logs of failed job", func(t *testing.T) {
+		_, err := ParseSummary("source registry is not reachable")
+
+		require.ErrorContains(t, err, "while decoding storage migration summary")
+	})
+}
+
+// fakeRegistry keeps the repositories in memory, it checks the digests of the pushed content the way a registry does
+type fakeRegistry struct {
+	manifests map[string]map[string]*registry.Manifest
+	blobs     map[string]map[string][]byte
+	tags      map[string][]string
+	mounts    int
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		manifests: map[string]map[string]*registry.Manifest{},
+		blobs:     map[string]map[string][]byte{},
+		tags:      map[string][]string{},
+	}
+}
+
+func (f *fakeRegistry) Repositories(_ context.Context) ([]string, error) {
+	repositories := []string{}
+	for repository := range f.tags {
+		repositories = append(repositories, repository)
+	}
+	sort.Strings(repositories)
+	return repositories, nil
+}
+
+func (f *fakeRegistry) Tags(_ context.Context, repository string) ([]string, error) {
+	return f.tags[repository], nil
+}
+
+func (f *fakeRegistry) Manifest(_ context.Context, repository, reference string) (*registry.Manifest, error) {
+	manifest, ok := f.manifests[repository][reference]
+	if !ok {
+		return nil, fmt.Errorf("manifest %s:%s not found", repository, reference)
+	}
+	copied := *manifest
+	return &copied, nil
+}
+
+func (f *fakeRegistry) ManifestDigest(_ context.Context, repository, reference string) (string, error) {
+	if manifest, ok := f.manifests[repository][reference]; ok {
+		return manifest.Digest, nil
+	}
+	return "", nil
+}
+
+func (f *fakeRegistry) Blob(_ context.Context, repository, blobDigest string) (io.ReadCloser, error) {
+	content, ok := f.blobs[repository][blobDigest]
+	if !ok {
+		return nil, fmt.Errorf("blob %s of repository %s not found", blobDigest, repository)
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (f *fakeRegistry) PutManifest(_ context.Context, repository, reference string, manifest *registry.Manifest) (string, error) {
+	content := ocispec.Manifest{}
+	if err := json.Unmarshal(manifest.Data, &content); err != nil {
+		return "", err
+	}
+	for _, blob := range append(content.Layers, content.Config) {
+		if _, ok := f.blobs[repository][blob.Digest.String()]; blob.Digest != "" && !ok {
+			return "", fmt.Errorf("blob %s unknown to repository %s", blob.Digest, repository)
+		}
+	}
+
+	pushed := &registry.Manifest{MediaType: manifest.MediaType, Digest: digest.FromBytes(manifest.Data).String(), Data: manifest.Data}
+	f.putManifest(repository, reference, pushed)
+	return pushed.Digest, nil
+}
+
+func (f *fakeRegistry) BlobExists(_ context.Context, repository, blobDigest string) (bool, error) {
+	_, ok := f.blobs[repository][blobDigest]
+	return ok, nil
+}
+
+func (f *fakeRegistry) MountBlob(_ context.Context, repository, blobDigest, from string) (bool, error) {
+	content, ok := f.blobs[from][blobDigest]
+	if !ok {
+		return false, nil
+	}
+	f.putBlob(repository, content)
+	f.mounts++
+	return true, nil
+}
+
+func (f *fakeRegistry) PutBlob(_ context.Context, repository, blobDigest string, size int64, content io.Reader) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != size || digest.FromBytes(data).String() != blobDigest {
+		return fmt.Errorf("blob %s is invalid", blobDigest)
+	}
+	f.putBlob(repository, data)
+	return nil
+}
+
+func (f *fakeRegistry) tagDigest(repository, tag string) string {
+	return f.manifests[repository][tag].Digest
+}
+
+func (f *fakeRegistry) putBlob(repository string, content []byte) ocispec.Descriptor {
+	if f.blobs[repository] == nil {
+		f.blobs[repository] = map[string][]byte{}
+	}
+	descriptor := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageLayer,
+		Digest:    digest.FromBytes(content),
+		Size:      int64(len(content)),
+	}
+	f.blobs[repository][descriptor.Digest.String()] = content
+	return descriptor
+}
+
+func (f *fakeRegistry) putManifest(repository, reference string, manifest *registry.Manifest) {
+	if f.manifests[repository] == nil {
+		f.manifests[repository] = map[string]*registry.Manifest{}
+	}
+	f.manifests[repository][manifest.Digest] = manifest
+	if reference != manifest.Digest {
+		f.manifests[repository][reference] = manifest
+		f.tags[repository] = append(f.tags[repository], reference)
+	}
+}
+
+func (f *fakeRegistry) pushImage(repository, tag string, layers ...string) string {
+	manifest := f.newImage(repository, tag, layers...)
+	f.putManifest(repository, tag, manifest)
+	return manifest.Digest
+}
+
+// pushIndex pushes an index of two images, only the index is tagged
+func (f *fakeRegistry) pushIndex(repository, tag string, layers ...string) string {
+	index := ocispec.Index{MediaType: ocispec.MediaTypeImageIndex}
+	for _, layer := range layers {
+		image := f.newImage(repository, tag+"-"+layer, layer)
+		f.putManifest(repository, image.Digest, image)
+		index.Manifests = append(index.Manifests, ocispec.Descriptor{
+			MediaType: image.MediaType,
+			Digest:    digest.Digest(image.Digest),
+			Size:      int64(len(image.Data)),
+		})
+	}
+
+	data, _ := json.Marshal(index)
+	manifest := &registry.Manifest{MediaType: ocispec.MediaTypeImageIndex, Digest: digest.FromBytes(data).String(), Data: data}
+	f.putManifest(repository, tag, manifest)
+	return manifest.Digest
+}
+
+func (f *fakeRegistry) newImage(repository, name string, layers ...string) *registry.Manifest {
+	image := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    f.putBlob(repository, []byte(`{"name":"`+name+`"}`)),
+	}
+	image.Config.MediaType = ocispec.MediaTypeImageConfig
+	for _, layer := range layers {
+		image.Layers = append(image.Layers, f.putBlob(repository, []byte(layer)))
+	}
+
+	data, _ := json.Marshal(image)
+	return &registry.Manifest{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(data).String(), Data: data}
+}
@@ -0,0 +1,128 @@
+package storageprobe
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/pkg/errors"
+)
+
+const (
+	// azureVersion is the version of the Blob service API the probe requests
+	azureVersion = "2021-08-06"
+	// azureHostFormat is the blob endpoint of the public cloud the storage driver of the registry defaults to
+	azureHostFormat = "%s.blob.core.windows.net"
+)
+
+type azureStorage struct {
+	client    *http.Client
+	container *url.URL
+	// account and accountKey sign the requests with the shared key, they are empty for the SAS token
+	account    string
+	accountKey []byte
+	sasToken   url.Values
+	now        func() time.Time
+}
+
+// NewAzure returns the blob container the registry is configured with, the requests are signed with the shared
+// key of the storage account
+func NewAzure(secret *v1alpha1.StorageAzureSecrets) (Storage, error) {
+	if secret.AccountName == "" || secret.Container == "" {
+		return nil, errors.New("azure secret is missing accountName or container")
+	}
+	accountKey, err := base64.StdEncoding.DecodeString(secret.AccountKey)
+	if err != nil || len(accountKey) == 0 {
+		return nil, errors.New("accountKey of the azure secret is not a base64 encoded key")
+	}
+
+	return &azureStorage{
+		client: &http.Client{},
+		container: &url.URL{
+			Scheme: "https",
+			Host:   fmt.Sprintf(azureHostFormat, secret.AccountName),
+			Path:   "/" + secret.Container,
+		},
+		account:    secret.AccountName,
+		accountKey: accountKey,
+		now:        time.Now,
+	}, nil
+}
+
+// NewAzureSAS returns the blob container of the BTP Object Store binding, the requests are authorized with its
+// SAS token the way the storage adapter of the operator authorizes the ones of the registry
+func NewAzureSAS(binding *registry.BTPAzureBinding) Storage {
+	container := *binding.ContainerURI
+	return &azureStorage{
+		client:    &http.Client{},
+		container: &container,
+		sasToken:  binding.SASToken,
+		now:       time.Now,
+	}
+}
+
+func (s *azureStorage) Put(ctx context.Context, name string, content []byte) error {
+	req, err := s.newRequest(ctx, http.MethodPut, name, content)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	s.sign(req)
+
+	_, err = send(s.client, req, http.StatusCreated)
+	return err
+}
+
+func (s *azureStorage) Get(ctx context.Context, name string) ([]byte, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req)
+
+	return send(s.client, req, http.StatusOK)
+}
+
+func (s *azureStorage) Delete(ctx context.Context, name string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, name, nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req)
+
+	_, err = send(s.client, req, http.StatusAccepted)
+	return err
+}
+
+func (s *azureStorage) newRequest(ctx context.Context, method, name string, content []byte) (*http.Request, error) {
+	blobURL := *s.container
+	blobURL.Path = path.Join("/", blobURL.Path, name)
+	blobURL.RawQuery = s.sasToken.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, blobURL.String(), bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureVersion)
+	req.Header.Set("x-ms-date", s.now().UTC().Format(http.TimeFormat))
+	return req, nil
+}
+
+// sign adds the shared key signature of the request to its headers, the requests authorized with the SAS token
+// are sent as they are
+func (s *azureStorage) sign(req *http.Request) {
+	if s.account == "" {
+		return
+	}
+
+	signature := registry.AzureSharedKeySignature(req, s.account, s.accountKey)
+	req.Header.Set("Authorization", fmt.Sprintf("SharedKey %s:%s", s.account, signature))
+}
@@ -0,0 +1,132 @@
+package storageprobe
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAzure(t *testing.T) {
+	t.Run("use blob endpoint of account", func(t *testing.T) {
+		storage, err := NewAzure(&v1alpha1.StorageAzureSecrets{
+			AccountName: "registryaccount",
+			AccountKey:  base64.StdEncoding.EncodeToString([]byte("account-key")),
+			Container:   "images",
+		})
+
+		require.NoError(t, err)
+		req, err := storage.(*azureStorage).newRequest(context.Background(), http.MethodGet, "probe", nil)
+		require.NoError(t, err)
+		require.Equal(t, "https://registryaccount.blob.core.windows.net/images/probe", req.URL.String())
+	})
+
+	t.Run("return error for account key that is not base64", func(t *testing.T) {
+		_, err := NewAzure(&v1alpha1.StorageAzureSecrets{AccountName: "registryaccount", AccountKey: "account-key!", Container: "images"})
+
+		require.EqualError(t, err, "accountKey of the azure secret is not a base64 encoded key")
+	})
+
+	t.Run("return error for missing container", func(t *testing.T) {
+		_, err := NewAzure(&v1alpha1.StorageAzureSecrets{AccountName: "registryaccount", AccountKey: "a2V5"})
+
+		require.EqualError(t, err, "azure secret is missing accountName or container")
+	})
+}
+
+func TestAzureStorage(t *testing.T) {
+	t.Run("probe container with shared key", func(t *testing.T) {
+		server := newObjectServer(t, http.StatusCreated, http.StatusAccepted)
+		server.authorize = func(r *http.Request, _ []byte) error {
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedKey registryaccount:") || r.Header.Get("x-ms-date") == "" {
+				return errors.New("request is not signed")
+			}
+			return nil
+		}
+		storage, err := NewAzure(&v1alpha1.StorageAzureSecrets{
+			AccountName: "registryaccount",
+			AccountKey:  base64.StdEncoding.EncodeToString([]byte("account-key")),
+			Container:   "images",
+		})
+		require.NoError(t, err)
+		serverURL, _ := url.Parse(server.URL)
+		storage.(*azureStorage).container.Scheme = serverURL.Scheme
+		storage.(*azureStorage).container.Host = serverURL.Host
+
+		err = Probe(context.Background(), storage)
+
+		require.NoError(t, err)
+		require.Len(t, server.requests, 3)
+		require.Equal(t, "BlockBlob", server.requests[0].Header.Get("x-ms-blob-type"))
+		require.True(t, strings.HasPrefix(server.requests[0].URL.Path, "/images/"+probeObjectPrefix))
+		require.Empty(t, server.objects)
+	})
+
+	t.Run("probe container of binding with sas token", func(t *testing.T) {
+		server := newObjectServer(t, http.StatusCreated, http.StatusAccepted)
+		server.authorize = func(r *http.Request, _ []byte) error {
+			if r.URL.Query().Get("sig") != "signature" || r.Header.Get("Authorization") != "" {
+				return errors.New("Server failed to authenticate the request.")
+			}
+			return nil
+		}
+		containerURI, _ := url.Parse(server.URL + "/images")
+
+		err := Probe(context.Background(), NewAzureSAS(&registry.BTPAzureBinding{
+			Container:    "images",
+			ContainerURI: containerURI,
+			SASToken:     url.Values{"sv": {"2021-08-06"}, "sp": {"racwdl"}, "sig": {"signature"}},
+		}))
+
+		require.NoError(t, err)
+		require.Len(t, server.requests, 3)
+		require.Empty(t, server.objects)
+	})
+
+	t.Run("return reason of rejected write", func(t *testing.T) {
+		server := newObjectServer(t, http.StatusCreated, http.StatusAccepted)
+		server.authorize = func(*http.Request, []byte) error {
+			return errors.New("Server failed to authenticate the request.")
+		}
+		containerURI, _ := url.Parse(server.URL + "/images")
+
+		err := Probe(context.Background(), NewAzureSAS(&registry.BTPAzureBinding{
+			Container:    "images",
+			ContainerURI: containerURI,
+			SASToken:     url.Values{"sig": {"expired"}},
+		}))
+
+		require.ErrorContains(t, err, "unexpected status 403 Forbidden: AccessDenied: Server failed to authenticate the request.")
+	})
+}
+
+func TestAzureStorageSign(t *testing.T) {
+	t.Run("sign canonical headers and resource", func(t *testing.T) {
+		storage := &azureStorage{
+			account:    "registryaccount",
+			accountKey: []byte("account-key"),
+			now:        func() time.Time { return time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC) },
+		}
+		storage.container, _ = url.Parse("https://registryaccount.blob.core.windows.net/images")
+		req, err := storage.newRequest(context.Background(), http.MethodGet, "probe", nil)
+		require.NoError(t, err)
+		other, err := storage.newRequest(context.Background(), http.MethodGet, "other", nil)
+		require.NoError(t, err)
+
+		storage.sign(req)
+		storage.sign(other)
+
+		require.Equal(t, "Fri, 02 Jan 2026 03:04:05 GMT", req.Header.Get("x-ms-date"))
+		require.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "SharedKey registryaccount:"))
+		// the signature covers the blob the request is sent for
+		require.NotEqual(t, req.Header.Get("Authorization"), other.Header.Get("Authorization"))
+	})
+}
@@ -0,0 +1,230 @@
+package storageprobe
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	gcsEndpoint = "https://storage.googleapis.com"
+	// gcsTokenURI is the token endpoint of the keys that don't name one
+	gcsTokenURI = "https://oauth2.googleapis.com/token"
+	// gcsScope is the scope the storage driver of the registry requests
+	gcsScope     = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+)
+
+// GCSKey is the service account key the registry authenticates to GCS with
+type GCSKey struct {
+	Type        string `json:"type"`
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	signer *rsa.PrivateKey
+}
+
+// ParseGCSKey decodes the JSON key of a service account and checks that it holds everything the registry signs
+// its token requests with
+func ParseGCSKey(data string) (*GCSKey, error) {
+	key := &GCSKey{}
+	if err := json.Unmarshal([]byte(data), key); err != nil {
+		return nil, errors.Wrap(err, "gcs key is not valid JSON")
+	}
+	if key.Type != "service_account" {
+		return nil, errors.Errorf("gcs key of type '%s' is not a service account key", key.Type)
+	}
+	if key.ClientEmail == "" {
+		return nil, errors.New("gcs key is missing client_email")
+	}
+
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, errors.New("private_key of the gcs key is not PEM encoded")
+	}
+	signer, err := parseRSAKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "while parsing private_key of the gcs key")
+	}
+	key.signer = signer
+
+	if key.TokenURI == "" {
+		key.TokenURI = gcsTokenURI
+	}
+	if tokenURI, err := url.Parse(key.TokenURI); err != nil || !tokenURI.IsAbs() {
+		return nil, errors.Errorf("token_uri '%s' of the gcs key is not an absolute url", key.TokenURI)
+	}
+	return key, nil
+}
+
+// parseRSAKey parses the PKCS #8 keys Google issues, and the PKCS #1 ones of the older keys
+func parseRSAKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("key is not an RSA key")
+	}
+	return key, nil
+}
+
+type gcsStorage struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+	root     string
+	key      *GCSKey
+	token    string
+	now      func() time.Time
+}
+
+// NewGCS returns the GCS bucket the registry is configured with, the requests are authorized with an access token
+// of the service account
+func NewGCS(key *GCSKey, bucket, root string) Storage {
+	return &gcsStorage{
+		client:   &http.Client{},
+		endpoint: gcsEndpoint,
+		bucket:   bucket,
+		root:     root,
+		key:      key,
+		now:      time.Now,
+	}
+}
+
+func (s *gcsStorage) Put(ctx context.Context, name string, content []byte) error {
+	query := url.Values{"uploadType": {"media"}, "name": {s.objectName(name)}}
+	uploadURL := s.endpoint + "/upload/storage/v1/b/" + url.PathEscape(s.bucket) + "/o?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	_, err = s.send(req, http.StatusOK)
+	return err
+}
+
+func (s *gcsStorage) Get(ctx context.Context, name string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(name)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.send(req, http.StatusOK)
+}
+
+func (s *gcsStorage) Delete(ctx context.Context, name string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.send(req, http.StatusNoContent, http.StatusOK)
+	return err
+}
+
+func (s *gcsStorage) objectName(name string) string {
+	return strings.TrimPrefix(path.Join(s.root, name), "/")
+}
+
+func (s *gcsStorage) objectURL(name string) string {
+	return s.endpoint + "/storage/v1/b/" + url.PathEscape(s.bucket) + "/o/" + url.PathEscape(s.objectName(name))
+}
+
+func (s *gcsStorage) send(req *http.Request, expected ...int) ([]byte, error) {
+	if s.token == "" {
+		token, err := s.requestToken(req.Context())
+		if err != nil {
+			return nil, errors.Wrapf(err, "while requesting access token of %s", s.key.ClientEmail)
+		}
+		s.token = token
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+
+	return send(s.client, req, expected...)
+}
+
+// requestToken exchanges the assertion signed with the key for an access token, the way the service account
+// credentials of the Google libraries do
+func (s *gcsStorage) requestToken(ctx context.Context) (string, error) {
+	assertion, err := s.assertion()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{"grant_type": {gcsGrantType}, "assertion": {assertion}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	token := struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", errors.Wrapf(err, "while decoding token response with status %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("unexpected status %s: %s: %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("token response has no access_token")
+	}
+	return token.AccessToken, nil
+}
+
+// assertion returns the JWT signed with the key of the service account
+func (s *gcsStorage) assertion() (string, error) {
+	now := s.now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iss":   s.key.ClientEmail,
+		"scope": gcsScope,
+		"aud":   s.key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(nil, s.key.signer, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Wrap(err, "while signing token request")
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
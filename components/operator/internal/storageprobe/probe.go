@@ -0,0 +1,112 @@
+package storageprobe
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// probeObjectPrefix is the name the probe objects start with, they are removed right after they are read back,
+// an object left behind by an interrupted probe is ignored by the registry
+const probeObjectPrefix = "dockerregistry-probe-"
+
+// Storage is the object storage of the registry, the probe writes, reads and deletes an object in it the way the
+// registry does with the layers it receives
+type Storage interface {
+	Put(ctx context.Context, name string, content []byte) error
+	Get(ctx context.Context, name string) ([]byte, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Probe writes an object with random content to the storage, reads it back and deletes it. The registry needs all
+// three operations, a storage that allows only some of them breaks the pushes or the garbage collection later.
+func Probe(ctx context.Context, storage Storage) error {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return errors.Wrap(err, "while generating probe object")
+	}
+	name := probeObjectPrefix + hex.EncodeToString(random[:8])
+	content := []byte(hex.EncodeToString(random))
+
+	if err := storage.Put(ctx, name, content); err != nil {
+		return errors.Wrapf(err, "while writing probe object %s", name)
+	}
+
+	read, err := storage.Get(ctx, name)
+	if err == nil && !bytes.Equal(read, content) {
+		err = errors.New("content differs from the written one")
+	}
+	if err != nil {
+		// the object is removed anyway, the read error is the one worth reporting
+		_ = storage.Delete(ctx, name)
+		return errors.Wrapf(err, "while reading probe object %s", name)
+	}
+
+	if err := storage.Delete(ctx, name); err != nil {
+		return errors.Wrapf(err, "while deleting probe object %s", name)
+	}
+	return nil
+}
+
+// send sends the request and returns the body of the response with one of the expected statuses
+func send(client *http.Client, req *http.Request, expected ...int) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "while reading response")
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return body, nil
+		}
+	}
+	return nil, statusError(resp.Status, body)
+}
+
+// statusError returns the error with the reason the storage gave, S3 and Azure describe it in XML and GCS in JSON
+func statusError(status string, body []byte) error {
+	xmlError := struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}{}
+	if err := xml.Unmarshal(body, &xmlError); err == nil && xmlError.Code != "" {
+		return errors.Errorf("unexpected status %s: %s: %s", status, xmlError.Code, firstLine(xmlError.Message))
+	}
+
+	jsonError := struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+	if err := json.Unmarshal(body, &jsonError); err == nil && jsonError.Error.Message != "" {
+		return errors.Errorf("unexpected status %s: %s", status, firstLine(jsonError.Error.Message))
+	}
+
+	if message := firstLine(string(body)); message != "" {
+		return errors.Errorf("unexpected status %s: %s", status, message)
+	}
+	return errors.Errorf("unexpected status %s", status)
+}
+
+// firstLine returns the first line of the message, cut to a length that fits a condition message
+func firstLine(message string) string {
+	message, _, _ = strings.Cut(strings.TrimSpace(message), "\n")
+	if len(message) > 256 {
+		return fmt.Sprintf("%s...", message[:256])
+	}
+	return message
+}
@@ -0,0 +1,192 @@
+package storageprobe
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbe(t *testing.T) {
+	t.Run("write, read and delete probe object", func(t *testing.T) {
+		storage := &fakeStorage{objects: map[string][]byte{}}
+
+		err := Probe(context.Background(), storage)
+
+		require.NoError(t, err)
+		require.Len(t, storage.written, 1)
+		require.True(t, strings.HasPrefix(storage.written[0], probeObjectPrefix))
+		require.Empty(t, storage.objects)
+	})
+
+	t.Run("return write error", func(t *testing.T) {
+		storage := &fakeStorage{objects: map[string][]byte{}, putErr: errors.New("access denied")}
+
+		err := Probe(context.Background(), storage)
+
+		require.ErrorContains(t, err, "while writing probe object "+probeObjectPrefix)
+		require.ErrorContains(t, err, "access denied")
+	})
+
+	t.Run("delete object that can't be read", func(t *testing.T) {
+		storage := &fakeStorage{objects: map[string][]byte{}, getErr: errors.New("access denied")}
+
+		err := Probe(context.Background(), storage)
+
+		require.ErrorContains(t, err, "while reading probe object")
+		require.Empty(t, storage.objects)
+	})
+
+	t.Run("return error for changed content", func(t *testing.T) {
+		storage := &fakeStorage{objects: map[string][]byte{}, content: []byte("other")}
+
+		err := Probe(context.Background(), storage)
+
+		require.ErrorContains(t, err, "content differs from the written one")
+	})
+
+	t.Run("return delete error", func(t *testing.T) {
+		storage := &fakeStorage{objects: map[string][]byte{}, deleteErr: errors.New("access denied")}
+
+		err := Probe(context.Background(), storage)
+
+		require.ErrorContains(t, err, "while deleting probe object")
+	})
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "xml error of s3 and azure",
+			body: `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchBucket</Code><Message>The specified bucket does not exist</Message></Error>`,
+			want: "unexpected status 404 Not Found: NoSuchBucket: The specified bucket does not exist",
+		},
+		{
+			name: "json error of gcs",
+			body: `{"error":{"code":404,"message":"The specified bucket does not exist."}}`,
+			want: "unexpected status 404 Not Found: The specified bucket does not exist.",
+		},
+		{
+			name: "plain text",
+			body: "not found\nat proxy",
+			want: "unexpected status 404 Not Found: not found",
+		},
+		{
+			name: "empty body",
+			want: "unexpected status 404 Not Found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.EqualError(t, statusError("404 Not Found", []byte(tt.body)), tt.want)
+		})
+	}
+}
+
+type fakeStorage struct {
+	objects   map[string][]byte
+	written   []string
+	content   []byte
+	putErr    error
+	getErr    error
+	deleteErr error
+}
+
+func (f *fakeStorage) Put(_ context.Context, name string, content []byte) error {
+	if f.putErr != nil {
+		return f.putErr
+	}
+	f.objects[name] = content
+	f.written = append(f.written, name)
+	return nil
+}
+
+func (f *fakeStorage) Get(_ context.Context, name string) ([]byte, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	if f.content != nil {
+		return f.content, nil
+	}
+	return f.objects[name], nil
+}
+
+func (f *fakeStorage) Delete(_ context.Context, name string) error {
+	if f.deleteErr != nil {
+		return f.deleteErr
+	}
+	delete(f.objects, name)
+	return nil
+}
+
+// objectServer stands in for the object storage, it keeps the objects by their paths and rejects the requests
+// the authorize function returns an error for
+type objectServer struct {
+	*httptest.Server
+	lock      sync.Mutex
+	objects   map[string][]byte
+	requests  []*http.Request
+	authorize func(r *http.Request, body []byte) error
+	// statuses are returned for the written, read and deleted objects
+	putStatus    int
+	deleteStatus int
+}
+
+func newObjectServer(t *testing.T, putStatus, deleteStatus int) *objectServer {
+	server := &objectServer{
+		objects:      map[string][]byte{},
+		authorize:    func(*http.Request, []byte) error { return nil },
+		putStatus:    putStatus,
+		deleteStatus: deleteStatus,
+	}
+	server.Server = httptest.NewServer(server)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *objectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	s.requests = append(s.requests, r)
+	if err := s.authorize(r, body); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`<Error><Code>AccessDenied</Code><Message>` + err.Error() + `</Message></Error>`))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		s.objects[objectKey(r)] = body
+		w.WriteHeader(s.putStatus)
+	case http.MethodGet:
+		content, ok := s.objects[objectKey(r)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(content)
+	case http.MethodDelete:
+		delete(s.objects, objectKey(r))
+		w.WriteHeader(s.deleteStatus)
+	}
+}
+
+// objectKey returns the path of the object, the GCS uploads name it in the query
+func objectKey(r *http.Request) string {
+	if name := r.URL.Query().Get("name"); name != "" {
+		return strings.Replace(r.URL.Path, "/upload", "", 1) + "/" + name
+	}
+	return r.URL.Path
+}
@@ -0,0 +1,212 @@
+package storageprobe
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/pkg/errors"
+)
+
+const (
+	s3Service         = "s3"
+	s3SigningScheme   = "AWS4-HMAC-SHA256"
+	s3DateFormat      = "20060102T150405Z"
+	s3ScopeDateFormat = "20060102"
+)
+
+type s3Storage struct {
+	client    *http.Client
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	root      string
+	region    string
+	accessKey string
+	secretKey string
+	// headers are sent with the written objects, they request the server side encryption the registry uses
+	headers map[string]string
+	now     func() time.Time
+}
+
+// NewS3 returns the S3 bucket the registry is configured with, the requests are signed with the access keys the
+// way the s3 storage driver of the registry signs them
+func NewS3(config *v1alpha1.StorageS3, secret *v1alpha1.StorageS3Secrets) (Storage, error) {
+	if config.V4Auth != nil && !*config.V4Auth {
+		return nil, errors.New("storage with the signature version 2 can't be probed")
+	}
+	if secret == nil || secret.AccessKey == "" || secret.SecretKey == "" {
+		return nil, errors.New("s3 secret is missing accessKey or secretKey")
+	}
+
+	endpoint, err := s3Endpoint(config)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]string{}
+	if config.Encrypt {
+		headers["x-amz-server-side-encryption"] = "AES256"
+		if config.KeyID != "" {
+			headers["x-amz-server-side-encryption"] = "aws:kms"
+			headers["x-amz-server-side-encryption-aws-kms-key-id"] = config.KeyID
+		}
+	}
+
+	client := &http.Client{}
+	if config.SkipVerify {
+		client.Transport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			// the registry skips the verification of the endpoint too, the probe has to reach the same storage
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec
+		}
+	}
+
+	return &s3Storage{
+		client:    client,
+		endpoint:  endpoint,
+		bucket:    config.Bucket,
+		pathStyle: config.ForcePathStyle,
+		root:      config.Rootdirectory,
+		region:    config.Region,
+		accessKey: secret.AccessKey,
+		secretKey: secret.SecretKey,
+		headers:   headers,
+		now:       time.Now,
+	}, nil
+}
+
+// s3Endpoint returns the regionEndpoint, or the AWS endpoint of the region, the driver uses https unless the
+// endpoint names the scheme
+func s3Endpoint(config *v1alpha1.StorageS3) (*url.URL, error) {
+	if config.RegionEndpoint == "" {
+		return &url.URL{Scheme: "https", Host: fmt.Sprintf("s3.%s.amazonaws.com", config.Region)}, nil
+	}
+
+	endpoint := config.RegionEndpoint
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Host == "" {
+		return nil, errors.Errorf("region endpoint '%s' is not a valid url", config.RegionEndpoint)
+	}
+	return parsed, nil
+}
+
+func (s *s3Storage) Put(ctx context.Context, name string, content []byte) error {
+	req, err := s.newRequest(ctx, http.MethodPut, name, content)
+	if err != nil {
+		return err
+	}
+	for key, value := range s.headers {
+		req.Header.Set(key, value)
+	}
+	s.sign(req, content)
+
+	_, err = send(s.client, req, http.StatusOK)
+	return err
+}
+
+func (s *s3Storage) Get(ctx context.Context, name string) ([]byte, error) {
+	req, err := s.newRequest(ctx, http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, nil)
+
+	return send(s.client, req, http.StatusOK)
+}
+
+func (s *s3Storage) Delete(ctx context.Context, name string) error {
+	req, err := s.newRequest(ctx, http.MethodDelete, name, nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, nil)
+
+	_, err = send(s.client, req, http.StatusNoContent, http.StatusOK)
+	return err
+}
+
+func (s *s3Storage) newRequest(ctx context.Context, method, name string, content []byte) (*http.Request, error) {
+	objectURL := *s.endpoint
+	key := path.Join("/", s.root, name)
+	if s.pathStyle {
+		objectURL.Path = path.Join("/", objectURL.Path, s.bucket, key)
+	} else {
+		objectURL.Host = s.bucket + "." + objectURL.Host
+		objectURL.Path = path.Join("/", objectURL.Path, key)
+	}
+
+	return http.NewRequestWithContext(ctx, method, objectURL.String(), bytes.NewReader(content))
+}
+
+// sign adds the signature version 4 of the request to its headers, all headers set so far are signed
+func (s *s3Storage) sign(req *http.Request, payload []byte) {
+	now := s.now().UTC()
+	payloadHash := sha256.Sum256(payload)
+	req.Header.Set("x-amz-date", now.Format(s3DateFormat))
+	req.Header.Set("x-amz-content-sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for key, values := range req.Header {
+		headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := strings.Builder{}
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		// the query is sorted by the keys when it is encoded
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := strings.Join([]string{now.Format(s3ScopeDateFormat), s.region, s3Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		s3SigningScheme,
+		now.Format(s3DateFormat),
+		scope,
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{now.Format(s3ScopeDateFormat), s.region, s3Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3SigningScheme, s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,57 @@
+package token
+
+import (
+	"context"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// grantActions are the actions the temporary credentials of a RegistryAccessGrant are allowed
+var grantActions = []string{"pull", "push"}
+
+// authenticateGrant accepts the temporary credentials of the RegistryAccessGrants issued for the registry, until
+// they expire or their Secret is deleted. It returns no identity for a username no grant was issued with.
+func (s *Server) authenticateGrant(ctx context.Context, instance *v1alpha1.DockerRegistry, req *tokenRequest) (*identity, error) {
+	grants := v1alpha1.RegistryAccessGrantList{}
+	if err := s.client.List(ctx, &grants); err != nil {
+		return nil, errors.Wrap(err, "while listing registryaccessgrants")
+	}
+
+	for i := range grants.Items {
+		grant := &grants.Items[i]
+		if grant.Status.Username == "" || grant.Status.Username != req.username ||
+			grant.Status.RegistryNamespace != instance.GetNamespace() {
+			continue
+		}
+		if !grant.IsActive(s.now()) {
+			return nil, errUnauthorized
+		}
+
+		secret, err := registry.GetSecret(ctx, s.client, grant.Status.SecretName, grant.GetNamespace())
+		if apierrors.IsNotFound(err) {
+			return nil, errUnauthorized
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "while fetching registryaccessgrant secret")
+		}
+		if !matchCredentials(secret.Data["username"], secret.Data["password"], req) {
+			return nil, errUnauthorized
+		}
+
+		id := &identity{
+			name:       grant.Status.Requester,
+			actions:    grantActions,
+			expiration: grant.Status.ExpirationTime.Time,
+		}
+		// the registry of the docker-registry namespace is shared, so a grant from another namespace pushes only
+		// to the repositories of its namespace
+		if grant.GetNamespace() != instance.GetNamespace() {
+			id.namespace = grant.GetNamespace()
+		}
+		return id, nil
+	}
+	return nil, nil
+}
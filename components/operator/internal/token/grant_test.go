@@ -0,0 +1,156 @@
+package token
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServer_authenticateGrant(t *testing.T) {
+	now := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+	keys, err := RotateKeys(nil, now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	t.Run("issue token for grant credentials until they expire", func(t *testing.T) {
+		grant := fixGrant("test-namespace", now.Add(2*time.Minute))
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), grant, fixGrantSecret(grant),
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService+
+			"&scope=repository:ci/app:pull,push,delete", nil)
+		req.SetBasicAuth("grant-user", "grant-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		require.Equal(t, int64(120), body.ExpiresIn)
+
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, "dev@example.com", claims.Subject)
+		require.Equal(t, now.Add(2*time.Minute).Unix(), claims.Expiration)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "ci/app", Actions: []string{"pull", "push"}},
+		}, claims.Access)
+	})
+
+	t.Run("limit grant from another namespace to its repositories", func(t *testing.T) {
+		grant := fixGrant("team-a", now.Add(time.Hour))
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), grant, fixGrantSecret(grant),
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService+
+			"&scope=repository:team-a/app:push&scope=repository:ci/app:pull,push", nil)
+		req.SetBasicAuth("grant-user", "grant-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, int64(600), body.ExpiresIn)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "team-a/app", Actions: []string{"push"}},
+			{Type: "repository", Name: "ci/app", Actions: []string{"pull"}},
+		}, claims.Access)
+	})
+
+	t.Run("accept grant credentials with oidc", func(t *testing.T) {
+		instance := fixServedInstance()
+		instance.Spec.ExternalAccess = &v1alpha1.ExternalAccess{OIDC: &v1alpha1.ExternalAccessOIDC{IssuerURL: "https://idp.example.com", ClientID: "registry"}}
+		grant := fixGrant("dev", now.Add(time.Hour))
+		server := fixServer(t, now, instance, fixAccessSecret(), grant, fixGrantSecret(grant),
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService, nil)
+		req.SetBasicAuth("grant-user", "grant-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("reject expired grant", func(t *testing.T) {
+		grant := fixGrant("dev", now)
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), grant, fixGrantSecret(grant),
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService, nil)
+		req.SetBasicAuth("grant-user", "grant-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("reject grant with deleted secret", func(t *testing.T) {
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), fixGrant("dev", now.Add(time.Hour)),
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService, nil)
+		req.SetBasicAuth("grant-user", "grant-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("reject grant for another registry", func(t *testing.T) {
+		grant := fixGrant("dev", now.Add(time.Hour))
+		grant.Status.RegistryNamespace = "docker-registry"
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), grant, fixGrantSecret(grant),
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService, nil)
+		req.SetBasicAuth("grant-user", "grant-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("reject grant credentials inside the cluster", func(t *testing.T) {
+		grant := fixGrant("dev", now.Add(time.Hour))
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), grant, fixGrantSecret(grant),
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService, nil)
+		req.SetBasicAuth("grant-user", "grant-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+}
+
+func fixGrant(namespace string, expiration time.Time) *v1alpha1.RegistryAccessGrant {
+	return &v1alpha1.RegistryAccessGrant{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "laptop",
+			Namespace: namespace,
+		},
+		Status: v1alpha1.RegistryAccessGrantStatus{
+			State:             v1alpha1.GrantStateActive,
+			Requester:         "dev@example.com",
+			RegistryNamespace: "test-namespace",
+			SecretName:        "laptop",
+			Username:          "grant-user",
+			ExpirationTime:    &metav1.Time{Time: expiration},
+		},
+	}
+}
+
+func fixGrantSecret(grant *v1alpha1.RegistryAccessGrant) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      grant.Status.SecretName,
+			Namespace: grant.GetNamespace(),
+		},
+		Data: map[string][]byte{
+			"username": []byte("grant-user"),
+			"password": []byte("grant-pass"),
+		},
+	}
+}
@@ -0,0 +1,113 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Issuer is the issuer of the tokens, the registry rejects tokens of other issuers
+	Issuer = "dockerregistry-operator"
+)
+
+// ResourceActions is an entry of the access claim, it grants the actions on the named resource of the type
+type ResourceActions struct {
+	Type    string   `json:"type"`
+	Name    string   `json:"name"`
+	Actions []string `json:"actions"`
+}
+
+// Claims are the claims of a token issued for the subject to access the registry identified by the audience
+type Claims struct {
+	Subject  string
+	Audience string
+	Access   []ResourceActions
+}
+
+type jwtHeader struct {
+	Type      string   `json:"typ"`
+	Algorithm string   `json:"alg"`
+	KeyID     string   `json:"kid"`
+	X509Chain []string `json:"x5c"`
+}
+
+type jwtClaims struct {
+	Issuer     string            `json:"iss"`
+	Subject    string            `json:"sub"`
+	Audience   string            `json:"aud"`
+	Expiration int64             `json:"exp"`
+	NotBefore  int64             `json:"nbf"`
+	IssuedAt   int64             `json:"iat"`
+	JWTID      string            `json:"jti"`
+	Access     []ResourceActions `json:"access"`
+}
+
+// Sign issues an ES256 JWT in the format of the Docker registry token authentication. The certificate of the key is
+// sent along, so that the registry can check it against the bundle without knowing the key id.
+func (k *SigningKey) Sign(claims Claims, now time.Time, ttl time.Duration) (string, error) {
+	access := claims.Access
+	if access == nil {
+		// the registry expects a list, also when nothing is granted
+		access = []ResourceActions{}
+	}
+
+	header, err := json.Marshal(jwtHeader{
+		Type:      "JWT",
+		Algorithm: "ES256",
+		KeyID:     k.KeyID(),
+		X509Chain: []string{base64.StdEncoding.EncodeToString(k.cert.Raw)},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(jwtClaims{
+		Issuer:     Issuer,
+		Subject:    claims.Subject,
+		Audience:   claims.Audience,
+		Expiration: now.Add(ttl).Unix(),
+		NotBefore:  now.Unix(),
+		IssuedAt:   now.Unix(),
+		JWTID:      uuid.NewString(),
+		Access:     access,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, k.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	// ES256 signatures are the fixed size big-endian r and s concatenated
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// ParseScope reads a scope in the `type:name:actions` format, the name of a repository may contain colons itself
+func ParseScope(scope string) (ResourceActions, bool) {
+	first := strings.Index(scope, ":")
+	last := strings.LastIndex(scope, ":")
+	if first <= 0 || first == last || last == len(scope)-1 {
+		return ResourceActions{}, false
+	}
+
+	return ResourceActions{
+		Type:    scope[:first],
+		Name:    scope[first+1 : last],
+		Actions: strings.Split(scope[last+1:], ","),
+	}, true
+}
@@ -0,0 +1,154 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningKey_Sign(t *testing.T) {
+	now := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+	data, err := RotateKeys(nil, now)
+	require.NoError(t, err)
+	key, err := parseSigningKey(data[currentKeyKey], data[currentCertKey])
+	require.NoError(t, err)
+
+	t.Run("sign token verifiable with certificate", func(t *testing.T) {
+		signed, err := key.Sign(Claims{
+			Subject:  "user",
+			Audience: "dockerregistry.test.svc.cluster.local",
+			Access: []ResourceActions{
+				{Type: "repository", Name: "ci/app", Actions: []string{"pull", "push"}},
+			},
+		}, now, 5*time.Minute)
+		require.NoError(t, err)
+
+		header, claims := verifyToken(t, signed)
+		require.Equal(t, "ES256", header.Algorithm)
+		require.Equal(t, key.KeyID(), header.KeyID)
+		require.Len(t, header.X509Chain, 1)
+
+		require.Equal(t, Issuer, claims.Issuer)
+		require.Equal(t, "user", claims.Subject)
+		require.Equal(t, "dockerregistry.test.svc.cluster.local", claims.Audience)
+		require.Equal(t, now.Unix(), claims.IssuedAt)
+		require.Equal(t, now.Add(5*time.Minute).Unix(), claims.Expiration)
+		require.NotEmpty(t, claims.JWTID)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "ci/app", Actions: []string{"pull", "push"}},
+		}, claims.Access)
+	})
+
+	t.Run("sign token without access", func(t *testing.T) {
+		signed, err := key.Sign(Claims{Audience: "dockerregistry.test.svc.cluster.local"}, now, time.Minute)
+		require.NoError(t, err)
+
+		payload, err := base64.RawURLEncoding.DecodeString(strings.Split(signed, ".")[1])
+		require.NoError(t, err)
+		require.Contains(t, string(payload), `"access":[]`)
+	})
+}
+
+func TestSigningKey_KeyID(t *testing.T) {
+	data, err := RotateKeys(nil, time.Now())
+	require.NoError(t, err)
+	key, err := parseSigningKey(data[currentKeyKey], data[currentCertKey])
+	require.NoError(t, err)
+
+	keyID := key.KeyID()
+
+	// the SHA-256 thumbprint is 32 bytes long
+	require.Len(t, keyID, 43)
+	require.Equal(t, keyID, key.KeyID())
+}
+
+func TestParseScope(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope string
+		want  ResourceActions
+		ok    bool
+	}{
+		{
+			name:  "repository scope",
+			scope: "repository:ci/app:pull,push",
+			want:  ResourceActions{Type: "repository", Name: "ci/app", Actions: []string{"pull", "push"}},
+			ok:    true,
+		},
+		{
+			name:  "repository name with port",
+			scope: "repository:localhost:5000/app:pull",
+			want:  ResourceActions{Type: "repository", Name: "localhost:5000/app", Actions: []string{"pull"}},
+			ok:    true,
+		},
+		{
+			name:  "catalog scope",
+			scope: "registry:catalog:*",
+			want:  ResourceActions{Type: "registry", Name: "catalog", Actions: []string{"*"}},
+			ok:    true,
+		},
+		{
+			name:  "missing actions",
+			scope: "repository:ci/app:",
+		},
+		{
+			name:  "missing name",
+			scope: "repository:pull",
+		},
+		{
+			name:  "missing type",
+			scope: ":ci/app:pull",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseScope(tt.scope)
+
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// verifyToken checks the signature with the certificate sent along, the way the registry does
+func verifyToken(t *testing.T, signed string) (jwtHeader, jwtClaims) {
+	parts := strings.Split(signed, ".")
+	require.Len(t, parts, 3)
+
+	header := jwtHeader{}
+	decodeSegment(t, parts[0], &header)
+	claims := jwtClaims{}
+	decodeSegment(t, parts[1], &claims)
+
+	der, err := base64.StdEncoding.DecodeString(header.X509Chain[0])
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	require.True(t, ok)
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	require.Len(t, signature, 64)
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	require.True(t, ecdsa.Verify(publicKey, digest[:], r, s))
+
+	return header, claims
+}
+
+func decodeSegment(t *testing.T, segment string, v interface{}) {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(raw, v))
+}
@@ -0,0 +1,176 @@
+package token
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// BundleKey holds the certificates of the keys the registry accepts tokens signed with
+	BundleKey = "bundle.pem"
+	// RotationTimeAnnotation is the time the signing key was last replaced at
+	RotationTimeAnnotation = "dockerregistry.kyma-project.io/token-key-rotation-time"
+
+	currentKeyKey   = "tls.key"
+	currentCertKey  = "tls.crt"
+	previousKeyKey  = "previous.key"
+	previousCertKey = "previous.crt"
+
+	// the key is replaced by the rotation, the certificate only has to outlive it
+	certValidity = 10 * 365 * 24 * time.Hour
+	// keyActivationDelay is the time the registry has to roll out the bundle with a new certificate,
+	// the previous key signs the tokens until then
+	keyActivationDelay = 10 * time.Minute
+)
+
+// SigningKey signs the tokens, the registry finds its certificate in the bundle by the key id
+type SigningKey struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+// RotateKeys returns the signing keys Secret data with a new current key, the current key becomes the previous one
+// and stays in the bundle, so that the tokens it signed remain valid until they expire
+func RotateKeys(data map[string][]byte, now time.Time) (map[string][]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: randomSerialNumber(),
+		Subject:      pkix.Name{CommonName: Issuer},
+		// the clocks of the registry nodes may be behind
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(certValidity),
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	rotated := map[string][]byte{
+		currentKeyKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		currentCertKey: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+	}
+	bundle := rotated[currentCertKey]
+	if _, err := parseSigningKey(data[currentKeyKey], data[currentCertKey]); err == nil {
+		rotated[previousKeyKey] = data[currentKeyKey]
+		rotated[previousCertKey] = data[currentCertKey]
+		bundle = append(bundle, data[currentCertKey]...)
+	}
+	rotated[BundleKey] = bundle
+
+	return rotated, nil
+}
+
+// RotationTime returns the time the keys of the Secret were last rotated at, it is zero when the Secret holds no keys
+func RotationTime(secret *corev1.Secret) time.Time {
+	if _, err := parseSigningKey(secret.Data[currentKeyKey], secret.Data[currentCertKey]); err != nil {
+		return time.Time{}
+	}
+
+	rotationTime, err := time.Parse(time.RFC3339, secret.GetAnnotations()[RotationTimeAnnotation])
+	if err != nil {
+		return time.Time{}
+	}
+	return rotationTime
+}
+
+// LoadSigningKey returns the key the tokens are signed with at the given time. A new key is used only once the
+// registry had the time to trust it.
+func LoadSigningKey(secret *corev1.Secret, now time.Time) (*SigningKey, error) {
+	current, err := parseSigningKey(secret.Data[currentKeyKey], secret.Data[currentCertKey])
+	if err != nil {
+		return nil, errors.Wrapf(err, "while reading signing key from secret %s/%s", secret.GetNamespace(), secret.GetName())
+	}
+
+	if now.Sub(RotationTime(secret)) >= keyActivationDelay {
+		return current, nil
+	}
+
+	previous, err := parseSigningKey(secret.Data[previousKeyKey], secret.Data[previousCertKey])
+	if err != nil {
+		// the first key is trusted together with the token authentication, there is nothing to wait for
+		return current, nil
+	}
+	return previous, nil
+}
+
+// KeyID returns the RFC 7638 thumbprint of the public key, the registry identifies the trusted keys by it
+func (k *SigningKey) KeyID() string {
+	// the uncompressed point holds both coordinates after the format byte
+	point, err := k.key.PublicKey.Bytes()
+	if err != nil {
+		return ""
+	}
+	size := (len(point) - 1) / 2
+
+	thumbprint, _ := json.Marshal(struct {
+		Crv string `json:"crv"`
+		Kty string `json:"kty"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}{
+		Crv: k.key.Curve.Params().Name,
+		Kty: "EC",
+		X:   base64.RawURLEncoding.EncodeToString(point[1 : 1+size]),
+		Y:   base64.RawURLEncoding.EncodeToString(point[1+size:]),
+	})
+
+	digest := sha256.Sum256(thumbprint)
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+func parseSigningKey(keyPEM, certPEM []byte) (*SigningKey, error) {
+	keyBlock, _ := pem.Decode(bytes.TrimSpace(keyPEM))
+	if keyBlock == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	certBlock, _ := pem.Decode(bytes.TrimSpace(certPEM))
+	if certBlock == nil {
+		return nil, errors.New("signing certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		return nil, errors.New("signing certificate does not match the signing key")
+	}
+
+	return &SigningKey{key: key, cert: cert}, nil
+}
+
+func randomSerialNumber() *big.Int {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serialNumber
+}
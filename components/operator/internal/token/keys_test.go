@@ -0,0 +1,148 @@
+package token
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRotateKeys(t *testing.T) {
+	now := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+
+	t.Run("generate first key", func(t *testing.T) {
+		data, err := RotateKeys(nil, now)
+
+		require.NoError(t, err)
+		require.NotEmpty(t, data[currentKeyKey])
+		require.NotContains(t, data, previousKeyKey)
+		require.Len(t, readBundle(t, data[BundleKey]), 1)
+
+		key, err := parseSigningKey(data[currentKeyKey], data[currentCertKey])
+		require.NoError(t, err)
+		require.Equal(t, Issuer, key.cert.Subject.CommonName)
+		require.True(t, key.cert.NotBefore.Before(now))
+	})
+
+	t.Run("keep previous key in bundle", func(t *testing.T) {
+		first, err := RotateKeys(nil, now)
+		require.NoError(t, err)
+
+		data, err := RotateKeys(first, now.Add(time.Hour))
+
+		require.NoError(t, err)
+		require.Equal(t, first[currentKeyKey], data[previousKeyKey])
+		require.Equal(t, first[currentCertKey], data[previousCertKey])
+		require.NotEqual(t, first[currentKeyKey], data[currentKeyKey])
+		require.Len(t, readBundle(t, data[BundleKey]), 2)
+	})
+
+	t.Run("drop the key before the previous one", func(t *testing.T) {
+		first, err := RotateKeys(nil, now)
+		require.NoError(t, err)
+		second, err := RotateKeys(first, now.Add(time.Hour))
+		require.NoError(t, err)
+
+		data, err := RotateKeys(second, now.Add(2*time.Hour))
+
+		require.NoError(t, err)
+		require.Equal(t, second[currentKeyKey], data[previousKeyKey])
+		require.Len(t, readBundle(t, data[BundleKey]), 2)
+	})
+
+	t.Run("ignore invalid current key", func(t *testing.T) {
+		data, err := RotateKeys(map[string][]byte{
+			currentKeyKey:  []byte("invalid"),
+			currentCertKey: []byte("invalid"),
+		}, now)
+
+		require.NoError(t, err)
+		require.NotContains(t, data, previousKeyKey)
+		require.Len(t, readBundle(t, data[BundleKey]), 1)
+	})
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	rotationTime := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+
+	first, err := RotateKeys(nil, rotationTime.Add(-time.Hour))
+	require.NoError(t, err)
+	second, err := RotateKeys(first, rotationTime)
+	require.NoError(t, err)
+
+	t.Run("sign with previous key until registry trusts the new one", func(t *testing.T) {
+		key, err := LoadSigningKey(fixKeysSecret(second, rotationTime), rotationTime.Add(time.Minute))
+
+		require.NoError(t, err)
+		require.Equal(t, second[previousCertKey], certPEM(key))
+	})
+
+	t.Run("sign with current key after activation delay", func(t *testing.T) {
+		key, err := LoadSigningKey(fixKeysSecret(second, rotationTime), rotationTime.Add(keyActivationDelay))
+
+		require.NoError(t, err)
+		require.Equal(t, second[currentCertKey], certPEM(key))
+	})
+
+	t.Run("sign with first key right away", func(t *testing.T) {
+		key, err := LoadSigningKey(fixKeysSecret(first, rotationTime), rotationTime)
+
+		require.NoError(t, err)
+		require.Equal(t, first[currentCertKey], certPEM(key))
+	})
+
+	t.Run("return error for secret without keys", func(t *testing.T) {
+		_, err := LoadSigningKey(fixKeysSecret(nil, rotationTime), rotationTime)
+
+		require.ErrorContains(t, err, "while reading signing key from secret docker-registry/token-keys")
+	})
+}
+
+func TestRotationTime(t *testing.T) {
+	rotationTime := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+	data, err := RotateKeys(nil, rotationTime)
+	require.NoError(t, err)
+
+	t.Run("read rotation time", func(t *testing.T) {
+		require.Equal(t, rotationTime, RotationTime(fixKeysSecret(data, rotationTime)))
+	})
+
+	t.Run("return zero time for secret without keys", func(t *testing.T) {
+		require.True(t, RotationTime(fixKeysSecret(nil, rotationTime)).IsZero())
+	})
+
+	t.Run("return zero time for secret without annotation", func(t *testing.T) {
+		require.True(t, RotationTime(&corev1.Secret{Data: data}).IsZero())
+	})
+}
+
+func fixKeysSecret(data map[string][]byte, rotationTime time.Time) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "token-keys",
+			Namespace: "docker-registry",
+			Annotations: map[string]string{
+				RotationTimeAnnotation: rotationTime.Format(time.RFC3339),
+			},
+		},
+		Data: data,
+	}
+}
+
+func certPEM(key *SigningKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: key.cert.Raw})
+}
+
+func readBundle(t *testing.T, bundle []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(bundle); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(t, err)
+		certs = append(certs, cert)
+	}
+	return certs
+}
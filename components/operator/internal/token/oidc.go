@@ -0,0 +1,89 @@
+package token
+
+import (
+	"context"
+	"strings"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/oidc"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/pkg/errors"
+)
+
+// OIDCClientSecretKey is the key of the client secret in the Secret referenced by the oidc configuration
+const OIDCClientSecretKey = "clientSecret"
+
+// oidcActions are the actions the users logged in with the identity provider are allowed, the deletes are left to
+// the clients inside the cluster
+var oidcActions = []string{"pull", "push"}
+
+// authenticateOIDC logs in the clients outside the cluster, which are sent to the token server by the VirtualService
+// of the registry. Neither the registry nor the external access credentials are accepted there. A password that is
+// an ID token, for example the result of a device flow, is verified on its own, any other password is exchanged for
+// an ID token with the password grant. The username of an ID token is not checked, the token is what identifies the user.
+func (s *Server) authenticateOIDC(ctx context.Context, instance *v1alpha1.DockerRegistry, _ registry.ResourceNames, req *tokenRequest) (*identity, error) {
+	config := instance.ExternalOIDC()
+	provider := s.oidcProvider(config)
+
+	idToken := req.password
+	if !isJWT(req.password) {
+		clientSecret, err := s.oidcClientSecret(ctx, instance, config)
+		if err != nil {
+			return nil, err
+		}
+
+		idToken, err = provider.PasswordGrant(ctx, req.username, req.password, clientSecret)
+		if errors.Is(err, oidc.ErrInvalidCredentials) {
+			return nil, errUnauthorized
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "while logging in with identity provider")
+		}
+	}
+
+	claims, err := provider.Verify(ctx, idToken, s.now())
+	if errors.Is(err, oidc.ErrInvalidToken) {
+		s.log.Debugf("rejected id token: %s", err)
+		return nil, errUnauthorized
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "while verifying id token")
+	}
+
+	username := claims.String(config.GetUsernameClaim())
+	if username == "" {
+		s.log.Debugf("rejected id token without the %s claim", config.GetUsernameClaim())
+		return nil, errUnauthorized
+	}
+	return &identity{
+		name:    username,
+		actions: oidcActions,
+		subject: &v1alpha1.PolicySubject{Kind: v1alpha1.PolicySubjectOIDCUser, Name: username},
+	}, nil
+}
+
+// oidcProvider returns the provider of the issuer and the client, the providers are kept to reuse the discovered
+// configuration and the fetched keys
+func (s *Server) oidcProvider(config *v1alpha1.ExternalAccessOIDC) *oidc.Provider {
+	key := strings.Join([]string{config.IssuerURL, config.ClientID}, " ")
+	provider, _ := s.oidcProviders.LoadOrStore(key, oidc.NewProvider(config.IssuerURL, config.ClientID, s.httpClient))
+	return provider.(*oidc.Provider)
+}
+
+// oidcClientSecret returns the client secret of a confidential client, or an empty string for a public client
+func (s *Server) oidcClientSecret(ctx context.Context, instance *v1alpha1.DockerRegistry, config *v1alpha1.ExternalAccessOIDC) (string, error) {
+	if config.ClientSecretRef == nil {
+		return "", nil
+	}
+
+	secret, err := registry.GetSecret(ctx, s.client, config.ClientSecretRef.Name, instance.GetNamespace())
+	if err != nil {
+		return "", errors.Wrap(err, "while fetching oidc client secret")
+	}
+
+	clientSecret := string(secret.Data[OIDCClientSecretKey])
+	if clientSecret == "" {
+		return "", errors.Errorf("secret %s has no %s key", config.ClientSecretRef.Name, OIDCClientSecretKey)
+	}
+	return clientSecret, nil
+}
@@ -0,0 +1,206 @@
+package token
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/oidc"
+	"github.com/kyma-project/docker-registry/components/operator/internal/oidc/oidctest"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const testExternalTokenPath = "/token/external"
+
+func TestServer_authenticateOIDC(t *testing.T) {
+	now := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+	keys, err := RotateKeys(nil, now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	idp := oidctest.NewProvider(t, "docker-registry", oidc.RS256)
+	idp.ClientSecret = "client-secret"
+	idp.AddUser("dev", "dev-password", map[string]interface{}{"email": "dev@example.com"})
+
+	t.Run("issue token for password of identity provider user", func(t *testing.T) {
+		server := fixOIDCServer(t, idp, now, fixOIDCInstance(idp), fixAccessSecret(), fixOIDCClientSecret(),
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService+
+			"&scope=repository:team-a/app:pull,push,delete", nil)
+		req.SetBasicAuth("dev", "dev-password")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, "dev@example.com", claims.Subject)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "team-a/app", Actions: []string{"pull", "push"}},
+		}, claims.Access)
+	})
+
+	t.Run("issue token for id token of device flow", func(t *testing.T) {
+		server := fixOIDCServer(t, idp, now, fixOIDCInstance(idp), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService+
+			"&scope=repository:team-a/app:pull", nil)
+		req.SetBasicAuth("oauth2", idp.IDToken(map[string]interface{}{"email": "dev@example.com"}))
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, "dev@example.com", claims.Subject)
+	})
+
+	t.Run("restrict identity provider user by access policies", func(t *testing.T) {
+		policy := fixAccessPolicy("dev", []v1alpha1.PolicySubject{
+			{Kind: v1alpha1.PolicySubjectOIDCUser, Name: "dev@example.com"},
+		}, []v1alpha1.PolicyRule{
+			{Repositories: []string{"team-a/*"}, Actions: []string{"pull"}},
+		})
+		server := fixOIDCServer(t, idp, now, fixOIDCInstance(idp), fixAccessSecret(), &policy,
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService+
+			"&scope=repository:team-a/app:pull,push", nil)
+		req.SetBasicAuth("oauth2", idp.IDToken(map[string]interface{}{"email": "dev@example.com"}))
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "team-a/app", Actions: []string{"pull"}},
+		}, claims.Access)
+		require.Equal(t, "Warning AccessDenied Registry access policies denied oidc user dev@example.com: repository:team-a/app:push",
+			<-server.recorder.(*record.FakeRecorder).Events)
+	})
+
+	t.Run("keep access of identity provider user named like a user listed in the CR", func(t *testing.T) {
+		policy := fixAccessPolicy("dev", []v1alpha1.PolicySubject{
+			{Kind: v1alpha1.PolicySubjectUser, Name: "dev@example.com"},
+		}, []v1alpha1.PolicyRule{
+			{Repositories: []string{"team-a/*"}, Actions: []string{"pull"}},
+		})
+		server := fixOIDCServer(t, idp, now, fixOIDCInstance(idp), fixAccessSecret(), &policy,
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService+
+			"&scope=repository:team-a/app:pull,push", nil)
+		req.SetBasicAuth("oauth2", idp.IDToken(map[string]interface{}{"email": "dev@example.com"}))
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "team-a/app", Actions: []string{"pull", "push"}},
+		}, claims.Access)
+	})
+
+	t.Run("reject registry credentials outside the cluster", func(t *testing.T) {
+		server := fixOIDCServer(t, idp, now, fixOIDCInstance(idp), fixAccessSecret(), fixOIDCClientSecret(),
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService, nil)
+		req.SetBasicAuth("user", "pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("reject id token without username claim", func(t *testing.T) {
+		server := fixOIDCServer(t, idp, now, fixOIDCInstance(idp), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService, nil)
+		req.SetBasicAuth("oauth2", idp.IDToken(map[string]interface{}{"sub": "1234"}))
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("reject expired id token", func(t *testing.T) {
+		server := fixOIDCServer(t, idp, now, fixOIDCInstance(idp), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService, nil)
+		req.SetBasicAuth("oauth2", idp.IDToken(map[string]interface{}{
+			"email": "dev@example.com",
+			"exp":   now.Add(-time.Hour).Unix(),
+		}))
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("reject external access credentials with oidc", func(t *testing.T) {
+		server := fixOIDCServer(t, idp, now, fixOIDCInstance(idp), fixAccessSecret(), fixExternalAccessSecret(), fixOIDCClientSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService, nil)
+		req.SetBasicAuth("external-user", "external-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("keep registry credentials inside the cluster", func(t *testing.T) {
+		server := fixOIDCServer(t, idp, now, fixOIDCInstance(idp), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService, nil)
+		req.SetBasicAuth("user", "pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("fail when client secret is missing", func(t *testing.T) {
+		server := fixOIDCServer(t, idp, now, fixOIDCInstance(idp), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, testExternalTokenPath+"?service="+testService, nil)
+		req.SetBasicAuth("dev", "dev-password")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusInternalServerError, resp.Code)
+	})
+}
+
+func fixOIDCServer(t *testing.T, idp *oidctest.Provider, now time.Time, objs ...client.Object) *Server {
+	server := fixServer(t, now, objs...)
+	server.httpClient = idp.Client()
+	return server
+}
+
+func fixOIDCInstance(idp *oidctest.Provider) *v1alpha1.DockerRegistry {
+	instance := fixServedInstance()
+	instance.Spec.ExternalAccess = &v1alpha1.ExternalAccess{
+		Enabled: ptr.To(true),
+		OIDC: &v1alpha1.ExternalAccessOIDC{
+			IssuerURL:       idp.URL,
+			ClientID:        idp.ClientID,
+			ClientSecretRef: &v1alpha1.SecretReference{Name: "oidc-client"},
+		},
+	}
+	return instance
+}
+
+func fixOIDCClientSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "oidc-client",
+			Namespace: "test-namespace",
+		},
+		Data: map[string][]byte{OIDCClientSecretKey: []byte("client-secret")},
+	}
+}
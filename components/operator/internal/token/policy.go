@@ -0,0 +1,132 @@
+package token
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AccessDeniedReason is the reason of the events reporting the actions the access policies denied
+const AccessDeniedReason = "AccessDenied"
+
+// restrictAccess narrows the granted access to what the access policies in the namespace of the registry allow the
+// subject, and reports the denied actions as an event in the namespace of the subject
+func (s *Server) restrictAccess(ctx context.Context, instance *v1alpha1.DockerRegistry, subject v1alpha1.PolicySubject, access []ResourceActions) ([]ResourceActions, error) {
+	policies := v1alpha1.RegistryAccessPolicyList{}
+	if err := s.client.List(ctx, &policies, client.InNamespace(instance.GetNamespace())); err != nil {
+		return nil, errors.Wrap(err, "while listing registry access policies")
+	}
+
+	allowed, denied := applyPolicies(policies.Items, subject, access)
+	if len(denied) > 0 {
+		s.recorder.Event(deniedEventObject(instance, subject), corev1.EventTypeWarning, AccessDeniedReason,
+			fmt.Sprintf("Registry access policies denied %s %s: %s", subjectKindName(subject.Kind), subject.Name, formatAccess(denied)))
+	}
+	return allowed, nil
+}
+
+// applyPolicies returns the access the rules of the policies naming the subject allow and the denied rest, the
+// access of a subject no policy names is left as it is
+func applyPolicies(policies []v1alpha1.RegistryAccessPolicy, subject v1alpha1.PolicySubject, access []ResourceActions) ([]ResourceActions, []ResourceActions) {
+	var rules []v1alpha1.PolicyRule
+	for _, policy := range policies {
+		if slices.ContainsFunc(policy.Spec.Subjects, func(s v1alpha1.PolicySubject) bool { return subjectMatches(s, subject) }) {
+			rules = append(rules, policy.Spec.Rules...)
+		}
+	}
+	if len(rules) == 0 {
+		return access, nil
+	}
+
+	var allowed, denied []ResourceActions
+	for _, requested := range access {
+		if requested.Type != "repository" {
+			allowed = append(allowed, requested)
+			continue
+		}
+
+		permitted := ruleActions(rules, requested.Name)
+		granted := ResourceActions{Type: requested.Type, Name: requested.Name}
+		rejected := ResourceActions{Type: requested.Type, Name: requested.Name}
+		for _, action := range requested.Actions {
+			if slices.Contains(permitted, action) {
+				granted.Actions = append(granted.Actions, action)
+			} else {
+				rejected.Actions = append(rejected.Actions, action)
+			}
+		}
+
+		if len(granted.Actions) > 0 {
+			allowed = append(allowed, granted)
+		}
+		if len(rejected.Actions) > 0 {
+			denied = append(denied, rejected)
+		}
+	}
+	return allowed, denied
+}
+
+func subjectMatches(policySubject, subject v1alpha1.PolicySubject) bool {
+	if policySubject.Kind != subject.Kind || policySubject.Name != subject.Name {
+		return false
+	}
+	return subject.Kind != v1alpha1.PolicySubjectServiceAccount || policySubject.Namespace == subject.Namespace
+}
+
+// ruleActions returns the actions the rules grant on the repository, the patterns are matched the same way as the
+// ones of the tag retention, a malformed pattern matches nothing
+func ruleActions(rules []v1alpha1.PolicyRule, repository string) []string {
+	var actions []string
+	for _, rule := range rules {
+		if slices.ContainsFunc(rule.Repositories, func(pattern string) bool {
+			matched, err := path.Match(pattern, repository)
+			return err == nil && matched
+		}) {
+			actions = append(actions, rule.Actions...)
+		}
+	}
+	return actions
+}
+
+// deniedEventObject returns the object the denied access is reported on: the copy of the access Secret in the
+// namespace, the ServiceAccount, or the DockerRegistry CR for the users, which belong to no other namespace
+func deniedEventObject(instance *v1alpha1.DockerRegistry, subject v1alpha1.PolicySubject) client.Object {
+	switch subject.Kind {
+	case v1alpha1.PolicySubjectNamespace:
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name:      registry.NewResourceNames(instance.GetNamespace()).InternalAccessSecretName,
+			Namespace: subject.Name,
+		}}
+	case v1alpha1.PolicySubjectServiceAccount:
+		return &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{
+			Name:      subject.Name,
+			Namespace: subject.Namespace,
+		}}
+	default:
+		return instance
+	}
+}
+
+func subjectKindName(kind v1alpha1.PolicySubjectKind) string {
+	if kind == v1alpha1.PolicySubjectOIDCUser {
+		return "oidc user"
+	}
+	return strings.ToLower(string(kind))
+}
+
+func formatAccess(access []ResourceActions) string {
+	scopes := make([]string, 0, len(access))
+	for _, resource := range access {
+		scopes = append(scopes, fmt.Sprintf("%s:%s:%s", resource.Type, resource.Name, strings.Join(resource.Actions, ",")))
+	}
+	return strings.Join(scopes, " ")
+}
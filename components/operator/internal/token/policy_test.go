@@ -0,0 +1,191 @@
+package token
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_applyPolicies(t *testing.T) {
+	teamA := v1alpha1.PolicySubject{Kind: v1alpha1.PolicySubjectNamespace, Name: "team-a"}
+	policies := []v1alpha1.RegistryAccessPolicy{
+		fixAccessPolicy("team-a", []v1alpha1.PolicySubject{teamA}, []v1alpha1.PolicyRule{
+			{Repositories: []string{"team-a/*"}, Actions: []string{"pull", "push"}},
+			{Repositories: []string{"shared/*"}, Actions: []string{"pull"}},
+		}),
+		fixAccessPolicy("robots", []v1alpha1.PolicySubject{
+			{Kind: v1alpha1.PolicySubjectServiceAccount, Name: "builder", Namespace: "ci"},
+		}, []v1alpha1.PolicyRule{
+			{Repositories: []string{"ci/*"}, Actions: []string{"pull", "push", "delete"}},
+		}),
+		fixAccessPolicy("users", []v1alpha1.PolicySubject{
+			{Kind: v1alpha1.PolicySubjectUser, Name: "dev"},
+		}, []v1alpha1.PolicyRule{
+			{Repositories: []string{"dev/*"}, Actions: []string{"pull"}},
+		}),
+	}
+
+	testCases := map[string]struct {
+		subject         v1alpha1.PolicySubject
+		access          []ResourceActions
+		expectedAllowed []ResourceActions
+		expectedDenied  []ResourceActions
+	}{
+		"allow actions of matching rules": {
+			subject: teamA,
+			access: []ResourceActions{
+				{Type: "repository", Name: "team-a/app", Actions: []string{"pull", "push"}},
+				{Type: "repository", Name: "shared/base", Actions: []string{"pull"}},
+			},
+			expectedAllowed: []ResourceActions{
+				{Type: "repository", Name: "team-a/app", Actions: []string{"pull", "push"}},
+				{Type: "repository", Name: "shared/base", Actions: []string{"pull"}},
+			},
+		},
+		"deny actions no rule grants": {
+			subject: teamA,
+			access: []ResourceActions{
+				{Type: "repository", Name: "shared/base", Actions: []string{"pull", "push"}},
+				{Type: "repository", Name: "team-b/app", Actions: []string{"pull"}},
+			},
+			expectedAllowed: []ResourceActions{
+				{Type: "repository", Name: "shared/base", Actions: []string{"pull"}},
+			},
+			expectedDenied: []ResourceActions{
+				{Type: "repository", Name: "shared/base", Actions: []string{"push"}},
+				{Type: "repository", Name: "team-b/app", Actions: []string{"pull"}},
+			},
+		},
+		"keep catalog access": {
+			subject: teamA,
+			access: []ResourceActions{
+				{Type: "registry", Name: "catalog", Actions: []string{"*"}},
+			},
+			expectedAllowed: []ResourceActions{
+				{Type: "registry", Name: "catalog", Actions: []string{"*"}},
+			},
+		},
+		"keep access of subject no policy names": {
+			subject: v1alpha1.PolicySubject{Kind: v1alpha1.PolicySubjectNamespace, Name: "team-b"},
+			access: []ResourceActions{
+				{Type: "repository", Name: "team-a/app", Actions: []string{"pull", "push"}},
+			},
+			expectedAllowed: []ResourceActions{
+				{Type: "repository", Name: "team-a/app", Actions: []string{"pull", "push"}},
+			},
+		},
+		"keep users listed in the CR and oidc users apart": {
+			subject: v1alpha1.PolicySubject{Kind: v1alpha1.PolicySubjectOIDCUser, Name: "dev"},
+			access: []ResourceActions{
+				{Type: "repository", Name: "team-a/app", Actions: []string{"push"}},
+			},
+			expectedAllowed: []ResourceActions{
+				{Type: "repository", Name: "team-a/app", Actions: []string{"push"}},
+			},
+		},
+		"match service account in its namespace only": {
+			subject: v1alpha1.PolicySubject{Kind: v1alpha1.PolicySubjectServiceAccount, Name: "builder", Namespace: "other"},
+			access: []ResourceActions{
+				{Type: "repository", Name: "team-a/app", Actions: []string{"push"}},
+			},
+			expectedAllowed: []ResourceActions{
+				{Type: "repository", Name: "team-a/app", Actions: []string{"push"}},
+			},
+		},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			allowed, denied := applyPolicies(policies, testCase.subject, testCase.access)
+
+			require.Equal(t, testCase.expectedAllowed, allowed)
+			require.Equal(t, testCase.expectedDenied, denied)
+		})
+	}
+}
+
+func TestServer_restrictAccess(t *testing.T) {
+	now := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+	keys, err := RotateKeys(nil, now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	t.Run("restrict namespace credentials and report denied actions", func(t *testing.T) {
+		entry, err := registry.HtpasswdEntry("team-a", "team-pass")
+		require.NoError(t, err)
+		credentials := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dockerregistry-namespace-credentials-test-namespace",
+				Namespace: "test-namespace",
+			},
+			Data: map[string][]byte{"team-a": []byte(entry)},
+		}
+		policy := fixAccessPolicy("team-a", []v1alpha1.PolicySubject{
+			{Kind: v1alpha1.PolicySubjectNamespace, Name: "team-a"},
+		}, []v1alpha1.PolicyRule{
+			{Repositories: []string{"team-a/*"}, Actions: []string{"pull", "push"}},
+		})
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), credentials, &policy,
+			fixNamespace("team-a", map[string]string{registry.PushAccessLabel: "true"}),
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService+
+			"&scope=repository:team-a/app:pull,push&scope=repository:team-b/app:pull,push", nil)
+		req.SetBasicAuth("team-a", "team-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "team-a/app", Actions: []string{"pull", "push"}},
+		}, claims.Access)
+		// the push to the repositories of the other namespaces is not granted to the namespace credentials at all
+		require.Equal(t, "Warning AccessDenied Registry access policies denied namespace team-a: repository:team-b/app:pull",
+			<-server.recorder.(*record.FakeRecorder).Events)
+	})
+
+	t.Run("keep access of registry credentials", func(t *testing.T) {
+		policy := fixAccessPolicy("everyone", []v1alpha1.PolicySubject{
+			{Kind: v1alpha1.PolicySubjectUser, Name: "user"},
+		}, []v1alpha1.PolicyRule{
+			{Repositories: []string{"none/*"}, Actions: []string{"pull"}},
+		})
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), &policy, fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService+"&scope=repository:ci/app:push", nil)
+		req.SetBasicAuth("user", "pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "ci/app", Actions: []string{"push"}},
+		}, claims.Access)
+		require.Empty(t, server.recorder.(*record.FakeRecorder).Events)
+	})
+}
+
+func fixAccessPolicy(name string, subjects []v1alpha1.PolicySubject, rules []v1alpha1.PolicyRule) v1alpha1.RegistryAccessPolicy {
+	return v1alpha1.RegistryAccessPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "test-namespace",
+		},
+		Spec: v1alpha1.RegistryAccessPolicySpec{
+			Subjects: subjects,
+			Rules:    rules,
+		},
+	}
+}
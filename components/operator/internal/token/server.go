@@ -0,0 +1,432 @@
+package token
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	DefaultBindAddress = ":8090"
+	DefaultServiceName = "dockerregistry-token-server"
+
+	readHeaderTimeout = 10 * time.Second
+	shutdownTimeout   = 10 * time.Second
+	// identityProviderTimeout limits the requests to the identity provider of the oidc login
+	identityProviderTimeout = 10 * time.Second
+	// the token requests are small forms, a larger one is not read to the end
+	maxRequestSize = 64 << 10
+)
+
+// grantedActions are the actions the registry credentials grant on every repository
+var grantedActions = []string{"pull", "push", "delete", "*"}
+
+// pullActions are the actions the pull-only credentials and the namespaces not labeled for push are granted
+var pullActions = []string{"pull"}
+
+var errUnauthorized = errors.New("invalid registry credentials")
+
+// badRequestError is returned for the requests no token can be issued for
+type badRequestError string
+
+func (e badRequestError) Error() string {
+	return string(e)
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IssuedAt    string `json:"issued_at"`
+}
+
+// tokenRequest holds what the client asks for, in both the basic auth and the OAuth2 password grant flavors
+type tokenRequest struct {
+	service       string
+	scopes        []string
+	username      string
+	password      string
+	authenticated bool
+	// external is set for the requests of the clients outside the cluster, which log in with the external access
+	// credentials, the credentials of the RegistryAccessGrants or oidc only
+	external bool
+}
+
+// Server issues the tokens of the registries with the token authentication. Every operator replica serves the
+// tokens, the keys are read from the Secrets the leader rotates.
+type Server struct {
+	addr          string
+	client        client.Client
+	recorder      record.EventRecorder
+	httpClient    *http.Client
+	oidcProviders sync.Map
+	log           *zap.SugaredLogger
+	now           func() time.Time
+}
+
+func NewServer(addr string, client client.Client, recorder record.EventRecorder, log *zap.SugaredLogger) *Server {
+	return &Server{
+		addr:       addr,
+		client:     client,
+		recorder:   recorder,
+		httpClient: &http.Client{Timeout: identityProviderTimeout},
+		log:        log.Named("token-server"),
+		now:        time.Now,
+	}
+}
+
+// NeedLeaderElection makes the standby replicas serve tokens too, so that the token Service has endpoints
+// while the leader is replaced
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start serves the tokens until the context is done
+func (s *Server) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle(registry.TokenServerPath, s)
+	mux.Handle(registry.ExternalTokenServerPath, s)
+
+	server := &http.Server{
+		Addr:              s.addr,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		s.log.Infof("serving registry tokens on %s", s.addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return errors.Wrap(err, "while serving registry tokens")
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return server.Shutdown(shutdownCtx)
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := readTokenRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.external = r.URL.Path == registry.ExternalTokenServerPath
+
+	var badRequest badRequestError
+	response, err := s.issue(r.Context(), req)
+	switch {
+	case errors.As(err, &badRequest):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, errUnauthorized):
+		w.Header().Set("WWW-Authenticate", `Basic realm="docker-registry"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		s.log.Errorf("failed to issue token for service %s: %s", req.service, err)
+		http.Error(w, "failed to issue token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func readTokenRequest(w http.ResponseWriter, r *http.Request) (*tokenRequest, error) {
+	switch r.Method {
+	case http.MethodGet:
+		req := &tokenRequest{
+			service: r.URL.Query().Get("service"),
+			scopes:  r.URL.Query()["scope"],
+		}
+		req.username, req.password, req.authenticated = r.BasicAuth()
+		return req, nil
+	case http.MethodPost:
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+		if err := r.ParseForm(); err != nil {
+			return nil, errors.Wrap(err, "invalid token request")
+		}
+		// the clients fall back to the basic auth when the other grants are rejected
+		if grantType := r.PostForm.Get("grant_type"); grantType != "password" {
+			return nil, errors.Errorf("unsupported grant type '%s'", grantType)
+		}
+		return &tokenRequest{
+			service:       r.PostForm.Get("service"),
+			scopes:        strings.Fields(r.PostForm.Get("scope")),
+			username:      r.PostForm.Get("username"),
+			password:      r.PostForm.Get("password"),
+			authenticated: true,
+		}, nil
+	default:
+		return nil, errors.Errorf("unsupported method %s", r.Method)
+	}
+}
+
+func (s *Server) issue(ctx context.Context, req *tokenRequest) (*tokenResponse, error) {
+	namespace, ok := registry.TokenServiceNamespace(req.service)
+	if !ok {
+		return nil, badRequestError(fmt.Sprintf("unknown service '%s'", req.service))
+	}
+
+	instance, err := s.servedInstance(ctx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	authenticate := s.authenticate
+	if req.external {
+		authenticate = s.authenticateExternal
+	}
+
+	names := registry.NewResourceNames(namespace)
+	claims := Claims{Audience: req.service}
+	ttl := instance.Spec.Auth.Token.GetTTL()
+	now := s.now()
+	if req.authenticated {
+		id, err := authenticate(ctx, instance, names, req)
+		if err != nil {
+			return nil, err
+		}
+		claims.Subject = req.username
+		if id.name != "" {
+			claims.Subject = id.name
+		}
+		claims.Access = grantAccess(req.scopes, id.actions, id.listsCatalog())
+		if id.namespace != "" {
+			claims.Access = limitToNamespace(claims.Access, id.namespace)
+		}
+		if id.subject != nil {
+			claims.Access, err = s.restrictAccess(ctx, instance, *id.subject, claims.Access)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// a token must not outlive the credentials it was issued for
+		if !id.expiration.IsZero() && id.expiration.Sub(now) < ttl {
+			ttl = id.expiration.Sub(now)
+		}
+	}
+
+	secret, err := registry.GetSecret(ctx, s.client, names.TokenKeySecretName, namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "while fetching token signing key")
+	}
+
+	key, err := LoadSigningKey(secret, now)
+	if err != nil {
+		return nil, err
+	}
+
+	signed, err := key.Sign(claims, now, ttl)
+	if err != nil {
+		return nil, errors.Wrap(err, "while signing token")
+	}
+
+	return &tokenResponse{
+		Token:       signed,
+		AccessToken: signed,
+		ExpiresIn:   int64(ttl.Seconds()),
+		IssuedAt:    now.UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// servedInstance returns the DockerRegistry served from the namespace, tokens are only issued for registries with
+// the token authentication
+func (s *Server) servedInstance(ctx context.Context, namespace string) (*v1alpha1.DockerRegistry, error) {
+	instances := v1alpha1.DockerRegistryList{}
+	if err := s.client.List(ctx, &instances, client.InNamespace(namespace)); err != nil {
+		return nil, errors.Wrap(err, "while listing dockerregistries")
+	}
+
+	for i := range instances.Items {
+		instance := &instances.Items[i]
+		if instance.Status.Served != v1alpha1.ServedTrue {
+			continue
+		}
+		if instance.Spec.Auth == nil || instance.Spec.Auth.Token == nil {
+			return nil, badRequestError(fmt.Sprintf("token authentication is not enabled for the registry in namespace %s", namespace))
+		}
+		return instance, nil
+	}
+	return nil, badRequestError(fmt.Sprintf("no registry is served in namespace %s", namespace))
+}
+
+// identity is who the credentials of a token request belong to and the repository actions they are allowed, the
+// registry credentials belong to no subject the access policies can name
+type identity struct {
+	// name replaces the username in the tokens when the username is not what identifies the client
+	name    string
+	actions []string
+	subject *v1alpha1.PolicySubject
+	// namespace limits the push and delete actions to the repositories prefixed with it
+	namespace string
+	// expiration is when the temporary credentials stop being valid, the tokens expire with them
+	expiration time.Time
+}
+
+// listsCatalog tells whether the identity may list the repositories of the registry, the catalog names the
+// repositories of every namespace, so only the registry credentials and the identities trusted as much are granted it
+func (id *identity) listsCatalog() bool {
+	return id.namespace == "" && id.subject == nil && slices.Equal(id.actions, grantedActions)
+}
+
+// authenticate accepts the registry credentials, the ones replaced by the last rotation, the pull-only ones, the
+// users listed in the CR, the ServiceAccount tokens and the credentials generated for a single namespace, which
+// push only to the repositories prefixed with the namespace
+func (s *Server) authenticate(ctx context.Context, instance *v1alpha1.DockerRegistry, names registry.ResourceNames, req *tokenRequest) (*identity, error) {
+	namespace := instance.GetNamespace()
+	secret, err := registry.GetSecret(ctx, s.client, names.InternalAccessSecretName, namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "while fetching internal access secret")
+	}
+
+	if matchCredentials(secret.Data["username"], secret.Data["password"], req) ||
+		matchCredentials(secret.Data[registry.PreviousUsernameKey], secret.Data[registry.PreviousPasswordKey], req) {
+		return &identity{actions: grantedActions}, nil
+	}
+	if matchCredentials(secret.Data[registry.PullUsernameKey], secret.Data[registry.PullPasswordKey], req) {
+		return &identity{actions: pullActions}, nil
+	}
+
+	// the users listed in the CR are trusted as much as the registry credentials
+	users, err := registry.GetSecret(ctx, s.client, names.UsersSecretName, namespace)
+	if client.IgnoreNotFound(err) != nil {
+		return nil, errors.Wrap(err, "while fetching users secret")
+	}
+	if err == nil && registry.VerifyHtpasswdEntry(string(users.Data[req.username]), req.username, req.password) {
+		return &identity{
+			actions: grantedActions,
+			subject: &v1alpha1.PolicySubject{Kind: v1alpha1.PolicySubjectUser, Name: req.username},
+		}, nil
+	}
+
+	if instance.Spec.Auth.Token.ServiceAccountTokens && isJWT(req.password) {
+		return s.authenticateServiceAccount(ctx, req)
+	}
+
+	credentials, err := registry.GetSecret(ctx, s.client, names.NamespaceCredentialsSecretName, namespace)
+	if apierrors.IsNotFound(err) {
+		return nil, errUnauthorized
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "while fetching namespace credentials secret")
+	}
+
+	// the entries are keyed by the namespace, which is also the username
+	entry := string(credentials.Data[req.username])
+	if entry == "" || !registry.VerifyHtpasswdEntry(entry, req.username, req.password) {
+		return nil, errUnauthorized
+	}
+	actions, err := s.namespaceActions(ctx, req.username)
+	if err != nil {
+		return nil, err
+	}
+	// the credentials of one namespace must not overwrite the images of the others
+	return &identity{
+		actions:   actions,
+		subject:   &v1alpha1.PolicySubject{Kind: v1alpha1.PolicySubjectNamespace, Name: req.username},
+		namespace: req.username,
+	}, nil
+}
+
+// authenticateExternal accepts only the credentials of the RegistryAccessGrants and the oidc identities, or the
+// external access credentials when the oidc login is not enabled. The clients outside the cluster are sent to the
+// token server by the VirtualService of the registry. The external access Secret is deleted to revoke them.
+func (s *Server) authenticateExternal(ctx context.Context, instance *v1alpha1.DockerRegistry, names registry.ResourceNames, req *tokenRequest) (*identity, error) {
+	id, err := s.authenticateGrant(ctx, instance, req)
+	if err != nil || id != nil {
+		return id, err
+	}
+	if instance.ExternalOIDC() != nil {
+		return s.authenticateOIDC(ctx, instance, names, req)
+	}
+
+	secret, err := registry.GetSecret(ctx, s.client, names.ExternalAccessSecretName, instance.GetNamespace())
+	if apierrors.IsNotFound(err) {
+		return nil, errUnauthorized
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "while fetching external access secret")
+	}
+	if !secret.GetDeletionTimestamp().IsZero() {
+		return nil, errUnauthorized
+	}
+
+	if !matchCredentials(secret.Data["username"], secret.Data["password"], req) {
+		return nil, errUnauthorized
+	}
+	return &identity{actions: grantedActions}, nil
+}
+
+// namespaceActions returns the actions of the per-namespace credentials, only the namespaces labeled for push can
+// push with them
+func (s *Server) namespaceActions(ctx context.Context, name string) ([]string, error) {
+	namespace := corev1.Namespace{}
+	err := s.client.Get(ctx, client.ObjectKey{Name: name}, &namespace)
+	if apierrors.IsNotFound(err) {
+		// the credentials of the deleted namespace are about to be revoked
+		return nil, errUnauthorized
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "while fetching namespace %s", name)
+	}
+
+	if namespace.GetLabels()[registry.PushAccessLabel] == "true" {
+		return grantedActions, nil
+	}
+	return pullActions, nil
+}
+
+func matchCredentials(username, password []byte, req *tokenRequest) bool {
+	return len(username) != 0 &&
+		subtle.ConstantTimeCompare(username, []byte(req.username)) == 1 &&
+		subtle.ConstantTimeCompare(password, []byte(req.password)) == 1
+}
+
+// grantAccess grants the requested repository actions the credentials are allowed and the catalog listing when the
+// credentials may list it
+func grantAccess(scopes []string, actions []string, catalog bool) []ResourceActions {
+	var access []ResourceActions
+	for _, scope := range scopes {
+		requested, ok := ParseScope(scope)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case requested.Type == "repository":
+			requested.Actions = slices.DeleteFunc(requested.Actions, func(action string) bool {
+				return !slices.Contains(actions, action)
+			})
+		case requested.Type == "registry" && requested.Name == "catalog" && catalog:
+			requested.Actions = []string{"*"}
+		default:
+			continue
+		}
+
+		if len(requested.Actions) > 0 {
+			access = append(access, requested)
+		}
+	}
+	return access
+}
@@ -0,0 +1,454 @@
+package token
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testService = "dockerregistry.test-namespace.svc.cluster.local"
+
+func TestServer_ServeHTTP(t *testing.T) {
+	now := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+	keys, err := RotateKeys(nil, now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	t.Run("issue token for basic auth request", func(t *testing.T) {
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService+
+			"&scope=repository:ci/app:pull,push&scope=registry:catalog:*", nil)
+		req.SetBasicAuth("user", "pass")
+
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		require.Equal(t, body.Token, body.AccessToken)
+		require.Equal(t, int64(600), body.ExpiresIn)
+
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, "user", claims.Subject)
+		require.Equal(t, testService, claims.Audience)
+		require.Equal(t, now.Add(10*time.Minute).Unix(), claims.Expiration)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "ci/app", Actions: []string{"pull", "push"}},
+			{Type: "registry", Name: "catalog", Actions: []string{"*"}},
+		}, claims.Access)
+	})
+
+	t.Run("issue token for password grant request", func(t *testing.T) {
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+		form := url.Values{
+			"grant_type": {"password"},
+			"service":    {testService},
+			"scope":      {"repository:ci/app:pull repository:ci/tool:delete"},
+			"username":   {"user"},
+			"password":   {"pass"},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.AccessToken)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "ci/app", Actions: []string{"pull"}},
+			{Type: "repository", Name: "ci/tool", Actions: []string{"delete"}},
+		}, claims.Access)
+	})
+
+	t.Run("issue token without access for anonymous request", func(t *testing.T) {
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService+"&scope=repository:ci/app:pull", nil)
+
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Empty(t, claims.Subject)
+		require.Empty(t, claims.Access)
+	})
+
+	t.Run("reject invalid credentials", func(t *testing.T) {
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService, nil)
+		req.SetBasicAuth("user", "wrong")
+
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+		require.Contains(t, resp.Header().Get("WWW-Authenticate"), "Basic")
+	})
+
+	t.Run("issue token for namespace credentials", func(t *testing.T) {
+		entry, err := registry.HtpasswdEntry("team-a", "team-pass")
+		require.NoError(t, err)
+		credentials := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dockerregistry-namespace-credentials-test-namespace",
+				Namespace: "test-namespace",
+			},
+			Data: map[string][]byte{"team-a": []byte(entry)},
+		}
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), credentials, fixNamespace("team-a", nil),
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService+"&scope=repository:ci/app:pull,push", nil)
+		req.SetBasicAuth("team-a", "team-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, "team-a", claims.Subject)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "ci/app", Actions: []string{"pull"}},
+		}, claims.Access)
+
+		req = httptest.NewRequest(http.MethodGet, "/token?service="+testService, nil)
+		req.SetBasicAuth("team-a", "wrong")
+		resp = serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("issue push token for namespace labeled for push", func(t *testing.T) {
+		entry, err := registry.HtpasswdEntry("team-a", "team-pass")
+		require.NoError(t, err)
+		credentials := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dockerregistry-namespace-credentials-test-namespace",
+				Namespace: "test-namespace",
+			},
+			Data: map[string][]byte{"team-a": []byte(entry)},
+		}
+		namespace := fixNamespace("team-a", map[string]string{registry.PushAccessLabel: "true"})
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), credentials, namespace,
+			fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService+
+			"&scope=repository:team-a/app:pull,push&scope=repository:ci/app:pull,push", nil)
+		req.SetBasicAuth("team-a", "team-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		// the repositories of the other namespaces are only pulled
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "team-a/app", Actions: []string{"pull", "push"}},
+			{Type: "repository", Name: "ci/app", Actions: []string{"pull"}},
+		}, claims.Access)
+	})
+
+	t.Run("issue pull token for pull-only credentials", func(t *testing.T) {
+		secret := fixAccessSecret()
+		secret.Data[registry.PullUsernameKey] = []byte("pull-user")
+		secret.Data[registry.PullPasswordKey] = []byte("pull-pass")
+		server := fixServer(t, now, fixServedInstance(), secret, fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService+"&scope=repository:ci/app:pull,push,delete", nil)
+		req.SetBasicAuth("pull-user", "pull-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, "pull-user", claims.Subject)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "ci/app", Actions: []string{"pull"}},
+		}, claims.Access)
+	})
+
+	t.Run("issue token for credentials replaced by rotation", func(t *testing.T) {
+		secret := fixAccessSecret()
+		secret.Data[registry.PreviousUsernameKey] = []byte("old-user")
+		secret.Data[registry.PreviousPasswordKey] = []byte("old-pass")
+		server := fixServer(t, now, fixServedInstance(), secret, fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService+"&scope=repository:ci/app:push", nil)
+		req.SetBasicAuth("old-user", "old-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "ci/app", Actions: []string{"push"}},
+		}, claims.Access)
+	})
+
+	t.Run("issue token for user listed in the CR", func(t *testing.T) {
+		entry, err := registry.HtpasswdEntry("ci", "ci-pass")
+		require.NoError(t, err)
+		users := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "dockerregistry-users-test-namespace",
+				Namespace: "test-namespace",
+			},
+			Data: map[string][]byte{"ci": []byte(entry)},
+		}
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), users, fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService+"&scope=repository:ci/app:pull,push", nil)
+		req.SetBasicAuth("ci", "ci-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, "ci", claims.Subject)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "ci/app", Actions: []string{"pull", "push"}},
+		}, claims.Access)
+
+		req = httptest.NewRequest(http.MethodGet, "/token?service="+testService, nil)
+		req.SetBasicAuth("ci", "wrong")
+		resp = serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("issue token for external access credentials", func(t *testing.T) {
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), fixExternalAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token/external?service="+testService+"&scope=repository:ci/app:push", nil)
+		req.SetBasicAuth("external-user", "external-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, "external-user", claims.Subject)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "ci/app", Actions: []string{"push"}},
+		}, claims.Access)
+	})
+
+	t.Run("keep registry credentials inside the cluster", func(t *testing.T) {
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), fixExternalAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token/external?service="+testService+"&scope=repository:ci/app:push", nil)
+		req.SetBasicAuth("user", "pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("reject external access credentials inside the cluster", func(t *testing.T) {
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), fixExternalAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService+"&scope=repository:ci/app:push", nil)
+		req.SetBasicAuth("external-user", "external-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("reject external request after external access secret is deleted", func(t *testing.T) {
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token/external?service="+testService+"&scope=repository:ci/app:push", nil)
+		req.SetBasicAuth("external-user", "external-pass")
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("reject unknown service", func(t *testing.T) {
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+		req := httptest.NewRequest(http.MethodGet, "/token?service=registry.example.com", nil)
+
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code)
+		require.Contains(t, resp.Body.String(), "unknown service 'registry.example.com'")
+	})
+
+	t.Run("reject registry without token authentication", func(t *testing.T) {
+		instance := fixServedInstance()
+		instance.Spec.Auth = nil
+		server := fixServer(t, now, instance, fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService, nil)
+
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code)
+		require.Contains(t, resp.Body.String(), "token authentication is not enabled for the registry in namespace test-namespace")
+	})
+
+	t.Run("reject namespace without served registry", func(t *testing.T) {
+		instance := fixServedInstance()
+		instance.Status.Served = v1alpha1.ServedFalse
+		server := fixServer(t, now, instance, fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService, nil)
+
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code)
+		require.Contains(t, resp.Body.String(), "no registry is served in namespace test-namespace")
+	})
+
+	t.Run("reject unsupported grant type", func(t *testing.T) {
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+		req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader("grant_type=refresh_token"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusBadRequest, resp.Code)
+		require.Contains(t, resp.Body.String(), "unsupported grant type 'refresh_token'")
+	})
+
+	t.Run("fail when signing keys are missing", func(t *testing.T) {
+		server := fixServer(t, now, fixServedInstance(), fixAccessSecret())
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService, nil)
+		req.SetBasicAuth("user", "pass")
+
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusInternalServerError, resp.Code)
+	})
+}
+
+func Test_grantAccess(t *testing.T) {
+	t.Run("filter unknown actions and resources", func(t *testing.T) {
+		access := grantAccess([]string{
+			"repository:ci/app:pull,escalate",
+			"repository:ci/tool:escalate",
+			"registry:other:*",
+			"invalid",
+		}, grantedActions, true)
+
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "ci/app", Actions: []string{"pull"}},
+		}, access)
+	})
+
+	t.Run("grant only pull to pull-only credentials", func(t *testing.T) {
+		access := grantAccess([]string{
+			"repository:ci/app:pull,push",
+			"repository:ci/tool:delete",
+			"registry:catalog:*",
+		}, pullActions, false)
+
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "ci/app", Actions: []string{"pull"}},
+		}, access)
+	})
+
+	t.Run("grant catalog to registry credentials", func(t *testing.T) {
+		access := grantAccess([]string{"registry:catalog:*"}, grantedActions, true)
+
+		require.Equal(t, []ResourceActions{
+			{Type: "registry", Name: "catalog", Actions: []string{"*"}},
+		}, access)
+	})
+}
+
+func serve(server *Server, req *http.Request) *httptest.ResponseRecorder {
+	resp := httptest.NewRecorder()
+	server.ServeHTTP(resp, req)
+	return resp
+}
+
+func fixServer(t *testing.T, now time.Time, objs ...client.Object) *Server {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		Build()
+
+	server := NewServer(DefaultBindAddress, c, record.NewFakeRecorder(10), zap.NewNop().Sugar())
+	server.now = func() time.Time { return now }
+	return server
+}
+
+func fixServedInstance() *v1alpha1.DockerRegistry {
+	return &v1alpha1.DockerRegistry{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "default",
+			Namespace: "test-namespace",
+		},
+		Spec: v1alpha1.DockerRegistrySpec{
+			Auth: &v1alpha1.Auth{
+				Token: &v1alpha1.TokenAuth{TTL: &metav1.Duration{Duration: 10 * time.Minute}},
+			},
+		},
+		Status: v1alpha1.DockerRegistryStatus{
+			Served: v1alpha1.ServedTrue,
+		},
+	}
+}
+
+func fixTokenKeySecret(data map[string][]byte, rotationTime time.Time) *corev1.Secret {
+	secret := fixKeysSecret(data, rotationTime)
+	secret.Name = "dockerregistry-token-key-test-namespace"
+	secret.Namespace = "test-namespace"
+	return secret
+}
+
+func fixNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+	}
+}
+
+func fixExternalAccessSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dockerregistry-config-external-test-namespace",
+			Namespace: "test-namespace",
+		},
+		Data: map[string][]byte{
+			"username": []byte("external-user"),
+			"password": []byte("external-pass"),
+		},
+	}
+}
+
+func fixAccessSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dockerregistry-config-test-namespace",
+			Namespace: "test-namespace",
+		},
+		Data: map[string][]byte{
+			"username": []byte("user"),
+			"password": []byte("pass"),
+		},
+	}
+}
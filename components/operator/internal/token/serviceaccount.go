@@ -0,0 +1,89 @@
+package token
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/pkg/errors"
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+// serviceAccountUsernamePrefix starts the usernames the TokenReview API returns for the ServiceAccount tokens
+const serviceAccountUsernamePrefix = "system:serviceaccount:"
+
+// serviceAccountActions are the actions the ServiceAccount tokens are allowed, the push is limited to the
+// repositories of the namespace of the ServiceAccount
+var serviceAccountActions = []string{"pull", "push"}
+
+// authenticateServiceAccount validates the ServiceAccount token sent as the password with the TokenReview API. The
+// token must be issued for the audience of the registry token service, so that neither the tokens mounted for the
+// API server nor the ones issued for another registry are accepted. A deleted ServiceAccount fails the review, so
+// it can't get new tokens anymore
+func (s *Server) authenticateServiceAccount(ctx context.Context, req *tokenRequest) (*identity, error) {
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     req.password,
+			Audiences: []string{req.service},
+		},
+	}
+	if err := s.client.Create(ctx, review); err != nil {
+		return nil, errors.Wrap(err, "while reviewing service account token")
+	}
+
+	if !review.Status.Authenticated || !slices.Contains(review.Status.Audiences, req.service) {
+		return nil, errUnauthorized
+	}
+
+	namespace, name, ok := serviceAccountName(review.Status.User.Username)
+	if !ok {
+		return nil, errUnauthorized
+	}
+
+	return &identity{
+		name:    review.Status.User.Username,
+		actions: serviceAccountActions,
+		subject: &v1alpha1.PolicySubject{
+			Kind:      v1alpha1.PolicySubjectServiceAccount,
+			Name:      name,
+			Namespace: namespace,
+		},
+		namespace: namespace,
+	}, nil
+}
+
+// serviceAccountName reads the namespace and the name from the username of a ServiceAccount
+func serviceAccountName(username string) (string, string, bool) {
+	qualified, found := strings.CutPrefix(username, serviceAccountUsernamePrefix)
+	if !found {
+		return "", "", false
+	}
+
+	namespace, name, found := strings.Cut(qualified, ":")
+	if !found || namespace == "" || name == "" {
+		return "", "", false
+	}
+	return namespace, name, true
+}
+
+// isJWT tells if the password looks like a token, the other passwords are never sent for a review
+func isJWT(password string) bool {
+	return strings.Count(password, ".") == 2
+}
+
+// limitToNamespace drops the actions other than pull on the repositories outside the namespace
+func limitToNamespace(access []ResourceActions, namespace string) []ResourceActions {
+	var limited []ResourceActions
+	for _, resource := range access {
+		if resource.Type == "repository" && !strings.HasPrefix(resource.Name, namespace+"/") {
+			resource.Actions = slices.DeleteFunc(slices.Clone(resource.Actions), func(action string) bool {
+				return action != "pull"
+			})
+		}
+		if len(resource.Actions) > 0 {
+			limited = append(limited, resource)
+		}
+	}
+	return limited
+}
@@ -0,0 +1,164 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+const testServiceAccountToken = "header.payload.signature"
+
+func TestServer_authenticateServiceAccount(t *testing.T) {
+	now := time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)
+	keys, err := RotateKeys(nil, now.Add(-time.Hour))
+	require.NoError(t, err)
+
+	t.Run("issue namespace-scoped push token for service account", func(t *testing.T) {
+		server := fixServiceAccountServer(t, now, fixTokenReviewStatus(true, testService, "system:serviceaccount:team-a:builder"),
+			fixServiceAccountInstance(), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService+
+			"&scope=repository:team-a/app:pull,push&scope=repository:team-b/app:pull,push", nil)
+		req.SetBasicAuth("builder", testServiceAccountToken)
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Equal(t, "system:serviceaccount:team-a:builder", claims.Subject)
+		require.Equal(t, []ResourceActions{
+			{Type: "repository", Name: "team-a/app", Actions: []string{"pull", "push"}},
+			{Type: "repository", Name: "team-b/app", Actions: []string{"pull"}},
+		}, claims.Access)
+	})
+
+	t.Run("restrict service account by access policies", func(t *testing.T) {
+		policy := fixAccessPolicy("builder", []v1alpha1.PolicySubject{
+			{Kind: v1alpha1.PolicySubjectServiceAccount, Name: "builder", Namespace: "team-a"},
+		}, []v1alpha1.PolicyRule{
+			{Repositories: []string{"team-a/*"}, Actions: []string{"pull", "push"}},
+		})
+		server := fixServiceAccountServer(t, now, fixTokenReviewStatus(true, testService, "system:serviceaccount:team-a:builder"),
+			fixServiceAccountInstance(), fixAccessSecret(), &policy, fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService+"&scope=repository:shared/base:pull", nil)
+		req.SetBasicAuth("builder", testServiceAccountToken)
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusOK, resp.Code)
+		body := tokenResponse{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		_, claims := verifyToken(t, body.Token)
+		require.Empty(t, claims.Access)
+		require.Equal(t, "Warning AccessDenied Registry access policies denied serviceaccount builder: repository:shared/base:pull",
+			<-server.recorder.(*record.FakeRecorder).Events)
+	})
+
+	t.Run("reject token for another audience", func(t *testing.T) {
+		server := fixServiceAccountServer(t, now, fixTokenReviewStatus(true, "https://kubernetes.default.svc", "system:serviceaccount:team-a:builder"),
+			fixServiceAccountInstance(), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService, nil)
+		req.SetBasicAuth("builder", testServiceAccountToken)
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("reject token of deleted service account", func(t *testing.T) {
+		server := fixServiceAccountServer(t, now, fixTokenReviewStatus(false, "", ""),
+			fixServiceAccountInstance(), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService, nil)
+		req.SetBasicAuth("builder", testServiceAccountToken)
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("skip review when service account tokens are disabled", func(t *testing.T) {
+		reviewed := false
+		server := fixServiceAccountServer(t, now, func(review *authenticationv1.TokenReview) {
+			reviewed = true
+		}, fixServedInstance(), fixAccessSecret(), fixTokenKeySecret(keys, now.Add(-time.Hour)))
+
+		req := httptest.NewRequest(http.MethodGet, "/token?service="+testService, nil)
+		req.SetBasicAuth("builder", testServiceAccountToken)
+		resp := serve(server, req)
+
+		require.Equal(t, http.StatusUnauthorized, resp.Code)
+		require.False(t, reviewed)
+	})
+}
+
+func Test_serviceAccountName(t *testing.T) {
+	t.Run("read namespace and name", func(t *testing.T) {
+		namespace, name, ok := serviceAccountName("system:serviceaccount:team-a:builder")
+		require.True(t, ok)
+		require.Equal(t, "team-a", namespace)
+		require.Equal(t, "builder", name)
+	})
+
+	t.Run("reject other users", func(t *testing.T) {
+		_, _, ok := serviceAccountName("system:node:worker")
+		require.False(t, ok)
+		_, _, ok = serviceAccountName("system:serviceaccount:team-a")
+		require.False(t, ok)
+	})
+}
+
+func fixServiceAccountServer(t *testing.T, now time.Time, review func(*authenticationv1.TokenReview), objs ...client.Object) *Server {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, authenticationv1.AddToScheme(scheme))
+
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithInterceptorFuncs(interceptor.Funcs{
+			Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+				if tokenReview, ok := obj.(*authenticationv1.TokenReview); ok {
+					review(tokenReview)
+					return nil
+				}
+				return c.Create(ctx, obj, opts...)
+			},
+		}).
+		Build()
+
+	server := NewServer(DefaultBindAddress, c, record.NewFakeRecorder(10), zap.NewNop().Sugar())
+	server.now = func() time.Time { return now }
+	return server
+}
+
+func fixServiceAccountInstance() *v1alpha1.DockerRegistry {
+	instance := fixServedInstance()
+	instance.Spec.Auth.Token.ServiceAccountTokens = true
+	return instance
+}
+
+func fixTokenReviewStatus(authenticated bool, audience, username string) func(*authenticationv1.TokenReview) {
+	return func(review *authenticationv1.TokenReview) {
+		review.Status = authenticationv1.TokenReviewStatus{
+			Authenticated: authenticated,
+			Audiences:     []string{audience},
+			User:          authenticationv1.UserInfo{Username: username},
+		}
+	}
+}
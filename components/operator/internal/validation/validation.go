@@ -0,0 +1,478 @@
+package validation
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/retention"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// MinCredentialsRotationInterval leaves the Secret controller enough time to propagate the new credentials before
+// the next rotation replaces them
+const MinCredentialsRotationInterval = time.Hour
+
+// MaxAccessGrantDuration keeps the credentials of a RegistryAccessGrant temporary, a longer access needs the
+// external access credentials
+const MaxAccessGrantDuration = 24 * time.Hour
+
+// MinS3Chunksize is the smallest multipart upload part S3 accepts
+const MinS3Chunksize = 5 << 20
+
+// The rules in this package are shared by the admission webhook and the state machine. The webhook
+// rejects a CR for exactly the reasons the reconciliation would otherwise report it as a warning.
+
+var (
+	ErrMultipleStorages         = errors.New("only one storage option can be used")
+	ErrCustomGatewayWithoutHost = errors.New("failed to resolve custom gateway because host is empty")
+	ErrHighAvailabilityStorage  = errors.New("high availability can't be used with the filesystem storage, replicas must share an object storage or a ReadWriteMany pvc")
+	ErrGarbageCollectionStorage = errors.New("garbage collection can't be used with the filesystem storage without a pvc, the garbage collector can't reach the registry data")
+	ErrRetentionDeleteDisabled  = errors.New("tag retention requires spec.storage.deleteEnabled, the registry refuses to delete tags otherwise")
+	ErrRetentionProxy           = errors.New("tag retention can't be used with the proxy, the pull-through cache refuses to delete tags and expires them after the ttl instead")
+	ErrOIDCTokenAuth            = errors.New("oidc login requires spec.auth.token, the token server is what logs the clients in")
+	ErrOIDCExternalCredentials  = errors.New("external access credentials are not used with the oidc login, the clients outside the cluster log in with their oidc identities")
+	ErrCredentialsSecretRotated = errors.New("credentials from the secret can't be rotated by the operator, rotate them in the secret instead")
+	ErrS3KeyIDWithoutEncrypt    = errors.New("kms key id requires encrypt, the objects are not encrypted on the server side otherwise")
+	ErrS3SkipVerifyEndpoint     = errors.New("skipVerify requires regionEndpoint, the certificates of the AWS endpoints are always verified")
+	ErrWorkloadIdentitySecret   = errors.New("secretName can't be used with workloadIdentity, the registry authenticates to the storage with one of them")
+	ErrAzureCredentialsMissing  = errors.New("azure storage requires secretName or workloadIdentity")
+)
+
+// DockerRegistry returns every violation of the spec rules as field errors.
+func DockerRegistry(dr *v1alpha1.DockerRegistry) field.ErrorList {
+	specPath := field.NewPath("spec")
+
+	var errs field.ErrorList
+	errs = append(errs, storage(dr.Spec.Storage, specPath.Child("storage"))...)
+	errs = append(errs, externalAccess(dr.Spec, specPath.Child("externalAccess"))...)
+	errs = append(errs, highAvailability(dr.Spec, specPath.Child("highAvailability"))...)
+	errs = append(errs, garbageCollection(dr.Spec, specPath.Child("garbageCollection"))...)
+	errs = append(errs, tagRetention(dr.Spec, specPath.Child("retention"))...)
+	errs = append(errs, proxy(dr.Spec.Proxy, specPath.Child("proxy"))...)
+	errs = append(errs, auth(dr.Spec, specPath.Child("auth"))...)
+	errs = append(errs, credentials(dr.Spec.Credentials, specPath.Child("credentials"))...)
+	errs = append(errs, users(dr.Spec.Users, specPath.Child("users"))...)
+	return errs
+}
+
+// StorageUnique makes sure only one of the storage backends is configured.
+func StorageUnique(storage *v1alpha1.Storage) error {
+	if storage == nil {
+		return nil
+	}
+
+	storages := 0
+	for _, configured := range []bool{
+		storage.Azure != nil,
+		storage.S3 != nil,
+		storage.GCS != nil,
+		storage.BTPObjectStore != nil,
+		storage.PVC != nil,
+	} {
+		if configured {
+			storages++
+		}
+	}
+	if storages > 1 {
+		return ErrMultipleStorages
+	}
+	return nil
+}
+
+// S3Options makes sure the options of the s3 storage are accepted by the registry.
+func S3Options(s3 *v1alpha1.StorageS3) error {
+	if s3 == nil {
+		return nil
+	}
+	return s3Options(s3, field.NewPath("spec", "storage", "s3")).ToAggregate()
+}
+
+// StorageAuth makes sure the object storage is authenticated either with the static keys from the Secret or with
+// the workload identity of the registry pods.
+func StorageAuth(storage *v1alpha1.Storage) error {
+	if storage == nil {
+		return nil
+	}
+	return storageAuth(storage, field.NewPath("spec", "storage")).ToAggregate()
+}
+
+// HighAvailabilityStorage makes sure the replicas can share the storage, the filesystem storage is not
+// shared between pods. Whether a PVC can be mounted by all replicas is only known in the cluster.
+func HighAvailabilityStorage(storage *v1alpha1.Storage) error {
+	if storage == nil || configuredStorages(storage) == "" {
+		return ErrHighAvailabilityStorage
+	}
+	return nil
+}
+
+// GarbageCollectionSchedule parses the garbage collection schedule in the standard cron format.
+func GarbageCollectionSchedule(schedule string) (cron.Schedule, error) {
+	parsed, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return nil, errors.Wrapf(err, "schedule '%s' is not a valid cron expression", schedule)
+	}
+	return parsed, nil
+}
+
+// GarbageCollectionStorage makes sure the garbage collector can reach the registry data, the filesystem
+// storage without a pvc lives in the registry pod only.
+func GarbageCollectionStorage(storage *v1alpha1.Storage) error {
+	if storage != nil && configuredStorages(storage) == "" {
+		return ErrGarbageCollectionStorage
+	}
+	return nil
+}
+
+// RetentionDeleteEnabled makes sure the registry allows deleting the tags the retention policies remove.
+func RetentionDeleteEnabled(storage *v1alpha1.Storage) error {
+	if storage == nil || !storage.DeleteEnabled {
+		return ErrRetentionDeleteDisabled
+	}
+	return nil
+}
+
+// RetentionProxy makes sure the retention policies are not applied to a pull-through cache.
+func RetentionProxy(proxy *v1alpha1.Proxy) error {
+	if proxy != nil {
+		return ErrRetentionProxy
+	}
+	return nil
+}
+
+// RetentionPolicy makes sure the repository globs and protected tag expressions of the policy are valid.
+func RetentionPolicy(policy v1alpha1.RetentionPolicy) error {
+	if err := retention.ValidateRepositories(policy.Repositories); err != nil {
+		return err
+	}
+	return retention.ValidateProtectedTags(policy.ProtectedTags)
+}
+
+// ProxyRemoteURL makes sure the upstream registry of the pull-through cache is an absolute http(s) URL.
+func ProxyRemoteURL(remoteURL string) error {
+	parsed, err := url.Parse(remoteURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.Errorf("remote url '%s' is not an absolute http or https url", remoteURL)
+	}
+	return nil
+}
+
+// ExternalAccessOIDC makes sure the clients outside the cluster are logged in by the token server, the external
+// access Secret holds no credentials for them otherwise.
+func ExternalAccessOIDC(spec v1alpha1.DockerRegistrySpec) error {
+	if !ExternalAccessEnabled(spec.ExternalAccess) || spec.ExternalAccess.OIDC == nil {
+		return nil
+	}
+	if spec.Auth == nil || spec.Auth.Token == nil {
+		return ErrOIDCTokenAuth
+	}
+	return OIDCIssuerURL(spec.ExternalAccess.OIDC.IssuerURL)
+}
+
+// OIDCIssuerURL makes sure the identity provider is discovered over https.
+func OIDCIssuerURL(issuerURL string) error {
+	parsed, err := url.Parse(issuerURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return errors.Errorf("issuer url '%s' is not an absolute https url", issuerURL)
+	}
+	return nil
+}
+
+// TokenTTL makes sure the tokens expire before the key that signed them is no longer trusted, the registry trusts
+// the previous key until the next rotation.
+func TokenTTL(auth *v1alpha1.TokenAuth) error {
+	if ttl, interval := auth.GetTTL(), auth.GetKeyRotationInterval(); ttl >= interval {
+		return errors.Errorf("token ttl '%s' must be shorter than the key rotation interval '%s'", ttl, interval)
+	}
+	return nil
+}
+
+// CredentialsRotationInterval makes sure the credentials are propagated to the namespaces before they are
+// replaced again.
+func CredentialsRotationInterval(interval time.Duration) error {
+	if interval < MinCredentialsRotationInterval {
+		return errors.Errorf("credentials rotation interval '%s' must not be shorter than '%s'", interval, MinCredentialsRotationInterval)
+	}
+	return nil
+}
+
+// AccessGrantDuration makes sure the credentials of a RegistryAccessGrant are valid long enough to be used and
+// expire within a day.
+func AccessGrantDuration(duration time.Duration) error {
+	if duration < time.Minute || duration > MaxAccessGrantDuration {
+		return errors.Errorf("access grant duration '%s' must be between '%s' and '%s'", duration, time.Minute, MaxAccessGrantDuration)
+	}
+	return nil
+}
+
+// ParseGateway splits a gateway in the <namespace>/<name> format.
+func ParseGateway(gateway string) (string, string, error) {
+	namespacedName := strings.Split(gateway, "/")
+	if len(namespacedName) != 2 || namespacedName[0] == "" || namespacedName[1] == "" {
+		return "", "", errors.Errorf("gateway '%s' is in wrong format", gateway)
+	}
+
+	return namespacedName[0], namespacedName[1], nil
+}
+
+// CustomGatewayHost makes sure a host is set when a custom gateway is used, because the default host
+// is derived from the kyma gateway and does not fit any other one.
+func CustomGatewayHost(gateway, host *string) error {
+	if gateway != nil && host == nil {
+		return ErrCustomGatewayWithoutHost
+	}
+	return nil
+}
+
+// ExternalAccessEnabled returns true if the registry is exposed through the gateway.
+func ExternalAccessEnabled(externalAccess *v1alpha1.ExternalAccess) bool {
+	return externalAccess != nil && externalAccess.Enabled != nil && *externalAccess.Enabled
+}
+
+// Duplicated describes why a DockerRegistry CR is not served while another one is.
+func Duplicated(served *v1alpha1.DockerRegistry) error {
+	return fmt.Errorf(
+		"only one instance of DockerRegistry per namespace is served (current served instance: %s/%s) - this DockerRegistry CR is a standby and takes the registry over when the served one is deleted",
+		served.GetNamespace(), served.GetName())
+}
+
+func storage(storage *v1alpha1.Storage, path *field.Path) field.ErrorList {
+	if err := StorageUnique(storage); err != nil {
+		return field.ErrorList{field.Invalid(path, configuredStorages(storage), err.Error())}
+	}
+	if storage == nil {
+		return nil
+	}
+
+	errs := storageAuth(storage, path)
+	if storage.S3 != nil {
+		errs = append(errs, s3Options(storage.S3, path.Child("s3"))...)
+	}
+	return errs
+}
+
+func storageAuth(storage *v1alpha1.Storage, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if storage.Azure != nil {
+		azurePath := path.Child("azure")
+		if storage.Azure.SecretName == "" && storage.Azure.WorkloadIdentity == nil {
+			errs = append(errs, field.Required(azurePath.Child("secretName"), ErrAzureCredentialsMissing.Error()))
+		}
+		errs = append(errs, workloadIdentitySecret(storage.Azure.SecretName, storage.Azure.WorkloadIdentity != nil, azurePath)...)
+	}
+	if storage.S3 != nil {
+		errs = append(errs, workloadIdentitySecret(storage.S3.SecretName, storage.S3.WorkloadIdentity != nil, path.Child("s3"))...)
+	}
+	if storage.GCS != nil {
+		errs = append(errs, workloadIdentitySecret(storage.GCS.SecretName, storage.GCS.WorkloadIdentity != nil, path.Child("gcs"))...)
+	}
+	return errs
+}
+
+func workloadIdentitySecret(secretName string, workloadIdentity bool, path *field.Path) field.ErrorList {
+	if secretName != "" && workloadIdentity {
+		return field.ErrorList{field.Forbidden(path.Child("workloadIdentity"), ErrWorkloadIdentitySecret.Error())}
+	}
+	return nil
+}
+
+func s3Options(s3 *v1alpha1.StorageS3, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if s3.KeyID != "" && !s3.Encrypt {
+		errs = append(errs, field.Invalid(path.Child("keyID"), s3.KeyID, ErrS3KeyIDWithoutEncrypt.Error()))
+	}
+	if s3.SkipVerify && s3.RegionEndpoint == "" {
+		errs = append(errs, field.Invalid(path.Child("skipVerify"), s3.SkipVerify, ErrS3SkipVerifyEndpoint.Error()))
+	}
+	if s3.Chunksize != 0 && s3.Chunksize < MinS3Chunksize {
+		errs = append(errs, field.Invalid(path.Child("chunksize"), s3.Chunksize, fmt.Sprintf("must be at least %d", MinS3Chunksize)))
+	}
+	return errs
+}
+
+func externalAccess(spec v1alpha1.DockerRegistrySpec, path *field.Path) field.ErrorList {
+	externalAccess := spec.ExternalAccess
+	// the gateway, host and oidc are only used when the registry is exposed
+	if !ExternalAccessEnabled(externalAccess) {
+		return nil
+	}
+
+	var errs field.ErrorList
+	if externalAccess.Gateway != nil {
+		if _, _, err := ParseGateway(*externalAccess.Gateway); err != nil {
+			errs = append(errs, field.Invalid(path.Child("gateway"), *externalAccess.Gateway, err.Error()))
+		}
+	}
+	if err := CustomGatewayHost(externalAccess.Gateway, externalAccess.Host); err != nil {
+		errs = append(errs, field.Required(path.Child("host"), err.Error()))
+	}
+	errs = append(errs, externalAccessOIDC(spec, path.Child("oidc"))...)
+	if externalAccess.CredentialsRotationInterval != nil {
+		rotationPath := path.Child("credentialsRotationInterval")
+		interval := externalAccess.CredentialsRotationInterval.Duration
+		if externalAccess.OIDC != nil {
+			errs = append(errs, field.Forbidden(rotationPath, ErrOIDCExternalCredentials.Error()))
+		}
+		if err := CredentialsRotationInterval(interval); err != nil {
+			errs = append(errs, field.Invalid(rotationPath, interval.String(), err.Error()))
+		}
+	}
+	return errs
+}
+
+func externalAccessOIDC(spec v1alpha1.DockerRegistrySpec, path *field.Path) field.ErrorList {
+	oidc := spec.ExternalAccess.OIDC
+	if oidc == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+	if spec.Auth == nil || spec.Auth.Token == nil {
+		errs = append(errs, field.Forbidden(path, ErrOIDCTokenAuth.Error()))
+	}
+	if err := OIDCIssuerURL(oidc.IssuerURL); err != nil {
+		errs = append(errs, field.Invalid(path.Child("issuerURL"), oidc.IssuerURL, err.Error()))
+	}
+	if oidc.ClientID == "" {
+		errs = append(errs, field.Required(path.Child("clientID"), "client id is required"))
+	}
+	if oidc.ClientSecretRef != nil && oidc.ClientSecretRef.Name == "" {
+		errs = append(errs, field.Required(path.Child("clientSecretRef", "name"), "secret name is required"))
+	}
+	return errs
+}
+
+func configuredStorages(storage *v1alpha1.Storage) string {
+	var names []string
+	if storage.Azure != nil {
+		names = append(names, "azure")
+	}
+	if storage.S3 != nil {
+		names = append(names, "s3")
+	}
+	if storage.GCS != nil {
+		names = append(names, "gcs")
+	}
+	if storage.BTPObjectStore != nil {
+		names = append(names, "btpObjectStore")
+	}
+	if storage.PVC != nil {
+		names = append(names, "pvc")
+	}
+	return strings.Join(names, ", ")
+}
+
+func highAvailability(spec v1alpha1.DockerRegistrySpec, path *field.Path) field.ErrorList {
+	if spec.HighAvailability == nil {
+		return nil
+	}
+	if err := HighAvailabilityStorage(spec.Storage); err != nil {
+		return field.ErrorList{field.Forbidden(path, err.Error())}
+	}
+	return nil
+}
+
+func garbageCollection(spec v1alpha1.DockerRegistrySpec, path *field.Path) field.ErrorList {
+	if spec.GarbageCollection == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+	if _, err := GarbageCollectionSchedule(spec.GarbageCollection.Schedule); err != nil {
+		errs = append(errs, field.Invalid(path.Child("schedule"), spec.GarbageCollection.Schedule, err.Error()))
+	}
+	if err := GarbageCollectionStorage(spec.Storage); err != nil {
+		errs = append(errs, field.Forbidden(path, err.Error()))
+	}
+	return errs
+}
+
+func tagRetention(spec v1alpha1.DockerRegistrySpec, path *field.Path) field.ErrorList {
+	if spec.Retention == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+	if err := RetentionDeleteEnabled(spec.Storage); err != nil {
+		errs = append(errs, field.Forbidden(path, err.Error()))
+	}
+	if err := RetentionProxy(spec.Proxy); err != nil {
+		errs = append(errs, field.Forbidden(path, err.Error()))
+	}
+	for i, policy := range spec.Retention.Policies {
+		if err := RetentionPolicy(policy); err != nil {
+			errs = append(errs, field.Invalid(path.Child("policies").Index(i), policy.Name, err.Error()))
+		}
+	}
+	return errs
+}
+
+func proxy(proxy *v1alpha1.Proxy, path *field.Path) field.ErrorList {
+	if proxy == nil {
+		return nil
+	}
+	if err := ProxyRemoteURL(proxy.RemoteURL); err != nil {
+		return field.ErrorList{field.Invalid(path.Child("remoteURL"), proxy.RemoteURL, err.Error())}
+	}
+	return nil
+}
+
+func auth(spec v1alpha1.DockerRegistrySpec, path *field.Path) field.ErrorList {
+	if spec.Auth == nil || spec.Auth.Token == nil {
+		return nil
+	}
+
+	if err := TokenTTL(spec.Auth.Token); err != nil {
+		tokenPath := path.Child("token")
+		return field.ErrorList{field.Invalid(tokenPath.Child("ttl"), spec.Auth.Token.GetTTL().String(), err.Error())}
+	}
+	return nil
+}
+
+func credentials(credentials *v1alpha1.Credentials, path *field.Path) field.ErrorList {
+	if credentials == nil {
+		return nil
+	}
+
+	var errs field.ErrorList
+	if credentials.SecretRef != nil && credentials.SecretRef.Name == "" {
+		errs = append(errs, field.Required(path.Child("secretRef", "name"), "secret name is required"))
+	}
+	if credentials.RotationInterval == nil {
+		return errs
+	}
+	if credentials.SecretRef != nil {
+		errs = append(errs, field.Forbidden(path.Child("rotationInterval"), ErrCredentialsSecretRotated.Error()))
+	}
+	interval := credentials.RotationInterval.Duration
+	if err := CredentialsRotationInterval(interval); err != nil {
+		errs = append(errs, field.Invalid(path.Child("rotationInterval"), interval.String(), err.Error()))
+	}
+	return errs
+}
+
+// users requires the names to be valid Secret keys, the operator keeps the htpasswd entry of every user under its
+// name, which also keeps ':' out of them
+func users(users []v1alpha1.RegistryUser, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	names := map[string]bool{}
+	for i, user := range users {
+		userPath := path.Index(i)
+		for _, msg := range utilvalidation.IsConfigMapKey(user.Name) {
+			errs = append(errs, field.Invalid(userPath.Child("name"), user.Name, msg))
+		}
+		if names[user.Name] {
+			errs = append(errs, field.Duplicate(userPath.Child("name"), user.Name))
+		}
+		names[user.Name] = true
+		if user.SecretRef.Name == "" {
+			errs = append(errs, field.Required(userPath.Child("secretRef", "name"), "secret name is required"))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,564 @@
+package validation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+)
+
+func TestDockerRegistry(t *testing.T) {
+	t.Run("accept empty spec", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{})
+
+		require.Empty(t, errs)
+	})
+
+	t.Run("accept one storage", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage: &v1alpha1.Storage{
+					S3:            &v1alpha1.StorageS3{Bucket: "bucket", Region: "region"},
+					DeleteEnabled: true,
+				},
+			},
+		})
+
+		require.Empty(t, errs)
+	})
+
+	t.Run("reject two storages", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage: &v1alpha1.Storage{
+					Azure: &v1alpha1.StorageAzure{SecretName: "azure"},
+					PVC:   &v1alpha1.StoragePVC{Name: "pvc"},
+				},
+			},
+		})
+
+		require.Equal(t, field.ErrorList{
+			field.Invalid(field.NewPath("spec", "storage"), "azure, pvc", "only one storage option can be used"),
+		}, errs)
+	})
+
+	t.Run("accept s3 options", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage: &v1alpha1.Storage{
+					S3: &v1alpha1.StorageS3{
+						Bucket:         "bucket",
+						Region:         "region",
+						RegionEndpoint: "https://minio.example.com",
+						Encrypt:        true,
+						KeyID:          "key",
+						Chunksize:      MinS3Chunksize,
+						SkipVerify:     true,
+					},
+				},
+			},
+		})
+
+		require.Empty(t, errs)
+	})
+
+	t.Run("reject invalid s3 options", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage: &v1alpha1.Storage{
+					S3: &v1alpha1.StorageS3{
+						Bucket:     "bucket",
+						Region:     "region",
+						KeyID:      "key",
+						Chunksize:  1024,
+						SkipVerify: true,
+					},
+				},
+			},
+		})
+
+		s3Path := field.NewPath("spec", "storage", "s3")
+		require.Equal(t, field.ErrorList{
+			field.Invalid(s3Path.Child("keyID"), "key", "kms key id requires encrypt, the objects are not encrypted on the server side otherwise"),
+			field.Invalid(s3Path.Child("skipVerify"), true, "skipVerify requires regionEndpoint, the certificates of the AWS endpoints are always verified"),
+			field.Invalid(s3Path.Child("chunksize"), int64(1024), "must be at least 5242880"),
+		}, errs)
+	})
+
+	t.Run("reject workload identity with secret", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage: &v1alpha1.Storage{
+					Azure: &v1alpha1.StorageAzure{
+						SecretName:       "azure",
+						WorkloadIdentity: &v1alpha1.AzureWorkloadIdentity{ClientID: "client-id", AccountName: "account", Container: "container"},
+					},
+				},
+			},
+		})
+
+		require.Equal(t, field.ErrorList{
+			field.Forbidden(field.NewPath("spec", "storage", "azure", "workloadIdentity"), "secretName can't be used with workloadIdentity, the registry authenticates to the storage with one of them"),
+		}, errs)
+	})
+
+	t.Run("accept high availability with object storage", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage: &v1alpha1.Storage{
+					GCS: &v1alpha1.StorageGCS{Bucket: "bucket"},
+				},
+				HighAvailability: &v1alpha1.HighAvailability{Replicas: ptr.To[int32](3)},
+			},
+		})
+
+		require.Empty(t, errs)
+	})
+
+	t.Run("reject high availability with filesystem storage", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage:          &v1alpha1.Storage{DeleteEnabled: true},
+				HighAvailability: &v1alpha1.HighAvailability{},
+			},
+		})
+
+		require.Equal(t, field.ErrorList{
+			field.Forbidden(field.NewPath("spec", "highAvailability"), ErrHighAvailabilityStorage.Error()),
+		}, errs)
+	})
+
+	t.Run("accept garbage collection with default storage", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				GarbageCollection: &v1alpha1.GarbageCollection{Schedule: "0 3 * * 0"},
+			},
+		})
+
+		require.Empty(t, errs)
+	})
+
+	t.Run("reject garbage collection with invalid schedule on filesystem storage", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage:           &v1alpha1.Storage{DeleteEnabled: true},
+				GarbageCollection: &v1alpha1.GarbageCollection{Schedule: "every sunday"},
+			},
+		})
+
+		require.Len(t, errs, 2)
+		require.Equal(t, field.NewPath("spec", "garbageCollection", "schedule").String(), errs[0].Field)
+		require.Contains(t, errs[0].Detail, "schedule 'every sunday' is not a valid cron expression")
+		require.Equal(t, field.Forbidden(field.NewPath("spec", "garbageCollection"), ErrGarbageCollectionStorage.Error()), errs[1])
+	})
+
+	t.Run("accept retention with delete enabled", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage: &v1alpha1.Storage{DeleteEnabled: true},
+				Retention: &v1alpha1.Retention{
+					Policies: []v1alpha1.RetentionPolicy{
+						{
+							Name:          "ci",
+							Repositories:  []string{"ci/*"},
+							KeepLast:      ptr.To[int32](10),
+							ProtectedTags: []string{"^v[0-9]+"},
+						},
+					},
+				},
+			},
+		})
+
+		require.Empty(t, errs)
+	})
+
+	t.Run("reject retention with delete disabled and invalid patterns", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Retention: &v1alpha1.Retention{
+					Policies: []v1alpha1.RetentionPolicy{
+						{Name: "glob", Repositories: []string{"ci/["}, KeepLast: ptr.To[int32](10)},
+						{Name: "regex", ProtectedTags: []string{"v("}, KeepLast: ptr.To[int32](10)},
+					},
+				},
+			},
+		})
+
+		require.Len(t, errs, 3)
+		require.Equal(t, field.Forbidden(field.NewPath("spec", "retention"), ErrRetentionDeleteDisabled.Error()), errs[0])
+		require.Equal(t, field.NewPath("spec", "retention", "policies").Index(0).String(), errs[1].Field)
+		require.Contains(t, errs[1].Detail, "repository pattern 'ci/[' is not a valid glob")
+		require.Equal(t, field.NewPath("spec", "retention", "policies").Index(1).String(), errs[2].Field)
+		require.Contains(t, errs[2].Detail, "protected tag 'v(' is not a valid regular expression")
+	})
+
+	t.Run("accept proxy", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Proxy: &v1alpha1.Proxy{RemoteURL: "https://registry-1.docker.io", SecretName: "docker-hub"},
+			},
+		})
+
+		require.Empty(t, errs)
+	})
+
+	t.Run("reject proxy without host", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Proxy: &v1alpha1.Proxy{RemoteURL: "https://"},
+			},
+		})
+
+		require.Len(t, errs, 1)
+		require.Equal(t, field.NewPath("spec", "proxy", "remoteURL").String(), errs[0].Field)
+		require.Contains(t, errs[0].Detail, "remote url 'https://' is not an absolute http or https url")
+	})
+
+	t.Run("reject retention with proxy", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Storage: &v1alpha1.Storage{DeleteEnabled: true},
+				Retention: &v1alpha1.Retention{
+					Policies: []v1alpha1.RetentionPolicy{{Name: "ci", KeepLast: ptr.To[int32](10)}},
+				},
+				Proxy: &v1alpha1.Proxy{RemoteURL: "https://registry-1.docker.io"},
+			},
+		})
+
+		require.Equal(t, field.ErrorList{
+			field.Forbidden(field.NewPath("spec", "retention"), ErrRetentionProxy.Error()),
+		}, errs)
+	})
+
+	t.Run("accept token authentication", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Auth: &v1alpha1.Auth{
+					Token: &v1alpha1.TokenAuth{TTL: &metav1.Duration{Duration: 10 * time.Minute}},
+				},
+			},
+		})
+
+		require.Empty(t, errs)
+	})
+
+	t.Run("reject token authentication with too long ttl", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				ExternalAccess: &v1alpha1.ExternalAccess{Enabled: ptr.To(true)},
+				Auth: &v1alpha1.Auth{
+					Token: &v1alpha1.TokenAuth{
+						TTL:                 &metav1.Duration{Duration: 2 * time.Hour},
+						KeyRotationInterval: &metav1.Duration{Duration: time.Hour},
+					},
+				},
+			},
+		})
+
+		require.Equal(t, field.ErrorList{
+			field.Invalid(field.NewPath("spec", "auth", "token", "ttl"), "2h0m0s",
+				"token ttl '2h0m0s' must be shorter than the key rotation interval '1h0m0s'"),
+		}, errs)
+	})
+
+	t.Run("accept token authentication with oidc external access", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				ExternalAccess: &v1alpha1.ExternalAccess{
+					Enabled: ptr.To(true),
+					OIDC: &v1alpha1.ExternalAccessOIDC{
+						IssuerURL:       "https://idp.example.com/realms/dev",
+						ClientID:        "docker-registry",
+						ClientSecretRef: &v1alpha1.SecretReference{Name: "oidc-client"},
+					},
+				},
+				Auth: &v1alpha1.Auth{Token: &v1alpha1.TokenAuth{}},
+			},
+		})
+
+		require.Empty(t, errs)
+	})
+
+	t.Run("accept external credentials rotation", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				ExternalAccess: &v1alpha1.ExternalAccess{
+					Enabled:                     ptr.To(true),
+					CredentialsRotationInterval: &metav1.Duration{Duration: 168 * time.Hour},
+				},
+				Auth: &v1alpha1.Auth{Token: &v1alpha1.TokenAuth{}},
+			},
+		})
+
+		require.Empty(t, errs)
+	})
+
+	t.Run("reject external credentials rotation with oidc and too short interval", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				ExternalAccess: &v1alpha1.ExternalAccess{
+					Enabled: ptr.To(true),
+					OIDC: &v1alpha1.ExternalAccessOIDC{
+						IssuerURL: "https://idp.example.com/realms/dev",
+						ClientID:  "docker-registry",
+					},
+					CredentialsRotationInterval: &metav1.Duration{Duration: time.Minute},
+				},
+				Auth: &v1alpha1.Auth{Token: &v1alpha1.TokenAuth{}},
+			},
+		})
+
+		rotationPath := field.NewPath("spec", "externalAccess", "credentialsRotationInterval")
+		require.Equal(t, field.ErrorList{
+			field.Forbidden(rotationPath, ErrOIDCExternalCredentials.Error()),
+			field.Invalid(rotationPath, "1m0s", "credentials rotation interval '1m0s' must not be shorter than '1h0m0s'"),
+		}, errs)
+	})
+
+	t.Run("reject invalid oidc without token authentication", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				ExternalAccess: &v1alpha1.ExternalAccess{
+					Enabled: ptr.To(true),
+					OIDC: &v1alpha1.ExternalAccessOIDC{
+						IssuerURL:       "http://idp.example.com",
+						ClientSecretRef: &v1alpha1.SecretReference{},
+					},
+				},
+			},
+		})
+
+		oidcPath := field.NewPath("spec", "externalAccess", "oidc")
+		require.Equal(t, field.ErrorList{
+			field.Forbidden(oidcPath, ErrOIDCTokenAuth.Error()),
+			field.Invalid(oidcPath.Child("issuerURL"), "http://idp.example.com",
+				"issuer url 'http://idp.example.com' is not an absolute https url"),
+			field.Required(oidcPath.Child("clientID"), "client id is required"),
+			field.Required(oidcPath.Child("clientSecretRef", "name"), "secret name is required"),
+		}, errs)
+	})
+
+	t.Run("ignore oidc of disabled external access", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				ExternalAccess: &v1alpha1.ExternalAccess{
+					Enabled: ptr.To(false),
+					OIDC:    &v1alpha1.ExternalAccessOIDC{},
+				},
+			},
+		})
+
+		require.Empty(t, errs)
+	})
+
+	t.Run("accept credentials rotation interval", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Credentials: &v1alpha1.Credentials{
+					RotationInterval: &metav1.Duration{Duration: 720 * time.Hour},
+				},
+			},
+		})
+
+		require.Empty(t, errs)
+	})
+
+	t.Run("reject too short credentials rotation interval", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Credentials: &v1alpha1.Credentials{
+					RotationInterval: &metav1.Duration{Duration: time.Minute},
+				},
+			},
+		})
+
+		require.Equal(t, field.ErrorList{
+			field.Invalid(field.NewPath("spec", "credentials", "rotationInterval"), "1m0s",
+				"credentials rotation interval '1m0s' must not be shorter than '1h0m0s'"),
+		}, errs)
+	})
+
+	t.Run("reject rotation of credentials from secret", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Credentials: &v1alpha1.Credentials{
+					SecretRef:        &v1alpha1.SecretReference{},
+					RotationInterval: &metav1.Duration{Duration: 720 * time.Hour},
+				},
+			},
+		})
+
+		require.Equal(t, field.ErrorList{
+			field.Required(field.NewPath("spec", "credentials", "secretRef", "name"), "secret name is required"),
+			field.Forbidden(field.NewPath("spec", "credentials", "rotationInterval"), ErrCredentialsSecretRotated.Error()),
+		}, errs)
+	})
+
+	t.Run("reject invalid users", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				Users: []v1alpha1.RegistryUser{
+					{Name: "ci", SecretRef: v1alpha1.SecretReference{Name: "ci-password"}},
+					{Name: "ci", SecretRef: v1alpha1.SecretReference{Name: "other-password"}},
+					{Name: "robot:1"},
+				},
+			},
+		})
+
+		usersPath := field.NewPath("spec", "users")
+		require.Len(t, errs, 3)
+		require.Equal(t, field.Duplicate(usersPath.Index(1).Child("name"), "ci"), errs[0])
+		require.Equal(t, usersPath.Index(2).Child("name").String(), errs[1].Field)
+		require.Equal(t, field.ErrorTypeInvalid, errs[1].Type)
+		require.Equal(t, field.Required(usersPath.Index(2).Child("secretRef", "name"), "secret name is required"), errs[2])
+	})
+
+	t.Run("reject gateway in wrong format", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				ExternalAccess: &v1alpha1.ExternalAccess{
+					Enabled: ptr.To(true),
+					Gateway: ptr.To("gateway"),
+					Host:    ptr.To("registry.example.com"),
+				},
+			},
+		})
+
+		require.Equal(t, field.ErrorList{
+			field.Invalid(field.NewPath("spec", "externalAccess", "gateway"), "gateway", "gateway 'gateway' is in wrong format"),
+		}, errs)
+	})
+
+	t.Run("reject custom gateway without host", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				ExternalAccess: &v1alpha1.ExternalAccess{
+					Enabled: ptr.To(true),
+					Gateway: ptr.To("istio-system/gateway"),
+				},
+			},
+		})
+
+		require.Equal(t, field.ErrorList{
+			field.Required(field.NewPath("spec", "externalAccess", "host"), "failed to resolve custom gateway because host is empty"),
+		}, errs)
+	})
+
+	t.Run("ignore external access configuration when it is disabled", func(t *testing.T) {
+		errs := DockerRegistry(&v1alpha1.DockerRegistry{
+			Spec: v1alpha1.DockerRegistrySpec{
+				ExternalAccess: &v1alpha1.ExternalAccess{
+					Enabled: ptr.To(false),
+					Gateway: ptr.To("gateway"),
+				},
+			},
+		})
+
+		require.Empty(t, errs)
+	})
+}
+
+func TestParseGateway(t *testing.T) {
+	tests := []struct {
+		name          string
+		gateway       string
+		wantNamespace string
+		wantName      string
+		wantErr       string
+	}{
+		{
+			name:          "namespaced name",
+			gateway:       "kyma-system/kyma-gateway",
+			wantNamespace: "kyma-system",
+			wantName:      "kyma-gateway",
+		},
+		{
+			name:    "name only",
+			gateway: "kyma-gateway",
+			wantErr: "gateway 'kyma-gateway' is in wrong format",
+		},
+		{
+			name:    "too many parts",
+			gateway: "a/b/c",
+			wantErr: "gateway 'a/b/c' is in wrong format",
+		},
+		{
+			name:    "empty name",
+			gateway: "kyma-system/",
+			wantErr: "gateway 'kyma-system/' is in wrong format",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespace, name, err := ParseGateway(tt.gateway)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantNamespace, namespace)
+			require.Equal(t, tt.wantName, name)
+		})
+	}
+}
+
+func TestStorageAuth(t *testing.T) {
+	t.Run("accept workload identity", func(t *testing.T) {
+		require.NoError(t, StorageAuth(&v1alpha1.Storage{
+			GCS: &v1alpha1.StorageGCS{Bucket: "bucket", WorkloadIdentity: &v1alpha1.GCSWorkloadIdentity{ServiceAccount: "registry@project.iam.gserviceaccount.com"}},
+		}))
+	})
+
+	t.Run("reject workload identity with secret", func(t *testing.T) {
+		err := StorageAuth(&v1alpha1.Storage{
+			S3: &v1alpha1.StorageS3{Bucket: "bucket", Region: "region", SecretName: "s3", WorkloadIdentity: &v1alpha1.S3WorkloadIdentity{}},
+		})
+
+		require.EqualError(t, err, "spec.storage.s3.workloadIdentity: Forbidden: "+ErrWorkloadIdentitySecret.Error())
+	})
+
+	t.Run("reject azure without credentials", func(t *testing.T) {
+		err := StorageAuth(&v1alpha1.Storage{Azure: &v1alpha1.StorageAzure{}})
+
+		require.EqualError(t, err, "spec.storage.azure.secretName: Required value: "+ErrAzureCredentialsMissing.Error())
+	})
+}
+
+func TestS3Options(t *testing.T) {
+	t.Run("accept s3 without options", func(t *testing.T) {
+		require.NoError(t, S3Options(&v1alpha1.StorageS3{Bucket: "bucket", Region: "region"}))
+	})
+
+	t.Run("reject kms key without encryption", func(t *testing.T) {
+		err := S3Options(&v1alpha1.StorageS3{Bucket: "bucket", Region: "region", KeyID: "key"})
+
+		require.ErrorContains(t, err, ErrS3KeyIDWithoutEncrypt.Error())
+	})
+}
+
+func TestDuplicated(t *testing.T) {
+	err := Duplicated(&v1alpha1.DockerRegistry{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "docker-registry"},
+	})
+
+	require.EqualError(t, err, "only one instance of DockerRegistry per namespace is served (current served instance: docker-registry/default) - this DockerRegistry CR is a standby and takes the registry over when the served one is deleted")
+}
+
+func TestAccessGrantDuration(t *testing.T) {
+	t.Run("accept duration within a day", func(t *testing.T) {
+		require.NoError(t, AccessGrantDuration(8*time.Hour))
+		require.NoError(t, AccessGrantDuration(MaxAccessGrantDuration))
+	})
+
+	t.Run("reject too short duration", func(t *testing.T) {
+		require.EqualError(t, AccessGrantDuration(time.Second), "access grant duration '1s' must be between '1m0s' and '24h0m0s'")
+	})
+
+	t.Run("reject too long duration", func(t *testing.T) {
+		require.EqualError(t, AccessGrantDuration(48*time.Hour), "access grant duration '48h0m0s' must be between '1m0s' and '24h0m0s'")
+	})
+}
@@ -0,0 +1,307 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	DefaultCertDir                     = "/tmp/k8s-webhook-server/serving-certs"
+	DefaultPort                        = 9443
+	DefaultServiceName                 = "dockerregistry-webhook"
+	DefaultSecretName                  = "dockerregistry-webhook-cert"
+	ValidatingWebhookConfigurationName = "dockerregistry-webhook"
+	MutatingWebhookConfigurationName   = "dockerregistry-mutating-webhook"
+	DockerRegistryCRDName              = "dockerregistries.operator.kyma-project.io"
+
+	caCertKey  = "ca.crt"
+	tlsCertKey = "tls.crt"
+	tlsKeyKey  = "tls.key"
+
+	certValidity = 10 * 365 * 24 * time.Hour
+	// certificates are only checked when the operator starts, so they are renewed well ahead of time
+	certRenewBefore = 90 * 24 * time.Hour
+)
+
+type CertificateConfig struct {
+	CertDir          string
+	ServiceName      string
+	ServiceNamespace string
+	SecretName       string
+}
+
+func (c CertificateConfig) secretKey() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: c.ServiceNamespace,
+		Name:      c.SecretName,
+	}
+}
+
+func (c CertificateConfig) dnsNames() []string {
+	return []string{
+		c.ServiceName,
+		fmt.Sprintf("%s.%s", c.ServiceName, c.ServiceNamespace),
+		fmt.Sprintf("%s.%s.svc", c.ServiceName, c.ServiceNamespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", c.ServiceName, c.ServiceNamespace),
+	}
+}
+
+// EnsureCertificate makes sure the webhook server has a serving certificate the API server trusts.
+//
+// The certificate is kept in a Secret, so that every operator replica serves the same one, and the CA
+// bundle is written into the webhook configurations and into the conversion webhook of the
+// DockerRegistry CRD. It returns the CA bundle for the other configurations that call the webhook server.
+func EnsureCertificate(ctx context.Context, c client.Client, config CertificateConfig) ([]byte, error) {
+	secret, err := ensureCertificateSecret(ctx, c, config, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "while ensuring webhook certificate secret")
+	}
+
+	if err := writeCertificate(config.CertDir, secret); err != nil {
+		return nil, errors.Wrap(err, "while writing webhook certificate")
+	}
+
+	caBundle := secret.Data[caCertKey]
+	if err := injectValidatingCABundle(ctx, c, ValidatingWebhookConfigurationName, caBundle); err != nil {
+		return nil, errors.Wrap(err, "while injecting CA bundle into validating webhook configuration")
+	}
+
+	if err := injectMutatingCABundle(ctx, c, MutatingWebhookConfigurationName, caBundle); err != nil {
+		return nil, errors.Wrap(err, "while injecting CA bundle into mutating webhook configuration")
+	}
+
+	if err := injectConversionCABundle(ctx, c, DockerRegistryCRDName, caBundle); err != nil {
+		return nil, errors.Wrap(err, "while injecting CA bundle into dockerregistry CRD")
+	}
+
+	return caBundle, nil
+}
+
+func ensureCertificateSecret(ctx context.Context, c client.Client, config CertificateConfig, now time.Time) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, config.secretKey(), secret)
+	if client.IgnoreNotFound(err) != nil {
+		return nil, err
+	}
+
+	if err == nil && isCertificateValid(secret, config.dnsNames(), now) {
+		return secret, nil
+	}
+
+	data, genErr := generateCertificate(config.dnsNames(), now, secret.Data[caCertKey])
+	if genErr != nil {
+		return nil, genErr
+	}
+
+	if apierrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      config.SecretName,
+				Namespace: config.ServiceNamespace,
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: data,
+		}
+		err = c.Create(ctx, secret)
+	} else {
+		secret.Data = data
+		err = c.Update(ctx, secret)
+	}
+
+	// another replica renewed the certificate at the same time, serve the one it stored
+	if apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err) {
+		secret = &corev1.Secret{}
+		return secret, c.Get(ctx, config.secretKey(), secret)
+	}
+
+	return secret, err
+}
+
+func isCertificateValid(secret *corev1.Secret, dnsNames []string, now time.Time) bool {
+	if _, err := tls.X509KeyPair(secret.Data[tlsCertKey], secret.Data[tlsKeyKey]); err != nil {
+		return false
+	}
+
+	cert, err := parseCertificate(secret.Data[tlsCertKey])
+	if err != nil {
+		return false
+	}
+
+	if now.Add(certRenewBefore).After(cert.NotAfter) {
+		return false
+	}
+
+	for _, name := range dnsNames {
+		if cert.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(secret.Data[caCertKey]) {
+		return false
+	}
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, CurrentTime: now})
+	return err == nil
+}
+
+// generateCertificate issues a new CA and a serving certificate signed by it. The previous CA stays in
+// the bundle while it is valid, so that replicas still serving the old certificate are trusted until
+// they restart and pick up the new one.
+func generateCertificate(dnsNames []string, now time.Time, previousCABundle []byte) (map[string][]byte, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          randomSerialNumber(),
+		Subject:               pkix.Name{CommonName: "dockerregistry-webhook-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: randomSerialNumber(),
+		Subject:      pkix.Name{CommonName: dnsNames[len(dnsNames)-1]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+	if previousCA, err := parseCertificate(previousCABundle); err == nil && now.Before(previousCA.NotAfter) {
+		caBundle = append(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: previousCA.Raw})...)
+	}
+
+	return map[string][]byte{
+		caCertKey:  caBundle,
+		tlsCertKey: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		tlsKeyKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+func writeCertificate(certDir string, secret *corev1.Secret) error {
+	if err := os.MkdirAll(certDir, 0o700); err != nil {
+		return err
+	}
+
+	for _, key := range []string{tlsCertKey, tlsKeyKey} {
+		if err := os.WriteFile(filepath.Join(certDir, key), secret.Data[key], 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func injectValidatingCABundle(ctx context.Context, c client.Client, name string, caBundle []byte) error {
+	config := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	if err := c.Get(ctx, client.ObjectKey{Name: name}, config); err != nil {
+		return err
+	}
+
+	original := config.DeepCopy()
+	for i := range config.Webhooks {
+		config.Webhooks[i].ClientConfig.CABundle = caBundle
+	}
+
+	return c.Patch(ctx, config, client.MergeFrom(original))
+}
+
+func injectMutatingCABundle(ctx context.Context, c client.Client, name string, caBundle []byte) error {
+	config := &admissionregistrationv1.MutatingWebhookConfiguration{}
+	if err := c.Get(ctx, client.ObjectKey{Name: name}, config); err != nil {
+		return err
+	}
+
+	original := config.DeepCopy()
+	for i := range config.Webhooks {
+		config.Webhooks[i].ClientConfig.CABundle = caBundle
+	}
+
+	return c.Patch(ctx, config, client.MergeFrom(original))
+}
+
+func injectConversionCABundle(ctx context.Context, c client.Client, name string, caBundle []byte) error {
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := c.Get(ctx, client.ObjectKey{Name: name}, crd); err != nil {
+		return err
+	}
+
+	conversion := crd.Spec.Conversion
+	if conversion == nil || conversion.Strategy != apiextensionsv1.WebhookConverter ||
+		conversion.Webhook == nil || conversion.Webhook.ClientConfig == nil {
+		// the CRD is older than the conversion webhook
+		return nil
+	}
+
+	original := crd.DeepCopy()
+	conversion.Webhook.ClientConfig.CABundle = caBundle
+
+	return c.Patch(ctx, crd, client.MergeFrom(original))
+}
+
+// parseCertificate returns the first certificate of a PEM bundle
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(bytes.TrimSpace(data))
+	if block == nil {
+		return nil, errors.New("certificate is not PEM encoded")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func randomSerialNumber() *big.Int {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serialNumber
+}
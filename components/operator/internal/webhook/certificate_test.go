@@ -0,0 +1,199 @@
+package webhook
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEnsureCertificate(t *testing.T) {
+	t.Run("generate certificate and inject CA bundle", func(t *testing.T) {
+		config := fixCertificateConfig(t)
+		c := fixCertificateClient(t, fixValidatingWebhookConfiguration(), fixMutatingWebhookConfiguration(), fixCRD(apiextensionsv1.WebhookConverter))
+
+		caBundle, err := EnsureCertificate(context.Background(), c, config)
+
+		require.NoError(t, err)
+		require.NotEmpty(t, caBundle)
+
+		secret := &corev1.Secret{}
+		require.NoError(t, c.Get(context.Background(), config.secretKey(), secret))
+		require.True(t, isCertificateValid(secret, config.dnsNames(), time.Now()))
+
+		for _, key := range []string{tlsCertKey, tlsKeyKey} {
+			data, readErr := os.ReadFile(filepath.Join(config.CertDir, key))
+			require.NoError(t, readErr)
+			require.Equal(t, secret.Data[key], data)
+		}
+
+		webhookConfig := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: ValidatingWebhookConfigurationName}, webhookConfig))
+		require.Equal(t, caBundle, webhookConfig.Webhooks[0].ClientConfig.CABundle)
+
+		mutatingConfig := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: MutatingWebhookConfigurationName}, mutatingConfig))
+		require.Equal(t, caBundle, mutatingConfig.Webhooks[0].ClientConfig.CABundle)
+
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		require.NoError(t, c.Get(context.Background(), client.ObjectKey{Name: DockerRegistryCRDName}, crd))
+		require.Equal(t, caBundle, crd.Spec.Conversion.Webhook.ClientConfig.CABundle)
+	})
+
+	t.Run("skip CRD without conversion webhook", func(t *testing.T) {
+		c := fixCertificateClient(t, fixValidatingWebhookConfiguration(), fixMutatingWebhookConfiguration(), fixCRD(apiextensionsv1.NoneConverter))
+
+		_, err := EnsureCertificate(context.Background(), c, fixCertificateConfig(t))
+
+		require.NoError(t, err)
+	})
+
+	t.Run("reuse valid certificate", func(t *testing.T) {
+		config := fixCertificateConfig(t)
+		c := fixCertificateClient(t, fixValidatingWebhookConfiguration(), fixMutatingWebhookConfiguration(), fixCRD(apiextensionsv1.WebhookConverter))
+
+		firstBundle, err := EnsureCertificate(context.Background(), c, config)
+		require.NoError(t, err)
+
+		secondBundle, err := EnsureCertificate(context.Background(), c, config)
+		require.NoError(t, err)
+		require.Equal(t, firstBundle, secondBundle)
+	})
+
+	t.Run("return error when webhook configuration does not exist", func(t *testing.T) {
+		c := fixCertificateClient(t)
+
+		_, err := EnsureCertificate(context.Background(), c, fixCertificateConfig(t))
+
+		require.ErrorContains(t, err, "while injecting CA bundle into validating webhook configuration")
+	})
+
+	t.Run("return error when mutating webhook configuration does not exist", func(t *testing.T) {
+		c := fixCertificateClient(t, fixValidatingWebhookConfiguration())
+
+		_, err := EnsureCertificate(context.Background(), c, fixCertificateConfig(t))
+
+		require.ErrorContains(t, err, "while injecting CA bundle into mutating webhook configuration")
+	})
+
+	t.Run("return error when CRD does not exist", func(t *testing.T) {
+		c := fixCertificateClient(t, fixValidatingWebhookConfiguration(), fixMutatingWebhookConfiguration())
+
+		_, err := EnsureCertificate(context.Background(), c, fixCertificateConfig(t))
+
+		require.ErrorContains(t, err, "while injecting CA bundle into dockerregistry CRD")
+	})
+}
+
+func Test_ensureCertificateSecret(t *testing.T) {
+	t.Run("renew certificate that expires soon and keep trusting the previous CA", func(t *testing.T) {
+		config := fixCertificateConfig(t)
+		c := fixCertificateClient(t)
+		issued := time.Now().Add(-certValidity + certRenewBefore/2)
+
+		oldSecret, err := ensureCertificateSecret(context.Background(), c, config, issued)
+		require.NoError(t, err)
+
+		newSecret, err := ensureCertificateSecret(context.Background(), c, config, time.Now())
+		require.NoError(t, err)
+
+		require.NotEqual(t, oldSecret.Data[tlsCertKey], newSecret.Data[tlsCertKey])
+		require.True(t, isCertificateValid(newSecret, config.dnsNames(), time.Now()))
+		require.Contains(t, string(newSecret.Data[caCertKey]), string(oldSecret.Data[caCertKey]))
+	})
+
+	t.Run("renew certificate issued for another service", func(t *testing.T) {
+		config := fixCertificateConfig(t)
+		c := fixCertificateClient(t)
+
+		oldSecret, err := ensureCertificateSecret(context.Background(), c, config, time.Now())
+		require.NoError(t, err)
+
+		config.ServiceName = "other-webhook"
+		newSecret, err := ensureCertificateSecret(context.Background(), c, config, time.Now())
+		require.NoError(t, err)
+
+		require.NotEqual(t, oldSecret.Data[tlsCertKey], newSecret.Data[tlsCertKey])
+		require.True(t, isCertificateValid(newSecret, config.dnsNames(), time.Now()))
+	})
+}
+
+func fixCertificateConfig(t *testing.T) CertificateConfig {
+	return CertificateConfig{
+		CertDir:          t.TempDir(),
+		ServiceName:      DefaultServiceName,
+		ServiceNamespace: "docker-registry",
+		SecretName:       DefaultSecretName,
+	}
+}
+
+func fixValidatingWebhookConfiguration() *admissionregistrationv1.ValidatingWebhookConfiguration {
+	return &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ValidatingWebhookConfigurationName,
+		},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{Name: "validation.dockerregistry.operator.kyma-project.io"},
+		},
+	}
+}
+
+func fixMutatingWebhookConfiguration() *admissionregistrationv1.MutatingWebhookConfiguration {
+	return &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: MutatingWebhookConfigurationName,
+		},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{Name: "requester.registryaccessgrant.operator.kyma-project.io"},
+		},
+	}
+}
+
+func fixCRD(strategy apiextensionsv1.ConversionStrategyType) *apiextensionsv1.CustomResourceDefinition {
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DockerRegistryCRDName,
+		},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Conversion: &apiextensionsv1.CustomResourceConversion{
+				Strategy: strategy,
+			},
+		},
+	}
+	if strategy == apiextensionsv1.WebhookConverter {
+		crd.Spec.Conversion.Webhook = &apiextensionsv1.WebhookConversion{
+			ClientConfig: &apiextensionsv1.WebhookClientConfig{
+				Service: &apiextensionsv1.ServiceReference{
+					Namespace: "docker-registry",
+					Name:      DefaultServiceName,
+					Path:      ptr.To("/convert"),
+				},
+			},
+			ConversionReviewVersions: []string{"v1"},
+		}
+	}
+	return crd
+}
+
+func fixCertificateClient(t *testing.T, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, admissionregistrationv1.AddToScheme(scheme))
+	require.NoError(t, apiextensionsv1.AddToScheme(scheme))
+
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		Build()
+}
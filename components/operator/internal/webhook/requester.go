@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ admission.CustomDefaulter = &requesterRecorder{}
+
+// requesterRecorder records the user who created a RegistryAccessGrant, the credentials are issued to that user.
+// The annotation is what the API server authenticated, so a value the user sets is overwritten and never changes
+// afterwards.
+type requesterRecorder struct{}
+
+func NewRequesterRecorder() admission.CustomDefaulter {
+	return &requesterRecorder{}
+}
+
+func SetupRequesterRecorder(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&v1alpha1.RegistryAccessGrant{}).
+		WithDefaulter(NewRequesterRecorder()).
+		Complete()
+}
+
+func (r *requesterRecorder) Default(ctx context.Context, obj runtime.Object) error {
+	grant, ok := obj.(*v1alpha1.RegistryAccessGrant)
+	if !ok {
+		return fmt.Errorf("expected a RegistryAccessGrant but got %T", obj)
+	}
+
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	requester := req.UserInfo.Username
+	if req.Operation == admissionv1.Update {
+		oldGrant := &v1alpha1.RegistryAccessGrant{}
+		if err := json.Unmarshal(req.OldObject.Raw, oldGrant); err != nil {
+			return errors.Wrap(err, "while decoding previous registryaccessgrant")
+		}
+		requester = oldGrant.GetAnnotations()[v1alpha1.RequesterAnnotation]
+	}
+
+	annotations := grant.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	if requester == "" {
+		delete(annotations, v1alpha1.RequesterAnnotation)
+	} else {
+		annotations[v1alpha1.RequesterAnnotation] = requester
+	}
+	grant.SetAnnotations(annotations)
+	return nil
+}
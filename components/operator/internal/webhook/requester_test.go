@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func Test_requesterRecorder_Default(t *testing.T) {
+	t.Run("record user who creates the grant", func(t *testing.T) {
+		grant := fixRegistryAccessGrant(nil)
+		ctx := fixAdmissionContext(t, admissionv1.Create, "dev@example.com", nil)
+
+		require.NoError(t, NewRequesterRecorder().Default(ctx, grant))
+
+		require.Equal(t, "dev@example.com", grant.GetAnnotations()[v1alpha1.RequesterAnnotation])
+	})
+
+	t.Run("overwrite requester set by the user", func(t *testing.T) {
+		grant := fixRegistryAccessGrant(map[string]string{v1alpha1.RequesterAnnotation: "admin"})
+		ctx := fixAdmissionContext(t, admissionv1.Create, "dev@example.com", nil)
+
+		require.NoError(t, NewRequesterRecorder().Default(ctx, grant))
+
+		require.Equal(t, "dev@example.com", grant.GetAnnotations()[v1alpha1.RequesterAnnotation])
+	})
+
+	t.Run("keep requester on update by another user", func(t *testing.T) {
+		oldGrant := fixRegistryAccessGrant(map[string]string{v1alpha1.RequesterAnnotation: "dev@example.com"})
+		grant := fixRegistryAccessGrant(map[string]string{v1alpha1.RequesterAnnotation: "admin", "team": "a"})
+		ctx := fixAdmissionContext(t, admissionv1.Update, "admin", oldGrant)
+
+		require.NoError(t, NewRequesterRecorder().Default(ctx, grant))
+
+		require.Equal(t, map[string]string{
+			v1alpha1.RequesterAnnotation: "dev@example.com",
+			"team":                       "a",
+		}, grant.GetAnnotations())
+	})
+
+	t.Run("drop requester added to grant created without it", func(t *testing.T) {
+		oldGrant := fixRegistryAccessGrant(nil)
+		grant := fixRegistryAccessGrant(map[string]string{v1alpha1.RequesterAnnotation: "admin"})
+		ctx := fixAdmissionContext(t, admissionv1.Update, "admin", oldGrant)
+
+		require.NoError(t, NewRequesterRecorder().Default(ctx, grant))
+
+		require.Empty(t, grant.GetAnnotations())
+	})
+
+	t.Run("fail without admission request", func(t *testing.T) {
+		err := NewRequesterRecorder().Default(context.Background(), fixRegistryAccessGrant(nil))
+
+		require.Error(t, err)
+	})
+}
+
+func fixRegistryAccessGrant(annotations map[string]string) *v1alpha1.RegistryAccessGrant {
+	return &v1alpha1.RegistryAccessGrant{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "laptop",
+			Namespace:   "dev",
+			Annotations: annotations,
+		},
+	}
+}
+
+func fixAdmissionContext(t *testing.T, operation admissionv1.Operation, username string, oldGrant *v1alpha1.RegistryAccessGrant) context.Context {
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: operation,
+		UserInfo:  authenticationv1.UserInfo{Username: username},
+	}}
+	if oldGrant != nil {
+		raw, err := json.Marshal(oldGrant)
+		require.NoError(t, err)
+		req.OldObject = runtime.RawExtension{Raw: raw}
+	}
+	return admission.NewContextWithRequest(context.Background(), req)
+}
@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/kyma-project/docker-registry/components/operator/internal/validation"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+var _ admission.CustomValidator = &dockerRegistryValidator{}
+
+// dockerRegistryValidator rejects DockerRegistry CRs the state machine would not be able to serve, and warns about
+// the ones that wait as a standby for the served one to be deleted
+type dockerRegistryValidator struct {
+	client client.Reader
+}
+
+func NewDockerRegistryValidator(client client.Reader) admission.CustomValidator {
+	return &dockerRegistryValidator{
+		client: client,
+	}
+}
+
+func SetupDockerRegistryValidator(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&v1alpha1.DockerRegistry{}).
+		// the cache of the manager may not hold a CR created a moment ago yet, so the CRs are listed
+		// straight from the API server
+		WithValidator(NewDockerRegistryValidator(mgr.GetAPIReader())).
+		Complete()
+}
+
+func (v *dockerRegistryValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	dockerRegistry, err := toDockerRegistry(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	warnings, err := v.standbyWarnings(ctx, dockerRegistry)
+	if err != nil {
+		return nil, err
+	}
+
+	return warnings, validateSpec(dockerRegistry)
+}
+
+func (v *dockerRegistryValidator) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldDockerRegistry, err := toDockerRegistry(oldObj)
+	if err != nil {
+		return nil, err
+	}
+	dockerRegistry, err := toDockerRegistry(newObj)
+	if err != nil {
+		return nil, err
+	}
+
+	// the operator keeps updating CRs that were accepted before the webhook existed, for example to
+	// remove the finalizer, so only a change of the spec itself is checked against the rules
+	if !dockerRegistry.GetDeletionTimestamp().IsZero() ||
+		reflect.DeepEqual(oldDockerRegistry.Spec, dockerRegistry.Spec) {
+		return nil, nil
+	}
+
+	return nil, validateSpec(dockerRegistry)
+}
+
+func (v *dockerRegistryValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// standbyWarnings is best-effort only: two CRs created at the same time don't see each other, and the webhook
+// ignores its failures, so the state machine stays the one that decides which CR is served
+func (v *dockerRegistryValidator) standbyWarnings(ctx context.Context, dockerRegistry *v1alpha1.DockerRegistry) (admission.Warnings, error) {
+	list := &v1alpha1.DockerRegistryList{}
+	if err := v.client.List(ctx, list, client.InNamespace(dockerRegistry.GetNamespace())); err != nil {
+		return nil, errors.Wrap(err, "while listing dockerregistry objects")
+	}
+
+	for i := range list.Items {
+		existing := &list.Items[i]
+		// a CR that is being deleted hands the registry over, and one that is not served already
+		// waits as a standby the same way as this one
+		if !existing.GetDeletionTimestamp().IsZero() || existing.Status.Served == v1alpha1.ServedFalse {
+			continue
+		}
+		if existing.GetName() == dockerRegistry.GetName() {
+			continue
+		}
+
+		return admission.Warnings{validation.Duplicated(existing).Error()}, nil
+	}
+
+	return nil, nil
+}
+
+func validateSpec(dockerRegistry *v1alpha1.DockerRegistry) error {
+	errs := validation.DockerRegistry(dockerRegistry)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(
+		v1alpha1.GroupVersion.WithKind("DockerRegistry").GroupKind(),
+		dockerRegistry.GetName(),
+		errs,
+	)
+}
+
+func toDockerRegistry(obj runtime.Object) (*v1alpha1.DockerRegistry, error) {
+	dockerRegistry, ok := obj.(*v1alpha1.DockerRegistry)
+	if !ok {
+		return nil, fmt.Errorf("expected a DockerRegistry but got %T", obj)
+	}
+	return dockerRegistry, nil
+}
@@ -0,0 +1,161 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func Test_dockerRegistryValidator_ValidateCreate(t *testing.T) {
+	t.Run("accept first dockerregistry", func(t *testing.T) {
+		validator := NewDockerRegistryValidator(fixClient(t))
+
+		_, err := validator.ValidateCreate(context.Background(), fixDockerRegistry("default", "docker-registry"))
+
+		require.NoError(t, err)
+	})
+
+	t.Run("accept duplicated dockerregistry as standby with warning", func(t *testing.T) {
+		served := fixDockerRegistry("default", "docker-registry")
+		served.Status.Served = v1alpha1.ServedTrue
+		validator := NewDockerRegistryValidator(fixClient(t, served))
+
+		warnings, err := validator.ValidateCreate(context.Background(), fixDockerRegistry("second", "docker-registry"))
+
+		require.NoError(t, err)
+		require.Equal(t, admission.Warnings{
+			"only one instance of DockerRegistry per namespace is served (current served instance: docker-registry/default) - this DockerRegistry CR is a standby and takes the registry over when the served one is deleted",
+		}, warnings)
+	})
+
+	t.Run("warn about dockerregistry while another one waits to be served", func(t *testing.T) {
+		validator := NewDockerRegistryValidator(fixClient(t, fixDockerRegistry("default", "docker-registry")))
+
+		warnings, err := validator.ValidateCreate(context.Background(), fixDockerRegistry("second", "docker-registry"))
+
+		require.NoError(t, err)
+		require.Len(t, warnings, 1)
+	})
+
+	t.Run("accept dockerregistry in another namespace", func(t *testing.T) {
+		served := fixDockerRegistry("default", "docker-registry")
+		served.Status.Served = v1alpha1.ServedTrue
+		validator := NewDockerRegistryValidator(fixClient(t, served))
+
+		warnings, err := validator.ValidateCreate(context.Background(), fixDockerRegistry("default", "tenant"))
+
+		require.NoError(t, err)
+		require.Empty(t, warnings)
+	})
+
+	t.Run("accept dockerregistry when the others are not served or being deleted", func(t *testing.T) {
+		notServed := fixDockerRegistry("not-served", "default")
+		notServed.Status.Served = v1alpha1.ServedFalse
+		deleted := fixDockerRegistry("deleted", "docker-registry")
+		deleted.Status.Served = v1alpha1.ServedTrue
+		deleted.DeletionTimestamp = ptr.To(metav1.Now())
+		deleted.Finalizers = []string{v1alpha1.Finalizer}
+		validator := NewDockerRegistryValidator(fixClient(t, notServed, deleted))
+
+		warnings, err := validator.ValidateCreate(context.Background(), fixDockerRegistry("default", "docker-registry"))
+
+		require.NoError(t, err)
+		require.Empty(t, warnings)
+	})
+
+	t.Run("reject invalid spec with field errors", func(t *testing.T) {
+		dockerRegistry := fixDockerRegistry("default", "docker-registry")
+		dockerRegistry.Spec.Storage = &v1alpha1.Storage{
+			S3:  &v1alpha1.StorageS3{Bucket: "bucket", Region: "region"},
+			GCS: &v1alpha1.StorageGCS{Bucket: "bucket"},
+		}
+		dockerRegistry.Spec.ExternalAccess = &v1alpha1.ExternalAccess{
+			Enabled: ptr.To(true),
+			Gateway: ptr.To("kyma-gateway"),
+		}
+		validator := NewDockerRegistryValidator(fixClient(t))
+
+		_, err := validator.ValidateCreate(context.Background(), dockerRegistry)
+
+		require.True(t, apierrors.IsInvalid(err))
+		statusErr := &apierrors.StatusError{}
+		require.ErrorAs(t, err, &statusErr)
+		causes := statusErr.Status().Details.Causes
+		require.Len(t, causes, 3)
+		require.Equal(t, "spec.storage", causes[0].Field)
+		require.Equal(t, "spec.externalAccess.gateway", causes[1].Field)
+		require.Equal(t, "spec.externalAccess.host", causes[2].Field)
+	})
+}
+
+func Test_dockerRegistryValidator_ValidateUpdate(t *testing.T) {
+	invalidSpec := v1alpha1.DockerRegistrySpec{
+		Storage: &v1alpha1.Storage{
+			S3:  &v1alpha1.StorageS3{Bucket: "bucket", Region: "region"},
+			GCS: &v1alpha1.StorageGCS{Bucket: "bucket"},
+		},
+	}
+
+	t.Run("reject invalid spec change", func(t *testing.T) {
+		oldDockerRegistry := fixDockerRegistry("default", "docker-registry")
+		newDockerRegistry := oldDockerRegistry.DeepCopy()
+		newDockerRegistry.Spec = invalidSpec
+		validator := NewDockerRegistryValidator(fixClient(t))
+
+		_, err := validator.ValidateUpdate(context.Background(), oldDockerRegistry, newDockerRegistry)
+
+		require.True(t, apierrors.IsInvalid(err))
+	})
+
+	t.Run("accept update of already invalid spec without spec change", func(t *testing.T) {
+		oldDockerRegistry := fixDockerRegistry("default", "docker-registry")
+		oldDockerRegistry.Spec = invalidSpec
+		newDockerRegistry := oldDockerRegistry.DeepCopy()
+		newDockerRegistry.Finalizers = []string{v1alpha1.Finalizer}
+		validator := NewDockerRegistryValidator(fixClient(t))
+
+		_, err := validator.ValidateUpdate(context.Background(), oldDockerRegistry, newDockerRegistry)
+
+		require.NoError(t, err)
+	})
+
+	t.Run("accept update of dockerregistry being deleted", func(t *testing.T) {
+		oldDockerRegistry := fixDockerRegistry("default", "docker-registry")
+		newDockerRegistry := oldDockerRegistry.DeepCopy()
+		newDockerRegistry.Spec = invalidSpec
+		newDockerRegistry.DeletionTimestamp = ptr.To(metav1.Now())
+		validator := NewDockerRegistryValidator(fixClient(t))
+
+		_, err := validator.ValidateUpdate(context.Background(), oldDockerRegistry, newDockerRegistry)
+
+		require.NoError(t, err)
+	})
+}
+
+func fixDockerRegistry(name, namespace string) *v1alpha1.DockerRegistry {
+	return &v1alpha1.DockerRegistry{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+}
+
+func fixClient(t *testing.T, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		Build()
+}
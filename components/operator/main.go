@@ -28,9 +28,11 @@ import (
 	"github.com/pkg/errors"
 	uberzap "go.uber.org/zap"
 	istionetworking "istio.io/client-go/pkg/apis/networking/v1beta1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	apiextensionsscheme "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/scheme"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
@@ -39,17 +41,26 @@ import (
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	ctrlwebhook "sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	"github.com/kyma-project/manager-toolkit/logging/config"
 	"github.com/kyma-project/manager-toolkit/logging/logger"
 
 	operatorv1alpha1 "github.com/kyma-project/docker-registry/components/operator/api/v1alpha1"
+	operatorv1beta1 "github.com/kyma-project/docker-registry/components/operator/api/v1beta1"
 	"github.com/kyma-project/docker-registry/components/operator/controllers"
+	"github.com/kyma-project/docker-registry/components/operator/internal/accessproxy"
 	internalconfig "github.com/kyma-project/docker-registry/components/operator/internal/config"
 	k8s "github.com/kyma-project/docker-registry/components/operator/internal/controllers/kubernetes"
+	"github.com/kyma-project/docker-registry/components/operator/internal/election"
 	"github.com/kyma-project/docker-registry/components/operator/internal/gitrepository"
+	"github.com/kyma-project/docker-registry/components/operator/internal/migration"
 	"github.com/kyma-project/docker-registry/components/operator/internal/registry"
 	internalresource "github.com/kyma-project/docker-registry/components/operator/internal/resource"
+	"github.com/kyma-project/docker-registry/components/operator/internal/storageadapter"
+	"github.com/kyma-project/docker-registry/components/operator/internal/storagemigration"
+	"github.com/kyma-project/docker-registry/components/operator/internal/token"
+	"github.com/kyma-project/docker-registry/components/operator/internal/webhook"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -63,6 +74,8 @@ func init() {
 
 	utilruntime.Must(operatorv1alpha1.AddToScheme(scheme))
 
+	utilruntime.Must(operatorv1beta1.AddToScheme(scheme))
+
 	utilruntime.Must(apiextensionsscheme.AddToScheme(scheme))
 
 	utilruntime.Must(istionetworking.AddToScheme(scheme))
@@ -71,15 +84,57 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == registry.StorageMigrationCommand {
+		migrateStorage(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == registry.AccessProxyCommand {
+		runAccessProxy(os.Args[2:])
+		return
+	}
+
 	var metricsAddr string
 	var probeAddr string
 	var configPath string
 	var syncPeriod time.Duration
+	var leaderElection election.Config
+	var tokenServerAddr string
+	tokenServer := types.NamespacedName{Name: token.DefaultServiceName}
+	var storageAdapterAddr string
+	storageAdapter := types.NamespacedName{Name: storageadapter.DefaultServiceName}
+	webhookCertificate := webhook.CertificateConfig{
+		ServiceName: webhook.DefaultServiceName,
+		SecretName:  webhook.DefaultSecretName,
+	}
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.StringVar(&configPath, "config-path", "", "Path to config file for dynamic reconfiguration.")
 	flag.DurationVar(&syncPeriod, "sync-period", 30*time.Minute, "Sync period for controller cache.")
+	flag.BoolVar(&leaderElection.Enabled, "leader-elect", false,
+		"Enable leader election, so that only one of the operator replicas reconciles at a time.")
+	flag.StringVar(&leaderElection.Namespace, "leader-election-namespace", election.DefaultLeaseNamespace,
+		"Namespace of the Lease used for leader election.")
+	flag.StringVar(&leaderElection.Name, "leader-election-id", election.DefaultLeaseName,
+		"Name of the Lease used for leader election.")
+	flag.DurationVar(&leaderElection.LeaseDuration, "leader-election-lease-duration", election.DefaultLeaseDuration,
+		"Duration that standby replicas wait before trying to take over a lease that was not renewed.")
+	flag.DurationVar(&leaderElection.RenewDeadline, "leader-election-renew-deadline", election.DefaultRenewDeadline,
+		"Duration that the leader keeps retrying to renew the lease before it gives up leadership.")
+	flag.DurationVar(&leaderElection.RetryPeriod, "leader-election-retry-period", election.DefaultRetryPeriod,
+		"Duration between leader election attempts.")
+	flag.StringVar(&webhookCertificate.CertDir, "webhook-cert-dir", webhook.DefaultCertDir,
+		"Directory the webhook server reads its serving certificate from.")
+	flag.StringVar(&webhookCertificate.ServiceNamespace, "webhook-service-namespace", "docker-registry",
+		"Namespace of the Service that exposes the webhook server.")
+	flag.StringVar(&tokenServerAddr, "token-server-bind-address", token.DefaultBindAddress,
+		"The address the registry token server binds to.")
+	flag.StringVar(&tokenServer.Namespace, "token-server-service-namespace", "docker-registry",
+		"Namespace of the Service that exposes the registry token server.")
+	flag.StringVar(&storageAdapterAddr, "storage-adapter-bind-address", storageadapter.DefaultBindAddress,
+		"The address the storage adapter binds to.")
+	flag.StringVar(&storageAdapter.Namespace, "storage-adapter-service-namespace", "docker-registry",
+		"Namespace of the Service that exposes the storage adapter.")
 	flag.Parse()
 
 	// Load ChartPath from environment
@@ -139,19 +194,51 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
 	defer cancel()
 
+	// We are going to talk to the API server _before_ we start the manager.
+	// Since the default manager client reads from cache, we will get an error.
+	// So, we create a "serverClient" that would read from the API directly.
+	// We only use it here, this only runs at start up, so it shouldn't be to much for the API
+	serverClient, err := ctrlclient.New(ctrl.GetConfigOrDie(), ctrlclient.Options{
+		Scheme: scheme,
+	})
+	if err != nil {
+		zapLog.Error("failed to create a server client", "error", err)
+		os.Exit(1)
+	}
+
 	zapLog.Info("cleaning orphan deprecated resources")
-	err = cleanupOrphanDeprecatedResources(ctx)
+	err = gitrepository.Cleanup(ctx, serverClient)
 	if err != nil {
 		zapLog.Error("while removing orphan resources", "error", err)
 		os.Exit(1)
 	}
 
+	zapLog.Info("ensuring webhook certificate")
+	_, err = webhook.EnsureCertificate(ctx, serverClient, webhookCertificate)
+	if err != nil {
+		zapLog.Error("while ensuring webhook certificate", "error", err)
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
 		Metrics: ctrlmetrics.Options{
 			BindAddress: metricsAddr,
 		},
 		HealthProbeBindAddress: probeAddr,
+		WebhookServer: ctrlwebhook.NewServer(ctrlwebhook.Options{
+			Port:    webhook.DefaultPort,
+			CertDir: webhookCertificate.CertDir,
+		}),
+		LeaderElection:          leaderElection.Enabled,
+		LeaderElectionID:        leaderElection.Name,
+		LeaderElectionNamespace: leaderElection.Namespace,
+		LeaseDuration:           &leaderElection.LeaseDuration,
+		RenewDeadline:           &leaderElection.RenewDeadline,
+		RetryPeriod:             &leaderElection.RetryPeriod,
+		// the process exits right after the manager stops, so the lease can be handed over at once
+		// instead of making the next replica wait until it expires
+		LeaderElectionReleaseOnCancel: true,
 		Cache: ctrlcache.Options{
 			SyncPeriod: &syncPeriod,
 			ByObject: map[ctrlclient.Object]ctrlcache.ByObject{
@@ -167,6 +254,9 @@ func main() {
 				DisableFor: []ctrlclient.Object{
 					&corev1.Secret{},
 					&corev1.ConfigMap{},
+					// garbage collection Jobs and their Pods are read rarely, caching all of them is not worth it
+					&corev1.Pod{},
+					&batchv1.Job{},
 				},
 			},
 		},
@@ -181,16 +271,19 @@ func main() {
 		mgr.GetEventRecorderFor("dockerregistry-operator"),
 		zapLog,
 		appCfg.ChartPath,
+		tokenServer,
+		storageAdapter,
 	)
 
 	configKubernetes := k8s.Config{
-		BaseNamespace:                 "docker-registry",
-		BaseInternalSecretName:        registry.InternalAccessSecretName,
-		BaseExternalSecretName:        registry.ExternalAccessSecretName,
-		ExcludedNamespaces:            k8s.DefaultExcludedNamespaces(),
-		ConfigMapRequeueDuration:      time.Minute,
-		SecretRequeueDuration:         time.Minute,
-		ServiceAccountRequeueDuration: time.Minute,
+		BaseNamespace:                      registry.BaseNamespace,
+		BaseInternalSecretName:             registry.InternalAccessSecretName,
+		BaseExternalSecretName:             registry.ExternalAccessSecretName,
+		BaseNamespaceCredentialsSecretName: registry.NamespaceCredentialsSecretName,
+		ExcludedNamespaces:                 k8s.DefaultExcludedNamespaces(),
+		ConfigMapRequeueDuration:           time.Minute,
+		SecretRequeueDuration:              time.Minute,
+		ServiceAccountRequeueDuration:      time.Minute,
 	}
 
 	resourceClient := internalresource.New(mgr.GetClient(), scheme)
@@ -201,6 +294,36 @@ func main() {
 		os.Exit(1)
 	}
 
+	// registers the conversion webhook too, as v1alpha1 converts to the v1beta1 hub
+	if err := webhook.SetupDockerRegistryValidator(mgr); err != nil {
+		zapLog.Error("unable to create webhook", "webhook", "DockerRegistry", "error", err)
+		os.Exit(1)
+	}
+
+	// the temporary credentials are issued to the user the webhook records as the requester of the grant
+	if err := webhook.SetupRequesterRecorder(mgr); err != nil {
+		zapLog.Error("unable to create webhook", "webhook", "RegistryAccessGrant", "error", err)
+		os.Exit(1)
+	}
+
+	if err := mgr.Add(migration.NewStorageVersion(mgr.GetClient(), mgr.GetAPIReader(), zapLog)); err != nil {
+		zapLog.Error("unable to set up storage version migration", "error", err)
+		os.Exit(1)
+	}
+
+	// the registries with the token authentication send the clients here for their tokens
+	if err := mgr.Add(token.NewServer(tokenServerAddr, mgr.GetClient(),
+		mgr.GetEventRecorderFor("dockerregistry-token-server"), zapLog)); err != nil {
+		zapLog.Error("unable to set up registry token server", "error", err)
+		os.Exit(1)
+	}
+
+	// the registries with the Azure BTP Object Store send their storage requests here to be authorized with the SAS token
+	if err := mgr.Add(storageadapter.NewServer(storageAdapterAddr, mgr.GetClient(), zapLog)); err != nil {
+		zapLog.Error("unable to set up storage adapter", "error", err)
+		os.Exit(1)
+	}
+
 	if err := k8s.NewNamespace(mgr.GetClient(), zapLog, configKubernetes, secretSvc).
 		SetupWithManager(mgr); err != nil {
 		zapLog.Error("unable to create Namespace controller", "error", err)
@@ -212,6 +335,12 @@ func main() {
 		zapLog.Error("unable to create Secret controller", "error", err)
 		os.Exit(1)
 	}
+
+	if err := k8s.NewGrant(mgr.GetClient(), zapLog, mgr.GetEventRecorderFor("dockerregistry-access-grant")).
+		SetupWithManager(mgr); err != nil {
+		zapLog.Error("unable to create RegistryAccessGrant controller", "error", err)
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -222,6 +351,15 @@ func main() {
 		zapLog.Error("unable to set up ready check", "error", err)
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+		zapLog.Error("unable to set up webhook ready check", "error", err)
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("leader-election",
+		election.ReadinessCheck(mgr.Elected(), mgr.GetAPIReader(), leaderElection)); err != nil {
+		zapLog.Error("unable to set up leader election ready check", "error", err)
+		os.Exit(1)
+	}
 
 	zapLog.Info("starting manager")
 	if err := mgr.Start(signalCtx); err != nil {
@@ -230,17 +368,29 @@ func main() {
 	}
 }
 
-func cleanupOrphanDeprecatedResources(ctx context.Context) error {
-	// We are going to talk to the API server _before_ we start the manager.
-	// Since the default manager client reads from cache, we will get an error.
-	// So, we create a "serverClient" that would read from the API directly.
-	// We only use it here, this only runs at start up, so it shouldn't be to much for the API
-	serverClient, err := ctrlclient.New(ctrl.GetConfigOrDie(), ctrlclient.Options{
-		Scheme: scheme,
-	})
+// migrateStorage runs in the storage migration Job, the operator image copies the images between the storages
+func migrateStorage(args []string) {
+	zapLog, err := uberzap.NewProduction()
 	if err != nil {
-		return errors.Wrap(err, "failed to create a server client")
+		panic(errors.Wrap(err, "unable to create logger"))
 	}
+	log := zapLog.Sugar()
 
-	return gitrepository.Cleanup(ctx, serverClient)
+	if err := storagemigration.Run(ctrl.SetupSignalHandler(), args, log); err != nil {
+		log.Errorf("storage migration failed: %s", err)
+		os.Exit(1)
+	}
+}
+
+func runAccessProxy(args []string) {
+	zapLog, err := uberzap.NewProduction()
+	if err != nil {
+		panic(errors.Wrap(err, "unable to create logger"))
+	}
+	log := zapLog.Sugar()
+
+	if err := accessproxy.Run(ctrl.SetupSignalHandler(), args, log); err != nil {
+		log.Errorf("access proxy failed: %s", err)
+		os.Exit(1)
+	}
 }
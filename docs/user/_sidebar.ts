@@ -7,6 +7,8 @@ export default [
     { text: 'Remove Image Manifest', link: './tutorials/01-30-remove-image-manifest' }
   ]},
   { text: 'Resources', link: './resources/README', collapsed: true, items: [
-    { text: 'Docker Registry Custom Resource', link: './resources/06-20-docker-registry-cr' }
+    { text: 'Docker Registry Custom Resource', link: './resources/06-20-docker-registry-cr' },
+    { text: 'Registry Access Policy Custom Resource', link: './resources/06-30-registry-access-policy-cr' },
+    { text: 'Registry Access Grant Custom Resource', link: './resources/06-40-registry-access-grant-cr' }
   ]}
 ];